@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// dateRange is the optional from/to filter accepted by the /api/me analytics endpoints.
+// From is inclusive and To exclusive; nil bounds are open.
+type dateRange struct {
+	From *time.Time `json:"from"`
+	To   *time.Time `json:"to"`
+}
+
+// parseDateRange reads the from and to query parameters. Both accept RFC 3339 timestamps or
+// plain dates; a plain "to" date includes that whole day.
+func parseDateRange(r *http.Request) (dateRange, error) {
+	var dr dateRange
+	q := r.URL.Query()
+	if v := q.Get("from"); v != "" {
+		t, _, err := parseDateParam(v)
+		if err != nil {
+			return dr, fmt.Errorf("invalid from: %w", err)
+		}
+		dr.From = &t
+	}
+	if v := q.Get("to"); v != "" {
+		t, dateOnly, err := parseDateParam(v)
+		if err != nil {
+			return dr, fmt.Errorf("invalid to: %w", err)
+		}
+		if dateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		dr.To = &t
+	}
+	if dr.From != nil && dr.To != nil && !dr.From.Before(*dr.To) {
+		return dr, fmt.Errorf("from must be before to")
+	}
+	return dr, nil
+}
+
+// parseDateParam parses an RFC 3339 timestamp or a YYYY-MM-DD date (midnight UTC).
+func parseDateParam(v string) (t time.Time, dateOnly bool, err error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%q is neither YYYY-MM-DD nor RFC 3339", v)
+	}
+	return t.UTC(), false, nil
+}
+
+// where adds createdAt bounds for dr to a Hasura boolean expression.
+func (dr dateRange) where(column string, exp map[string]any) map[string]any {
+	cond := map[string]any{}
+	if dr.From != nil {
+		cond["_gte"] = dr.From.Format(time.RFC3339Nano)
+	}
+	if dr.To != nil {
+		cond["_lt"] = dr.To.Format(time.RFC3339Nano)
+	}
+	if len(cond) > 0 {
+		exp[column] = cond
+	}
+	return exp
+}
+
+// Timeline bucket sizes.
+const (
+	bucketDay   = "day"
+	bucketWeek  = "week"
+	bucketMonth = "month"
+)
+
+// bucketStart truncates t (in UTC) to the start of its bucket; weeks start on Monday.
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch bucket {
+	case bucketWeek:
+		offset := (int(day.Weekday()) + 6) % 7 // days since Monday
+		return day.AddDate(0, 0, -offset)
+	case bucketMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day
+}
+
+// nextBucket returns the start of the bucket following start.
+func nextBucket(start time.Time, bucket string) time.Time {
+	switch bucket {
+	case bucketWeek:
+		return start.AddDate(0, 0, 7)
+	case bucketMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
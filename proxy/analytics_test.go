@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseDateRange(t *testing.T) {
+	dr, err := parseDateRange(httptest.NewRequest("GET", "/?from=2024-01-01&to=2024-01-31", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dr.From.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !dr.To.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("plain dates should cover whole days, got %v - %v", dr.From, dr.To)
+	}
+
+	dr, err = parseDateRange(httptest.NewRequest("GET", "/?to=2024-01-31T12:00:00%2B02:00", nil))
+	if err != nil || dr.From != nil || !dr.To.Equal(time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected range %v %v", dr, err)
+	}
+
+	for _, q := range []string{"from=yesterday", "to=2024-13-01", "from=2024-02-01&to=2024-01-01", "from=2024-01-01&to=2023-12-31"} {
+		if _, err := parseDateRange(httptest.NewRequest("GET", "/?"+q, nil)); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+}
+
+func TestDateRangeWhere(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	got := dateRange{From: &from}.where("createdAt", map[string]any{})
+	cond, _ := got["createdAt"].(map[string]any)
+	if len(cond) != 1 || cond["_gte"] != "2024-01-01T00:00:00Z" {
+		t.Fatalf("unexpected where %v", got)
+	}
+	if got := (dateRange{}).where("createdAt", map[string]any{}); len(got) != 0 {
+		t.Fatalf("open ranges add no condition, got %v", got)
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	ts := time.Date(2024, 2, 15, 13, 45, 0, 0, time.FixedZone("EET", 2*3600)) // a Thursday
+	for bucket, want := range map[string]time.Time{
+		bucketDay:   time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC),
+		bucketWeek:  time.Date(2024, 2, 12, 0, 0, 0, 0, time.UTC),
+		bucketMonth: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		if got := bucketStart(ts, bucket); !got.Equal(want) {
+			t.Errorf("%s: got %v, want %v", bucket, got, want)
+		}
+	}
+	sunday := time.Date(2024, 2, 18, 23, 0, 0, 0, time.UTC)
+	if got := bucketStart(sunday, bucketWeek); got.Day() != 12 {
+		t.Errorf("Sunday belongs to the week starting Monday 12th, got %v", got)
+	}
+	if got := nextBucket(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), bucketMonth); got.Month() != time.February {
+		t.Errorf("unexpected next month %v", got)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// apiCaller is the authenticated user behind an /api/me request.
+type apiCaller struct {
+	Token   string
+	Subject string
+	UserID  int // 0 when the token carries no numeric Hasura user id
+	Login   string
+}
+
+// newAPICaller describes the user behind token from its claims.
+func newAPICaller(token, subject string) *apiCaller {
+	c := &apiCaller{Token: token, Subject: subject}
+	if tok, err := parseJWT(token); err == nil {
+		c.UserID, _ = strconv.Atoi(tok.Claims.UserID())
+		c.Login = tok.Claims.Login
+	}
+	return c
+}
+
+// meHandler wraps the read-only /api/me endpoints: CORS, GET only, the bearer token or
+// session cookie (verified locally when JWT_VERIFY is on) and the caller's GraphQL quota,
+// since every endpoint is backed by upstream queries.
+func meHandler(fn func(w http.ResponseWriter, r *http.Request, c *apiCaller)) http.HandlerFunc {
+	return callerHandler([]string{http.MethodGet}, fn)
+}
+
+// callerHandler is meHandler for /api/me endpoints that accept methods other than GET.
+func callerHandler(methods []string, fn func(w http.ResponseWriter, r *http.Request, c *apiCaller)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		withCORS(w, r)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !slices.Contains(methods, r.Method) {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token, ok := requestToken(r)
+		if !ok {
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		if err := verifyLocally(token); err != nil {
+			log.Printf("api rejected token: %v", err)
+			writeTokenError(w, err)
+			return
+		}
+		subject, role := tokenSubject(r.Context(), token)
+		quota := graphqlQuotas.Acquire(subject, role)
+		setRateLimitHeaders(w, quota)
+		if !quota.Allowed {
+			tooManyRequests(w, quota.RetryAfter, quota.Reason)
+			return
+		}
+		defer quota.Release()
+
+		c := newAPICaller(token, subject)
+		sw := &statusWriter{ResponseWriter: w}
+		fn(sw, r, c)
+		if sw.status >= http.StatusBadRequest {
+			return // the upstream may have refused the token: remember only callers it served
+		}
+		if snapshots.enabled() || webhooks.enabled() {
+			if owner, err := c.owner(r.Context()); err == nil {
+				snapshots.observe(owner, c)
+				webhooks.observe(owner, c)
+			}
+		}
+	}
+}
+
+// statusWriter records the status code a handler answered with.
+type statusWriter struct {
+	http.ResponseWriter
+	status int // 0 until the handler writes
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush exports.
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// upstreamQueryError is a GraphQL query issued by the proxy that the upstream did not answer
+// with data.
+type upstreamQueryError struct {
+	Status  int
+	Code    string // extensions.code of the first GraphQL error, if any
+	Message string
+}
+
+func (e *upstreamQueryError) Error() string {
+	return fmt.Sprintf("upstream query failed (status %d): %s", e.Status, e.Message)
+}
+
+// queryUpstream runs query as the caller and decodes its data into out. Top-level fields
+// marked @paginate are fetched completely, as for clients of /graphql.
+func queryUpstream(ctx context.Context, token, query string, vars map[string]any, out any) error {
+	req := graphqlRequest{Query: query, Variables: vars}
+	p, err := parseOperation(req)
+	if err != nil {
+		return err
+	}
+	plan, err := planPagination(p, req)
+	if err != nil {
+		return err
+	}
+	var status int
+	var body []byte
+	if plan != nil {
+		status, body, err = plan.run(ctx, token)
+	} else {
+		var reqBody []byte
+		if reqBody, err = upstreamBody(req); err == nil {
+			status, body, err = fetchGraphQL(ctx, token, reqBody)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []gqlError      `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return &upstreamQueryError{Status: status, Message: "invalid response body"}
+	}
+	if len(resp.Errors) > 0 {
+		code, _ := resp.Errors[0].Extensions["code"].(string)
+		return &upstreamQueryError{Status: status, Code: code, Message: resp.Errors[0].Message}
+	}
+	if status != http.StatusOK || len(resp.Data) == 0 {
+		return &upstreamQueryError{Status: status, Message: "no data"}
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+// writeUpstreamError reports a failed upstream query: 401 when the upstream rejected the
+// token (Hasura answers 200 with an "invalid-jwt" error), 502 otherwise.
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	log.Printf("api upstream error: %v", err)
+	if tokenRejected(err) {
+		unauthorized(w, "upstream rejected token")
+		return
+	}
+	http.Error(w, "upstream query failed", http.StatusBadGateway)
+}
+
+// tokenRejected reports whether err means the upstream no longer accepts the token.
+func tokenRejected(err error) bool {
+	var qe *upstreamQueryError
+	return errors.As(err, &qe) && (qe.Status == http.StatusUnauthorized || qe.Status == http.StatusForbidden || qe.Code == "invalid-jwt")
+}
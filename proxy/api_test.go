@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeZone01 is a small stand-in for the upstream Hasura engine. It serves rows from
+// in-memory tables keyed by root field name, honouring where (column operators and nested
+// relations), limit and offset; order_by is ignored, so tables must be stored in order.
+type fakeZone01 struct {
+	mu     sync.Mutex
+	tables map[string][]map[string]any
+	calls  map[string]int
+	// errors, when set for a root field, is returned instead of its rows.
+	errors map[string]gqlError
+}
+
+func newFakeZone01(t *testing.T) *fakeZone01 {
+	t.Helper()
+	z := &fakeZone01{tables: map[string][]map[string]any{}, calls: map[string]int{}, errors: map[string]gqlError{}}
+	srv := httptest.NewServer(http.HandlerFunc(z.serve))
+	t.Cleanup(srv.Close)
+	overridePaths(t, srv.URL, signinPath, "/graphql")
+	return z
+}
+
+// add appends a row to table; values are normalised through JSON like real responses.
+func (z *fakeZone01) add(table string, row map[string]any) {
+	b, _ := json.Marshal(row)
+	var norm map[string]any
+	json.Unmarshal(b, &norm)
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.tables[table] = append(z.tables[table], norm)
+}
+
+// load adds the rows of testdata/<name>, in the shape of an upstream response, to the
+// tables named by its root fields. transactions.json is still hand-written; a real account
+// can replace it through TestRecordTransactionsFixture.
+func (z *fakeZone01) load(t *testing.T, name string) {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture struct {
+		Data map[string][]map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(b, &fixture); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	for table, rows := range fixture.Data {
+		for _, row := range rows {
+			z.add(table, row)
+		}
+	}
+}
+
+func (z *fakeZone01) callCount(field string) int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.calls[field]
+}
+
+func (z *fakeZone01) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]any{"errors": []gqlError{{Message: err.Error()}}})
+		return
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	data := map[string]any{}
+	for _, sel := range doc.Operations[0].Selections {
+		f := sel.(*gqlField)
+		z.calls[f.Name]++
+		if e, ok := z.errors[f.Name]; ok {
+			json.NewEncoder(w).Encode(map[string]any{"data": nil, "errors": []gqlError{e}})
+			return
+		}
+		rows := []map[string]any{}
+		var where map[string]any
+		if a := f.Argument("where"); a != nil {
+			where, _ = fakeValue(a.Value, req.Variables).(map[string]any)
+		}
+		for _, row := range z.tables[f.Name] {
+			if fakeMatch(row, where) {
+				rows = append(rows, row)
+			}
+		}
+		if f.Name == "user" && len(z.tables["user"]) == 0 {
+			// like Hasura, show the user the token belongs to
+			if tok, err := parseJWT(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")); err == nil {
+				id, _ := strconv.Atoi(tok.Claims.UserID())
+				rows = append(rows, map[string]any{"id": id, "login": tok.Claims.Login})
+			}
+		}
+		if a := f.Argument("offset"); a != nil {
+			n := int(fakeValue(a.Value, req.Variables).(float64))
+			rows = rows[min(n, len(rows)):]
+		}
+		if a := f.Argument("limit"); a != nil {
+			if n, ok := fakeValue(a.Value, req.Variables).(float64); ok {
+				rows = rows[:min(int(n), len(rows))]
+			}
+		}
+		data[f.ResponseKey()] = rows
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+// fakeValue converts a literal to its JSON form, substituting variables.
+func fakeValue(v gqlValue, vars map[string]any) any {
+	switch v.Kind {
+	case gqlVariable:
+		return vars[v.Raw]
+	case gqlInt, gqlFloat:
+		n, _ := strconv.ParseFloat(v.Raw, 64)
+		return n
+	case gqlBoolean:
+		return v.Raw == "true"
+	case gqlNull:
+		return nil
+	case gqlList:
+		out := []any{}
+		for _, item := range v.List {
+			out = append(out, fakeValue(item, vars))
+		}
+		return out
+	case gqlObject:
+		out := map[string]any{}
+		for _, f := range v.Fields {
+			out[f.Name] = fakeValue(f.Value, vars)
+		}
+		return out
+	}
+	return v.Raw
+}
+
+// fakeMatch evaluates a Hasura boolean expression against row.
+func fakeMatch(row map[string]any, where map[string]any) bool {
+	for key, cond := range where {
+		c, _ := cond.(map[string]any)
+		switch key {
+		case "_and":
+			for _, sub := range cond.([]any) {
+				if !fakeMatch(row, sub.(map[string]any)) {
+					return false
+				}
+			}
+			continue
+		case "_or":
+			matched := false
+			for _, sub := range cond.([]any) {
+				matched = matched || fakeMatch(row, sub.(map[string]any))
+			}
+			if !matched {
+				return false
+			}
+			continue
+		}
+		if nested, ok := row[key].(map[string]any); ok {
+			if !fakeMatch(nested, c) {
+				return false
+			}
+			continue
+		}
+		for op, want := range c {
+			if !fakeCompare(row[key], op, want) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func fakeCompare(got any, op string, want any) bool {
+	cmp := func() int {
+		switch g := got.(type) {
+		case float64:
+			w, _ := want.(float64)
+			switch {
+			case g < w:
+				return -1
+			case g > w:
+				return 1
+			}
+			return 0
+		case string:
+			return strings.Compare(g, want.(string))
+		case bool:
+			if g == want {
+				return 0
+			}
+		}
+		return -2
+	}
+	switch op {
+	case "_eq":
+		return cmp() == 0
+	case "_neq":
+		return cmp() != 0
+	case "_gt":
+		return cmp() == 1
+	case "_gte":
+		c := cmp()
+		return c == 0 || c == 1
+	case "_lt":
+		return cmp() == -1
+	case "_lte":
+		c := cmp()
+		return c == 0 || c == -1
+	case "_in", "_nin":
+		found := false
+		for _, item := range want.([]any) {
+			found = found || fakeCompare(got, "_eq", item)
+		}
+		return found == (op == "_in")
+	case "_like":
+		return strings.Contains(got.(string), strings.Trim(want.(string), "%"))
+	}
+	return false
+}
+
+// apiGet sends an authenticated GET to h as user id.
+func apiGet(t *testing.T, h http.Handler, target, userID string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Authorization", "Bearer "+makeJWT(t, zoneClaims(userID, time.Now().Add(time.Hour))))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func useObjectCache(t *testing.T, c *objectCache) {
+	old := objects
+	objects = c
+	t.Cleanup(func() { objects = old })
+}
+
+func TestMeHandlerRequiresGETAndToken(t *testing.T) {
+	h := meHandler(func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		okJSON(w, map[string]any{"userId": c.UserID, "subject": c.Subject})
+	})
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/me/x", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/me/x", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for POST, got %d", rr.Code)
+	}
+	rr = apiGet(t, h, "/api/me/x", "42")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"userId":42`) {
+		t.Fatalf("unexpected response %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestQueryUpstreamErrors(t *testing.T) {
+	z := newFakeZone01(t)
+	z.errors["transaction"] = gqlError{Message: "Could not verify JWT", Extensions: map[string]any{"code": "invalid-jwt"}}
+	rr := apiGet(t, xpTransactionsHandler(), "/api/me/xp/transactions", "42")
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 when upstream rejects the token, got %d", rr.Code)
+	}
+
+	z.errors["transaction"] = gqlError{Message: "boom"}
+	rr = apiGet(t, xpTransactionsHandler(), "/api/me/xp/transactions", "42")
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 on upstream errors, got %d", rr.Code)
+	}
+
+	overridePaths(t, "http://127.0.0.1:0", signinPath, "/graphql")
+	rr = apiGet(t, xpTransactionsHandler(), "/api/me/xp/transactions", "42")
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 when upstream is unreachable, got %d", rr.Code)
+	}
+}
+
+func TestFakeZone01Filters(t *testing.T) {
+	rows := []map[string]any{
+		{"id": 1.0, "type": "xp", "object": map[string]any{"type": "project"}},
+		{"id": 2.0, "type": "level", "object": map[string]any{"type": "exercise"}},
+	}
+	var got []float64
+	for _, row := range rows {
+		if fakeMatch(row, map[string]any{"type": map[string]any{"_in": []any{"xp", "up"}}, "object": map[string]any{"type": map[string]any{"_eq": "project"}}}) {
+			got = append(got, row["id"].(float64))
+		}
+	}
+	sort.Float64s(got)
+	if len(got) != 1 || got[0] != 1 {
+		t.Fatalf("unexpected matches %v", got)
+	}
+}
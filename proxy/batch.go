@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Batches are JSON arrays of GraphQL requests. GQL_BATCH_MAX bounds their length and
+// GQL_BATCH_CONCURRENCY how many of their operations run against the upstream at once.
+var gqlBatchMax = getenvInt("GQL_BATCH_MAX", 10)
+var gqlBatchConcurrency = getenvInt("GQL_BATCH_CONCURRENCY", 4)
+
+// Extension codes of batch entries that did not run: codeUpstreamUnreachable marks entries
+// whose upstream call failed, codeRateLimited those refused by the caller's GraphQL quota.
+const (
+	codeUpstreamUnreachable = "UPSTREAM_UNREACHABLE"
+	codeRateLimited         = "RATE_LIMITED"
+)
+
+// isBatch reports whether a request body is a JSON array.
+func isBatch(body []byte) bool {
+	trimmed := bytes.TrimLeft(body, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// runGraphQLBatch executes every operation of a batch concurrently, at most
+// gqlBatchConcurrency at a time, and answers with their results in request order.
+// Each entry carries its own errors, so one failing operation does not fail the others.
+// Every entry counts against the caller's quota: the first one against the request's, the
+// others take a request each and fail with codeRateLimited once the quota is exhausted.
+func runGraphQLBatch(w http.ResponseWriter, r *http.Request, subject, role, token string, body []byte, mediaType string) {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(body, &entries); err != nil {
+		writeGraphQLError(w, mediaType, http.StatusBadRequest, requestErrorf(codeBadRequest, "The request body must be a JSON array of GraphQL requests."))
+		return
+	}
+	if len(entries) == 0 {
+		writeGraphQLError(w, mediaType, http.StatusBadRequest, requestErrorf(codeBadRequest, "Empty batch."))
+		return
+	}
+	if gqlBatchMax > 0 && len(entries) > gqlBatchMax {
+		writeGraphQLError(w, mediaType, http.StatusBadRequest, requestErrorf(codeBadRequest, "Batch of %d operations exceeds the maximum of %d.", len(entries), gqlBatchMax))
+		return
+	}
+
+	results := make([]json.RawMessage, len(entries))
+	sem := make(chan struct{}, max(gqlBatchConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, raw := range entries {
+		if i > 0 {
+			if q := graphqlQuotas.Take(subject, role); !q.Allowed {
+				results[i] = graphqlErrorResult(requestErrorf(codeRateLimited, "%s, retry in %s", q.Reason, q.RetryAfter.Round(time.Second))).body
+				continue
+			}
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, raw json.RawMessage) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = runBatchEntry(r, subject, token, raw)
+		}(i, raw)
+	}
+	wg.Wait()
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		writeGraphQLError(w, mediaType, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeGraphQLResponse(w, mediaType, http.StatusOK, body)
+}
+
+// runBatchEntry executes one batch operation and returns its result as a JSON value.
+func runBatchEntry(r *http.Request, subject, token string, raw json.RawMessage) json.RawMessage {
+	gqlReq, err := decodeGraphQLRequest(raw)
+	if err != nil {
+		return graphqlErrorResult(requestErrorf(codeBadRequest, "Batch entries must be GraphQL request objects.")).body
+	}
+	res := runGraphQL(r.Context(), subject, token, gqlReq, nil)
+	if res.err != nil {
+		log.Printf("graphql batch proxy error: %v", res.err)
+		return graphqlErrorResult(requestErrorf(codeUpstreamUnreachable, "graphql upstream unreachable")).body
+	}
+	if !json.Valid(res.body) {
+		return graphqlErrorResult(requestErrorf(codeUpstreamUnreachable, "graphql upstream answered with status %d", res.status)).body
+	}
+	return res.body
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func useBatchLimits(t *testing.T, maxEntries, concurrency int) {
+	oldMax, oldConc := gqlBatchMax, gqlBatchConcurrency
+	gqlBatchMax, gqlBatchConcurrency = maxEntries, concurrency
+	t.Cleanup(func() { gqlBatchMax, gqlBatchConcurrency = oldMax, oldConc })
+}
+
+// echoUpstream answers with the request's variables so tests can match results to entries.
+func echoUpstream(t *testing.T, delay time.Duration) (inflightMax *atomic.Int32) {
+	t.Helper()
+	var inflight atomic.Int32
+	inflightMax = &atomic.Int32{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			old := inflightMax.Load()
+			if n <= old || inflightMax.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(delay)
+		var req graphqlRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]any{"data": req.Variables})
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, signinPath, "/graphql")
+	return inflightMax
+}
+
+func postBatch(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer "+makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour))))
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIsBatch(t *testing.T) {
+	for body, want := range map[string]bool{`[{}]`: true, " \n[": true, `{"query":"{ a }"}`: false, ``: false} {
+		if got := isBatch([]byte(body)); got != want {
+			t.Errorf("%q: got %t, want %t", body, got, want)
+		}
+	}
+}
+
+func TestGraphQLBatchKeepsOrderAndPerEntryErrors(t *testing.T) {
+	useBatchLimits(t, 10, 4)
+	echoUpstream(t, 0)
+	rr := postBatch(t, `[
+		{"query": "query MyXp($limit: Int) { transaction(limit: $limit) { id } }", "variables": {"limit": 1}},
+		{"query": "{ user { id "},
+		{"query": "query MyXp($limit: Int) { transaction(limit: $limit) { id } }", "variables": {"limit": 3}},
+		42
+	]`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var results []struct {
+		Data   map[string]any `json:"data"`
+		Errors []gqlError     `json:"errors"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil || len(results) != 4 {
+		t.Fatalf("expected 4 results, got %s", rr.Body.String())
+	}
+	if results[0].Data["limit"] != float64(1) || results[2].Data["limit"] != float64(3) {
+		t.Fatalf("results out of order: %s", rr.Body.String())
+	}
+	if len(results[1].Errors) != 1 || results[1].Errors[0].Extensions["code"] != codeParseFailed {
+		t.Fatalf("expected a parse error for entry 1: %s", rr.Body.String())
+	}
+	if len(results[3].Errors) != 1 || results[3].Errors[0].Extensions["code"] != codeBadRequest {
+		t.Fatalf("expected a bad request error for entry 3: %s", rr.Body.String())
+	}
+}
+
+func TestGraphQLBatchBoundsFanOut(t *testing.T) {
+	useBatchLimits(t, 10, 2)
+	inflightMax := echoUpstream(t, 20*time.Millisecond)
+	var entries []map[string]any
+	for i := 0; i < 6; i++ {
+		entries = append(entries, map[string]any{"query": "query Q($n: Int) { a(limit: $n) { id } }", "variables": map[string]any{"n": i}})
+	}
+	body, _ := json.Marshal(entries)
+	rr := postBatch(t, string(body))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if got := inflightMax.Load(); got != 2 {
+		t.Fatalf("expected at most 2 concurrent upstream calls, saw %d", got)
+	}
+}
+
+func TestGraphQLBatchRejectsBadBatches(t *testing.T) {
+	useBatchLimits(t, 2, 2)
+	echoUpstream(t, 0)
+	for _, body := range []string{`[]`, `[{"query":"{ a }"},{"query":"{ b }"},{"query":"{ c }"}]`, `[{"query":`} {
+		if rr := postBatch(t, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rr.Code)
+		}
+	}
+}
+
+func TestGraphQLBatchUpstreamFailureStaysInEntry(t *testing.T) {
+	useBatchLimits(t, 10, 4)
+	overridePaths(t, "http://127.0.0.1:0", signinPath, "/graphql")
+	rr := postBatch(t, fmt.Sprintf(`[{"query":%q}]`, queryMe))
+	body, _ := io.ReadAll(rr.Body)
+	var results []gqlErrorResponse
+	if err := json.Unmarshal(body, &results); err != nil || len(results) != 1 {
+		t.Fatalf("unexpected body %s", body)
+	}
+	if rr.Code != http.StatusOK || results[0].Errors[0].Extensions["code"] != codeUpstreamUnreachable {
+		t.Fatalf("expected %s inside the batch, got %d %s", codeUpstreamUnreachable, rr.Code, body)
+	}
+}
+
+func TestGraphQLBatchEntriesCountAgainstTheQuota(t *testing.T) {
+	useBatchLimits(t, 10, 4)
+	useQuotas(t, newQuotaManager(quota{Rate: 1, Burst: 2, Concurrent: 1}, nil))
+	echoUpstream(t, 0)
+	rr := postBatch(t, `[{"query":"{ a }","variables":{"n":0}},{"query":"{ a }","variables":{"n":1}},{"query":"{ a }","variables":{"n":2}}]`)
+	var results []gqlErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil || len(results) != 3 {
+		t.Fatalf("unexpected body %s", rr.Body.String())
+	}
+	if len(results[0].Errors) != 0 || len(results[1].Errors) != 0 || results[2].Errors[0].Extensions["code"] != codeRateLimited {
+		t.Fatalf("only the entries within the quota should run, got %s", rr.Body.String())
+	}
+}
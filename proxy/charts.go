@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server-side versions of the SvgXpOverTime, SvgXpByProject and SvgPassFailDonut components,
+// rendered as standalone documents for READMEs, emails and chat. Geometry follows the
+// components; colours come from a theme instead of CSS variables.
+
+// chartTheme holds the colours of a rendered chart.
+type chartTheme struct {
+	Background string
+	Text       string
+	Track      string // donut background ring
+	Pass       string
+	Fail       string
+}
+
+var chartThemes = map[string]chartTheme{
+	"light": {Background: "#ffffff", Text: "#213547", Track: "#e5e7eb", Pass: "#10b981", Fail: "#ef4444"},
+	"dark":  {Background: "#242424", Text: "#dedede", Track: "#3f3f46", Pass: "#10b981", Fail: "#ef4444"},
+}
+
+// Bounds for the width and height parameters.
+const (
+	chartMinSize = 100
+	chartMaxSize = 2000
+)
+
+// chartOptions are the rendering parameters shared by every chart.
+type chartOptions struct {
+	Width, Height int
+	Theme         chartTheme
+}
+
+// parseChartOptions reads ?theme=light|dark (default light), ?width= and ?height=, falling
+// back to the component defaults w and h.
+func parseChartOptions(r *http.Request, w, h int) (chartOptions, error) {
+	q := r.URL.Query()
+	name := q.Get("theme")
+	if name == "" {
+		name = "light"
+	}
+	theme, ok := chartThemes[name]
+	if !ok {
+		return chartOptions{}, fmt.Errorf("theme must be light or dark")
+	}
+	o := chartOptions{Width: w, Height: h, Theme: theme}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"width", &o.Width}, {"height", &o.Height}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < chartMinSize || n > chartMaxSize {
+			return chartOptions{}, fmt.Errorf("%s must be an integer between %d and %d", p.name, chartMinSize, chartMaxSize)
+		}
+		*p.dst = n
+	}
+	return o, nil
+}
+
+// svgWriter builds an SVG document. Attributes are given as name/value pairs; numbers are
+// rounded to two decimals so output is stable across platforms.
+type svgWriter struct {
+	b strings.Builder
+}
+
+func newSVG(o chartOptions) *svgWriter {
+	s := &svgWriter{}
+	fmt.Fprintf(&s.b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="system-ui, Avenir, Helvetica, Arial, sans-serif">`, o.Width, o.Height, o.Width, o.Height)
+	s.b.WriteByte('\n')
+	s.el("rect", "width", o.Width, "height", o.Height, "fill", o.Theme.Background)
+	return s
+}
+
+func svgNum(v float64) string {
+	v = math.Round(v*100) / 100
+	if v == 0 {
+		v = 0 // no "-0"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func (s *svgWriter) start(name string, attrs ...any) {
+	s.b.WriteByte('<')
+	s.b.WriteString(name)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.b.WriteByte(' ')
+		s.b.WriteString(attrs[i].(string))
+		s.b.WriteString(`="`)
+		switch v := attrs[i+1].(type) {
+		case float64:
+			s.b.WriteString(svgNum(v))
+		case int:
+			s.b.WriteString(strconv.Itoa(v))
+		default:
+			xml.EscapeText(&s.b, []byte(fmt.Sprint(v)))
+		}
+		s.b.WriteByte('"')
+	}
+}
+
+// el writes an empty element.
+func (s *svgWriter) el(name string, attrs ...any) {
+	s.start(name, attrs...)
+	s.b.WriteString("/>\n")
+}
+
+// text writes a text element with escaped content.
+func (s *svgWriter) text(content string, attrs ...any) {
+	s.content("text", content, attrs...)
+}
+
+// content writes an element holding escaped text.
+func (s *svgWriter) content(name, text string, attrs ...any) {
+	s.start(name, attrs...)
+	s.b.WriteByte('>')
+	xml.EscapeText(&s.b, []byte(text))
+	s.b.WriteString("</" + name + ">\n")
+}
+
+func (s *svgWriter) open(name string, attrs ...any) {
+	s.start(name, attrs...)
+	s.b.WriteString(">\n")
+}
+
+func (s *svgWriter) close(name string) {
+	s.b.WriteString("</" + name + ">\n")
+}
+
+func (s *svgWriter) bytes() []byte {
+	s.close("svg")
+	return []byte(s.b.String())
+}
+
+// chartPadding is the plot area inset used by the axis charts.
+type chartPadding struct{ t, r, b, l float64 }
+
+// inner returns the size of the plot area, which is empty when the chart is smaller than
+// the padding.
+func (pad chartPadding) inner(o chartOptions) (w, h float64) {
+	return math.Max(0, float64(o.Width)-pad.l-pad.r), math.Max(0, float64(o.Height)-pad.t-pad.b)
+}
+
+// renderXPOverTime plots cumulative XP; txs must be oldest first.
+func renderXPOverTime(txs []transaction, o chartOptions) []byte {
+	s := newSVG(o)
+	if len(txs) == 0 {
+		s.text("No XP yet", "x", 16, "y", 24, "font-size", 14, "fill", o.Theme.Text)
+		return s.bytes()
+	}
+	pad := chartPadding{t: 20, r: 20, b: 40, l: 48}
+	innerW, innerH := pad.inner(o)
+
+	cum := make([]float64, len(txs))
+	var sum float64
+	for i, tx := range txs {
+		sum += tx.Amount
+		cum[i] = sum
+	}
+	ymax := 0.0
+	for _, v := range cum {
+		ymax = math.Max(ymax, v)
+	}
+	t0, t1 := txs[0].CreatedAt, txs[len(txs)-1].CreatedAt
+	span := float64(t1.Sub(t0))
+	if span == 0 {
+		span = 1
+	}
+	x := func(t time.Time) float64 { return float64(t.Sub(t0)) / span * innerW }
+	y := func(v float64) float64 {
+		if ymax == 0 {
+			return innerH
+		}
+		return innerH - v/ymax*innerH
+	}
+
+	drawAxes(s, o, pad, innerW, innerH)
+	const xticks, yticks = 6, 5
+	for i := 0; i < xticks; i++ {
+		d := t0.Add(time.Duration(float64(i) / (xticks - 1) * float64(t1.Sub(t0))))
+		xx := pad.l + x(d)
+		s.el("line", "x1", xx, "x2", xx, "y1", pad.t+innerH, "y2", pad.t+innerH+6, "stroke", o.Theme.Text)
+		s.text(d.UTC().Format(time.DateOnly), "x", xx, "y", pad.t+innerH+20, "text-anchor", "middle", "font-size", 10, "fill", o.Theme.Text)
+	}
+	for i := 0; i < yticks; i++ {
+		v := math.Round(float64(i) / (yticks - 1) * ymax)
+		yy := pad.t + y(v)
+		s.el("line", "x1", pad.l-6, "x2", pad.l, "y1", yy, "y2", yy, "stroke", o.Theme.Text)
+		s.text(strconv.FormatFloat(v, 'f', -1, 64), "x", pad.l-10, "y", yy+3, "text-anchor", "end", "font-size", 10, "fill", o.Theme.Text)
+		s.el("line", "x1", pad.l, "x2", pad.l+innerW, "y1", yy, "y2", yy, "stroke", o.Theme.Text, "stroke-opacity", 0.1)
+	}
+
+	var d strings.Builder
+	for i, tx := range txs {
+		if i == 0 {
+			d.WriteString("M ")
+		} else {
+			d.WriteString(" L ")
+		}
+		d.WriteString(svgNum(pad.l + x(tx.CreatedAt)))
+		d.WriteByte(' ')
+		d.WriteString(svgNum(pad.t + y(cum[i])))
+	}
+	s.el("path", "d", d.String(), "fill", "none", "stroke", o.Theme.Text, "stroke-width", 2)
+	return s.bytes()
+}
+
+// drawAxes draws the x and y axis lines of the plot area.
+func drawAxes(s *svgWriter, o chartOptions, pad chartPadding, innerW, innerH float64) {
+	s.el("line", "x1", pad.l, "y1", pad.t+innerH, "x2", pad.l+innerW, "y2", pad.t+innerH, "stroke", o.Theme.Text)
+	s.el("line", "x1", pad.l, "y1", pad.t, "x2", pad.l, "y2", pad.t+innerH, "stroke", o.Theme.Text)
+}
+
+// chartLabelMax is the longest project name drawn next to a bar; the label column is
+// 160px wide and there is no text measurement on the server.
+const chartLabelMax = 24
+
+// renderXPByProject draws one horizontal bar per project, others last when present.
+func renderXPByProject(projects []projectXP, others *projectXP, o chartOptions) []byte {
+	s := newSVG(o)
+	rows := projects
+	if others != nil {
+		rows = append(rows[:len(rows):len(rows)], *others)
+	}
+	if len(rows) == 0 {
+		s.text("No project XP yet", "x", 16, "y", 24, "font-size", 14, "fill", o.Theme.Text)
+		return s.bytes()
+	}
+	pad := chartPadding{t: 20, r: 20, b: 40, l: 160}
+	innerW, innerH := pad.inner(o)
+
+	xmax := 0.0
+	for _, r := range rows {
+		xmax = math.Max(xmax, r.XP)
+	}
+	x := func(v float64) float64 {
+		if xmax == 0 {
+			return 0
+		}
+		return v / xmax * innerW
+	}
+	n := float64(len(rows))
+	barH := innerH / n * 0.7
+	gap := (innerH - barH*n) / math.Max(n-1, 1)
+
+	drawAxes(s, o, pad, innerW, innerH)
+	for i, r := range rows {
+		name := r.Name
+		if runes := []rune(name); len(runes) > chartLabelMax {
+			name = string(runes[:chartLabelMax-1]) + "…"
+		}
+		s.open("g", "transform", "translate("+svgNum(pad.l)+","+svgNum(pad.t+float64(i)*(barH+gap))+")")
+		s.el("rect", "width", x(r.XP), "height", barH, "fill", o.Theme.Text)
+		s.text(name, "x", -10, "y", barH/2+4, "text-anchor", "end", "font-size", 12, "fill", o.Theme.Text)
+		s.text(strconv.FormatFloat(r.XP, 'f', -1, 64), "x", x(r.XP)+6, "y", barH/2+4, "font-size", 12, "fill", o.Theme.Text)
+		s.close("g")
+	}
+	return s.bytes()
+}
+
+// renderPassFail draws the pass rate donut with its legend.
+func renderPassFail(pass, fail int, o chartOptions) []byte {
+	s := newSVG(o)
+	total := pass + fail
+	rate := 0.0
+	if total > 0 {
+		rate = float64(pass) / float64(total)
+	}
+	size := math.Min(float64(o.Width), float64(o.Height))
+	cx, cy := size/2, size/2
+	r := size * 0.34
+	stroke := math.Max(10, size*0.12)
+	c := 2 * math.Pi * r
+	passLen := c * rate
+	failLen := c - passLen
+	offset := "translate(" + svgNum((float64(o.Width)-size)/2) + "," + svgNum((float64(o.Height)-size)/2) + ")"
+
+	s.open("g", "transform", offset+" rotate(-90 "+svgNum(cx)+" "+svgNum(cy)+")")
+	s.el("circle", "cx", cx, "cy", cy, "r", r, "fill", "none", "stroke", o.Theme.Track, "stroke-width", stroke)
+	if total > 0 {
+		s.el("circle", "cx", cx, "cy", cy, "r", r, "fill", "none", "stroke", o.Theme.Fail, "stroke-width", stroke,
+			"stroke-dasharray", svgNum(failLen)+" "+svgNum(c-failLen), "transform", "rotate("+svgNum(rate*360)+" "+svgNum(cx)+" "+svgNum(cy)+")")
+		s.el("circle", "cx", cx, "cy", cy, "r", r, "fill", "none", "stroke", o.Theme.Pass, "stroke-width", stroke,
+			"stroke-linecap", "round", "stroke-dasharray", svgNum(passLen)+" "+svgNum(c-passLen))
+	}
+	s.close("g")
+
+	s.open("g", "transform", offset)
+	s.text(strconv.Itoa(int(math.Round(rate*100)))+"%", "x", cx, "y", cy-8, "text-anchor", "middle", "font-size", 18, "font-weight", 600, "fill", o.Theme.Text)
+	s.text("Pass rate", "x", cx, "y", cy+14, "text-anchor", "middle", "font-size", 12, "fill", o.Theme.Text)
+	for _, l := range []struct {
+		dx     float64
+		colour string
+		label  string
+	}{{-60, o.Theme.Pass, "Pass: " + strconv.Itoa(pass)}, {30, o.Theme.Fail, "Fail: " + strconv.Itoa(fail)}} {
+		s.open("g", "transform", "translate("+svgNum(cx+l.dx)+","+svgNum(size-12)+")")
+		s.el("rect", "width", 10, "height", 10, "rx", 2, "fill", l.colour)
+		s.text(l.label, "x", 16, "y", 10, "font-size", 12, "fill", o.Theme.Text)
+		s.close("g")
+	}
+	s.close("g")
+	return s.bytes()
+}
+
+// writeSVG sends a rendered chart.
+func writeSVG(w http.ResponseWriter, svg []byte) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(svg)
+}
+
+// chartHandler wraps the chart endpoints: it parses the theme and size parameters, with the
+// component's default size, before handing them to render.
+func chartHandler(width, height int, render func(w http.ResponseWriter, r *http.Request, c *apiCaller, o chartOptions)) http.HandlerFunc {
+	return meHandler(func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		o, err := parseChartOptions(r, width, height)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		render(w, r, c, o)
+	})
+}
+
+// xpOverTimeChartHandler renders cumulative XP within from/to.
+func xpOverTimeChartHandler() http.HandlerFunc {
+	return chartHandler(760, 320, func(w http.ResponseWriter, r *http.Request, c *apiCaller, o chartOptions) {
+		dr, err := parseDateRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		txs, err := fetchXPTransactions(r.Context(), c.Token, dr)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		writeSVG(w, renderXPOverTime(txs, o))
+	})
+}
+
+// xpByProjectChartHandler renders XP per project within from/to; ?top=N (default 10, as
+// in the dashboard) keeps the N largest and lumps the rest into "Others".
+func xpByProjectChartHandler() http.HandlerFunc {
+	return chartHandler(760, 360, func(w http.ResponseWriter, r *http.Request, c *apiCaller, o chartOptions) {
+		dr, err := parseDateRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		top := 10
+		if v := r.URL.Query().Get("top"); v != "" {
+			if top, err = strconv.Atoi(v); err != nil || top <= 0 {
+				http.Error(w, "top must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+		txs, err := fetchXPTransactions(r.Context(), c.Token, dr)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		projects, others := topProjects(xpByProject(txs), top)
+		writeSVG(w, renderXPByProject(projects, others, o))
+	})
+}
+
+// passFailChartHandler renders the pass rate of finished results within from/to, using the
+// same pass rule as /api/me/progress/stats.
+func passFailChartHandler() http.HandlerFunc {
+	return chartHandler(280, 220, func(w http.ResponseWriter, r *http.Request, c *apiCaller, o chartOptions) {
+		dr, err := parseDateRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, err := fetchProgress(r.Context(), c, dr)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		stats := progressStats(entries)
+		writeSVG(w, renderPassFail(stats.Pass, stats.Fail, o))
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata/charts")
+
+// checkGolden compares got with testdata/charts/<name>, rewriting it with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "charts", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -run %s -update to create it)", err, t.Name())
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s differs from the golden file; run go test -run %s -update and review the diff\n%s", name, t.Name(), got)
+	}
+	dec := xml.NewDecoder(bytes.NewReader(got))
+	for {
+		if _, err := dec.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("%s is not well-formed: %v", name, err)
+		}
+	}
+}
+
+func chartTxs() []transaction {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	return []transaction{
+		{ID: 1, Amount: 1000, CreatedAt: day(1)},
+		{ID: 2, Amount: 2500, CreatedAt: day(8)},
+		{ID: 3, Amount: 500, CreatedAt: day(20)},
+		{ID: 4, Amount: 4000, CreatedAt: day(31)},
+	}
+}
+
+func TestRenderXPOverTimeGolden(t *testing.T) {
+	checkGolden(t, "xp-over-time-light.svg", renderXPOverTime(chartTxs(), chartOptions{Width: 760, Height: 320, Theme: chartThemes["light"]}))
+	checkGolden(t, "xp-over-time-dark-400x200.svg", renderXPOverTime(chartTxs(), chartOptions{Width: 400, Height: 200, Theme: chartThemes["dark"]}))
+	checkGolden(t, "xp-over-time-empty.svg", renderXPOverTime(nil, chartOptions{Width: 760, Height: 320, Theme: chartThemes["light"]}))
+}
+
+func TestRenderXPByProjectGolden(t *testing.T) {
+	id := 10
+	projects := []projectXP{
+		{ObjectID: &id, Name: "ascii-art-web-stylize-and-dockerize", Type: "project", XP: 24500, Count: 1},
+		{Name: "go-reloaded", Type: "project", XP: 9000, Count: 2},
+		{Name: "<checkpoint & co>", XP: 500, Count: 1},
+	}
+	others := &projectXP{Name: "Others", XP: 1200, Count: 3}
+	checkGolden(t, "xp-by-project-light.svg", renderXPByProject(projects, others, chartOptions{Width: 760, Height: 360, Theme: chartThemes["light"]}))
+	checkGolden(t, "xp-by-project-dark.svg", renderXPByProject(projects[:2], nil, chartOptions{Width: 600, Height: 240, Theme: chartThemes["dark"]}))
+	checkGolden(t, "xp-by-project-empty.svg", renderXPByProject(nil, nil, chartOptions{Width: 760, Height: 360, Theme: chartThemes["light"]}))
+}
+
+func TestRenderPassFailGolden(t *testing.T) {
+	checkGolden(t, "pass-fail-light.svg", renderPassFail(7, 3, chartOptions{Width: 280, Height: 220, Theme: chartThemes["light"]}))
+	checkGolden(t, "pass-fail-dark-400x400.svg", renderPassFail(1, 2, chartOptions{Width: 400, Height: 400, Theme: chartThemes["dark"]}))
+	checkGolden(t, "pass-fail-empty.svg", renderPassFail(0, 0, chartOptions{Width: 280, Height: 220, Theme: chartThemes["light"]}))
+}
+
+func TestChartHandlers(t *testing.T) {
+	usePassThreshold(t, 1)
+	useObjectCache(t, newObjectCache(time.Hour, 100))
+	z := newFakeZone01(t)
+	seedXP(z)
+	seedProgress(z)
+
+	for _, tc := range []struct {
+		h      http.Handler
+		target string
+		want   string
+	}{
+		{xpOverTimeChartHandler(), "/api/me/charts/xp-over-time.svg?theme=dark", `fill="#242424"`},
+		{xpByProjectChartHandler(), "/api/me/charts/xp-by-project.svg?top=1", ">Others</text>"},
+		{passFailChartHandler(), "/api/me/charts/pass-fail.svg?width=300&height=300", ">Pass: 2</text>"},
+	} {
+		rr := apiGet(t, tc.h, tc.target, "42")
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/svg+xml" {
+			t.Fatalf("%s: unexpected response %d %v", tc.target, rr.Code, rr.Header())
+		}
+		if !strings.Contains(rr.Body.String(), tc.want) {
+			t.Fatalf("%s: expected %s in\n%s", tc.target, tc.want, rr.Body.String())
+		}
+	}
+	if rr := apiGet(t, passFailChartHandler(), "/api/me/charts/pass-fail.svg?width=300&height=300", "42"); !strings.Contains(rr.Body.String(), `width="300" height="300"`) {
+		t.Fatalf("size parameters ignored: %s", rr.Body.String())
+	}
+}
+
+func TestChartOptionsValidation(t *testing.T) {
+	for _, target := range []string{"?theme=sepia", "?width=50", "?height=5000", "?width=wide"} {
+		if rr := apiGet(t, xpOverTimeChartHandler(), "/api/me/charts/xp-over-time.svg"+target, "42"); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", target, rr.Code)
+		}
+	}
+}
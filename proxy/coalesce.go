@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// gqlCoalesce collapses identical in-flight queries bearing the same token into one upstream
+// call; GQL_COALESCE=false opts out.
+var gqlCoalesce = getenv("GQL_COALESCE", "true") != "false"
+
+// gqlFlights tracks the upstream calls currently shared by graphqlHandler.
+var gqlFlights = newFlightGroup()
+
+// flightCall is one upstream call and the callers waiting for it.
+type flightCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	status  int
+	body    []byte
+	err     error
+}
+
+// flightGroup runs at most one call per key at a time, in the spirit of x/sync/singleflight,
+// but callers may give up independently: the call is detached from the context of whoever
+// started it and is only cancelled once every waiter has left.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: map[string]*flightCall{}}
+}
+
+// Do returns the result of fn for key, joining a call that is already running when there is one.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func(context.Context) (int, []byte, error)) (int, []byte, error) {
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		go g.run(key, c, callCtx, fn)
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.status, c.body, c.err
+	case <-ctx.Done():
+		g.leave(key, c)
+		return 0, nil, ctx.Err()
+	}
+}
+
+// run executes fn and publishes its result to every waiter of c.
+func (g *flightGroup) run(key string, c *flightCall, ctx context.Context, fn func(context.Context) (int, []byte, error)) {
+	c.status, c.body, c.err = fn(ctx)
+	g.mu.Lock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	c.cancel()
+	close(c.done)
+}
+
+// leave drops one waiter from c, cancelling the call when nobody is left waiting for it.
+func (g *flightGroup) leave(key string, c *flightCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c.waiters--; c.waiters > 0 {
+		return
+	}
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	c.cancel()
+}
+
+// fetchShared runs fetch with identical concurrent requests sharing one upstream call; an
+// empty key opts out, as do mutations and subscriptions, whose callers get a key of "".
+func fetchShared(ctx context.Context, key string, fetch func(context.Context) (int, []byte, error)) (int, []byte, error) {
+	if key == "" || !gqlCoalesce {
+		return fetch(ctx)
+	}
+	return gqlFlights.Do(ctx, key, fetch)
+}
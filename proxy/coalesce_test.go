@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForWaiters blocks until n callers are waiting on the flight for key.
+func waitForWaiters(t *testing.T, g *flightGroup, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		g.mu.Lock()
+		c, ok := g.calls[key]
+		got := 0
+		if ok {
+			got = c.waiters
+		}
+		g.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d waiters on %q", n, key)
+}
+
+func TestFlightGroupSharesOneCall(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	var calls atomic.Int32
+	fn := func(ctx context.Context) (int, []byte, error) {
+		calls.Add(1)
+		<-release
+		return http.StatusOK, []byte("result"), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, body, err := g.Do(context.Background(), "k", fn)
+			if err == nil {
+				results[i] = string(body)
+			}
+		}(i)
+	}
+	waitForWaiters(t, g, "k", 5)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("expected one call, got %d", calls.Load())
+	}
+	for i, r := range results {
+		if r != "result" {
+			t.Fatalf("waiter %d got %q", i, r)
+		}
+	}
+	if len(g.calls) != 0 {
+		t.Fatal("finished calls should be forgotten")
+	}
+}
+
+func TestFlightGroupCancelledWaiterDoesNotAbortOthers(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (int, []byte, error) {
+		select {
+		case <-release:
+			return http.StatusOK, []byte("ok"), nil
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, _, err := g.Do(leaderCtx, "k", fn)
+		leaderErr <- err
+	}()
+	waitForWaiters(t, g, "k", 1)
+
+	followerBody := make(chan string, 1)
+	go func() {
+		_, body, _ := g.Do(context.Background(), "k", fn)
+		followerBody <- string(body)
+	}()
+	waitForWaiters(t, g, "k", 2)
+
+	cancelLeader()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller should get its context error, got %v", err)
+	}
+	close(release)
+	if got := <-followerBody; got != "ok" {
+		t.Fatalf("remaining waiter should still get the result, got %q", got)
+	}
+}
+
+func TestFlightGroupCancelsWhenEveryoneLeaves(t *testing.T) {
+	g := newFlightGroup()
+	aborted := make(chan struct{})
+	fn := func(ctx context.Context) (int, []byte, error) {
+		<-ctx.Done()
+		close(aborted)
+		return 0, nil, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		g.Do(ctx, "k", fn)
+		close(done)
+	}()
+	waitForWaiters(t, g, "k", 1)
+	cancel()
+	<-done
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream call should be cancelled once no caller is waiting")
+	}
+}
+
+func TestGraphQLHandlerCoalescesIdenticalQueries(t *testing.T) {
+	useCache(t, 0, map[string]time.Duration{}, newResponseCache(1<<20))
+	g := newFlightGroup()
+	oldFlights, oldCoalesce := gqlFlights, gqlCoalesce
+	gqlFlights, gqlCoalesce = g, true
+	t.Cleanup(func() { gqlFlights, gqlCoalesce = oldFlights, oldCoalesce })
+
+	release := make(chan struct{})
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, _ := io.ReadAll(r.Body); !strings.Contains(string(body), identityQuery) {
+			calls.Add(1) // identity queries for quotas are answered at once
+			<-release
+		}
+		io.WriteString(w, `{"data":{"user":[{"id":42}]}}`)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, signinPath, "/graphql")
+
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	var wg sync.WaitGroup
+	bodies := make([]string, 3)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr := cachedRequest(t, token, queryMe, nil, nil)
+			bodies[i] = rr.Body.String()
+		}(i)
+	}
+
+	p, err := parseOperation(graphqlRequest{Query: queryMe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := tokenSubject(context.Background(), token)
+	key, _ := operationKey(subject, token, p, graphqlRequest{Query: queryMe})
+	waitForWaiters(t, g, key, 3)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("expected a single upstream call, got %d", calls.Load())
+	}
+	for i, b := range bodies {
+		if !strings.Contains(b, `"id":42`) {
+			t.Fatalf("request %d got %q", i, b)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// /events pushes the caller's new transactions and changed progress rows as Server-Sent
+// Events. The proxy polls the upstream on the caller's behalf every EVENTS_POLL_INTERVAL
+// and writes a comment every EVENTS_KEEPALIVE so idle connections survive intermediaries.
+var eventsPollInterval = getenvDuration("EVENTS_POLL_INTERVAL", 30*time.Second)
+var eventsKeepAlive = getenvDuration("EVENTS_KEEPALIVE", 15*time.Second)
+
+// Live event types.
+const (
+	liveTransactionCreated = "transaction.created"
+	liveProgressUpdated    = "progress.updated"
+	liveReady              = "ready"         // first event of a stream, carries the starting cursor
+	liveTokenExpired       = "token-expired" // last event of a stream whose token stopped working
+)
+
+// liveCursor is the position of a stream: the last transaction id and the (updatedAt, id)
+// of the last progress row sent. Its string form is the SSE event id, so a reconnecting
+// client resumes where it stopped with Last-Event-ID.
+type liveCursor struct {
+	Transaction       int
+	ProgressUpdatedAt time.Time
+	Progress          int
+}
+
+func (c liveCursor) String() string {
+	var at int64 // the zero time stays 0
+	if !c.ProgressUpdatedAt.IsZero() {
+		at = c.ProgressUpdatedAt.UnixMicro()
+	}
+	return fmt.Sprintf("%d:%d:%d", c.Transaction, at, c.Progress)
+}
+
+// parseLiveCursor reads a cursor written by String.
+func parseLiveCursor(s string) (liveCursor, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return liveCursor{}, errors.New("malformed cursor")
+	}
+	var n [3]int64
+	for i, p := range parts {
+		v, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || v < 0 {
+			return liveCursor{}, errors.New("malformed cursor")
+		}
+		n[i] = v
+	}
+	c := liveCursor{Transaction: int(n[0]), Progress: int(n[2])}
+	if n[1] != 0 {
+		c.ProgressUpdatedAt = time.UnixMicro(n[1]).UTC()
+	}
+	return c, nil
+}
+
+// after reports whether p sorts after the progress position of c.
+func (c liveCursor) after(p progressEntry) bool {
+	return p.UpdatedAt.After(c.ProgressUpdatedAt) || p.UpdatedAt.Equal(c.ProgressUpdatedAt) && p.ID > c.Progress
+}
+
+// liveEvent is one event of a stream; ID is the cursor just after it.
+type liveEvent struct {
+	ID   string
+	Type string
+	Data any
+}
+
+// livePoller finds the rows of one user that changed since its cursor.
+type livePoller struct {
+	caller  *apiCaller
+	cursor  liveCursor
+	resumed bool // started from a client's cursor rather than the current rows
+}
+
+// newLivePoller starts at resume, an event id previously sent to the client, or at the
+// caller's current rows when resume is empty or malformed.
+func newLivePoller(ctx context.Context, c *apiCaller, resume string) (*livePoller, error) {
+	p := &livePoller{caller: c}
+	if cur, err := parseLiveCursor(resume); err == nil {
+		p.cursor, p.resumed = cur, true
+		return p, nil
+	}
+	txs, err := fetchTransactions(ctx, c.Token, map[string]any{})
+	if err != nil {
+		return nil, err
+	}
+	progress, err := fetchProgressWhere(ctx, c, map[string]any{})
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range txs {
+		p.cursor.Transaction = max(p.cursor.Transaction, tx.ID)
+	}
+	for _, e := range progress {
+		if p.cursor.after(e) {
+			p.cursor.ProgressUpdatedAt, p.cursor.Progress = e.UpdatedAt, e.ID
+		}
+	}
+	return p, nil
+}
+
+// poll returns the transactions created and progress rows updated since the cursor, in
+// that order and each oldest first, and advances the cursor.
+func (p *livePoller) poll(ctx context.Context) ([]liveEvent, error) {
+	txs, err := fetchTransactions(ctx, p.caller.Token, map[string]any{"id": map[string]any{"_gt": p.cursor.Transaction}})
+	if err != nil {
+		return nil, err
+	}
+	where := map[string]any{}
+	if !p.cursor.ProgressUpdatedAt.IsZero() {
+		where["updatedAt"] = map[string]any{"_gte": p.cursor.ProgressUpdatedAt.Format(time.RFC3339Nano)}
+	}
+	progress, err := fetchProgressWhere(ctx, p.caller, where)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].ID < txs[j].ID })
+	sort.Slice(progress, func(i, j int) bool {
+		a, b := progress[i], progress[j]
+		return a.UpdatedAt.Before(b.UpdatedAt) || a.UpdatedAt.Equal(b.UpdatedAt) && a.ID < b.ID
+	})
+
+	var events []liveEvent
+	for _, tx := range txs {
+		p.cursor.Transaction = tx.ID
+		events = append(events, liveEvent{ID: p.cursor.String(), Type: liveTransactionCreated, Data: tx})
+	}
+	for _, e := range progress {
+		if !p.cursor.after(e) {
+			continue
+		}
+		p.cursor.ProgressUpdatedAt, p.cursor.Progress = e.UpdatedAt, e.ID
+		events = append(events, liveEvent{ID: p.cursor.String(), Type: liveProgressUpdated, Data: e})
+	}
+	return events, nil
+}
+
+// writeSSE writes one event; data is encoded as a single line of JSON.
+func writeSSE(w http.ResponseWriter, e liveEvent) error {
+	b, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	if e.ID != "" {
+		sb.WriteString("id: " + e.ID + "\n")
+	}
+	sb.WriteString("event: " + e.Type + "\ndata: ")
+	sb.Write(b)
+	sb.WriteString("\n\n")
+	_, err = w.Write([]byte(sb.String()))
+	return err
+}
+
+// eventsHandler serves GET /events, authenticated like /graphql. The stream starts with a
+// "ready" event whose id is the starting cursor, so a client that reconnects before any
+// change still resumes without gaps. Each poll counts against the caller's GraphQL quota
+// and is skipped while it is exhausted; the stream ends once the token expires or the
+// upstream rejects it.
+func eventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		withCORS(w, r)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token, ok := requestToken(r)
+		if !ok {
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		if err := verifyLocally(token); err != nil {
+			log.Printf("events rejected token: %v", err)
+			writeTokenError(w, err)
+			return
+		}
+		subject, role := tokenSubject(r.Context(), token)
+		c := newAPICaller(token, subject)
+
+		quota := graphqlQuotas.Acquire(subject, role)
+		setRateLimitHeaders(w, quota)
+		if !quota.Allowed {
+			tooManyRequests(w, quota.RetryAfter, quota.Reason)
+			return
+		}
+		poller, err := newLivePoller(r.Context(), c, r.Header.Get("Last-Event-ID"))
+		quota.Release()
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-store")
+		h.Set("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+		rc := http.NewResponseController(w)
+		fmt.Fprintf(w, "retry: %d\n\n", eventsPollInterval.Milliseconds())
+		if writeSSE(w, liveEvent{ID: poller.cursor.String(), Type: liveReady, Data: map[string]any{}}) != nil || rc.Flush() != nil {
+			return
+		}
+
+		// step polls once and writes the events; false ends the stream.
+		expires := tokenExpiry(token)
+		step := func() bool {
+			if !expires.IsZero() && !time.Now().Before(expires) {
+				writeSSE(w, liveEvent{Type: liveTokenExpired, Data: map[string]string{"reason": "token_expired"}})
+				rc.Flush()
+				return false
+			}
+			quota := graphqlQuotas.Acquire(subject, role)
+			if !quota.Allowed {
+				return true
+			}
+			events, err := poller.poll(r.Context())
+			quota.Release()
+			if tokenRejected(err) {
+				writeSSE(w, liveEvent{Type: liveTokenExpired, Data: map[string]string{"reason": "upstream_rejected"}})
+				rc.Flush()
+				return false
+			}
+			if err != nil {
+				if r.Context().Err() == nil {
+					log.Printf("events poll for %s failed: %v", subject, err)
+				}
+				return true
+			}
+			for _, e := range events {
+				if writeSSE(w, e) != nil {
+					return false
+				}
+			}
+			return len(events) == 0 || rc.Flush() == nil
+		}
+		// a resumed stream catches up at once
+		if poller.resumed && !step() {
+			return
+		}
+
+		poll := time.NewTicker(eventsPollInterval)
+		defer poll.Stop()
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil || rc.Flush() != nil {
+					return
+				}
+			case <-poll.C:
+				if !step() {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useEventIntervals(t *testing.T, poll, keepAlive time.Duration) {
+	oldPoll, oldKeepAlive := eventsPollInterval, eventsKeepAlive
+	eventsPollInterval, eventsKeepAlive = poll, keepAlive
+	t.Cleanup(func() { eventsPollInterval, eventsKeepAlive = oldPoll, oldKeepAlive })
+}
+
+// sseMessage is one block of an event stream; comments have no event.
+type sseMessage struct {
+	id, event, data, comment string
+}
+
+// sseStream is a client connection to /events.
+type sseStream struct {
+	resp   *http.Response
+	lines  *bufio.Scanner
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the handler returned
+}
+
+// openEvents connects to /events as user 42, resuming from lastEventID unless it is empty.
+func openEvents(t *testing.T, lastEventID string) *sseStream {
+	t.Helper()
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		eventsHandler()(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events", nil)
+	req.Header.Set("Authorization", "Bearer "+makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour))))
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %v", resp.StatusCode, resp.Header)
+	}
+	return &sseStream{resp: resp, lines: bufio.NewScanner(resp.Body), cancel: cancel, done: done}
+}
+
+// next reads the next message, or fails after a few seconds.
+func (s *sseStream) next(t *testing.T) sseMessage {
+	t.Helper()
+	got := make(chan sseMessage, 1)
+	go func() {
+		var m sseMessage
+		for s.lines.Scan() {
+			line := s.lines.Text()
+			switch {
+			case line == "":
+				if m != (sseMessage{}) {
+					got <- m
+					return
+				}
+			case strings.HasPrefix(line, ":"):
+				m.comment = strings.TrimSpace(line[1:])
+			case strings.HasPrefix(line, "id: "):
+				m.id = line[4:]
+			case strings.HasPrefix(line, "event: "):
+				m.event = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				m.data = line[6:]
+			}
+		}
+		close(got)
+	}()
+	select {
+	case m, ok := <-got:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return sseMessage{}
+}
+
+// nextEvent skips comments and retry hints.
+func (s *sseStream) nextEvent(t *testing.T) sseMessage {
+	t.Helper()
+	for {
+		if m := s.next(t); m.event != "" {
+			return m
+		}
+	}
+}
+
+func TestEventsStreamNewRows(t *testing.T) {
+	useEventIntervals(t, 20*time.Millisecond, time.Hour)
+	z := newFakeZone01(t)
+	seedXP(z)
+	seedProgress(z)
+
+	s := openEvents(t, "")
+	ready := s.nextEvent(t)
+	if ready.event != liveReady || ready.id != "4:0:5" {
+		t.Fatalf("unexpected first event %+v", ready)
+	}
+
+	z.add("transaction", map[string]any{"id": 7, "type": "xp", "amount": 1500, "objectId": nil, "userId": 42, "createdAt": "2024-03-02T08:00:00+00:00", "path": "/athens/div-01/x"})
+	gradeQuest02(z, "2024-03-02T09:00:00+00:00")
+	tx := s.nextEvent(t)
+	if tx.event != liveTransactionCreated || !strings.Contains(tx.data, `"id":7`) || !strings.HasPrefix(tx.id, "7:0:5") {
+		t.Fatalf("unexpected transaction event %+v", tx)
+	}
+	p := s.nextEvent(t)
+	want := time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)
+	if p.event != liveProgressUpdated || !strings.Contains(p.data, `"grade":1`) || p.id != "7:"+strconv.FormatInt(want.UnixMicro(), 10)+":5" {
+		t.Fatalf("unexpected progress event %+v", p)
+	}
+
+	s.cancel()
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler should return once the client disconnects")
+	}
+}
+
+func TestEventsResumeFromLastEventID(t *testing.T) {
+	useEventIntervals(t, time.Hour, time.Hour)
+	z := newFakeZone01(t)
+	seedXP(z)
+	seedProgress(z)
+	z.add("transaction", map[string]any{"id": 7, "type": "up", "amount": 20, "objectId": nil, "userId": 42, "createdAt": "2024-03-02T08:00:00+00:00", "path": "/athens/div-01/x"})
+
+	// the client saw transaction 4 before it lost the connection
+	s := openEvents(t, "4:0:5")
+	if m := s.nextEvent(t); m.event != liveReady || m.id != "4:0:5" {
+		t.Fatalf("unexpected first event %+v", m)
+	}
+	if m := s.nextEvent(t); m.event != liveTransactionCreated || m.id != "7:0:5" {
+		t.Fatalf("missed events should be sent right away, got %+v", m)
+	}
+}
+
+func TestEventsKeepAlive(t *testing.T) {
+	useEventIntervals(t, time.Hour, 10*time.Millisecond)
+	seedXP(newFakeZone01(t))
+	s := openEvents(t, "")
+	s.nextEvent(t)
+	if m := s.next(t); m.comment != "keep-alive" {
+		t.Fatalf("expected a keep-alive comment, got %+v", m)
+	}
+}
+
+func TestEventsEndWhenUpstreamRejectsToken(t *testing.T) {
+	useEventIntervals(t, 10*time.Millisecond, time.Hour)
+	z := newFakeZone01(t)
+	seedXP(z)
+	s := openEvents(t, "")
+	s.nextEvent(t)
+
+	z.mu.Lock()
+	z.errors["transaction"] = gqlError{Message: "Could not verify JWT", Extensions: map[string]any{"code": "invalid-jwt"}}
+	z.mu.Unlock()
+	if m := s.nextEvent(t); m.event != liveTokenExpired || !strings.Contains(m.data, "upstream_rejected") {
+		t.Fatalf("unexpected event %+v", m)
+	}
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stream should end")
+	}
+}
+
+func TestEventsRequireToken(t *testing.T) {
+	rr := httptest.NewRecorder()
+	eventsHandler()(rr, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestLiveCursorRoundTrip(t *testing.T) {
+	c := liveCursor{Transaction: 12, ProgressUpdatedAt: time.Date(2024, 3, 2, 9, 0, 0, 123456000, time.UTC), Progress: 3}
+	got, err := parseLiveCursor(c.String())
+	if err != nil || got != c {
+		t.Fatalf("round trip of %v gave %v %v", c, got, err)
+	}
+	for _, bad := range []string{"", "1:2", "a:b:c", "1:-5:2"} {
+		if _, err := parseLiveCursor(bad); err == nil {
+			t.Fatalf("%q should be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exports stream the caller's full history page by page (GQL_PAGE_SIZE rows per upstream
+// query), so memory use does not grow with the dataset. Each page after the first waits for
+// a request of the caller's GraphQL quota.
+const (
+	exportTransactionsQuery = `query ExportTransactions($where: transaction_bool_exp!, $limit: Int!, $offset: Int!) {
+  transaction(where: $where, order_by: [{ createdAt: asc }, { id: asc }], limit: $limit, offset: $offset) {
+    id type amount objectId userId createdAt path
+  }
+}`
+	exportProgressQuery = `query ExportProgress($where: progress_bool_exp!, $limit: Int!, $offset: Int!) {
+  progress(where: $where, order_by: [{ createdAt: asc }, { id: asc }], limit: $limit, offset: $offset) {
+    id grade isDone createdAt updatedAt path objectId
+    object { id name type }
+  }
+}`
+)
+
+// exportRow is one exported record. NDJSON encodes the row itself; CSV and XLSX use
+// exportValues, one value per column of its dataset: nil, string, bool, int, float64 or
+// time.Time.
+type exportRow interface {
+	exportValues() []any
+}
+
+// exportDataset is a dataset offered by /api/me/export.
+type exportDataset struct {
+	name    string
+	columns []string
+	// page returns up to limit rows created within dr, starting at offset, oldest first.
+	page func(ctx context.Context, c *apiCaller, dr dateRange, limit, offset int) ([]exportRow, error)
+}
+
+var transactionsExport = exportDataset{
+	name:    "transactions",
+	columns: []string{"id", "type", "amount", "objectId", "objectName", "objectType", "path", "createdAt"},
+	page: func(ctx context.Context, c *apiCaller, dr dateRange, limit, offset int) ([]exportRow, error) {
+		var data struct {
+			Transaction []transaction `json:"transaction"`
+		}
+		vars := map[string]any{"where": dr.where("createdAt", map[string]any{}), "limit": limit, "offset": offset}
+		if err := queryUpstream(ctx, c.Token, exportTransactionsQuery, vars, &data); err != nil {
+			return nil, err
+		}
+		if err := joinObjects(ctx, c.Token, data.Transaction); err != nil {
+			return nil, err
+		}
+		rows := make([]exportRow, len(data.Transaction))
+		for i, tx := range data.Transaction {
+			rows[i] = tx
+		}
+		return rows, nil
+	},
+}
+
+var progressExport = exportDataset{
+	name:    "progress",
+	columns: []string{"id", "grade", "isDone", "objectId", "objectName", "objectType", "path", "createdAt", "updatedAt"},
+	page: func(ctx context.Context, c *apiCaller, dr dateRange, limit, offset int) ([]exportRow, error) {
+		where := dr.where("createdAt", map[string]any{})
+		if c.UserID != 0 {
+			where["userId"] = map[string]any{"_eq": c.UserID}
+		}
+		var data struct {
+			Progress []progressEntry `json:"progress"`
+		}
+		vars := map[string]any{"where": where, "limit": limit, "offset": offset}
+		if err := queryUpstream(ctx, c.Token, exportProgressQuery, vars, &data); err != nil {
+			return nil, err
+		}
+		rows := make([]exportRow, len(data.Progress))
+		for i, p := range data.Progress {
+			rows[i] = p
+		}
+		return rows, nil
+	},
+}
+
+func (tx transaction) exportValues() []any {
+	name, typ := objectColumns(tx.Object)
+	return []any{tx.ID, tx.Type, tx.Amount, optional(tx.ObjectID), name, typ, tx.Path, tx.CreatedAt}
+}
+
+func (p progressEntry) exportValues() []any {
+	name, typ := objectColumns(p.Object)
+	return []any{p.ID, optional(p.Grade), p.IsDone, optional(p.ObjectID), name, typ, p.Path, p.CreatedAt, p.UpdatedAt}
+}
+
+// objectColumns returns the name and type of o, or nils when the object is unknown.
+func objectColumns(o *objectInfo) (any, any) {
+	if o == nil {
+		return nil, nil
+	}
+	return o.Name, o.Type
+}
+
+// optional dereferences p, mapping nil to a nil value.
+func optional[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// exportWriter encodes rows in one output format.
+type exportWriter interface {
+	write(row exportRow) error
+	flush() error // pushes buffered rows to the underlying writer
+	close() error // completes the file
+}
+
+// exportFormat is an output format of /api/me/export.
+type exportFormat struct {
+	ext         string
+	contentType string
+	open        func(w io.Writer, sheet string, columns []string) (exportWriter, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":    {ext: "csv", contentType: "text/csv; charset=utf-8", open: newCSVExport},
+	"ndjson": {ext: "ndjson", contentType: "application/x-ndjson", open: newNDJSONExport},
+	"xlsx":   {ext: "xlsx", contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", open: newXLSXExport},
+}
+
+type csvExport struct{ w *csv.Writer }
+
+func newCSVExport(w io.Writer, _ string, columns []string) (exportWriter, error) {
+	cw := csv.NewWriter(w)
+	return &csvExport{w: cw}, cw.Write(columns)
+}
+
+func (e *csvExport) write(row exportRow) error {
+	values := row.exportValues()
+	record := make([]string, len(values))
+	for i, v := range values {
+		record[i] = csvCell(v)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExport) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExport) close() error { return e.flush() }
+
+// csvCell formats v for CSV. Text starting with a formula character is prefixed with a quote
+// so spreadsheets do not evaluate names coming from the upstream.
+func csvCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+type ndjsonExport struct{ enc *json.Encoder }
+
+func newNDJSONExport(w io.Writer, _ string, _ []string) (exportWriter, error) {
+	return &ndjsonExport{enc: json.NewEncoder(w)}, nil
+}
+
+func (e *ndjsonExport) write(row exportRow) error { return e.enc.Encode(row) }
+func (e *ndjsonExport) flush() error              { return nil }
+func (e *ndjsonExport) close() error              { return nil }
+
+// exportFilename names the download after the dataset, the caller and today's date, e.g.
+// zone01-jdoe-transactions-2024-03-01.csv.
+func exportFilename(c *apiCaller, dataset, ext string, now time.Time) string {
+	who := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return -1
+	}, c.Login)
+	switch {
+	case who != "":
+	case c.UserID != 0:
+		who = "user-" + strconv.Itoa(c.UserID)
+	default:
+		who = "me"
+	}
+	return fmt.Sprintf("zone01-%s-%s-%s.%s", who, dataset, now.UTC().Format(time.DateOnly), ext)
+}
+
+// exportHandler streams ds as ?format=csv|ndjson|xlsx (default csv), optionally limited by
+// from/to. Upstream failures on the first page are reported with a status code; once rows
+// have been sent a failure aborts the response so the client sees an incomplete download
+// rather than a short file.
+func exportHandler(ds exportDataset) http.HandlerFunc {
+	return meHandler(func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		dr, err := parseDateRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := r.URL.Query().Get("format")
+		if name == "" {
+			name = "csv"
+		}
+		format, ok := exportFormats[name]
+		if !ok {
+			http.Error(w, "format must be csv, ndjson or xlsx", http.StatusBadRequest)
+			return
+		}
+		rows, err := ds.page(r.Context(), c, dr, gqlPageSize, 0)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Content-Type", format.contentType)
+		h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(c, ds.name, format.ext, time.Now())))
+		h.Set("Cache-Control", "no-store")
+		rc := http.NewResponseController(w)
+		out, err := format.open(w, ds.name, ds.columns)
+		for offset := 0; err == nil; {
+			for _, row := range rows {
+				if err = out.write(row); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = out.flush()
+			}
+			if err != nil || len(rows) < gqlPageSize {
+				break
+			}
+			rc.Flush()
+			offset += len(rows)
+			if err = waitForQuota(r.Context(), c.Token); err == nil {
+				rows, err = ds.page(r.Context(), c, dr, gqlPageSize, offset)
+			}
+			if err != nil {
+				log.Printf("export %s aborted after %d rows: %v", ds.name, offset, err)
+				panic(http.ErrAbortHandler)
+			}
+		}
+		if err == nil {
+			err = out.close()
+		}
+		if err != nil {
+			log.Printf("export %s: %v", ds.name, err)
+		}
+	})
+}
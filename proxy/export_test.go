@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportTransactionsCSV(t *testing.T) {
+	useObjectCache(t, newObjectCache(time.Hour, 100))
+	seedXP(newFakeZone01(t))
+
+	rr := apiGet(t, exportHandler(transactionsExport), "/api/me/export/transactions", "42")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	want := `attachment; filename="zone01-user-42-transactions-` + time.Now().UTC().Format(time.DateOnly) + `.csv"`
+	if cd := rr.Header().Get("Content-Disposition"); cd != want {
+		t.Fatalf("expected %s, got %s", want, cd)
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 || strings.Join(records[0], ",") != "id,type,amount,objectId,objectName,objectType,path,createdAt" {
+		t.Fatalf("unexpected records %q", records)
+	}
+	if got := strings.Join(records[1], ","); got != "1,xp,1000,10,go-reloaded,project,/athens/div-01/go-reloaded,2024-01-05T10:00:00Z" {
+		t.Fatalf("unexpected first row %s", got)
+	}
+	if got := strings.Join(records[4], ","); got != "4,xp,500,,,,/athens/div-01/checkpoint,2024-03-01T08:00:00Z" {
+		t.Fatalf("unexpected row without object %s", got)
+	}
+}
+
+func TestExportPagesThroughUpstream(t *testing.T) {
+	usePagination(t, 2, 100)
+	useObjectCache(t, newObjectCache(time.Hour, 100))
+	useQuotas(t, newQuotaManager(quota{Rate: 0.001, Burst: 10, Concurrent: 1}, nil))
+	z := newFakeZone01(t)
+	seedXP(z)
+
+	rr := apiGet(t, exportHandler(transactionsExport), "/api/me/export/transactions?format=ndjson&from=2024-01-06", "42")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("unexpected response %d %v", rr.Code, rr.Header())
+	}
+	var ids []int
+	sc := bufio.NewScanner(rr.Body)
+	for sc.Scan() {
+		var tx transaction
+		if err := json.Unmarshal(sc.Bytes(), &tx); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, tx.ID)
+	}
+	if len(ids) != 3 || ids[0] != 2 || ids[2] != 4 {
+		t.Fatalf("unexpected rows %v", ids)
+	}
+	// pages of 2, 1
+	if n := z.callCount("transaction"); n != 2 {
+		t.Fatalf("expected 2 upstream pages, got %d", n)
+	}
+	if snap := graphqlQuotas.Snapshot(); len(snap) != 1 || int(snap[0].Tokens) != 8 {
+		t.Fatalf("the second page should take a request from the quota, got %+v", snap)
+	}
+}
+
+func TestExportProgressNDJSON(t *testing.T) {
+	seedProgress(newFakeZone01(t))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/me/export/progress?format=ndjson", nil)
+	claims := zoneClaims("42", time.Now().Add(time.Hour))
+	claims["login"] = "j.doe/../x"
+	req.Header.Set("Authorization", "Bearer "+makeJWT(t, claims))
+	rr := httptest.NewRecorder()
+	exportHandler(progressExport).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.Contains(cd, `filename="zone01-j.doe..x-progress-`) || !strings.HasSuffix(cd, `.ndjson"`) {
+		t.Fatalf("unexpected Content-Disposition %s", cd)
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected user 42's 5 results, got %d", len(lines))
+	}
+	var last progressEntry
+	if err := json.Unmarshal([]byte(lines[4]), &last); err != nil {
+		t.Fatal(err)
+	}
+	if last.ID != 5 || last.IsDone || last.Grade != nil {
+		t.Fatalf("unfinished result should be exported as is: %+v", last)
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	z := newFakeZone01(t)
+	if rr := apiGet(t, exportHandler(transactionsExport), "/api/me/export/transactions?format=pdf", "42"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown format, got %d", rr.Code)
+	}
+	z.errors["transaction"] = gqlError{Message: "Could not verify JWT", Extensions: map[string]any{"code": "invalid-jwt"}}
+	rr := apiGet(t, exportHandler(transactionsExport), "/api/me/export/transactions", "42")
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("Content-Disposition") != "" {
+		t.Fatalf("expected a plain 401, got %d %v", rr.Code, rr.Header())
+	}
+}
+
+func TestExportAbortsOnLaterPageFailure(t *testing.T) {
+	usePagination(t, 1, 100)
+	ds := exportDataset{
+		name:    "transactions",
+		columns: transactionsExport.columns,
+		page: func(_ context.Context, _ *apiCaller, _ dateRange, _, offset int) ([]exportRow, error) {
+			if offset > 0 {
+				return nil, errors.New("upstream went away")
+			}
+			return []exportRow{transaction{ID: 1, Type: "xp"}}, nil
+		},
+	}
+	srv := httptest.NewServer(exportHandler(ds))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Authorization", "Bearer "+makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour))))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the stream to start, got %d", resp.StatusCode)
+	}
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatal("expected the download to be cut short")
+	}
+}
+
+func TestCSVCellNeutralisesFormulas(t *testing.T) {
+	for in, want := range map[string]string{"=HYPERLINK()": "'=HYPERLINK()", "-1": "'-1", "go": "go", "": ""} {
+		if got := csvCell(in); got != want {
+			t.Fatalf("csvCell(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := csvCell(-1.5); got != "-1.5" {
+		t.Fatalf("numbers must not be prefixed, got %q", got)
+	}
+}
@@ -2,4 +2,7 @@ module proxy
 
 go 1.22
 
-require github.com/gorilla/mux v1.8.1
+require (
+	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.3
+)
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The GraphQL response cache is opt-in: it stays off while GQL_CACHE_TTL is 0 and no
+// operation has a TTL of its own. GQL_CACHE_TTLS sets per-operation TTLs by operation name,
+// e.g. "MyXp=60s,MyProgress=2m,ObjByIds=10m"; a TTL of 0 excludes an operation.
+var gqlCacheTTL = getenvDuration("GQL_CACHE_TTL", 0)
+var gqlCacheTTLs = parseCacheTTLs(getenv("GQL_CACHE_TTLS", ""))
+var gqlCacheMaxBytes = getenvInt("GQL_CACHE_MAX_BYTES", 8<<20)
+
+// gqlCache holds cached upstream responses, shared by every caller.
+var gqlCache = newResponseCache(gqlCacheMaxBytes)
+
+// parseCacheTTLs reads "operation=duration" pairs separated by commas; bad entries are skipped.
+func parseCacheTTLs(v string) map[string]time.Duration {
+	out := map[string]time.Duration{}
+	for _, pair := range strings.Split(v, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(pair, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(spec))
+		if !ok || err != nil || d < 0 {
+			log.Printf("invalid GQL_CACHE_TTLS entry %q", pair)
+			continue
+		}
+		out[strings.TrimSpace(name)] = d
+	}
+	return out
+}
+
+// cacheTTL returns how long responses to op may be cached; 0 means never.
+func cacheTTL(op *gqlOperation) time.Duration {
+	if op.Type != "query" {
+		return 0
+	}
+	if d, ok := gqlCacheTTLs[op.Name]; ok && op.Name != "" {
+		return d
+	}
+	return gqlCacheTTL
+}
+
+// operationKey identifies a request by subject, raw token, normalised document, operation
+// name and canonical variables (encoding/json sorts map keys). It keys both the response
+// cache and request coalescing. The token is part of the key so that a result is only ever
+// shared with requests bearing the token that fetched it, whatever subject a token claims.
+func operationKey(subject, token string, p *parsedOperation, req graphqlRequest) (string, error) {
+	vars, err := json.Marshal(req.Variables)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, part := range []string{subject, token, printDocument(p.Doc), p.Op.Name, string(vars)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheEntry is one cached response body.
+type cacheEntry struct {
+	key     string
+	body    []byte
+	etag    string
+	expires time.Time
+}
+
+// size approximates the memory held by e.
+func (e *cacheEntry) size() int {
+	return len(e.key) + len(e.body) + len(e.etag)
+}
+
+// responseCache is an LRU cache bounded by the total size of its entries.
+type responseCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+	now      func() time.Time
+}
+
+func newResponseCache(maxBytes int) *responseCache {
+	return &responseCache{maxBytes: maxBytes, order: list.New(), entries: map[string]*list.Element{}, now: time.Now}
+}
+
+// Get returns the live entry stored under key; expired entries are dropped.
+func (c *responseCache) Get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !c.now().Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e, true
+}
+
+// Put stores body under key for ttl, evicting least recently used entries to stay within
+// maxBytes. Bodies larger than the whole cache are not stored but still get an entry back.
+func (c *responseCache) Put(key string, body []byte, ttl time.Duration) *cacheEntry {
+	e := &cacheEntry{key: key, body: body, etag: bodyETag(body)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e.expires = c.now().Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	if e.size() > c.maxBytes {
+		return e
+	}
+	for c.bytes+e.size() > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+	c.entries[key] = c.order.PushFront(e)
+	c.bytes += e.size()
+	return e
+}
+
+// remove drops el; callers must hold c.mu.
+func (c *responseCache) remove(el *list.Element) {
+	e := c.order.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.bytes -= e.size()
+}
+
+// Len reports the number of cached entries.
+func (c *responseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// cacheableResponse reports whether an upstream answer may be cached: a 200 whose body is
+// a GraphQL result without errors.
+func cacheableResponse(status int, body []byte) bool {
+	if status != http.StatusOK {
+		return false
+	}
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false
+	}
+	errs := bytes.TrimSpace(resp.Errors)
+	return len(resp.Data) > 0 && (len(errs) == 0 || bytes.Equal(errs, []byte("null")) || bytes.Equal(errs, []byte("[]")))
+}
+
+// bodyETag is the strong validator of a response body.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCachedResponse serves e with validators, answering 304 when the client already has it.
+// status is reported in X-Cache ("HIT" or "MISS"); the body is sent as mediaType.
+func writeCachedResponse(w http.ResponseWriter, r *http.Request, e *cacheEntry, now time.Time, status, mediaType string) {
+	maxAge := int(e.expires.Sub(now).Round(time.Second).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	h := w.Header()
+	h.Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+	h.Set("ETag", e.etag)
+	h.Add("Vary", "Authorization, Cookie")
+	h.Set("X-Cache", status)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, e.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeGraphQLResponse(w, mediaType, http.StatusOK, e.body)
+}
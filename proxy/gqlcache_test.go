@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func useCache(t *testing.T, ttl time.Duration, ttls map[string]time.Duration, c *responseCache) {
+	oldTTL, oldTTLs, oldCache := gqlCacheTTL, gqlCacheTTLs, gqlCache
+	gqlCacheTTL, gqlCacheTTLs, gqlCache = ttl, ttls, c
+	t.Cleanup(func() { gqlCacheTTL, gqlCacheTTLs, gqlCache = oldTTL, oldTTLs, oldCache })
+}
+
+// countingUpstream answers every GraphQL request with reply and counts the calls, except
+// the identity queries made for quotas.
+func countingUpstream(t *testing.T, reply string) *atomic.Int32 {
+	t.Helper()
+	calls := &atomic.Int32{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), identityQuery) { // quota lookups are not counted
+			calls.Add(1)
+		}
+		io.WriteString(w, reply)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, signinPath, "/graphql")
+	return calls
+}
+
+func cachedRequest(t *testing.T, token, query string, vars map[string]any, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(map[string]any{"query": query, "variables": vars})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestParseCacheTTLs(t *testing.T) {
+	got := parseCacheTTLs("MyXp=60s, MyProgress = 2m,bad,Neg=-1s,Off=0")
+	if len(got) != 3 || got["MyXp"] != time.Minute || got["MyProgress"] != 2*time.Minute || got["Off"] != 0 {
+		t.Fatalf("unexpected TTLs: %v", got)
+	}
+}
+
+func TestCacheTTLPerOperation(t *testing.T) {
+	useCache(t, 0, map[string]time.Duration{"MyXp": time.Minute}, newResponseCache(1024))
+	for _, tc := range []struct {
+		src  string
+		want time.Duration
+	}{
+		{queryXpTransactions, time.Minute},
+		{queryProgress, 0},
+		{`mutation MyXp { m }`, 0},
+	} {
+		doc, err := parseGraphQL(tc.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cacheTTL(doc.Operations[0]); got != tc.want {
+			t.Errorf("%q: got %s, want %s", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newResponseCache(3 * (1 + 10 + 34)) // room for three one-byte keys with ten-byte bodies
+	body := []byte("0123456789")
+	c.Put("a", body, time.Minute)
+	c.Put("b", body, time.Minute)
+	c.Put("c", body, time.Minute)
+	c.Get("a")
+	c.Put("d", body, time.Minute)
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(k); !ok {
+			t.Fatalf("expected %q to remain cached", k)
+		}
+	}
+	c.Put("huge", bytes.Repeat(body, 100), time.Minute)
+	if c.Len() != 3 {
+		t.Fatalf("oversized body must not evict anything, have %d entries", c.Len())
+	}
+}
+
+func TestResponseCacheExpires(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	c := newResponseCache(1024)
+	c.now = clock.now
+	c.Put("k", []byte("{}"), time.Minute)
+	clock.advance(59 * time.Second)
+	if _, ok := c.Get("k"); !ok {
+		t.Fatal("entry should still be fresh")
+	}
+	clock.advance(time.Second)
+	if _, ok := c.Get("k"); ok || c.Len() != 0 {
+		t.Fatal("expired entry should be dropped")
+	}
+}
+
+func TestCacheableResponse(t *testing.T) {
+	for body, want := range map[string]bool{
+		`{"data":{"user":[]}}`:                              true,
+		`{"data":{"user":[]},"errors":[]}`:                  true,
+		`{"data":null,"errors":[{"message":"x"}]}`:          false,
+		`{"data":{"a":1},"errors":[{"message":"partial"}]}`: false,
+		`not json`: false,
+	} {
+		if got := cacheableResponse(http.StatusOK, []byte(body)); got != want {
+			t.Errorf("%s: got %t, want %t", body, got, want)
+		}
+	}
+	if cacheableResponse(http.StatusInternalServerError, []byte(`{"data":{}}`)) {
+		t.Error("non-200 responses must not be cached")
+	}
+}
+
+func TestGraphQLHandlerServesFromCache(t *testing.T) {
+	useCache(t, time.Minute, map[string]time.Duration{}, newResponseCache(1<<20))
+	calls := countingUpstream(t, `{"data":{"transaction":[]}}`)
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	vars := map[string]any{"limit": 10}
+
+	first := cachedRequest(t, token, queryXpTransactions, vars, nil)
+	if first.Code != http.StatusOK || first.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first request: %d %q", first.Code, first.Header().Get("X-Cache"))
+	}
+	if cc := first.Header().Get("Cache-Control"); cc != "private, max-age=60" {
+		t.Fatalf("unexpected Cache-Control %q", cc)
+	}
+
+	// same operation with different formatting hits the cache
+	second := cachedRequest(t, token, "query MyXp($limit: Int = 1000) { transaction(where: {type: {_eq: \"xp\"}}, order_by: {createdAt: asc}, limit: $limit) { id amount objectId userId createdAt path } }", vars, nil)
+	if second.Header().Get("X-Cache") != "HIT" || second.Body.String() != first.Body.String() || calls.Load() != 1 {
+		t.Fatalf("expected a cache hit, upstream calls %d", calls.Load())
+	}
+
+	etag := first.Header().Get("ETag")
+	notModified := cachedRequest(t, token, queryXpTransactions, vars, http.Header{"If-None-Match": {etag}})
+	if notModified.Code != http.StatusNotModified || notModified.Body.Len() != 0 {
+		t.Fatalf("expected 304, got %d", notModified.Code)
+	}
+
+	cachedRequest(t, token, queryXpTransactions, map[string]any{"limit": 20}, nil)
+	cachedRequest(t, makeJWT(t, zoneClaims("7", time.Now().Add(time.Hour))), queryXpTransactions, vars, nil)
+	if calls.Load() != 3 {
+		t.Fatalf("different variables or users must not share entries, upstream calls %d", calls.Load())
+	}
+}
+
+func TestGraphQLHandlerCacheIsBoundToTheToken(t *testing.T) {
+	useCache(t, time.Minute, map[string]time.Duration{}, newResponseCache(1<<20))
+	calls := countingUpstream(t, `{"data":{"user":[{"id":42}]}}`)
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	forged := token[:strings.LastIndex(token, ".")+1] + "Zm9yZ2Vk"
+
+	cachedRequest(t, token, queryMe, nil, nil)
+	if rr := cachedRequest(t, forged, queryMe, nil, nil); rr.Header().Get("X-Cache") != "MISS" || calls.Load() != 2 {
+		t.Fatalf("a token claiming the same user must not get the cached result, upstream calls %d", calls.Load())
+	}
+}
+
+func TestGraphQLHandlerSkipsCacheForErrorsAndMutations(t *testing.T) {
+	useCache(t, time.Minute, map[string]time.Duration{}, newResponseCache(1<<20))
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+
+	calls := countingUpstream(t, `{"errors":[{"message":"boom"}]}`)
+	for i := 0; i < 2; i++ {
+		rr := cachedRequest(t, token, queryMe, nil, nil)
+		if rr.Header().Get("Cache-Control") != "no-store" || rr.Header().Get("ETag") != "" {
+			t.Fatalf("error responses must not be cacheable: %v", rr.Header())
+		}
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("error responses must not be cached, upstream calls %d", calls.Load())
+	}
+
+	calls = countingUpstream(t, `{"data":{"update_user":{"id":1}}}`)
+	for i := 0; i < 2; i++ {
+		cachedRequest(t, token, `mutation { update_user { id } }`, nil, nil)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("mutations must not be cached, upstream calls %d", calls.Load())
+	}
+}
+
+func TestGraphQLHandlerCacheDisabledByDefault(t *testing.T) {
+	useCache(t, 0, map[string]time.Duration{}, newResponseCache(1<<20))
+	calls := countingUpstream(t, `{"data":{}}`)
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	cachedRequest(t, token, queryMe, nil, nil)
+	rr := cachedRequest(t, token, queryMe, nil, nil)
+	if calls.Load() != 2 || rr.Header().Get("X-Cache") != "" {
+		t.Fatalf("cache should be off without a TTL, upstream calls %d", calls.Load())
+	}
+}
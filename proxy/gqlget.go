@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// GET /graphql takes the request in the URL as the GraphQL-over-HTTP spec describes:
+// ?query=…&operationName=…&variables=<JSON>&extensions=<JSON>. Only queries may be sent
+// this way, so the answers can be cached by browsers and revalidated with their ETag.
+
+// graphqlRequestFromQuery decodes the URL parameters of a GET request.
+func graphqlRequestFromQuery(q url.Values) (graphqlRequest, error) {
+	req := graphqlRequest{Query: q.Get("query"), OperationName: q.Get("operationName")}
+	if v := q.Get("variables"); v != "" {
+		if err := decodeJSONParam(v, &req.Variables); err != nil {
+			return graphqlRequest{}, fmt.Errorf("invalid variables parameter: %w", err)
+		}
+	}
+	if v := q.Get("extensions"); v != "" {
+		if err := decodeJSONParam(v, &req.Extensions); err != nil {
+			return graphqlRequest{}, fmt.Errorf("invalid extensions parameter: %w", err)
+		}
+	}
+	return req, nil
+}
+
+// decodeJSONParam decodes a JSON object parameter, keeping numbers as in request bodies.
+func decodeJSONParam(v string, out any) error {
+	dec := json.NewDecoder(bytes.NewReader([]byte(v)))
+	dec.UseNumber()
+	return dec.Decode(out)
+}
+
+// operationType returns the type of the operation req selects, resolving persisted queries
+// first, or "" when the request is invalid; runGraphQL then reports why.
+func operationType(req graphqlRequest) string {
+	if _, err := resolvePersistedQuery(&req); err != nil {
+		return ""
+	}
+	p, err := parseOperation(req)
+	if err != nil {
+		return ""
+	}
+	return p.Op.Type
+}
+
+// serveGraphQLGet answers GET /graphql for an authenticated caller. Mutations and
+// subscriptions get 405, as GET requests must not have side effects. Error-free results
+// carry an ETag: from the response cache with its max-age when it is enabled, otherwise
+// with "no-cache" so clients revalidate and get a 304 while the result is unchanged.
+func serveGraphQLGet(w http.ResponseWriter, r *http.Request, subject, token, mediaType string) {
+	gqlReq, err := graphqlRequestFromQuery(r.URL.Query())
+	if err != nil {
+		writeGraphQLError(w, mediaType, http.StatusBadRequest, requestErrorf(codeBadRequest, "%s", err))
+		return
+	}
+	if typ := operationType(gqlReq); typ != "" && typ != "query" {
+		w.Header().Set("Allow", http.MethodPost)
+		writeGraphQLError(w, mediaType, http.StatusMethodNotAllowed, requestErrorf(codeBadRequest, "Can only perform a %s operation from a POST request.", typ))
+		return
+	}
+	res := runGraphQL(r.Context(), subject, token, gqlReq, nil)
+	if res.err != nil {
+		log.Printf("graphql proxy error: %v", res.err)
+		writeGraphQLError(w, mediaType, http.StatusBadGateway, requestErrorf(codeUpstreamUnreachable, "graphql upstream unreachable"))
+		return
+	}
+	if res.entry != nil {
+		writeCachedResponse(w, r, res.entry, gqlCache.now(), res.cacheStatus, mediaType)
+		return
+	}
+	h := w.Header()
+	if res.noStore || !cacheableResponse(res.status, res.body) {
+		h.Set("Cache-Control", "no-store")
+		writeGraphQLResponse(w, mediaType, responseStatus(mediaType, res), res.body)
+		return
+	}
+	etag := bodyETag(res.body)
+	h.Set("Cache-Control", "private, no-cache")
+	h.Set("ETag", etag)
+	h.Add("Vary", "Authorization, Cookie")
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeGraphQLResponse(w, mediaType, res.status, res.body)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// getGraphQL sends a GET /graphql with params through the router.
+func getGraphQL(t *testing.T, params url.Values, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/graphql?"+params.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer token")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGraphQLRequestFromQuery(t *testing.T) {
+	req, err := graphqlRequestFromQuery(url.Values{
+		"query":         {"query Q($n: Int) { user(limit: $n) { id } }"},
+		"operationName": {"Q"},
+		"variables":     {`{"n":2}`},
+		"extensions":    {`{"persistedQuery":{"version":1,"sha256Hash":"abc"}}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.OperationName != "Q" || req.Variables["n"] != json.Number("2") || string(req.Extensions["persistedQuery"]) != `{"version":1,"sha256Hash":"abc"}` {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	for _, bad := range []url.Values{{"variables": {"{"}}, {"variables": {"[1]"}}, {"extensions": {"x"}}} {
+		if _, err := graphqlRequestFromQuery(bad); err == nil {
+			t.Fatalf("%v should be rejected", bad)
+		}
+	}
+}
+
+func TestGraphQLGetQuery(t *testing.T) {
+	useCache(t, 0, nil, newResponseCache(1<<20))
+	calls := countingUpstream(t, `{"data":{"user":[{"id":1}]}}`)
+
+	rr := getGraphQL(t, url.Values{"query": {"{ user { id } }"}}, nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"data":{"user":[{"id":1}]}}` {
+		t.Fatalf("unexpected response %d %s", rr.Code, rr.Body.String())
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" || rr.Header().Get("Cache-Control") != "private, no-cache" {
+		t.Fatalf("expected validators, got %v", rr.Header())
+	}
+
+	rr = getGraphQL(t, url.Values{"query": {"{ user { id } }"}}, http.Header{"If-None-Match": {etag}})
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("expected 304, got %d %s", rr.Code, rr.Body.String())
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("without a cache TTL every request reaches the upstream, got %d calls", calls.Load())
+	}
+}
+
+func TestGraphQLGetUsesResponseCache(t *testing.T) {
+	useCache(t, time.Minute, nil, newResponseCache(1<<20))
+	calls := countingUpstream(t, `{"data":{"user":[{"id":1}]}}`)
+
+	getGraphQL(t, url.Values{"query": {"{ user { id } }"}}, nil)
+	rr := getGraphQL(t, url.Values{"query": {"{ user { id } }"}}, nil)
+	if rr.Header().Get("X-Cache") != "HIT" || rr.Header().Get("Cache-Control") != "private, max-age=60" || calls.Load() != 1 {
+		t.Fatalf("expected a cache hit, got %v after %d calls", rr.Header(), calls.Load())
+	}
+}
+
+func TestGraphQLGetRejectsMutations(t *testing.T) {
+	calls := countingUpstream(t, `{"data":{}}`)
+	for _, q := range []string{"mutation { delete_user { affected_rows } }", "subscription { user { id } }"} {
+		rr := getGraphQL(t, url.Values{"query": {q}}, nil)
+		if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != http.MethodPost {
+			t.Fatalf("%s: expected 405 with Allow: POST, got %d %v", q, rr.Code, rr.Header())
+		}
+	}
+	if calls.Load() != 0 {
+		t.Fatal("rejected operations must not reach the upstream")
+	}
+}
+
+func TestGraphQLGetErrorsAreNotCached(t *testing.T) {
+	countingUpstream(t, `{"errors":[{"message":"boom"}]}`)
+	rr := getGraphQL(t, url.Values{"query": {"{ user { id } }"}}, nil)
+	if rr.Header().Get("Cache-Control") != "no-store" || rr.Header().Get("ETag") != "" {
+		t.Fatalf("error results must not be cached, got %v", rr.Header())
+	}
+	if rr = getGraphQL(t, url.Values{"query": {"{ user { id"}}, nil); errorCode(t, rr) != codeParseFailed {
+		t.Fatalf("expected a parse error, got %s", rr.Body.String())
+	}
+	if rr = getGraphQL(t, url.Values{"query": {"{ user { id } }"}, "variables": {"nope"}}, nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for malformed variables, got %d", rr.Code)
+	}
+}
+
+func TestGraphQLGetPersistedQuery(t *testing.T) {
+	usePersisted(t, persistedAPQ, newPersistedQueryStore(nil, 10))
+	countingUpstream(t, `{"data":{"user":[]}}`)
+	doc := "{ user { id } }"
+	ext := `{"persistedQuery":{"version":1,"sha256Hash":"` + queryHash(doc) + `"}}`
+
+	if rr := getGraphQL(t, url.Values{"extensions": {ext}}, nil); errorCode(t, rr) != codePersistedNotFound {
+		t.Fatalf("expected %s before registration, got %s", codePersistedNotFound, rr.Body.String())
+	}
+	if rr := getGraphQL(t, url.Values{"query": {doc}, "extensions": {ext}}, nil); rr.Code != http.StatusOK || errorCode(t, rr) != "" {
+		t.Fatalf("registration failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := getGraphQL(t, url.Values{"extensions": {ext}}, nil); rr.Code != http.StatusOK || rr.Header().Get("ETag") == "" {
+		t.Fatalf("hash-only GET failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	mutation := "mutation { delete_user { affected_rows } }"
+	getGraphQL(t, url.Values{"query": {mutation}, "extensions": {`{"persistedQuery":{"version":1,"sha256Hash":"` + queryHash(mutation) + `"}}`}}, nil)
+	if _, ok := persistedQueries.Lookup(queryHash(mutation)); ok {
+		t.Fatal("a mutation sent with GET must not be registered")
+	}
+}
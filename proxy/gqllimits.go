@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Query limits applied before anything is forwarded upstream; 0 disables a limit.
+var gqlMaxDepth = getenvInt("GQL_MAX_DEPTH", 10)
+var gqlMaxAliases = getenvInt("GQL_MAX_ALIASES", 20)
+var gqlMaxLimit = getenvInt("GQL_MAX_LIMIT", 5000)
+var gqlMaxCost = getenvInt("GQL_MAX_COST", 50000)
+
+// gqlMaxBodyBytes caps the size of /graphql request bodies, batches included.
+var gqlMaxBodyBytes = getenvInt("GQL_MAX_BODY_BYTES", 1<<20)
+
+// Extension codes attached to errors produced by the proxy itself.
+const (
+	codeBadRequest       = "BAD_REQUEST"
+	codeUnauthenticated  = "UNAUTHENTICATED"
+	codeParseFailed      = "GRAPHQL_PARSE_FAILED"
+	codeValidationFailed = "GRAPHQL_VALIDATION_FAILED"
+	codeTooDeep          = "QUERY_TOO_DEEP"
+	codeTooManyAliases   = "TOO_MANY_ALIASES"
+	codeLimitTooLarge    = "LIMIT_TOO_LARGE"
+	codeTooComplex       = "QUERY_TOO_COMPLEX"
+)
+
+// gqlRequestError is a failure that is reported to the client as a GraphQL error entry.
+type gqlRequestError struct {
+	Code      string
+	Message   string
+	Locations []gqlLocation
+}
+
+func (e *gqlRequestError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// requestErrorf builds a gqlRequestError with a formatted message.
+func requestErrorf(code, format string, args ...any) *gqlRequestError {
+	return &gqlRequestError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// toGraphQLError converts err into a response error entry.
+func toGraphQLError(err error) gqlError {
+	var reqErr *gqlRequestError
+	if errors.As(err, &reqErr) {
+		return gqlError{Message: reqErr.Message, Locations: reqErr.Locations, Extensions: map[string]any{"code": reqErr.Code}}
+	}
+	var synErr *gqlSyntaxError
+	if errors.As(err, &synErr) {
+		return gqlError{
+			Message:    synErr.Error(),
+			Locations:  []gqlLocation{{Line: synErr.Line, Column: synErr.Column}},
+			Extensions: map[string]any{"code": codeParseFailed},
+		}
+	}
+	return gqlError{Message: err.Error()}
+}
+
+// decodeGraphQLRequest reads a JSON request body, keeping numbers exact for re-encoding.
+func decodeGraphQLRequest(body []byte) (graphqlRequest, error) {
+	var req graphqlRequest
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		return graphqlRequest{}, err
+	}
+	return req, nil
+}
+
+// upstreamBody encodes req for the upstream engine, dropping proxy-only extensions.
+func upstreamBody(req graphqlRequest) ([]byte, error) {
+	return json.Marshal(graphqlRequest{Query: req.Query, Variables: req.Variables, OperationName: req.OperationName})
+}
+
+// parsedOperation is a request whose document has been parsed and whose operation was selected.
+type parsedOperation struct {
+	Doc *gqlDocument
+	Op  *gqlOperation
+}
+
+// parseOperation parses req.Query and picks the operation named by req.OperationName.
+func parseOperation(req graphqlRequest) (*parsedOperation, error) {
+	if req.Query == "" {
+		return nil, requestErrorf(codeBadRequest, "Must provide query string.")
+	}
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return nil, err
+	}
+	return &parsedOperation{Doc: doc, Op: op}, nil
+}
+
+// selectOperation returns the operation to execute following the spec's GetOperation rules.
+func selectOperation(doc *gqlDocument, name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(doc.Operations) != 1 {
+			return nil, requestErrorf(codeValidationFailed, "Must provide operation name if query contains multiple operations.")
+		}
+		return doc.Operations[0], nil
+	}
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, requestErrorf(codeValidationFailed, "Unknown operation named %q.", name)
+}
+
+// fragment returns the fragment definition called name.
+func (d *gqlDocument) fragment(name string) *gqlFragment {
+	for _, f := range d.Fragments {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// queryStats summarises the shape of an operation or of a selection set, whose own fields
+// are at depth 1.
+type queryStats struct {
+	Depth   int
+	Aliases int
+	Cost    int64
+}
+
+// maxCostValue caps cost arithmetic so nested multipliers cannot overflow.
+const maxCostValue = math.MaxInt64 / 4
+
+// gqlMaxSpreads caps the fragment spreads one analysis may visit. Fragments are measured
+// once, so only documents with an absurd number of spreads reach it.
+const gqlMaxSpreads = 10000
+
+// add accumulates the stats of a sibling selection.
+func (s *queryStats) add(o queryStats) {
+	s.Depth = max(s.Depth, o.Depth)
+	s.Aliases = int(min(int64(s.Aliases)+int64(o.Aliases), maxCostValue))
+	s.Cost = min(s.Cost+o.Cost, maxCostValue)
+}
+
+// queryAnalyzer walks an operation, expanding fragments, to compute queryStats. Each
+// fragment is measured once and reused wherever it is spread, so a document of fragments
+// that spread each other several times costs time linear in its size.
+type queryAnalyzer struct {
+	doc       *gqlDocument
+	op        *gqlOperation
+	vars      map[string]any
+	maxLimit  int
+	maxCost   int64                 // stop as soon as the cost passes it; 0 disables
+	active    map[string]bool       // fragments on the current path, for cycle detection
+	fragments map[string]queryStats // measured fragments
+	spreads   int
+}
+
+func newQueryAnalyzer(p *parsedOperation, vars map[string]any) *queryAnalyzer {
+	return &queryAnalyzer{doc: p.Doc, op: p.Op, vars: vars, active: map[string]bool{}, fragments: map[string]queryStats{}}
+}
+
+// analyzeOperation computes the stats of p and enforces GQL_MAX_LIMIT on "limit" arguments.
+// Costs over GQL_MAX_COST fail as soon as they are reached.
+func analyzeOperation(p *parsedOperation, vars map[string]any) (queryStats, error) {
+	a := newQueryAnalyzer(p, vars)
+	a.maxLimit, a.maxCost = gqlMaxLimit, int64(gqlMaxCost)
+	return a.selections(p.Op.Selections)
+}
+
+// selections returns the stats of sels.
+func (a *queryAnalyzer) selections(sels []gqlSelection) (queryStats, error) {
+	var total queryStats
+	for _, sel := range sels {
+		var stats queryStats
+		var err error
+		switch s := sel.(type) {
+		case *gqlField:
+			stats, err = a.field(s)
+		case *gqlInlineFragment:
+			stats, err = a.selections(s.Selections)
+		case *gqlFragmentSpread:
+			stats, err = a.spread(s)
+		}
+		if err != nil {
+			return queryStats{}, err
+		}
+		total.add(stats)
+		// a selection never costs less than its parts, so the whole query is too expensive
+		if a.maxCost > 0 && total.Cost > a.maxCost {
+			return queryStats{}, requestErrorf(codeTooComplex, "Query cost exceeds the maximum of %d.", a.maxCost)
+		}
+	}
+	return total, nil
+}
+
+// spread returns the stats of the fragment s spreads, measuring it on first use.
+func (a *queryAnalyzer) spread(s *gqlFragmentSpread) (queryStats, error) {
+	if a.spreads++; a.spreads > gqlMaxSpreads {
+		return queryStats{}, requestErrorf(codeTooComplex, "Query spreads fragments more than %d times.", gqlMaxSpreads)
+	}
+	if stats, ok := a.fragments[s.Name]; ok {
+		return stats, nil
+	}
+	frag := a.doc.fragment(s.Name)
+	if frag == nil {
+		return queryStats{}, requestErrorf(codeValidationFailed, "Unknown fragment %q.", s.Name)
+	}
+	if a.active[s.Name] {
+		return queryStats{}, requestErrorf(codeValidationFailed, "Cannot spread fragment %q within itself.", s.Name)
+	}
+	a.active[s.Name] = true
+	stats, err := a.selections(frag.Selections)
+	delete(a.active, s.Name)
+	if err != nil {
+		return queryStats{}, err
+	}
+	a.fragments[s.Name] = stats
+	return stats, nil
+}
+
+// field accounts for one field: it costs 1 plus its children, multiplied by its limit argument.
+func (a *queryAnalyzer) field(f *gqlField) (queryStats, error) {
+	stats := queryStats{Depth: 1, Cost: 1}
+	if f.Alias != "" {
+		stats.Aliases = 1
+	}
+	if len(f.Selections) == 0 {
+		return stats, nil
+	}
+	multiplier := int64(1)
+	if arg := f.Argument("limit"); arg != nil {
+		n, ok := a.intValue(arg.Value)
+		if ok {
+			if a.maxLimit > 0 && n > int64(a.maxLimit) {
+				return queryStats{}, requestErrorf(codeLimitTooLarge, "limit %d on field %q exceeds the maximum of %d.", n, f.ResponseKey(), a.maxLimit)
+			}
+			if n > 0 {
+				multiplier = n
+			}
+		}
+	}
+	children, err := a.selections(f.Selections)
+	if err != nil {
+		return queryStats{}, err
+	}
+	stats.Depth += children.Depth
+	stats.Aliases = int(min(int64(stats.Aliases)+int64(children.Aliases), maxCostValue))
+	if multiplier > 1 && children.Cost > maxCostValue/multiplier {
+		stats.Cost = maxCostValue
+	} else {
+		stats.Cost = min(1+multiplier*children.Cost, maxCostValue)
+	}
+	if a.maxCost > 0 && stats.Cost > a.maxCost {
+		return queryStats{}, requestErrorf(codeTooComplex, "Query cost exceeds the maximum of %d.", a.maxCost)
+	}
+	return stats, nil
+}
+
+// intValue resolves v (a literal or a variable) to an integer.
+func (a *queryAnalyzer) intValue(v gqlValue) (int64, bool) {
+	switch v.Kind {
+	case gqlInt:
+		n, err := strconv.ParseInt(v.Raw, 10, 64)
+		return n, err == nil
+	case gqlVariable:
+		if raw, ok := a.vars[v.Raw]; ok {
+			return jsonInt(raw)
+		}
+		for _, def := range a.op.Variables {
+			if def.Name == v.Raw && def.Default != nil {
+				return a.intValue(*def.Default)
+			}
+		}
+	}
+	return 0, false
+}
+
+// jsonInt converts a decoded JSON number to an integer.
+func jsonInt(v any) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		if n != math.Trunc(n) {
+			return 0, false
+		}
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// checkQueryLimits parses req and rejects operations that exceed the configured limits.
+func checkQueryLimits(req graphqlRequest) (*parsedOperation, error) {
+	p, err := parseOperation(req)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := analyzeOperation(p, req.Variables)
+	if err != nil {
+		return nil, err
+	}
+	if gqlMaxDepth > 0 && stats.Depth > gqlMaxDepth {
+		return nil, requestErrorf(codeTooDeep, "Query depth %d exceeds the maximum of %d.", stats.Depth, gqlMaxDepth)
+	}
+	if gqlMaxAliases > 0 && stats.Aliases > gqlMaxAliases {
+		return nil, requestErrorf(codeTooManyAliases, "Query uses %d aliases, the maximum is %d.", stats.Aliases, gqlMaxAliases)
+	}
+	if gqlMaxCost > 0 && stats.Cost > int64(gqlMaxCost) {
+		return nil, requestErrorf(codeTooComplex, "Query cost %d exceeds the maximum of %d.", stats.Cost, gqlMaxCost)
+	}
+	return p, nil
+}
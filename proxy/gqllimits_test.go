@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func overrideQueryLimits(t *testing.T, depth, aliases, limit, cost int) {
+	oldDepth, oldAliases, oldLimit, oldCost := gqlMaxDepth, gqlMaxAliases, gqlMaxLimit, gqlMaxCost
+	gqlMaxDepth, gqlMaxAliases, gqlMaxLimit, gqlMaxCost = depth, aliases, limit, cost
+	t.Cleanup(func() {
+		gqlMaxDepth, gqlMaxAliases, gqlMaxLimit, gqlMaxCost = oldDepth, oldAliases, oldLimit, oldCost
+	})
+}
+
+func codeOf(err error) string {
+	var re *gqlRequestError
+	if errors.As(err, &re) {
+		return re.Code
+	}
+	var se *gqlSyntaxError
+	if errors.As(err, &se) {
+		return codeParseFailed
+	}
+	return ""
+}
+
+func TestFrontendQueriesPassDefaultLimits(t *testing.T) {
+	for _, src := range []string{queryMe, queryXpTransactions, queryObjectByIDs, queryProgress} {
+		req := graphqlRequest{Query: src, Variables: map[string]any{"limit": float64(2000), "userId": float64(1)}}
+		if _, err := checkQueryLimits(req); err != nil {
+			t.Fatalf("frontend query rejected: %v\n%s", err, src)
+		}
+	}
+}
+
+func TestAnalyzeOperationStats(t *testing.T) {
+	p, err := parseOperation(graphqlRequest{Query: queryProgress})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	stats, err := analyzeOperation(p, nil)
+	if err != nil {
+		t.Fatalf("analyze: %v", err)
+	}
+	// progress(limit: 2000 by default) with 6 scalars, object{3} and user{2}
+	if stats.Depth != 3 || stats.Aliases != 0 || stats.Cost != 1+2000*(6+4+3) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCheckQueryLimits(t *testing.T) {
+	overrideQueryLimits(t, 3, 1, 100, 500)
+	tests := []struct {
+		name  string
+		query string
+		vars  map[string]any
+		code  string
+	}{
+		{"ok", `{ user { id } }`, nil, ""},
+		{"depth", `{ a { b { c { d } } } }`, nil, codeTooDeep},
+		{"depth via fragment", `{ a { ...F } } fragment F on T { b { c { d } } }`, nil, codeTooDeep},
+		{"aliases", `{ x: user { id } y: user { id } }`, nil, codeTooManyAliases},
+		{"literal limit", `{ transaction(limit: 101) { id } }`, nil, codeLimitTooLarge},
+		{"variable limit", `query($n: Int) { transaction(limit: $n) { id } }`, map[string]any{"n": float64(1000)}, codeLimitTooLarge},
+		{"default limit", `query($n: Int = 1000) { transaction(limit: $n) { id } }`, nil, codeLimitTooLarge},
+		{"cost", `{ transaction(limit: 100) { a b c d e f } }`, nil, codeTooComplex},
+		{"parse", `{ user {`, nil, codeParseFailed},
+		{"missing query", ``, nil, codeBadRequest},
+		{"cycle", `{ a { ...F } } fragment F on T { b { ...F } }`, nil, codeValidationFailed},
+		{"unknown fragment", `{ a { ...Nope } }`, nil, codeValidationFailed},
+		{"ambiguous operation", `query A { a } query B { b }`, nil, codeValidationFailed},
+	}
+	for _, tc := range tests {
+		_, err := checkQueryLimits(graphqlRequest{Query: tc.query, Variables: tc.vars})
+		if got := codeOf(err); got != tc.code {
+			t.Errorf("%s: expected code %q, got %q (%v)", tc.name, tc.code, got, err)
+		}
+	}
+}
+
+func TestSelectOperationByName(t *testing.T) {
+	p, err := parseOperation(graphqlRequest{Query: `query A { a } query B { b }`, OperationName: "B"})
+	if err != nil || p.Op.Name != "B" {
+		t.Fatalf("expected operation B, got %+v %v", p, err)
+	}
+	if _, err := parseOperation(graphqlRequest{Query: `query A { a }`, OperationName: "C"}); codeOf(err) != codeValidationFailed {
+		t.Fatalf("expected unknown operation error, got %v", err)
+	}
+}
+
+func TestCostSaturates(t *testing.T) {
+	overrideQueryLimits(t, 0, 0, 0, 0)
+	q := `{ a(limit: 1000000000) { b(limit: 1000000000) { c(limit: 1000000000) { d } } } }`
+	p, _ := parseOperation(graphqlRequest{Query: q})
+	stats, err := analyzeOperation(p, nil)
+	if err != nil || stats.Cost != maxCostValue {
+		t.Fatalf("expected saturated cost, got %d %v", stats.Cost, err)
+	}
+}
+
+// doublingFragments builds a document of n fragments, each spreading the next one twice,
+// whose naive expansion visits 2^n fields.
+func doublingFragments(n int) string {
+	var b strings.Builder
+	b.WriteString(`query { transaction(limit: 10) { ...F0 } }`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, " fragment F%d on transaction { ...F%d ...F%d }", i, i+1, i+1)
+	}
+	fmt.Fprintf(&b, " fragment F%d on transaction { id }", n)
+	return b.String()
+}
+
+func TestFragmentSpreadsAreMeasuredOnce(t *testing.T) {
+	req := graphqlRequest{Query: doublingFragments(60)}
+	start := time.Now()
+	if _, err := checkQueryLimits(req); codeOf(err) != codeTooComplex {
+		t.Fatalf("expected %s, got %v", codeTooComplex, err)
+	}
+
+	// a cost limit the saturated cost stays under, so pagination measures the rows too
+	overrideQueryLimits(t, 0, 0, 0, math.MaxInt)
+	p, err := checkQueryLimits(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := planPagination(p, graphqlRequest{Query: req.Query, Extensions: map[string]json.RawMessage{"paginate": json.RawMessage("true")}}); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("exponential spreads took %v", d)
+	}
+
+	q := `{ a { ` + strings.Repeat("...F ", gqlMaxSpreads+1) + `} } fragment F on T { id }`
+	if _, err := checkQueryLimits(graphqlRequest{Query: q}); codeOf(err) != codeTooComplex {
+		t.Fatalf("expected %s over %d spreads, got %v", codeTooComplex, gqlMaxSpreads, err)
+	}
+}
+
+func TestGraphqlHandlerRejectsDeepQuery(t *testing.T) {
+	overrideQueryLimits(t, 2, 0, 0, 0)
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, signinPath, "/graphql")
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{ a { b { c } } }"}`))
+	req.Header.Set("Authorization", "Bearer token")
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+
+	if called {
+		t.Fatal("rejected query must not reach upstream")
+	}
+	if got := rr.Result().Header.Get("Content-Type"); got != "application/json" {
+		t.Fatalf("expected JSON header, got %q", got)
+	}
+	var resp gqlErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response not JSON: %v", err)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Extensions["code"] != codeTooDeep {
+		t.Fatalf("unexpected errors: %+v", resp)
+	}
+}
+
+func TestGraphqlHandlerRejectsInvalidJSON(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":`))
+	req.Header.Set("Authorization", "Bearer token")
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+}
+
+func TestGraphqlHandlerCapsBodySize(t *testing.T) {
+	old := gqlMaxBodyBytes
+	gqlMaxBodyBytes = 64
+	t.Cleanup(func() { gqlMaxBodyBytes = old })
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{ user { id } }","variables":{"pad":"`+strings.Repeat("x", 64)+`"}}`))
+	req.Header.Set("Authorization", "Bearer token")
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge || errorCode(t, rr) != codeBadRequest {
+		t.Fatalf("expected 413, got %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,909 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file holds a small parser for GraphQL executable documents (queries, mutations,
+// subscriptions and fragments). It covers the October 2021 spec grammar for those definitions;
+// type-system definitions are rejected since the proxy never needs them.
+
+// gqlSyntaxError reports a lexing or parsing failure at a 1-based line and column.
+type gqlSyntaxError struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+func (e *gqlSyntaxError) Error() string {
+	return fmt.Sprintf("Syntax Error: %s (%d:%d)", e.Message, e.Line, e.Column)
+}
+
+// gqlDocument is a parsed executable document.
+type gqlDocument struct {
+	Operations []*gqlOperation
+	Fragments  []*gqlFragment
+}
+
+// gqlOperation is a query, mutation or subscription definition.
+type gqlOperation struct {
+	Type       string // "query", "mutation" or "subscription"
+	Name       string
+	Variables  []*gqlVariableDef
+	Directives []*gqlDirective
+	Selections []gqlSelection
+}
+
+// gqlVariableDef declares one operation variable; Type is kept in its printed form.
+type gqlVariableDef struct {
+	Name       string
+	Type       string
+	Default    *gqlValue
+	Directives []*gqlDirective
+}
+
+// gqlFragment is a named fragment definition.
+type gqlFragment struct {
+	Name          string
+	TypeCondition string
+	Directives    []*gqlDirective
+	Selections    []gqlSelection
+}
+
+// gqlSelection is one of *gqlField, *gqlFragmentSpread or *gqlInlineFragment.
+type gqlSelection interface {
+	selection()
+}
+
+// gqlField is a field selection.
+type gqlField struct {
+	Alias      string
+	Name       string
+	Arguments  []*gqlArgument
+	Directives []*gqlDirective
+	Selections []gqlSelection
+}
+
+// gqlFragmentSpread is a "...Name" selection.
+type gqlFragmentSpread struct {
+	Name       string
+	Directives []*gqlDirective
+}
+
+// gqlInlineFragment is a "... on Type { }" selection.
+type gqlInlineFragment struct {
+	TypeCondition string
+	Directives    []*gqlDirective
+	Selections    []gqlSelection
+}
+
+func (*gqlField) selection()          {}
+func (*gqlFragmentSpread) selection() {}
+func (*gqlInlineFragment) selection() {}
+
+// ResponseKey is the key the field occupies in the response: its alias or its name.
+func (f *gqlField) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// Argument returns the argument called name, or nil.
+func (f *gqlField) Argument(name string) *gqlArgument {
+	for _, a := range f.Arguments {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// gqlArgument is a name/value pair on a field or directive.
+type gqlArgument struct {
+	Name  string
+	Value gqlValue
+}
+
+// gqlDirective is an "@name(args)" annotation.
+type gqlDirective struct {
+	Name      string
+	Arguments []*gqlArgument
+}
+
+// gqlValueKind enumerates GraphQL input value literals.
+type gqlValueKind int
+
+const (
+	gqlVariable gqlValueKind = iota
+	gqlInt
+	gqlFloat
+	gqlString
+	gqlBoolean
+	gqlNull
+	gqlEnum
+	gqlList
+	gqlObject
+)
+
+// gqlValue is an input value literal. Raw holds the variable name, the number or enum text,
+// the decoded string, or "true"/"false".
+type gqlValue struct {
+	Kind   gqlValueKind
+	Raw    string
+	List   []gqlValue
+	Fields []gqlObjectField
+}
+
+// gqlObjectField is one entry of an input object literal.
+type gqlObjectField struct {
+	Name  string
+	Value gqlValue
+}
+
+// parseGraphQL parses an executable GraphQL document.
+func parseGraphQL(src string) (*gqlDocument, error) {
+	p := &gqlParser{lex: gqlLexer{src: src, line: 1, lineStart: 0}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return p.document()
+}
+
+// Token kinds produced by gqlLexer.
+type gqlTokenKind int
+
+const (
+	tokEOF gqlTokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type gqlToken struct {
+	kind   gqlTokenKind
+	value  string
+	line   int
+	column int
+}
+
+// gqlLexer splits a document into tokens, skipping whitespace, commas and comments.
+type gqlLexer struct {
+	src       string
+	pos       int
+	line      int
+	lineStart int
+}
+
+func (l *gqlLexer) errorf(format string, args ...any) error {
+	return &gqlSyntaxError{Message: fmt.Sprintf(format, args...), Line: l.line, Column: l.pos - l.lineStart + 1}
+}
+
+func (l *gqlLexer) newline() {
+	l.line++
+	l.lineStart = l.pos
+}
+
+// skipIgnored moves past whitespace, line terminators, commas, comments and a BOM.
+func (l *gqlLexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == ',':
+			l.pos++
+		case c == '\n':
+			l.pos++
+			l.newline()
+		case c == '\r':
+			l.pos++
+			if l.pos < len(l.src) && l.src[l.pos] == '\n' {
+				l.pos++
+			}
+			l.newline()
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "\uFEFF"):
+			l.pos += len("\uFEFF")
+		default:
+			return
+		}
+	}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// next returns the following token.
+func (l *gqlLexer) next() (gqlToken, error) {
+	l.skipIgnored()
+	tok := gqlToken{line: l.line, column: l.pos - l.lineStart + 1}
+	if l.pos >= len(l.src) {
+		tok.kind = tokEOF
+		return tok, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		l.pos++
+		tok.kind, tok.value = tokPunct, string(c)
+		return tok, nil
+	case c == '.':
+		if strings.HasPrefix(l.src[l.pos:], "...") {
+			l.pos += 3
+			tok.kind, tok.value = tokPunct, "..."
+			return tok, nil
+		}
+		return tok, l.errorf("unexpected character %q", c)
+	case isNameStart(c):
+		start := l.pos
+		for l.pos < len(l.src) && isNameContinue(l.src[l.pos]) {
+			l.pos++
+		}
+		tok.kind, tok.value = tokName, l.src[start:l.pos]
+		return tok, nil
+	case c == '-' || isDigit(c):
+		return l.number(tok)
+	case c == '"':
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			return l.blockString(tok)
+		}
+		return l.string(tok)
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return tok, l.errorf("unexpected character %q", r)
+}
+
+// number lexes IntValue and FloatValue tokens.
+func (l *gqlLexer) number(tok gqlToken) (gqlToken, error) {
+	start := l.pos
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := func() int {
+		n := 0
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+			n++
+		}
+		return n
+	}
+	intStart := l.pos
+	if digits() == 0 {
+		return tok, l.errorf("invalid number")
+	}
+	if l.pos-intStart > 1 && l.src[intStart] == '0' {
+		l.pos = intStart + 1
+		return tok, l.errorf("invalid number, unexpected digit after 0")
+	}
+	tok.kind = tokInt
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		l.pos++
+		if digits() == 0 {
+			return tok, l.errorf("invalid number, expected digit after '.'")
+		}
+		tok.kind = tokFloat
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if digits() == 0 {
+			return tok, l.errorf("invalid number, expected digit in exponent")
+		}
+		tok.kind = tokFloat
+	}
+	if l.pos < len(l.src) && (isNameStart(l.src[l.pos]) || l.src[l.pos] == '.') {
+		return tok, l.errorf("invalid number, unexpected %q", l.src[l.pos])
+	}
+	tok.value = l.src[start:l.pos]
+	return tok, nil
+}
+
+// string lexes a regular quoted string and decodes its escapes.
+func (l *gqlLexer) string(tok gqlToken) (gqlToken, error) {
+	l.pos++ // opening quote
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			tok.kind, tok.value = tokString, b.String()
+			return tok, nil
+		case c == '\n' || c == '\r':
+			return tok, l.errorf("unterminated string")
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return tok, l.errorf("unterminated string")
+			}
+			esc := l.src[l.pos+1]
+			l.pos += 2
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				r, err := l.unicodeEscape()
+				if err != nil {
+					return tok, err
+				}
+				b.WriteRune(r)
+			default:
+				return tok, l.errorf("invalid escape sequence \\%c", esc)
+			}
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			b.WriteRune(r)
+			l.pos += size
+		}
+	}
+	return tok, l.errorf("unterminated string")
+}
+
+// unicodeEscape decodes the hex digits of a \u escape, which may be \uXXXX or \u{X...}.
+func (l *gqlLexer) unicodeEscape() (rune, error) {
+	hex := func(s string) (rune, bool) {
+		var r rune
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+			switch {
+			case c >= '0' && c <= '9':
+				r = r*16 + rune(c-'0')
+			case c >= 'a' && c <= 'f':
+				r = r*16 + rune(c-'a'+10)
+			case c >= 'A' && c <= 'F':
+				r = r*16 + rune(c-'A'+10)
+			default:
+				return 0, false
+			}
+		}
+		return r, len(s) > 0
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '{' {
+		end := strings.IndexByte(l.src[l.pos:], '}')
+		if end < 0 {
+			return 0, l.errorf("invalid unicode escape")
+		}
+		r, ok := hex(l.src[l.pos+1 : l.pos+end])
+		if !ok || r > utf8.MaxRune {
+			return 0, l.errorf("invalid unicode escape")
+		}
+		l.pos += end + 1
+		return r, nil
+	}
+	if l.pos+4 > len(l.src) {
+		return 0, l.errorf("invalid unicode escape")
+	}
+	r, ok := hex(l.src[l.pos : l.pos+4])
+	if !ok {
+		return 0, l.errorf("invalid unicode escape")
+	}
+	l.pos += 4
+	return r, nil
+}
+
+// blockString lexes a """block string""" and applies the spec's indentation rules.
+func (l *gqlLexer) blockString(tok gqlToken) (gqlToken, error) {
+	l.pos += 3
+	var raw strings.Builder
+	for l.pos < len(l.src) {
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], `"""`):
+			l.pos += 3
+			tok.kind, tok.value = tokString, blockStringValue(raw.String())
+			return tok, nil
+		case strings.HasPrefix(l.src[l.pos:], `\"""`):
+			raw.WriteString(`"""`)
+			l.pos += 4
+		default:
+			c := l.src[l.pos]
+			raw.WriteByte(c)
+			l.pos++
+			if c == '\n' || (c == '\r' && (l.pos >= len(l.src) || l.src[l.pos] != '\n')) {
+				l.newline()
+			}
+		}
+	}
+	return tok, l.errorf("unterminated block string")
+}
+
+// blockStringValue implements the BlockStringValue algorithm from the spec.
+func blockStringValue(raw string) string {
+	raw = strings.ReplaceAll(strings.ReplaceAll(raw, "\r\n", "\n"), "\r", "\n")
+	lines := strings.Split(raw, "\n")
+	common := -1
+	for _, line := range lines[1:] {
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < len(line) && (common < 0 || indent < common) {
+			common = indent
+		}
+	}
+	if common > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= common {
+				lines[i] = lines[i][common:]
+			} else {
+				lines[i] = ""
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// gqlMaxNesting bounds how deeply selection sets, list and object values and list types
+// may nest. The parser recurses at each level, so without it a document of nothing but "["
+// would exhaust the stack; GQL_MAX_DEPTH rejects legitimate queries long before this.
+const gqlMaxNesting = 128
+
+// gqlParser is a recursive-descent parser over gqlLexer tokens.
+type gqlParser struct {
+	lex   gqlLexer
+	tok   gqlToken
+	depth int
+}
+
+func (p *gqlParser) advance() error {
+	t, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	return nil
+}
+
+func (p *gqlParser) errorf(format string, args ...any) error {
+	return &gqlSyntaxError{Message: fmt.Sprintf(format, args...), Line: p.tok.line, Column: p.tok.column}
+}
+
+func (p *gqlParser) describe() string {
+	switch p.tok.kind {
+	case tokEOF:
+		return "<EOF>"
+	case tokString:
+		return "string"
+	}
+	return fmt.Sprintf("%q", p.tok.value)
+}
+
+// enter descends one nesting level and fails past gqlMaxNesting; every successful call
+// must be paired with leave.
+func (p *gqlParser) enter() error {
+	if p.depth >= gqlMaxNesting {
+		return p.errorf("document nests deeper than %d levels", gqlMaxNesting)
+	}
+	p.depth++
+	return nil
+}
+
+func (p *gqlParser) leave() { p.depth-- }
+
+func (p *gqlParser) peek(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.value == punct
+}
+
+func (p *gqlParser) expect(punct string) error {
+	if !p.peek(punct) {
+		return p.errorf("expected %q, found %s", punct, p.describe())
+	}
+	return p.advance()
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.errorf("expected Name, found %s", p.describe())
+	}
+	v := p.tok.value
+	return v, p.advance()
+}
+
+func (p *gqlParser) document() (*gqlDocument, error) {
+	doc := &gqlDocument{}
+	if p.tok.kind == tokEOF {
+		return nil, p.errorf("unexpected <EOF>")
+	}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek("{"):
+			sel, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &gqlOperation{Type: "query", Selections: sel})
+		case p.tok.kind == tokName && (p.tok.value == "query" || p.tok.value == "mutation" || p.tok.value == "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case p.tok.kind == tokName && p.tok.value == "fragment":
+			frag, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			doc.Fragments = append(doc.Fragments, frag)
+		default:
+			return nil, p.errorf("unexpected %s", p.describe())
+		}
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	op := &gqlOperation{Type: p.tok.value}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.tok.kind == tokName {
+		if op.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if op.Variables, err = p.variableDefinitions(); err != nil {
+			return nil, err
+		}
+	}
+	if op.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if op.Selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (p *gqlParser) variableDefinitions() ([]*gqlVariableDef, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var defs []*gqlVariableDef
+	for !p.peek(")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		typ, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		def := &gqlVariableDef{Name: name, Type: typ}
+		if p.peek("=") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			v, err := p.value(true)
+			if err != nil {
+				return nil, err
+			}
+			def.Default = &v
+		}
+		if def.Directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	if len(defs) == 0 {
+		return nil, p.errorf("expected variable definition, found %s", p.describe())
+	}
+	return defs, p.advance()
+}
+
+// typeRef parses a type reference and returns it in canonical printed form.
+func (p *gqlParser) typeRef() (string, error) {
+	var t string
+	if p.peek("[") {
+		if err := p.enter(); err != nil {
+			return "", err
+		}
+		defer p.leave()
+		if err := p.advance(); err != nil {
+			return "", err
+		}
+		inner, err := p.typeRef()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		t = "[" + inner + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		t = name
+	}
+	if p.peek("!") {
+		if err := p.advance(); err != nil {
+			return "", err
+		}
+		t += "!"
+	}
+	return t, nil
+}
+
+func (p *gqlParser) fragment() (*gqlFragment, error) {
+	if err := p.advance(); err != nil { // "fragment"
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, p.errorf("unexpected Name \"on\"")
+	}
+	if p.tok.kind != tokName || p.tok.value != "on" {
+		return nil, p.errorf("expected \"on\", found %s", p.describe())
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	frag := &gqlFragment{Name: name}
+	if frag.TypeCondition, err = p.name(); err != nil {
+		return nil, err
+	}
+	if frag.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if frag.Selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return frag, nil
+}
+
+func (p *gqlParser) selectionSet() ([]gqlSelection, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var sels []gqlSelection
+	for !p.peek("}") {
+		if p.tok.kind == tokEOF {
+			return nil, p.errorf("expected \"}\", found <EOF>")
+		}
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	if len(sels) == 0 {
+		return nil, p.errorf("expected Name, found \"}\"")
+	}
+	return sels, p.advance()
+}
+
+func (p *gqlParser) selection() (gqlSelection, error) {
+	if p.peek("...") {
+		return p.fragmentSelection()
+	}
+	return p.field()
+}
+
+func (p *gqlParser) field() (*gqlField, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	f := &gqlField{Name: name}
+	if p.peek(":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		f.Alias = name
+		if f.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek("(") {
+		if f.Arguments, err = p.arguments(false); err != nil {
+			return nil, err
+		}
+	}
+	if f.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if f.Selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *gqlParser) fragmentSelection() (gqlSelection, error) {
+	if err := p.advance(); err != nil { // "..."
+		return nil, err
+	}
+	if p.tok.kind == tokName && p.tok.value != "on" {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		spread := &gqlFragmentSpread{Name: name}
+		if spread.Directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		return spread, nil
+	}
+	inline := &gqlInlineFragment{}
+	var err error
+	if p.tok.kind == tokName && p.tok.value == "on" {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if inline.TypeCondition, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if inline.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if inline.Selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return inline, nil
+}
+
+func (p *gqlParser) arguments(constant bool) ([]*gqlArgument, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []*gqlArgument
+	for !p.peek(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, &gqlArgument{Name: name, Value: v})
+	}
+	if len(args) == 0 {
+		return nil, p.errorf("expected Name, found \")\"")
+	}
+	return args, p.advance()
+}
+
+func (p *gqlParser) directives() ([]*gqlDirective, error) {
+	var dirs []*gqlDirective
+	for p.peek("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		d := &gqlDirective{Name: name}
+		if p.peek("(") {
+			if d.Arguments, err = p.arguments(false); err != nil {
+				return nil, err
+			}
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+// value parses an input value; constant forbids variables (used for default values).
+func (p *gqlParser) value(constant bool) (gqlValue, error) {
+	t := p.tok
+	if p.peek("[") || p.peek("{") {
+		if err := p.enter(); err != nil {
+			return gqlValue{}, err
+		}
+		defer p.leave()
+	}
+	switch {
+	case p.peek("$"):
+		if constant {
+			return gqlValue{}, p.errorf("unexpected variable in constant value")
+		}
+		if err := p.advance(); err != nil {
+			return gqlValue{}, err
+		}
+		name, err := p.name()
+		return gqlValue{Kind: gqlVariable, Raw: name}, err
+	case p.peek("["):
+		if err := p.advance(); err != nil {
+			return gqlValue{}, err
+		}
+		v := gqlValue{Kind: gqlList, List: []gqlValue{}}
+		for !p.peek("]") {
+			if p.tok.kind == tokEOF {
+				return gqlValue{}, p.errorf("expected \"]\", found <EOF>")
+			}
+			item, err := p.value(constant)
+			if err != nil {
+				return gqlValue{}, err
+			}
+			v.List = append(v.List, item)
+		}
+		return v, p.advance()
+	case p.peek("{"):
+		if err := p.advance(); err != nil {
+			return gqlValue{}, err
+		}
+		v := gqlValue{Kind: gqlObject, Fields: []gqlObjectField{}}
+		for !p.peek("}") {
+			name, err := p.name()
+			if err != nil {
+				return gqlValue{}, err
+			}
+			if err := p.expect(":"); err != nil {
+				return gqlValue{}, err
+			}
+			fv, err := p.value(constant)
+			if err != nil {
+				return gqlValue{}, err
+			}
+			v.Fields = append(v.Fields, gqlObjectField{Name: name, Value: fv})
+		}
+		return v, p.advance()
+	case t.kind == tokInt:
+		return gqlValue{Kind: gqlInt, Raw: t.value}, p.advance()
+	case t.kind == tokFloat:
+		return gqlValue{Kind: gqlFloat, Raw: t.value}, p.advance()
+	case t.kind == tokString:
+		return gqlValue{Kind: gqlString, Raw: t.value}, p.advance()
+	case t.kind == tokName:
+		v := gqlValue{Kind: gqlEnum, Raw: t.value}
+		switch t.value {
+		case "true", "false":
+			v.Kind = gqlBoolean
+		case "null":
+			v.Kind = gqlNull
+		}
+		return v, p.advance()
+	}
+	return gqlValue{}, p.errorf("unexpected %s", p.describe())
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Documents sent by zone01-profile/src/graphql/queries.ts.
+const (
+	queryMe = `query { user { id login firstName lastName email } }`
+
+	queryXpTransactions = `
+query MyXp($limit: Int = 1000) {
+  transaction(
+    where: { type: { _eq: "xp" } }
+    order_by: { createdAt: asc }
+    limit: $limit
+  ) { id amount objectId userId createdAt path }
+}
+`
+
+	queryObjectByIDs = `
+query ObjByIds($ids: [Int!]) {
+  object(where: { id: { _in: $ids } }) { id name type }
+}
+`
+
+	queryProgress = `
+query MyProgress($limit: Int = 2000, $userId: Int!) {
+  progress(
+    order_by: [{ updatedAt: desc }, { createdAt: desc }]
+    limit: $limit
+    where: { userId: { _eq: $userId }, isDone: { _eq: true } }
+  ) {
+    id
+    grade
+    createdAt
+    updatedAt
+    path
+    objectId
+    object { id name type }
+    user { id login }
+  }
+}
+`
+)
+
+func TestParseFrontendQueries(t *testing.T) {
+	for _, src := range []string{queryMe, queryXpTransactions, queryObjectByIDs, queryProgress} {
+		doc, err := parseGraphQL(src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", src, err)
+		}
+		if len(doc.Operations) != 1 || doc.Operations[0].Type != "query" {
+			t.Fatalf("unexpected operations: %+v", doc.Operations)
+		}
+	}
+}
+
+func TestParseOperationDetails(t *testing.T) {
+	doc, err := parseGraphQL(queryProgress)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	op := doc.Operations[0]
+	if op.Name != "MyProgress" || len(op.Variables) != 2 {
+		t.Fatalf("unexpected operation header: %+v", op)
+	}
+	if v := op.Variables[1]; v.Name != "userId" || v.Type != "Int!" || v.Default != nil {
+		t.Fatalf("unexpected variable: %+v", v)
+	}
+	if d := op.Variables[0].Default; d == nil || d.Kind != gqlInt || d.Raw != "2000" {
+		t.Fatalf("unexpected default: %+v", d)
+	}
+	progress := op.Selections[0].(*gqlField)
+	if progress.Name != "progress" || len(progress.Arguments) != 3 || len(progress.Selections) != 8 {
+		t.Fatalf("unexpected field: %+v", progress)
+	}
+	orderBy := progress.Argument("order_by").Value
+	if orderBy.Kind != gqlList || len(orderBy.List) != 2 || orderBy.List[0].Fields[0].Value.Raw != "desc" {
+		t.Fatalf("unexpected order_by: %+v", orderBy)
+	}
+	if v := progress.Argument("limit").Value; v.Kind != gqlVariable || v.Raw != "limit" {
+		t.Fatalf("unexpected limit: %+v", v)
+	}
+}
+
+func TestParseFragmentsAndDirectives(t *testing.T) {
+	src := `
+# comment
+query Q($skip: Boolean!) {
+  me: user @include(if: true) { ...UserBits ... on user { email } ... @skip(if: $skip) { login } }
+}
+fragment UserBits on user { id, login }
+`
+	doc, err := parseGraphQL(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	field := doc.Operations[0].Selections[0].(*gqlField)
+	if field.Alias != "me" || field.Name != "user" || field.ResponseKey() != "me" {
+		t.Fatalf("unexpected alias handling: %+v", field)
+	}
+	if len(field.Directives) != 1 || field.Directives[0].Name != "include" {
+		t.Fatalf("unexpected directives: %+v", field.Directives)
+	}
+	if _, ok := field.Selections[0].(*gqlFragmentSpread); !ok {
+		t.Fatalf("expected fragment spread, got %T", field.Selections[0])
+	}
+	inline := field.Selections[1].(*gqlInlineFragment)
+	if inline.TypeCondition != "user" {
+		t.Fatalf("unexpected inline fragment: %+v", inline)
+	}
+	if bare := field.Selections[2].(*gqlInlineFragment); bare.TypeCondition != "" || bare.Directives[0].Name != "skip" {
+		t.Fatalf("unexpected bare inline fragment: %+v", bare)
+	}
+	if doc.fragment("UserBits") == nil || doc.fragment("UserBits").TypeCondition != "user" {
+		t.Fatalf("fragment not parsed: %+v", doc.Fragments)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	src := `{ f(a: -1.5e3, b: "tab\té\u{1F600}", c: null, d: ENUM, e: false, g: """
+      block
+        indented
+    """) { id } }`
+	doc, err := parseGraphQL(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	f := doc.Operations[0].Selections[0].(*gqlField)
+	want := map[string]gqlValue{
+		"a": {Kind: gqlFloat, Raw: "-1.5e3"},
+		"b": {Kind: gqlString, Raw: "tab\té😀"},
+		"c": {Kind: gqlNull, Raw: "null"},
+		"d": {Kind: gqlEnum, Raw: "ENUM"},
+		"e": {Kind: gqlBoolean, Raw: "false"},
+		"g": {Kind: gqlString, Raw: "block\n  indented"},
+	}
+	for name, w := range want {
+		got := f.Argument(name).Value
+		if got.Kind != w.Kind || got.Raw != w.Raw {
+			t.Errorf("%s: expected %+v, got %+v", name, w, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src          string
+		line, column int
+	}{
+		{"", 1, 1},
+		{"{}", 1, 2},
+		{"{ user { id }", 1, 14},
+		{"query {\n  user(limit: 01) { id }\n}", 2, 16},
+		{"{ f(a: \"open) }", 1, 16},
+		{"type Query { id: ID }", 1, 1},
+		{"query ($v: Int = $w) { id }", 1, 18},
+		{"{ a ... }", 1, 9},
+		{"{ f(a: " + strings.Repeat("[", 1<<16) + " }", 1, 135},
+		{"query ($v: " + strings.Repeat("[", 200) + "Int" + strings.Repeat("]", 200) + ") { id }", 1, 140},
+		{strings.Repeat("{ a ", 200) + strings.Repeat("}", 200), 1, 513},
+	}
+	for _, tc := range tests {
+		_, err := parseGraphQL(tc.src)
+		var se *gqlSyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("%q: expected syntax error, got %v", tc.src, err)
+			continue
+		}
+		if se.Line != tc.line || se.Column != tc.column {
+			t.Errorf("%q: expected %d:%d, got %d:%d (%s)", tc.src, tc.line, tc.column, se.Line, se.Column, se.Message)
+		}
+	}
+}
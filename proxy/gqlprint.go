@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// printDocument renders doc in a compact canonical form: comments, commas and insignificant
+// whitespace are dropped and string literals are re-escaped, so equivalent documents that only
+// differ in formatting print identically. The output parses back to the same document.
+func printDocument(doc *gqlDocument) string {
+	var b strings.Builder
+	for i, op := range doc.Operations {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		printOperation(&b, op)
+	}
+	for i, f := range doc.Fragments {
+		if i > 0 || len(doc.Operations) > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString("fragment ")
+		b.WriteString(f.Name)
+		b.WriteString(" on ")
+		b.WriteString(f.TypeCondition)
+		printDirectives(&b, f.Directives)
+		printSelections(&b, f.Selections)
+	}
+	return b.String()
+}
+
+func printOperation(b *strings.Builder, op *gqlOperation) {
+	if op.Type == "query" && op.Name == "" && len(op.Variables) == 0 && len(op.Directives) == 0 {
+		var sel strings.Builder
+		printSelections(&sel, op.Selections)
+		b.WriteString(sel.String()[1:]) // shorthand form starts with the brace
+		return
+	}
+	b.WriteString(op.Type)
+	if op.Name != "" {
+		b.WriteByte(' ')
+		b.WriteString(op.Name)
+	}
+	if len(op.Variables) > 0 {
+		b.WriteByte('(')
+		for i, v := range op.Variables {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteByte('$')
+			b.WriteString(v.Name)
+			b.WriteString(": ")
+			b.WriteString(v.Type)
+			if v.Default != nil {
+				b.WriteString(" = ")
+				printValue(b, *v.Default)
+			}
+			printDirectives(b, v.Directives)
+		}
+		b.WriteByte(')')
+	}
+	printDirectives(b, op.Directives)
+	printSelections(b, op.Selections)
+}
+
+// printSelections writes a selection set preceded by a space.
+func printSelections(b *strings.Builder, sels []gqlSelection) {
+	b.WriteString(" {")
+	for _, sel := range sels {
+		b.WriteByte(' ')
+		switch s := sel.(type) {
+		case *gqlField:
+			if s.Alias != "" {
+				b.WriteString(s.Alias)
+				b.WriteString(": ")
+			}
+			b.WriteString(s.Name)
+			printArguments(b, s.Arguments)
+			printDirectives(b, s.Directives)
+			if len(s.Selections) > 0 {
+				printSelections(b, s.Selections)
+			}
+		case *gqlFragmentSpread:
+			b.WriteString("...")
+			b.WriteString(s.Name)
+			printDirectives(b, s.Directives)
+		case *gqlInlineFragment:
+			b.WriteString("...")
+			if s.TypeCondition != "" {
+				b.WriteString(" on ")
+				b.WriteString(s.TypeCondition)
+			}
+			printDirectives(b, s.Directives)
+			printSelections(b, s.Selections)
+		}
+	}
+	b.WriteString(" }")
+}
+
+func printArguments(b *strings.Builder, args []*gqlArgument) {
+	if len(args) == 0 {
+		return
+	}
+	b.WriteByte('(')
+	for i, a := range args {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(a.Name)
+		b.WriteString(": ")
+		printValue(b, a.Value)
+	}
+	b.WriteByte(')')
+}
+
+func printDirectives(b *strings.Builder, dirs []*gqlDirective) {
+	for _, d := range dirs {
+		b.WriteString(" @")
+		b.WriteString(d.Name)
+		printArguments(b, d.Arguments)
+	}
+}
+
+func printValue(b *strings.Builder, v gqlValue) {
+	switch v.Kind {
+	case gqlVariable:
+		b.WriteByte('$')
+		b.WriteString(v.Raw)
+	case gqlString:
+		printString(b, v.Raw)
+	case gqlNull:
+		b.WriteString("null")
+	case gqlList:
+		b.WriteByte('[')
+		for i, item := range v.List {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			printValue(b, item)
+		}
+		b.WriteByte(']')
+	case gqlObject:
+		b.WriteByte('{')
+		for i, f := range v.Fields {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteByte(' ')
+			b.WriteString(f.Name)
+			b.WriteString(": ")
+			printValue(b, f.Value)
+		}
+		if len(v.Fields) > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteByte('}')
+	default: // numbers, booleans and enums keep their source text
+		b.WriteString(v.Raw)
+	}
+}
+
+// printString writes s as a quoted GraphQL string, escaping only what the grammar requires.
+func printString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				b.WriteString(`\u`)
+				hex := strconv.FormatInt(int64(r), 16)
+				b.WriteString(strings.Repeat("0", 4-len(hex)) + hex)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+}
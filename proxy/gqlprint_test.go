@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestPrintDocumentNormalizesFormatting(t *testing.T) {
+	a := `query MyXp($limit: Int = 1000) { transaction(where: {type: {_eq: "xp"}}, limit: $limit) { id amount } }`
+	b := `
+# fetch xp
+query MyXp(
+  $limit: Int = 1000
+) {
+  transaction(where: { type: { _eq: "xp" } } limit: $limit) {
+    id, amount
+  }
+}`
+	docA, err := parseGraphQL(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docB, err := parseGraphQL(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printDocument(docA) != printDocument(docB) {
+		t.Fatalf("expected identical output:\n%s\n%s", printDocument(docA), printDocument(docB))
+	}
+}
+
+func TestPrintDocumentRoundTrips(t *testing.T) {
+	srcs := []string{
+		queryMe, queryXpTransactions, queryObjectByIDs, queryProgress,
+		`query Q($a: [Int!]! = [1, 2], $s: String @deprecated) @live { a: f(x: null, y: ENUM, z: 1.5e3, s: "q\"\\\n\u0001é") @include(if: true) { ...F ... on T { b } ... @skip(if: $a) { c } } } fragment F on T { d }`,
+		`mutation { m(input: {}) { id } } subscription S { s }`,
+		`fragment Only on T { id }`,
+		`{ s(v: """ block "string" """) }`,
+	}
+	for _, src := range srcs {
+		doc, err := parseGraphQL(src)
+		if err != nil {
+			t.Fatalf("parse %q: %v", src, err)
+		}
+		printed := printDocument(doc)
+		again, err := parseGraphQL(printed)
+		if err != nil {
+			t.Fatalf("reparse %q: %v", printed, err)
+		}
+		if got := printDocument(again); got != printed {
+			t.Fatalf("print is not stable:\n%s\n%s", printed, got)
+		}
+	}
+}
+
+func TestPrintShorthandQuery(t *testing.T) {
+	doc, err := parseGraphQL(queryMe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := printDocument(doc), "{ user { id login firstName lastName email } }"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
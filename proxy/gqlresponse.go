@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GraphQL-over-HTTP response media types. Clients that accept
+// application/graphql-response+json get status codes that tell whether the operation ran:
+// 200 when the response has data, 4xx when the request was rejected before execution.
+// Legacy application/json clients keep getting the upstream status, 200 for errors.
+const (
+	mediaTypeJSON            = "application/json"
+	mediaTypeGraphQLResponse = "application/graphql-response+json"
+)
+
+// negotiateResponseType picks the media type of a GraphQL response from an Accept header.
+// application/graphql-response+json wins when it is accepted with at least the preference
+// of application/json; anything else, including a missing header, gets application/json.
+func negotiateResponseType(accept string) string {
+	var gqlQ, jsonQ float64 = -1, -1
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mt {
+		case mediaTypeGraphQLResponse:
+			gqlQ = max(gqlQ, q)
+		case mediaTypeJSON:
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	if gqlQ > 0 && gqlQ >= jsonQ {
+		return mediaTypeGraphQLResponse
+	}
+	return mediaTypeJSON
+}
+
+// responseStatus is the status code of res in mediaType.
+func responseStatus(mediaType string, res graphqlResult) int {
+	if mediaType != mediaTypeGraphQLResponse {
+		return res.status
+	}
+	if res.rejected {
+		return http.StatusBadRequest
+	}
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []gqlError      `json:"errors"`
+	}
+	if err := json.Unmarshal(res.body, &resp); err != nil {
+		return http.StatusBadGateway
+	}
+	switch {
+	case res.status >= 400:
+		return res.status
+	case resp.Data != nil:
+		return http.StatusOK
+	case len(resp.Errors) > 0 && resp.Errors[0].Extensions["code"] == "invalid-jwt":
+		return http.StatusUnauthorized
+	}
+	return http.StatusBadRequest
+}
+
+// writeGraphQLResponse writes body as mediaType.
+func writeGraphQLResponse(w http.ResponseWriter, mediaType string, status int, body []byte) {
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeGraphQLError answers with a GraphQL response that only carries err, for requests
+// the proxy could not run at all.
+func writeGraphQLError(w http.ResponseWriter, mediaType string, status int, err error) {
+	body, _ := json.Marshal(gqlErrorResponse{Errors: []gqlError{toGraphQLError(err)}})
+	writeGraphQLResponse(w, mediaType, status, body)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var acceptGraphQLResponse = http.Header{"Accept": {mediaTypeGraphQLResponse}}
+
+func TestNegotiateResponseType(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                       mediaTypeJSON,
+		"*/*":                    mediaTypeJSON,
+		"application/json":       mediaTypeJSON,
+		"text/html":              mediaTypeJSON,
+		mediaTypeGraphQLResponse: mediaTypeGraphQLResponse,
+		"application/graphql-response+json, application/json;q=0.9": mediaTypeGraphQLResponse,
+		"application/json, application/graphql-response+json":       mediaTypeGraphQLResponse,
+		"application/json, application/graphql-response+json;q=0.5": mediaTypeJSON,
+		"application/graphql-response+json;q=0":                     mediaTypeJSON,
+	} {
+		if got := negotiateResponseType(accept); got != want {
+			t.Errorf("%q: got %s, want %s", accept, got, want)
+		}
+	}
+}
+
+// rawGraphQL posts body to graphqlHandler with the given Accept header.
+func rawGraphQL(t *testing.T, body, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer token")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGraphQLResponseStatusCodes(t *testing.T) {
+	cases := []struct {
+		name, reply, query string
+		legacy, spec       int
+	}{
+		{"data", `{"data":{"user":[]}}`, "{ user { id } }", http.StatusOK, http.StatusOK},
+		{"field error", `{"data":null,"errors":[{"message":"boom"}]}`, "{ user { id } }", http.StatusOK, http.StatusOK},
+		{"parse failure", `{"data":{}}`, "{ user { id }", http.StatusOK, http.StatusBadRequest},
+		{"validation failure", `{"data":{}}`, "query A { user { id } } query A { user { id } }", http.StatusOK, http.StatusBadRequest},
+		{"upstream validation failure", `{"errors":[{"message":"field not found","extensions":{"code":"validation-failed"}}]}`, "{ nope }", http.StatusOK, http.StatusBadRequest},
+		{"upstream rejected token", `{"errors":[{"message":"Could not verify JWT","extensions":{"code":"invalid-jwt"}}]}`, "{ user { id } }", http.StatusOK, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			countingUpstream(t, tc.reply)
+			rr := cachedRequest(t, "token", tc.query, nil, nil)
+			if rr.Code != tc.legacy || rr.Header().Get("Content-Type") != mediaTypeJSON {
+				t.Fatalf("application/json: expected %d, got %d %s", tc.legacy, rr.Code, rr.Header().Get("Content-Type"))
+			}
+			rr = cachedRequest(t, "token", tc.query, nil, acceptGraphQLResponse)
+			if rr.Code != tc.spec || rr.Header().Get("Content-Type") != mediaTypeGraphQLResponse {
+				t.Fatalf("%s: expected %d, got %d %s", mediaTypeGraphQLResponse, tc.spec, rr.Code, rr.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestGraphQLMalformedRequestsGetErrors(t *testing.T) {
+	countingUpstream(t, `{"data":{}}`)
+	for _, body := range []string{"", "{", `{"query":5}`, "[]", "[1"} {
+		for _, accept := range []string{"", mediaTypeGraphQLResponse} {
+			rr := rawGraphQL(t, body, accept)
+			if rr.Code != http.StatusBadRequest || errorCode(t, rr) != codeBadRequest {
+				t.Fatalf("%q (%q): expected a 400 with %s, got %d %s", body, accept, codeBadRequest, rr.Code, rr.Body.String())
+			}
+			if want := negotiateResponseType(accept); rr.Header().Get("Content-Type") != want {
+				t.Fatalf("%q: expected %s, got %s", body, want, rr.Header().Get("Content-Type"))
+			}
+		}
+	}
+}
+
+func TestGraphQLUpstreamUnreachableGetsErrors(t *testing.T) {
+	overridePaths(t, "http://127.0.0.1:0", signinPath, "/graphql")
+	rr := rawGraphQL(t, `{"query":"{ user { id } }"}`, mediaTypeGraphQLResponse)
+	var resp gqlErrorResponse
+	if rr.Code != http.StatusBadGateway || json.Unmarshal(rr.Body.Bytes(), &resp) != nil || errorCode(t, rr) != codeUpstreamUnreachable {
+		t.Fatalf("expected a 502 with %s, got %d %s", codeUpstreamUnreachable, rr.Code, rr.Body.String())
+	}
+}
+
+func TestGraphQLBatchUsesTheNegotiatedType(t *testing.T) {
+	countingUpstream(t, `{"data":{"user":[]}}`)
+	for _, accept := range []string{"", mediaTypeGraphQLResponse} {
+		rr := rawGraphQL(t, `[{"query":"{ user { id } }"},{"query":"{ user { id } }"}]`, accept)
+		var results []json.RawMessage
+		if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &results) != nil || len(results) != 2 {
+			t.Fatalf("%q: unexpected batch response %d %s", accept, rr.Code, rr.Body.String())
+		}
+		if want := negotiateResponseType(accept); rr.Header().Get("Content-Type") != want {
+			t.Fatalf("%q: expected %s, got %s", accept, want, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestGraphQLMissingTokenGetsErrors(t *testing.T) {
+	for _, accept := range []string{"", mediaTypeGraphQLResponse} {
+		req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{ user { id } }"}`))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		graphqlHandler().ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized || errorCode(t, rr) != codeUnauthenticated || rr.Header().Get("Content-Type") != negotiateResponseType(accept) {
+			t.Fatalf("%q: expected a 401 with %s, got %d %s %s", accept, codeUnauthenticated, rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+		}
+	}
+}
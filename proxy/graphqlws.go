@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket upgrades of /graphql speak the graphql-transport-ws protocol. The upstream is
+// only reachable over HTTP, so a subscription is emulated: its document is re-run upstream
+// as a query every GQL_WS_POLL_INTERVAL and only what changed is sent to the client.
+var wsPollInterval = getenvDuration("GQL_WS_POLL_INTERVAL", 10*time.Second)
+var wsInitTimeout = getenvDuration("GQL_WS_INIT_TIMEOUT", 10*time.Second)
+var wsMaxSubscriptions = getenvInt("GQL_WS_MAX_SUBSCRIPTIONS", 20) // per connection
+
+const wsSubprotocol = "graphql-transport-ws"
+
+// wsWriteTimeout bounds one write to a client.
+const wsWriteTimeout = 10 * time.Second
+
+// graphql-transport-ws message types.
+const (
+	wsConnectionInit = "connection_init"
+	wsConnectionAck  = "connection_ack"
+	wsPing           = "ping"
+	wsPong           = "pong"
+	wsSubscribe      = "subscribe"
+	wsNext           = "next"
+	wsError          = "error"
+	wsComplete       = "complete"
+)
+
+// Close codes defined by graphql-transport-ws.
+const (
+	wsCloseBadMessage         = 4400
+	wsCloseUnauthorized       = 4401
+	wsCloseForbidden          = 4403
+	wsCloseBadSubprotocol     = 4406
+	wsCloseInitTimeout        = 4408
+	wsCloseSubscriberExists   = 4409
+	wsCloseTooManyInitRequest = 4429
+)
+
+// wsMessage is one graphql-transport-ws message.
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// CORS allows every origin and the token travels in connection_init, so any origin may
+// connect; a session cookie is only trusted for same-origin connections (see wsToken).
+var wsUpgrader = websocket.Upgrader{
+	Subprotocols: []string{wsSubprotocol},
+	CheckOrigin:  func(*http.Request) bool { return true },
+}
+
+// wsConn is one client connection.
+type wsConn struct {
+	conn    *websocket.Conn
+	upgrade *http.Request
+	writeMu sync.Mutex
+
+	caller *apiCaller // set by connection_init
+	role   string
+
+	mu   sync.Mutex
+	subs map[string]context.CancelFunc
+	wg   sync.WaitGroup // running operations
+}
+
+// send writes msg; errors mean the connection is gone and are left to the read loop.
+func (c *wsConn) send(msg wsMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("graphql-ws encode error: %v", err)
+		return
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	c.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// sendPayload sends a message whose payload is v.
+func (c *wsConn) sendPayload(id, typ string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("graphql-ws encode error: %v", err)
+		return
+	}
+	c.send(wsMessage{ID: id, Type: typ, Payload: b})
+}
+
+// close ends the connection with a protocol close code.
+func (c *wsConn) close(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+}
+
+// graphqlWSHandler upgrades GET /graphql and serves graphql-transport-ws until the client
+// goes away or breaks the protocol.
+func graphqlWSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // the upgrader already answered with an HTTP error
+		}
+		conn.SetReadLimit(int64(gqlMaxBodyBytes)) // the same cap as POST /graphql bodies
+		c := &wsConn{conn: conn, upgrade: r, subs: map[string]context.CancelFunc{}}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer func() {
+			cancel()
+			c.wg.Wait()
+			conn.Close()
+		}()
+		if conn.Subprotocol() != wsSubprotocol {
+			c.close(wsCloseBadSubprotocol, "Subprotocol not acceptable")
+			return
+		}
+		c.serve(ctx)
+	}
+}
+
+// serve reads messages until the connection ends.
+func (c *wsConn) serve(ctx context.Context) {
+	c.conn.SetReadDeadline(time.Now().Add(wsInitTimeout))
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			var ne interface{ Timeout() bool }
+			if c.caller == nil && errors.As(err, &ne) && ne.Timeout() {
+				c.close(wsCloseInitTimeout, "Connection initialisation timeout")
+			}
+			return
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+			c.close(wsCloseBadMessage, "Invalid message received")
+			return
+		}
+		switch msg.Type {
+		case wsConnectionInit:
+			if c.caller != nil {
+				c.close(wsCloseTooManyInitRequest, "Too many initialisation requests")
+				return
+			}
+			token, ok := wsToken(c.upgrade, msg.Payload)
+			if !ok {
+				c.close(wsCloseForbidden, "Forbidden")
+				return
+			}
+			if err := verifyLocally(token); err != nil {
+				log.Printf("graphql-ws rejected token: %v", err)
+				c.close(wsCloseForbidden, "Forbidden")
+				return
+			}
+			subject, role := tokenSubject(ctx, token)
+			c.caller, c.role = newAPICaller(token, subject), role
+			c.conn.SetReadDeadline(time.Time{})
+			c.send(wsMessage{Type: wsConnectionAck})
+		case wsPing:
+			c.send(wsMessage{Type: wsPong, Payload: msg.Payload})
+		case wsPong:
+		case wsSubscribe:
+			if c.caller == nil {
+				c.close(wsCloseUnauthorized, "Unauthorized")
+				return
+			}
+			req, err := decodeGraphQLRequest(msg.Payload)
+			if msg.ID == "" || err != nil {
+				c.close(wsCloseBadMessage, "Invalid message received")
+				return
+			}
+			if !c.start(ctx, msg.ID, req) {
+				c.close(wsCloseSubscriberExists, "Subscriber for "+msg.ID+" already exists")
+				return
+			}
+		case wsComplete:
+			c.stop(msg.ID)
+		default:
+			c.close(wsCloseBadMessage, "Invalid message received")
+			return
+		}
+	}
+}
+
+// wsToken reads the token from the connection_init payload ({"Authorization": "Bearer …"}
+// or {"token": "…"}), falling back to the upgrade request's bearer token, or its session
+// cookie when the page is served from the proxy's own origin.
+func wsToken(r *http.Request, payload json.RawMessage) (string, bool) {
+	var p map[string]any
+	if len(payload) > 0 && json.Unmarshal(payload, &p) == nil {
+		for _, key := range []string{"Authorization", "authorization", "token"} {
+			if v, ok := p[key].(string); ok && v != "" {
+				if scheme, rest, ok := strings.Cut(v, " "); ok && strings.EqualFold(scheme, "Bearer") {
+					v = rest
+				}
+				return strings.TrimSpace(v), true
+			}
+		}
+	}
+	if tok, ok := bearerToken(r); ok {
+		return tok, true
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); (err != nil || u.Host != r.Host) && !corsOriginAllowed(origin) {
+			return "", false
+		}
+	}
+	return requestToken(r)
+}
+
+// start runs an operation under id unless one is already running.
+func (c *wsConn) start(ctx context.Context, id string, req graphqlRequest) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subs[id]; ok {
+		return false
+	}
+	if len(c.subs) >= wsMaxSubscriptions {
+		c.sendPayload(id, wsError, []gqlError{{Message: "Too many operations on this connection.", Extensions: map[string]any{"code": codeBadRequest}}})
+		return true
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.subs[id] = cancel
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.run(ctx, id, req)
+		c.mu.Lock()
+		if ctx.Err() == nil { // else stop already removed it, and id may be reused
+			delete(c.subs, id)
+		}
+		c.mu.Unlock()
+		cancel()
+	}()
+	return true
+}
+
+// stop cancels the operation id, if it is still running; no complete is sent for it.
+func (c *wsConn) stop(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cancel, ok := c.subs[id]; ok {
+		cancel()
+		delete(c.subs, id)
+	}
+}
+
+// run executes one operation: queries and mutations answer once like POST /graphql,
+// subscriptions poll until completed.
+func (c *wsConn) run(ctx context.Context, id string, req graphqlRequest) {
+	resolved := req
+	if _, err := resolvePersistedQuery(&resolved); err != nil {
+		c.sendPayload(id, wsError, []gqlError{toGraphQLError(err)})
+		return
+	}
+	parsed, err := checkQueryLimits(resolved)
+	if err != nil {
+		c.sendPayload(id, wsError, []gqlError{toGraphQLError(err)})
+		return
+	}
+
+	if parsed.Op.Type != "subscription" {
+		quota, ok := c.acquire(ctx)
+		if !ok {
+			return
+		}
+		res := runGraphQL(ctx, c.caller.Subject, c.caller.Token, req, nil)
+		quota.Release()
+		if ctx.Err() != nil { // completed by the client or disconnected
+			return
+		}
+		if res.err != nil {
+			log.Printf("graphql-ws proxy error: %v", res.err)
+			c.sendPayload(id, wsError, []gqlError{{Message: "graphql upstream unreachable"}})
+			return
+		}
+		c.send(wsMessage{ID: id, Type: wsNext, Payload: res.body})
+		c.send(wsMessage{ID: id, Type: wsComplete})
+		return
+	}
+
+	// the same document as a query; plain query rules (pagination included) apply upstream
+	parsed.Op.Type = "query"
+	resolved.Query = printDocument(parsed.Doc)
+	plan, err := planPagination(parsed, resolved)
+	if err != nil {
+		c.sendPayload(id, wsError, []gqlError{toGraphQLError(err)})
+		return
+	}
+	body, err := upstreamBody(resolved)
+	if err != nil {
+		c.sendPayload(id, wsError, []gqlError{toGraphQLError(err)})
+		return
+	}
+	var prev map[string]json.RawMessage
+	expires := tokenExpiry(c.caller.Token)
+	ticker := time.NewTicker(wsPollInterval)
+	defer ticker.Stop()
+	for {
+		if !expires.IsZero() && !time.Now().Before(expires) {
+			c.sendPayload(id, wsError, []gqlError{{Message: "token expired", Extensions: map[string]any{"code": "invalid-jwt"}}})
+			return
+		}
+		if quota, ok := c.acquire(ctx); ok {
+			var status int
+			var respBody []byte
+			if plan != nil {
+				status, respBody, err = plan.run(ctx, c.caller.Token)
+			} else {
+				status, respBody, err = fetchGraphQL(ctx, c.caller.Token, body)
+			}
+			quota.Release()
+			if ctx.Err() != nil { // completed by the client or disconnected
+				return
+			}
+			if err != nil {
+				log.Printf("graphql-ws poll for %s failed: %v", c.caller.Subject, err)
+			} else if !c.emit(id, status, respBody, &prev) {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquire takes a slot of the caller's GraphQL quota for one upstream call, waiting while
+// it is exhausted; it reports false once ctx is done.
+func (c *wsConn) acquire(ctx context.Context) (quotaDecision, bool) {
+	for {
+		q := graphqlQuotas.Acquire(c.caller.Subject, c.role)
+		if q.Allowed {
+			return q, true
+		}
+		select {
+		case <-ctx.Done():
+			return q, false
+		case <-time.After(max(q.RetryAfter, 100*time.Millisecond)):
+		}
+	}
+}
+
+// wsResult is an upstream response to a polled subscription.
+type wsResult struct {
+	Data       map[string]json.RawMessage `json:"data,omitempty"`
+	Errors     []gqlError                 `json:"errors,omitempty"`
+	Extensions map[string]any             `json:"extensions,omitempty"`
+}
+
+// emit sends what changed in a poll result since *prev. The first result is sent whole;
+// later ones only carry the changed root fields, with list fields reduced to their added
+// or changed items and removed items listed in extensions.removed. A result with errors
+// is passed through, and ends the subscription when the upstream rejected the token.
+func (c *wsConn) emit(id string, status int, body []byte, prev *map[string]json.RawMessage) bool {
+	var res wsResult
+	if err := json.Unmarshal(body, &res); err != nil {
+		log.Printf("graphql-ws poll answered %d with an invalid body", status)
+		return true
+	}
+	if len(res.Errors) > 0 || res.Data == nil {
+		var code string
+		if len(res.Errors) > 0 {
+			code, _ = res.Errors[0].Extensions["code"].(string)
+		}
+		if status == http.StatusUnauthorized || status == http.StatusForbidden || code == "invalid-jwt" {
+			c.sendPayload(id, wsError, res.Errors)
+			return false
+		}
+		if res.Data == nil {
+			c.send(wsMessage{ID: id, Type: wsNext, Payload: body})
+			return true
+		}
+	}
+	if *prev == nil {
+		*prev = res.Data
+		c.send(wsMessage{ID: id, Type: wsNext, Payload: body})
+		return true
+	}
+	data, removed := diffResultData(*prev, res.Data)
+	*prev = res.Data
+	if len(data) == 0 && len(removed) == 0 && len(res.Errors) == 0 {
+		return true
+	}
+	out := wsResult{Data: data, Errors: res.Errors, Extensions: res.Extensions}
+	if out.Data == nil {
+		out.Data = map[string]json.RawMessage{}
+	}
+	if len(removed) > 0 {
+		if out.Extensions == nil {
+			out.Extensions = map[string]any{}
+		}
+		out.Extensions["removed"] = removed
+	}
+	c.sendPayload(id, wsNext, out)
+	return true
+}
+
+// diffResultData compares two results field by field. Lists keep only the items that are
+// new or changed, matched by their "id" when they have one; the ids (or values) of items
+// that disappeared are returned per field.
+func diffResultData(prev, cur map[string]json.RawMessage) (map[string]json.RawMessage, map[string][]json.RawMessage) {
+	data := map[string]json.RawMessage{}
+	removed := map[string][]json.RawMessage{}
+	for key, v := range cur {
+		old, ok := prev[key]
+		if ok && jsonEqual(old, v) {
+			continue
+		}
+		var oldItems, newItems []json.RawMessage
+		if !ok || json.Unmarshal(old, &oldItems) != nil || json.Unmarshal(v, &newItems) != nil || oldItems == nil || newItems == nil {
+			data[key] = v
+			continue
+		}
+		before := map[string]json.RawMessage{}
+		for _, item := range oldItems {
+			before[itemKey(item)] = item
+		}
+		changed := []json.RawMessage{}
+		seen := map[string]bool{}
+		for _, item := range newItems {
+			k := itemKey(item)
+			seen[k] = true
+			if o, ok := before[k]; !ok || !jsonEqual(o, item) {
+				changed = append(changed, item)
+			}
+		}
+		for _, item := range oldItems {
+			if k := itemKey(item); !seen[k] {
+				removed[key] = append(removed[key], json.RawMessage(k))
+			}
+		}
+		if len(changed) > 0 {
+			b, _ := json.Marshal(changed)
+			data[key] = b
+		}
+	}
+	return data, removed
+}
+
+// itemKey identifies a list item: its "id" when it is an object with one, else its value.
+func itemKey(item json.RawMessage) string {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(item, &obj) == nil {
+		if id, ok := obj["id"]; ok {
+			return string(id)
+		}
+	}
+	var b bytes.Buffer
+	if json.Compact(&b, item) != nil {
+		return string(item)
+	}
+	return b.String()
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func useWSIntervals(t *testing.T, poll, initTimeout time.Duration) {
+	oldPoll, oldInit := wsPollInterval, wsInitTimeout
+	wsPollInterval, wsInitTimeout = poll, initTimeout
+	t.Cleanup(func() { wsPollInterval, wsInitTimeout = oldPoll, oldInit })
+}
+
+// dialWS connects to /graphql through the router with the given subprotocols. Cleanup
+// closes the connection and waits for the handler, so no poll outlives the test.
+func dialWS(t *testing.T, subprotocols ...string) *websocket.Conn {
+	t.Helper()
+	router := mux.NewRouter()
+	RegisterRoutes(router)
+	var handlers sync.WaitGroup
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	d := websocket.Dialer{Subprotocols: subprotocols, HandshakeTimeout: 5 * time.Second}
+	conn, resp, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/graphql", nil)
+	if err != nil {
+		t.Fatalf("dial: %v %v", err, resp)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		handlers.Wait()
+	})
+	return conn
+}
+
+func sendWS(t *testing.T, conn *websocket.Conn, msg string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readWS returns the next message, or fails after a few seconds.
+func readWS(t *testing.T, conn *websocket.Conn) wsMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var msg wsMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("invalid message %s: %v", data, err)
+	}
+	return msg
+}
+
+// expectClose reads until the server closes the connection with code.
+func expectClose(t *testing.T, conn *websocket.Conn, code int) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		var ce *websocket.CloseError
+		if errors.As(err, &ce) {
+			if ce.Code != code {
+				t.Fatalf("expected close %d, got %d %q", code, ce.Code, ce.Text)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("expected close %d, got %v", code, err)
+		}
+	}
+}
+
+// openWS connects and initialises the connection as user 42.
+func openWS(t *testing.T) *websocket.Conn {
+	t.Helper()
+	conn := dialWS(t, wsSubprotocol)
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	sendWS(t, conn, `{"type":"connection_init","payload":{"Authorization":"Bearer `+token+`"}}`)
+	if m := readWS(t, conn); m.Type != wsConnectionAck {
+		t.Fatalf("expected connection_ack, got %+v", m)
+	}
+	return conn
+}
+
+// nextData decodes the payload of a next message for id.
+func nextData(t *testing.T, m wsMessage, id string) wsResult {
+	t.Helper()
+	if m.Type != wsNext || m.ID != id {
+		t.Fatalf("expected next for %s, got %+v %s", id, m, m.Payload)
+	}
+	var res wsResult
+	if err := json.Unmarshal(m.Payload, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// wsRows decodes the list under field of a result.
+func wsRows(t *testing.T, res wsResult, field string) []map[string]any {
+	t.Helper()
+	var rows []map[string]any
+	if err := json.Unmarshal(res.Data[field], &rows); err != nil {
+		t.Fatalf("%s: %v", field, err)
+	}
+	return rows
+}
+
+func TestGraphQLWSPing(t *testing.T) {
+	conn := openWS(t)
+	sendWS(t, conn, `{"type":"ping","payload":{"n":1}}`)
+	if m := readWS(t, conn); m.Type != wsPong || string(m.Payload) != `{"n":1}` {
+		t.Fatalf("expected pong echoing the payload, got %+v", m)
+	}
+}
+
+func TestGraphQLWSSubscriptionSendsDiffs(t *testing.T) {
+	useWSIntervals(t, 20*time.Millisecond, time.Second)
+	z := newFakeZone01(t)
+	seedXP(z)
+	conn := openWS(t)
+
+	sendWS(t, conn, `{"id":"1","type":"subscribe","payload":{"query":"subscription { transaction { id amount } }"}}`)
+	first := nextData(t, readWS(t, conn), "1")
+	if rows := wsRows(t, first, "transaction"); len(rows) != 4 {
+		t.Fatalf("the first result should be complete, got %v", rows)
+	}
+
+	z.add("transaction", map[string]any{"id": 7, "type": "xp", "amount": 1500, "userId": 42, "createdAt": "2024-03-02T08:00:00+00:00", "path": "/athens/div-01/x"})
+	added := nextData(t, readWS(t, conn), "1")
+	if rows := wsRows(t, added, "transaction"); len(rows) != 1 || rows[0]["id"] != float64(7) {
+		t.Fatalf("only the new row should be sent, got %v", rows)
+	}
+
+	z.mu.Lock()
+	txs := z.tables["transaction"]
+	txs[0]["amount"] = float64(1200)
+	z.tables["transaction"] = append(txs[:1], txs[2:]...)
+	z.mu.Unlock()
+	changed := nextData(t, readWS(t, conn), "1")
+	if rows := wsRows(t, changed, "transaction"); len(rows) != 1 || rows[0]["id"] != float64(1) || rows[0]["amount"] != float64(1200) {
+		t.Fatalf("only the changed row should be sent, got %v", rows)
+	}
+	if removed, _ := json.Marshal(changed.Extensions["removed"]); string(removed) != `{"transaction":[2]}` {
+		t.Fatalf("removed rows should be listed, got %s", removed)
+	}
+}
+
+func TestGraphQLWSCompleteStopsPolling(t *testing.T) {
+	useWSIntervals(t, 10*time.Millisecond, time.Second)
+	z := newFakeZone01(t)
+	seedXP(z)
+	conn := openWS(t)
+
+	sendWS(t, conn, `{"id":"a","type":"subscribe","payload":{"query":"subscription { transaction { id } }"}}`)
+	nextData(t, readWS(t, conn), "a")
+	sendWS(t, conn, `{"id":"a","type":"complete"}`)
+	time.Sleep(50 * time.Millisecond)
+	calls := z.callCount("transaction")
+	time.Sleep(100 * time.Millisecond)
+	if got := z.callCount("transaction"); got != calls {
+		t.Fatalf("polling should stop after complete, %d calls became %d", calls, got)
+	}
+
+	// the id is free again
+	sendWS(t, conn, `{"id":"a","type":"subscribe","payload":{"query":"subscription { transaction { id } }"}}`)
+	nextData(t, readWS(t, conn), "a")
+}
+
+func TestGraphQLWSQueryAnswersOnce(t *testing.T) {
+	z := newFakeZone01(t)
+	seedXP(z)
+	conn := openWS(t)
+
+	sendWS(t, conn, `{"id":"q","type":"subscribe","payload":{"query":"{ transaction { id } }"}}`)
+	res := nextData(t, readWS(t, conn), "q")
+	if !strings.Contains(string(res.Data["transaction"]), `"id":4`) {
+		t.Fatalf("unexpected result %s", res.Data["transaction"])
+	}
+	if m := readWS(t, conn); m.Type != wsComplete || m.ID != "q" {
+		t.Fatalf("expected complete, got %+v", m)
+	}
+}
+
+func TestGraphQLWSErrors(t *testing.T) {
+	useWSIntervals(t, 10*time.Millisecond, time.Second)
+	z := newFakeZone01(t)
+	seedXP(z)
+	conn := openWS(t)
+
+	sendWS(t, conn, `{"id":"p","type":"subscribe","payload":{"query":"subscription { transaction { id }"}}`)
+	if m := readWS(t, conn); m.Type != wsError || m.ID != "p" || !strings.Contains(string(m.Payload), codeParseFailed) {
+		t.Fatalf("expected a parse error, got %+v %s", m, m.Payload)
+	}
+
+	z.mu.Lock()
+	z.errors["transaction"] = gqlError{Message: "Could not verify JWT", Extensions: map[string]any{"code": "invalid-jwt"}}
+	z.mu.Unlock()
+	sendWS(t, conn, `{"id":"s","type":"subscribe","payload":{"query":"subscription { transaction { id } }"}}`)
+	if m := readWS(t, conn); m.Type != wsError || m.ID != "s" || !strings.Contains(string(m.Payload), "invalid-jwt") {
+		t.Fatalf("a rejected token should end the subscription, got %+v %s", m, m.Payload)
+	}
+}
+
+func TestGraphQLWSProtocolViolations(t *testing.T) {
+	useWSIntervals(t, time.Hour, 50*time.Millisecond)
+	seedXP(newFakeZone01(t))
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	init := `{"type":"connection_init","payload":{"token":"` + token + `"}}`
+	subscribe := `{"id":"1","type":"subscribe","payload":{"query":"subscription { transaction { id } }"}}`
+
+	cases := []struct {
+		name string
+		msgs []string
+		code int
+	}{
+		{"subscribe before init", []string{subscribe}, wsCloseUnauthorized},
+		{"second init", []string{init, init}, wsCloseTooManyInitRequest},
+		{"no token", []string{`{"type":"connection_init"}`}, wsCloseForbidden},
+		{"duplicate id", []string{init, subscribe, subscribe}, wsCloseSubscriberExists},
+		{"unknown type", []string{`{"type":"start"}`}, wsCloseBadMessage},
+		{"subscribe without id", []string{init, `{"type":"subscribe","payload":{"query":"{ transaction { id } }"}}`}, wsCloseBadMessage},
+		{"no init", nil, wsCloseInitTimeout},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := dialWS(t, wsSubprotocol)
+			for _, m := range tc.msgs {
+				sendWS(t, conn, m)
+			}
+			expectClose(t, conn, tc.code)
+		})
+	}
+}
+
+func TestGraphQLWSRequiresSubprotocol(t *testing.T) {
+	expectClose(t, dialWS(t), wsCloseBadSubprotocol)
+}
+
+func TestWSToken(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+	for payload, want := range map[string]string{
+		`{"Authorization":"Bearer abc"}`: "abc",
+		`{"authorization":"bearer abc"}`: "abc",
+		`{"token":"abc"}`:                "abc",
+	} {
+		if got, ok := wsToken(r, json.RawMessage(payload)); !ok || got != want {
+			t.Fatalf("%s: got %q %v", payload, got, ok)
+		}
+	}
+	r.Header.Set("Authorization", "Bearer hdr")
+	if got, ok := wsToken(r, nil); !ok || got != "hdr" {
+		t.Fatalf("the upgrade request's bearer token should be used, got %q %v", got, ok)
+	}
+
+	store := enableSessions(t)
+	store.Put("sid", session{Token: "cookie", ExpiresAt: time.Now().Add(time.Hour)})
+	r = httptest.NewRequest(http.MethodGet, "http://proxy.example/graphql", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "sid"})
+	r.Header.Set("Origin", "https://app.example")
+	if _, ok := wsToken(r, nil); ok {
+		t.Fatal("other origins must not use the session cookie")
+	}
+	useCORSOrigins(t, "https://app.example")
+	if got, ok := wsToken(r, nil); !ok || got != "cookie" {
+		t.Fatalf("listed origins may use the session cookie, got %q %v", got, ok)
+	}
+}
+
+func TestGraphQLWSCapsMessageSize(t *testing.T) {
+	useWSIntervals(t, time.Hour, time.Second)
+	old := gqlMaxBodyBytes
+	gqlMaxBodyBytes = 1 << 10
+	t.Cleanup(func() { gqlMaxBodyBytes = old })
+	conn := dialWS(t, wsSubprotocol)
+	sendWS(t, conn, `{"type":"connection_init","payload":{"token":"`+strings.Repeat("a", 2<<10)+`"}}`)
+	expectClose(t, conn, websocket.CloseMessageTooBig)
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -16,7 +19,8 @@ var zone01Base = getenv("ZONE01_BASE", "https://platform.zone01.gr")
 var signinPath = getenv("SIGNIN_PATH", "/api/auth/signin")
 var graphqlPath = getenv("GRAPHQL_PATH", "/api/graphql-engine/v1/graphql")
 
-// authHandler validates user credentials against Zone01 and returns the JWT from the upstream service.
+// authHandler validates user credentials against Zone01 and returns the JWT from the upstream service
+// together with its decoded expiry and identity claims.
 func authHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		withCORS(w, r)
@@ -28,6 +32,11 @@ func authHandler() http.HandlerFunc {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		if ok, wait := signinGuard.allowIP(clientIP(r)); !ok {
+			log.Printf("auth signin throttled ip=%s", clientIP(r))
+			tooManyRequests(w, wait, "too many sign-in attempts")
+			return
+		}
 		var req loginRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			log.Printf("auth signin decode error: %v", err)
@@ -39,59 +48,136 @@ func authHandler() http.HandlerFunc {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
-		basic := base64.StdEncoding.EncodeToString([]byte(req.Identity + ":" + req.Password))
-		zReq, err := http.NewRequest(http.MethodPost, zone01Base+signinPath, nil)
-		if err != nil {
-			http.Error(w, "cannot create auth request", http.StatusInternalServerError)
+		if ok, wait := signinGuard.allowIdentity(req.Identity); !ok {
+			log.Printf("auth signin throttled identity")
+			tooManyRequests(w, wait, "too many sign-in attempts")
 			return
 		}
-		zReq.Header.Set("Authorization", "Basic "+basic)
-
-		client := &http.Client{Timeout: 15 * time.Second}
-		zResp, err := client.Do(zReq)
+		basic := base64.StdEncoding.EncodeToString([]byte(req.Identity + ":" + req.Password))
+		tok, err := requestUpstreamToken(zone01Base+signinPath, "Basic "+basic)
 		if err != nil {
-			log.Printf("auth signin proxy error: %v", err)
-			http.Error(w, "auth service unreachable", http.StatusBadGateway)
+			var statusErr *upstreamStatusError
+			var tokErr *tokenError
+			switch {
+			case errors.As(err, &statusErr):
+				log.Printf("auth signin upstream status=%d body=%q", statusErr.Status, statusErr.Body)
+				if statusErr.Status == http.StatusUnauthorized || statusErr.Status == http.StatusForbidden {
+					signinGuard.lockout.Failure(identityKey(req.Identity))
+				}
+				// avoid leaking server messages; keep it generic
+				http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			case errors.Is(err, errNoToken):
+				http.Error(w, "could not parse token", http.StatusBadGateway)
+			case errors.As(err, &tokErr):
+				log.Printf("auth signin upstream token rejected: %v", err)
+				http.Error(w, "upstream returned an invalid token", http.StatusBadGateway)
+			default:
+				log.Printf("auth signin proxy error: %v", err)
+				http.Error(w, "auth service unreachable", http.StatusBadGateway)
+			}
 			return
 		}
-		defer zResp.Body.Close()
-
-		body, _ := io.ReadAll(zResp.Body)
-		if zResp.StatusCode < 200 || zResp.StatusCode >= 300 {
-			log.Printf("auth signin upstream status=%d body=%q", zResp.StatusCode, string(body))
-			// avoid leaking server messages; keep it generic
-			http.Error(w, "invalid credentials", http.StatusUnauthorized)
-			return
+		signinGuard.lockout.Success(identityKey(req.Identity))
+		if refreshMode == refreshModeCredentials {
+			heldCredentials.remember(tok.Claims.UserID(), "Basic "+basic, tok.Claims.Expiry())
 		}
 
-		// The signin endpoint might return plain text (JWT) or JSON.
-		// 1) Try JSON: { "token": "<JWT>" } or { "jwt": "<JWT>" }
-		type anyResp map[string]any
-		var js anyResp
-		token := ""
-		if json.Unmarshal(body, &js) == nil && js != nil {
-			if v, ok := js["token"].(string); ok {
-				token = v
-			} else if v, ok := js["jwt"].(string); ok {
-				token = v
+		resp := newLoginResponse(tok, req.Identity)
+		if sessionsEnabled() {
+			if err := startSession(w, tok); err != nil {
+				log.Printf("auth signin session error: %v", err)
+				http.Error(w, "cannot create session", http.StatusInternalServerError)
+				return
 			}
-		}
-		// 2) Fallback to raw string (strip quotes/newlines)
-		if token == "" {
-			token = strings.TrimSpace(string(bytes.Trim(body, "\" \n\r\t")))
-		}
-		if token == "" {
-			http.Error(w, "could not parse token", http.StatusBadGateway)
-			return
+			resp.Token = "" // the JWT stays server-side in session mode
 		}
 
 		withJSON(w)
-		okJSON(w, loginResponse{Token: token})
+		okJSON(w, resp)
 
 	}
 }
 
-// refreshHandler keeps the session alive by returning a simple ok JSON response.
+// upstreamStatusError reports a non-2xx answer from an upstream auth endpoint.
+type upstreamStatusError struct {
+	Status int
+	Body   string
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("upstream status %d", e.Status)
+}
+
+// errNoToken is returned when an upstream auth response carries no token at all.
+var errNoToken = errors.New("no token in upstream response")
+
+// requestUpstreamToken POSTs to an upstream auth endpoint with the given Authorization
+// header and decodes the JWT it hands back.
+func requestUpstreamToken(url, authorization string) (*jwtToken, error) {
+	zReq, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	zReq.Header.Set("Authorization", authorization)
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	zResp, err := client.Do(zReq)
+	if err != nil {
+		return nil, err
+	}
+	defer zResp.Body.Close()
+
+	body, _ := io.ReadAll(zResp.Body)
+	if zResp.StatusCode < 200 || zResp.StatusCode >= 300 {
+		return nil, &upstreamStatusError{Status: zResp.StatusCode, Body: string(body)}
+	}
+	token := extractToken(body)
+	if token == "" {
+		return nil, errNoToken
+	}
+	return parseJWT(token)
+}
+
+// extractToken pulls the JWT out of a sign-in response body.
+// The signin endpoint might return plain text (JWT) or JSON.
+func extractToken(body []byte) string {
+	// 1) Try JSON: { "token": "<JWT>" } or { "jwt": "<JWT>" }
+	var js map[string]any
+	if json.Unmarshal(body, &js) == nil && js != nil {
+		if v, ok := js["token"].(string); ok {
+			return v
+		}
+		if v, ok := js["jwt"].(string); ok {
+			return v
+		}
+	}
+	// 2) Fallback to raw string (strip quotes/newlines)
+	return strings.TrimSpace(string(bytes.Trim(body, "\" \n\r\t")))
+}
+
+// newLoginResponse copies the interesting claims of tok into the sign-in payload.
+// Zone01 tokens carry no login claim, so a non-email identity stands in for it.
+func newLoginResponse(tok *jwtToken, identity string) loginResponse {
+	c := tok.Claims
+	resp := loginResponse{
+		Token:        tok.Raw,
+		Exp:          &c.ExpiresAt,
+		UserID:       c.UserID(),
+		DefaultRole:  c.Hasura.DefaultRole,
+		AllowedRoles: c.Hasura.AllowedRoles,
+		Login:        c.Login,
+	}
+	if c.IssuedAt != 0 {
+		resp.Iat = &c.IssuedAt
+	}
+	if resp.Login == "" && !strings.Contains(identity, "@") {
+		resp.Login = identity
+	}
+	return resp
+}
+
+// refreshHandler re-validates the presented bearer token, reports its remaining lifetime and
+// swaps it for a fresh one when it is within REFRESH_WINDOW of expiring.
 func refreshHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		withCORS(w, r)
@@ -103,12 +189,74 @@ func refreshHandler() http.HandlerFunc {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		raw, ok := requestToken(r)
+		if !ok {
+			unauthorized(w, "missing bearer token")
+			return
+		}
+		tok, err := parseJWT(raw)
+		if err != nil {
+			log.Printf("auth refresh rejected token: %v", err)
+			unauthorized(w, "invalid token")
+			return
+		}
+		if !time.Now().Before(tok.Claims.Expiry()) {
+			unauthorized(w, "token expired")
+			return
+		}
+		if localVerifier != nil {
+			if err := localVerifier.Verify(tok); err != nil {
+				log.Printf("auth refresh rejected token: %v", err)
+				writeTokenError(w, err)
+				return
+			}
+		}
+		valid, err := validateUpstreamSession(raw)
+		if err != nil {
+			log.Printf("auth refresh upstream error: %v", err)
+			http.Error(w, "auth service unreachable", http.StatusBadGateway)
+			return
+		}
+		if !valid {
+			unauthorized(w, "invalid token")
+			return
+		}
+
+		resp := refreshResponse{Status: "ok"}
+		if refreshMode != refreshModeNone && time.Until(tok.Claims.Expiry()) < refreshWindow {
+			fresh, err := refreshToken(tok)
+			if err != nil {
+				// the current token is still good, so report it rather than failing the ping
+				log.Printf("auth refresh could not renew token: %v", err)
+			} else {
+				tok = fresh
+				resp.Refreshed = true
+				resp.Token = fresh.Raw
+				if id, s, ok := sessionFromRequest(r); ok && s.Token == raw {
+					s.Token, s.ExpiresAt = fresh.Raw, fresh.Claims.Expiry()
+					if err := sessions.Put(id, s); err != nil {
+						log.Printf("auth refresh session update error: %v", err)
+					}
+					resp.Token = ""
+				}
+			}
+		}
+		resp.Exp = tok.Claims.ExpiresAt
+		resp.ExpiresIn = int64(time.Until(tok.Claims.Expiry()).Seconds())
+
 		withJSON(w)
-		okJSON(w, map[string]string{"status": "ok"})
+		okJSON(w, resp)
 	}
 }
 
-// graphqlHandler proxies GraphQL POST requests and streams the upstream response.
+// graphqlHandler proxies GraphQL POST and GET requests and relays the upstream response.
+// In session mode the bearer token is attached from the caller's session cookie.
+// Documents are parsed first so queries exceeding the GQL_MAX_* limits never reach upstream,
+// and persisted query hashes are resolved to full documents before forwarding.
+// Query results may be served from gqlCache when a TTL is configured for the operation, and
+// identical queries in flight with the same token may share a single upstream call.
+// A JSON array body is treated as a batch of operations (see runGraphQLBatch), and GET
+// requests carry a single query in the URL (see serveGraphQLGet).
 func graphqlHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		withCORS(w, r)
@@ -116,42 +264,177 @@ func graphqlHandler() http.HandlerFunc {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		if r.Method != http.MethodPost {
+		if r.Method != http.MethodPost && r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET, POST, OPTIONS")
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		bearer := r.Header.Get("Authorization")
-		if !strings.HasPrefix(strings.ToLower(bearer), "bearer ") {
-			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		mediaType := negotiateResponseType(r.Header.Get("Accept"))
+		w.Header().Add("Vary", "Accept")
+		token, ok := requestToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeGraphQLError(w, mediaType, http.StatusUnauthorized, requestErrorf(codeUnauthenticated, "Missing bearer token."))
 			return
 		}
-
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "invalid request body", http.StatusBadRequest)
+		if err := verifyLocally(token); err != nil {
+			log.Printf("graphql rejected token: %v", err)
+			writeTokenError(w, err)
 			return
 		}
+		subject, role := tokenSubject(r.Context(), token)
+		quota := graphqlQuotas.Acquire(subject, role)
+		setRateLimitHeaders(w, quota)
+		if !quota.Allowed {
+			tooManyRequests(w, quota.RetryAfter, quota.Reason)
+			return
+		}
+		defer quota.Release()
 
-		zReq, err := http.NewRequest(http.MethodPost, zone01Base+graphqlPath, bytes.NewReader(body))
+		if r.Method == http.MethodGet {
+			serveGraphQLGet(w, r, subject, token, mediaType)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(gqlMaxBodyBytes)))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeGraphQLError(w, mediaType, http.StatusRequestEntityTooLarge, requestErrorf(codeBadRequest, "The request body exceeds %d bytes.", tooLarge.Limit))
+			return
+		}
 		if err != nil {
-			http.Error(w, "cannot create upstream request", http.StatusInternalServerError)
+			writeGraphQLError(w, mediaType, http.StatusBadRequest, requestErrorf(codeBadRequest, "Could not read the request body."))
 			return
 		}
-		zReq.Header.Set("Content-Type", "application/json")
-		zReq.Header.Set("Authorization", bearer)
-
-		client := &http.Client{Timeout: 30 * time.Second}
-		zResp, err := client.Do(zReq)
+		if isBatch(body) {
+			runGraphQLBatch(w, r, subject, role, token, body, mediaType)
+			return
+		}
+		gqlReq, err := decodeGraphQLRequest(body)
 		if err != nil {
-			log.Printf("graphql proxy error: %v", err)
-			http.Error(w, "graphql upstream unreachable", http.StatusBadGateway)
+			writeGraphQLError(w, mediaType, http.StatusBadRequest, requestErrorf(codeBadRequest, "The request body must be a JSON object with a query string."))
+			return
+		}
+		res := runGraphQL(r.Context(), subject, token, gqlReq, body)
+		if res.err != nil {
+			log.Printf("graphql proxy error: %v", res.err)
+			writeGraphQLError(w, mediaType, http.StatusBadGateway, requestErrorf(codeUpstreamUnreachable, "graphql upstream unreachable"))
+			return
+		}
+		if res.entry != nil {
+			writeCachedResponse(w, r, res.entry, gqlCache.now(), res.cacheStatus, mediaType)
 			return
 		}
-		defer zResp.Body.Close()
+		if res.noStore {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		// upstream GraphQL errors are passed through as JSON
+		writeGraphQLResponse(w, mediaType, responseStatus(mediaType, res), res.body)
+	}
+}
 
-		withJSON(w)
-		w.WriteHeader(zResp.StatusCode)
-		io.Copy(w, zResp.Body) // stream back (includes GraphQL errors as JSON)
+// graphqlResult is the outcome of one GraphQL operation.
+type graphqlResult struct {
+	status      int
+	body        []byte
+	entry       *cacheEntry // set for cacheable results
+	cacheStatus string      // "HIT" or "MISS" alongside entry
+	noStore     bool        // the result must not be cached by the client
+	rejected    bool        // the proxy refused the operation before execution
+	err         error       // the upstream could not be reached
+}
 
+// graphqlErrorResult builds a result that only carries errors produced by the proxy.
+func graphqlErrorResult(errs ...error) graphqlResult {
+	resp := gqlErrorResponse{}
+	for _, err := range errs {
+		resp.Errors = append(resp.Errors, toGraphQLError(err))
+	}
+	body, _ := json.Marshal(resp)
+	return graphqlResult{status: http.StatusOK, body: body, rejected: true}
+}
+
+// runGraphQL executes one operation on behalf of subject: it resolves persisted queries,
+// enforces the query limits, then answers from the cache or the upstream, paginating
+// when the request asks for it. body is the
+// original request encoding and is forwarded unchanged when nothing had to be rewritten.
+func runGraphQL(ctx context.Context, subject, token string, gqlReq graphqlRequest, body []byte) graphqlResult {
+	persisted, err := resolvePersistedQuery(&gqlReq)
+	if err != nil {
+		return graphqlErrorResult(err)
+	}
+	parsed, err := checkQueryLimits(gqlReq)
+	if err != nil {
+		log.Printf("graphql rejected query: %v", err)
+		return graphqlErrorResult(err)
+	}
+	plan, err := planPagination(parsed, gqlReq)
+	if err != nil {
+		return graphqlErrorResult(err)
+	}
+	if persisted || body == nil {
+		// the upstream knows nothing about persisted queries, so send it the full document
+		if persisted {
+			registerPersistedQuery(gqlReq)
+		}
+		if body, err = upstreamBody(gqlReq); err != nil {
+			return graphqlErrorResult(err)
+		}
+	}
+
+	// only queries are safe to share between callers, whether from the cache or in flight
+	var key string
+	if parsed.Op.Type == "query" {
+		if key, err = operationKey(subject, token, parsed, gqlReq); err != nil {
+			key = ""
+		}
+	}
+	ttl := cacheTTL(parsed.Op)
+	if key == "" {
+		ttl = 0
+	}
+	if ttl > 0 {
+		if e, ok := gqlCache.Get(key); ok {
+			return graphqlResult{status: http.StatusOK, body: e.body, entry: e, cacheStatus: "HIT"}
+		}
+	}
+
+	fetch := func(ctx context.Context) (int, []byte, error) {
+		return fetchGraphQL(ctx, token, body)
+	}
+	if plan != nil {
+		fetch = func(ctx context.Context) (int, []byte, error) {
+			return plan.run(ctx, token)
+		}
+	}
+	status, respBody, err := fetchShared(ctx, key, fetch)
+	if err != nil {
+		return graphqlResult{err: err}
+	}
+	if ttl > 0 && cacheableResponse(status, respBody) {
+		e := gqlCache.Put(key, respBody, ttl)
+		return graphqlResult{status: status, body: respBody, entry: e, cacheStatus: "MISS"}
+	}
+	return graphqlResult{status: status, body: respBody, noStore: ttl > 0 || parsed.Op.Type != "query"}
+}
+
+// fetchGraphQL posts body to the upstream GraphQL endpoint and returns its buffered answer.
+func fetchGraphQL(ctx context.Context, token string, body []byte) (int, []byte, error) {
+	zReq, err := http.NewRequestWithContext(ctx, http.MethodPost, zone01Base+graphqlPath, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	zReq.Header.Set("Content-Type", "application/json")
+	zReq.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	zResp, err := client.Do(zReq)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zResp.Body.Close()
+	respBody, err := io.ReadAll(zResp.Body)
+	if err != nil {
+		return 0, nil, err
 	}
+	return zResp.StatusCode, respBody, nil
 }
@@ -9,13 +9,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func overridePaths(t *testing.T, base, signin, graphql string) {
-	oldBase, oldSignin, oldGraphql := zone01Base, signinPath, graphqlPath
-	zone01Base, signinPath, graphqlPath = base, signin, graphql
+	oldBase, oldSignin, oldGraphql, oldIdentities := zone01Base, signinPath, graphqlPath, identities
+	// identities confirmed by another upstream do not hold for this one
+	zone01Base, signinPath, graphqlPath, identities = base, signin, graphql, newIdentityCache()
 	t.Cleanup(func() {
-		zone01Base, signinPath, graphqlPath = oldBase, oldSignin, oldGraphql
+		zone01Base, signinPath, graphqlPath, identities = oldBase, oldSignin, oldGraphql, oldIdentities
 	})
 }
 
@@ -92,10 +94,12 @@ func TestAuthHandlerUpstreamError(t *testing.T) {
 }
 
 func TestAuthHandlerSuccessJSONToken(t *testing.T) {
+	exp := time.Unix(1900000000, 0)
+	token := makeJWT(t, zoneClaims("42", exp))
 	seenAuth := ""
 	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		seenAuth = r.Header.Get("Authorization")
-		io.WriteString(w, `{"token":"jwt-123"}`)
+		io.WriteString(w, `{"token":"`+token+`"}`)
 	}))
 	t.Cleanup(upstream.Close)
 	overridePaths(t, upstream.URL, "/signin", graphqlPath)
@@ -122,14 +126,27 @@ func TestAuthHandlerSuccessJSONToken(t *testing.T) {
 	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("response not JSON: %v", err)
 	}
-	if resp.Token != "jwt-123" {
+	if resp.Token != token {
 		t.Fatalf("unexpected token: %+v", resp)
 	}
+	if resp.Exp == nil || *resp.Exp != exp.Unix() {
+		t.Fatalf("unexpected exp: %+v", resp)
+	}
+	if resp.Iat == nil || *resp.Iat != exp.Add(-24*time.Hour).Unix() {
+		t.Fatalf("unexpected iat: %+v", resp)
+	}
+	if resp.UserID != "42" || resp.DefaultRole != "user" || len(resp.AllowedRoles) != 1 {
+		t.Fatalf("unexpected hasura claims: %+v", resp)
+	}
+	if resp.Login != "user" {
+		t.Fatalf("expected identity to stand in for login, got %q", resp.Login)
+	}
 }
 
 func TestAuthHandlerSuccessPlainToken(t *testing.T) {
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
 	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		io.WriteString(w, "  plain-token\n")
+		io.WriteString(w, "  "+token+"\n")
 	}))
 	t.Cleanup(upstream.Close)
 	overridePaths(t, upstream.URL, "/signin", graphqlPath)
@@ -148,11 +165,30 @@ func TestAuthHandlerSuccessPlainToken(t *testing.T) {
 	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("response not JSON: %v", err)
 	}
-	if resp.Token != "plain-token" {
+	if resp.Token != token {
 		t.Fatalf("unexpected token: %+v", resp)
 	}
 }
 
+func TestAuthHandlerMalformedUpstreamToken(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"token":"not-a-jwt"}`)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, "/signin", graphqlPath)
+
+	handler := authHandler()
+	body := `{"identity":"user@example.com","password":"pass"}`
+	req := httptest.NewRequest(http.MethodPost, "/auth/signin", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected status 502, got %d", rr.Code)
+	}
+}
+
 func TestRefreshHandlerOptions(t *testing.T) {
 	handler := refreshHandler()
 	req := httptest.NewRequest(http.MethodOptions, "/auth/refresh", nil)
@@ -166,8 +202,16 @@ func TestRefreshHandlerOptions(t *testing.T) {
 }
 
 func TestRefreshHandlerPost(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"data":{"user":[{"id":42}]}}`)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, signinPath, "/graphql")
+
+	exp := time.Now().Add(time.Hour)
 	handler := refreshHandler()
 	req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+makeJWT(t, zoneClaims("42", exp)))
 	rr := httptest.NewRecorder()
 
 	handler.ServeHTTP(rr, req)
@@ -175,13 +219,31 @@ func TestRefreshHandlerPost(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Fatalf("expected status 200, got %d", rr.Code)
 	}
-	var resp map[string]string
+	var resp refreshResponse
 	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
 		t.Fatalf("response not JSON: %v", err)
 	}
-	if resp["status"] != "ok" {
+	if resp.Status != "ok" || resp.Exp != exp.Unix() || resp.Refreshed {
 		t.Fatalf("unexpected payload: %+v", resp)
 	}
+	if resp.ExpiresIn < 3500 || resp.ExpiresIn > 3600 {
+		t.Fatalf("unexpected remaining lifetime: %d", resp.ExpiresIn)
+	}
+}
+
+func TestRefreshHandlerMissingBearer(t *testing.T) {
+	handler := refreshHandler()
+	req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+	if got := rr.Result().Header.Get("WWW-Authenticate"); got == "" {
+		t.Fatal("expected a WWW-Authenticate challenge")
+	}
 }
 
 func TestRefreshHandlerMethodNotAllowed(t *testing.T) {
@@ -216,7 +278,7 @@ func TestGraphqlHandlerOptions(t *testing.T) {
 
 func TestGraphqlHandlerMethodNotAllowed(t *testing.T) {
 	handler := graphqlHandler()
-	req := httptest.NewRequest(http.MethodGet, "/graphql", nil)
+	req := httptest.NewRequest(http.MethodPut, "/graphql", nil)
 	rr := httptest.NewRecorder()
 
 	handler.ServeHTTP(rr, req)
@@ -228,7 +290,7 @@ func TestGraphqlHandlerMethodNotAllowed(t *testing.T) {
 
 func TestGraphqlHandlerMissingBearer(t *testing.T) {
 	handler := graphqlHandler()
-	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{}"}`))
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{ user { id } }"}`))
 	rr := httptest.NewRecorder()
 
 	handler.ServeHTTP(rr, req)
@@ -256,7 +318,7 @@ func TestGraphqlHandlerUpstreamError(t *testing.T) {
 	overridePaths(t, "http://127.0.0.1:0", signinPath, "/graphql")
 
 	handler := graphqlHandler()
-	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{}"}`))
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{ user { id } }"}`))
 	req.Header.Set("Authorization", "Bearer token")
 	rr := httptest.NewRecorder()
 
@@ -276,7 +338,7 @@ func TestGraphqlHandlerSuccess(t *testing.T) {
 			t.Fatalf("unexpected Content-Type header: %q", got)
 		}
 		body, _ := io.ReadAll(r.Body)
-		if !bytes.Equal(body, []byte(`{"query":"{}"}`)) {
+		if !bytes.Equal(body, []byte(`{"query":"{ user { id } }"}`)) {
 			t.Fatalf("unexpected upstream body: %s", string(body))
 		}
 		w.Header().Set("X-Upstream", "present")
@@ -287,7 +349,7 @@ func TestGraphqlHandlerSuccess(t *testing.T) {
 	overridePaths(t, upstream.URL, signinPath, "/graphql")
 
 	handler := graphqlHandler()
-	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{}"}`))
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{ user { id } }"}`))
 	req.Header.Set("Authorization", "Bearer token")
 	rr := httptest.NewRecorder()
 
@@ -5,19 +5,55 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// withCORS mirrors the caller origin and configures minimal headers for browser requests.
+// corsAllowedOrigins lists the origins (CORS_ALLOWED_ORIGINS, comma-separated, e.g.
+// "https://app.example,http://localhost:5173") allowed to read responses with the caller's
+// session cookie. While it is empty every origin is mirrored, but never with credentials.
+var corsAllowedOrigins = parseOriginList(getenv("CORS_ALLOWED_ORIGINS", ""))
+
+// parseOriginList reads a comma-separated list of origins.
+func parseOriginList(v string) map[string]bool {
+	origins := map[string]bool{}
+	for _, o := range strings.Split(v, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			origins[o] = true
+		}
+	}
+	return origins
+}
+
+// corsOriginAllowed reports whether origin is listed in CORS_ALLOWED_ORIGINS.
+func corsOriginAllowed(origin string) bool {
+	return corsAllowedOrigins[origin]
+}
+
+// withCORS configures minimal headers for browser requests. The caller origin is mirrored
+// unless an allowlist is configured and does not contain it; credentials are only allowed
+// for listed origins.
 func withCORS(w http.ResponseWriter, r *http.Request) {
 	origin := r.Header.Get("Origin")
-	if origin == "" {
+	listed := origin != "" && corsOriginAllowed(origin)
+	switch {
+	case origin == "":
 		origin = "*"
+	case len(corsAllowedOrigins) > 0 && !listed:
+		origin = "" // the browser blocks the response
+	}
+	if origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
 	}
-	w.Header().Set("Access-Control-Allow-Origin", origin)
 	w.Header().Set("Vary", "Origin")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-None-Match")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Cache, Content-Disposition")
+	if sessionsEnabled() && listed {
+		// session cookies are only sent cross-origin when credentials are explicitly allowed
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
 }
 
 // okJSON encodes the provided value and ignores serialization errors for simplicity.
@@ -46,3 +82,55 @@ func getenv(key, def string) string {
 func withJSON(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 }
+
+// getenvDuration parses a time.Duration from the environment, falling back to def when unset or invalid.
+func getenvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid duration %s=%q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// getenvInt parses an integer from the environment, falling back to def when unset or invalid.
+func getenvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid integer %s=%q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// getenvFloat parses a float from the environment, falling back to def when unset or invalid.
+func getenvFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("invalid number %s=%q, using %g", key, v, def)
+		return def
+	}
+	return f
+}
+
+// bearerToken returns the raw token from an "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(strings.ToLower(h), "bearer ") {
+		return "", false
+	}
+	tok := strings.TrimSpace(h[len("bearer "):])
+	return tok, tok != ""
+}
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestGetenv(t *testing.T) {
@@ -27,12 +28,15 @@ func TestWithCORS(t *testing.T) {
 	if got := h.Get("Access-Control-Allow-Origin"); got != "https://app.example" {
 		t.Fatalf("expected echoed origin, got %q", got)
 	}
-	if got := h.Get("Access-Control-Allow-Methods"); got != "POST, OPTIONS" {
+	if got := h.Get("Access-Control-Allow-Methods"); got != "GET, POST, DELETE, OPTIONS" {
 		t.Fatalf("unexpected methods header: %q", got)
 	}
-	if got := h.Get("Access-Control-Allow-Headers"); got != "Content-Type, Authorization" {
+	if got := h.Get("Access-Control-Allow-Headers"); got != "Content-Type, Authorization, If-None-Match" {
 		t.Fatalf("unexpected allow headers: %q", got)
 	}
+	if got := h.Get("Access-Control-Expose-Headers"); got != "ETag, X-Cache, Content-Disposition" {
+		t.Fatalf("unexpected expose headers: %q", got)
+	}
 	if got := h.Get("Vary"); got != "Origin" {
 		t.Fatalf("unexpected vary header: %q", got)
 	}
@@ -91,3 +95,44 @@ func TestGetenvIgnoresEmpty(t *testing.T) {
 		t.Fatalf("expected fallback for empty env, got %q", got)
 	}
 }
+
+func TestGetenvDuration(t *testing.T) {
+	t.Setenv("DUR_ENV", "90s")
+	if got := getenvDuration("DUR_ENV", time.Second); got != 90*time.Second {
+		t.Fatalf("expected parsed duration, got %s", got)
+	}
+	t.Setenv("DUR_ENV", "soon")
+	if got := getenvDuration("DUR_ENV", time.Second); got != time.Second {
+		t.Fatalf("expected default for invalid duration, got %s", got)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	if _, ok := bearerToken(req); ok {
+		t.Fatal("expected no token without header")
+	}
+	req.Header.Set("Authorization", "bearer  abc ")
+	if got, ok := bearerToken(req); !ok || got != "abc" {
+		t.Fatalf("unexpected token: %q %t", got, ok)
+	}
+	req.Header.Set("Authorization", "Basic abc")
+	if _, ok := bearerToken(req); ok {
+		t.Fatal("expected Basic scheme to be ignored")
+	}
+}
+
+func TestGetenvNumbers(t *testing.T) {
+	t.Setenv("INT_ENV", "12")
+	t.Setenv("FLOAT_ENV", "2.5")
+	if got := getenvInt("INT_ENV", 1); got != 12 {
+		t.Fatalf("unexpected int: %d", got)
+	}
+	if got := getenvFloat("FLOAT_ENV", 1); got != 2.5 {
+		t.Fatalf("unexpected float: %g", got)
+	}
+	t.Setenv("INT_ENV", "many")
+	if got := getenvInt("INT_ENV", 1); got != 1 {
+		t.Fatalf("expected default for invalid int, got %d", got)
+	}
+}
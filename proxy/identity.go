@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Share links, snapshots and webhooks outlive the request that created them and are keyed
+// by the user behind the token. A token only claims its user: unless JWT_VERIFY checks
+// signatures, anybody can mint one for somebody else's id. Such stores therefore use the id
+// the upstream returns for the token, which it only answers after checking the signature.
+
+// identityQuery asks the upstream which user a token belongs to; row-level permissions
+// make user return exactly that user.
+const identityQuery = `query { user { id } }`
+
+// identities is shared by every handler that keys a store by user.
+var identities = newIdentityCache()
+
+// identityRetry is how long a token the upstream refused stays refused before it is asked
+// about again.
+const identityRetry = time.Minute
+
+// confirmedIdentity is the user the upstream answered for one token, or its refusal.
+type confirmedIdentity struct {
+	owner   string
+	err     error // set for refused tokens
+	expires time.Time
+}
+
+// identityCache remembers confirmed users per token hash until the token expires, so a
+// token costs at most one extra upstream query; refusals are remembered for identityRetry.
+type identityCache struct {
+	mu        sync.Mutex
+	byToken   map[string]confirmedIdentity
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newIdentityCache() *identityCache {
+	return &identityCache{byToken: map[string]confirmedIdentity{}, now: time.Now}
+}
+
+// owner returns the verified id of the user behind token: its claims when localVerifier
+// checked the signature, otherwise the id the upstream returns for identityQuery. A token
+// the upstream refuses, or that sees no user, fails with an *upstreamQueryError.
+func (ic *identityCache) owner(ctx context.Context, token string) (string, error) {
+	if tok, err := parseJWT(token); err == nil && tok.Claims.UserID() != "" && localVerifier.checksSignatures() && localVerifier.Verify(tok) == nil {
+		return tok.Claims.UserID(), nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	ic.mu.Lock()
+	now := ic.now()
+	ic.sweep(now)
+	id, ok := ic.byToken[key]
+	ic.mu.Unlock()
+	if ok && now.Before(id.expires) {
+		return id.owner, id.err
+	}
+
+	var out struct {
+		User []struct {
+			ID json.Number `json:"id"`
+		} `json:"user"`
+	}
+	err := queryUpstream(ctx, token, identityQuery, nil, &out)
+	if err == nil && (len(out.User) != 1 || out.User[0].ID == "") {
+		err = &upstreamQueryError{Status: http.StatusForbidden, Message: "token does not identify a user"}
+	}
+	switch {
+	case err == nil:
+		id = confirmedIdentity{owner: out.User[0].ID.String(), expires: tokenExpiry(token)}
+	case tokenRejected(err):
+		id = confirmedIdentity{err: err, expires: now.Add(identityRetry)}
+	default:
+		return "", err // the upstream failed: ask again next time
+	}
+	if now.Before(id.expires) {
+		ic.mu.Lock()
+		ic.byToken[key] = id
+		ic.mu.Unlock()
+	}
+	return id.owner, id.err
+}
+
+// sweep forgets identities of expired tokens at most once a minute; callers must hold ic.mu.
+func (ic *identityCache) sweep(now time.Time) {
+	if now.Sub(ic.lastSweep) < time.Minute {
+		return
+	}
+	ic.lastSweep = now
+	for k, id := range ic.byToken {
+		if !now.Before(id.expires) {
+			delete(ic.byToken, k)
+		}
+	}
+}
+
+// owner returns the verified id of the user behind c, see identityCache.owner.
+func (c *apiCaller) owner(ctx context.Context) (string, error) {
+	return identities.owner(ctx, c.Token)
+}
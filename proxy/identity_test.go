@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdentityComesFromTheUpstream(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.WriteString(w, `{"data":{"user":[{"id":7}]}}`)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, signinPath, "/graphql")
+	ic := newIdentityCache()
+	clock := &fakeClock{t: time.Now()}
+	ic.now = clock.now
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+
+	for i := 0; i < 2; i++ {
+		if owner, err := ic.owner(context.Background(), token); err != nil || owner != "7" {
+			t.Fatalf("the upstream's answer wins over the claims, got %q %v", owner, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("confirmed identities should be cached, got %d calls", calls.Load())
+	}
+	clock.advance(2 * time.Hour)
+	if ic.owner(context.Background(), token); calls.Load() != 2 || len(ic.byToken) != 0 {
+		t.Fatalf("identities should expire with the token, got %d calls %v", calls.Load(), ic.byToken)
+	}
+}
+
+func TestIdentityRejectedTokens(t *testing.T) {
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	for _, reply := range []string{
+		`{"errors":[{"message":"Could not verify JWT","extensions":{"code":"invalid-jwt"}}]}`,
+		`{"data":{"user":[]}}`,
+	} {
+		countingUpstream(t, reply)
+		if _, err := newIdentityCache().owner(context.Background(), token); !tokenRejected(err) {
+			t.Fatalf("%s: expected a rejected token, got %v", reply, err)
+		}
+	}
+}
+
+func TestIdentityRemembersRefusals(t *testing.T) {
+	z := newFakeZone01(t)
+	z.errors["user"] = gqlError{Message: "Could not verify JWT", Extensions: map[string]any{"code": "invalid-jwt"}}
+	ic := newIdentityCache()
+	clock := &fakeClock{t: time.Now()}
+	ic.now = clock.now
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	for i := 0; i < 2; i++ {
+		if _, err := ic.owner(context.Background(), token); !tokenRejected(err) {
+			t.Fatalf("expected a rejected token, got %v", err)
+		}
+	}
+	if n := z.callCount("user"); n != 1 {
+		t.Fatalf("refusals should be remembered, got %d queries", n)
+	}
+	delete(z.errors, "user")
+	clock.advance(identityRetry)
+	if owner, err := ic.owner(context.Background(), token); err != nil || owner != "42" {
+		t.Fatalf("refusals should be retried after %v, got %q %v", identityRetry, owner, err)
+	}
+}
+
+func TestIdentityOfVerifiedTokens(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.WriteString(w, `{"data":{"user":[{"id":7}]}}`)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, signinPath, "/graphql")
+	useVerifier(t, testVerifier(verificationKey{key: []byte("s3cret")}))
+	token := signJWT(t, "HS256", "", []byte("s3cret"), zoneClaims("42", time.Now().Add(time.Hour)))
+	if owner, err := newIdentityCache().owner(context.Background(), token); err != nil || owner != "42" || calls.Load() != 0 {
+		t.Fatalf("verified claims need no upstream query, got %q %v after %d calls", owner, err, calls.Load())
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// hasuraClaimsKey is the namespace Hasura (and therefore Zone01) uses for its authorization claims.
+const hasuraClaimsKey = "https://hasura.io/jwt/claims"
+
+// Reason codes attached to tokenError so callers can tell failures apart without string matching.
+const (
+	reasonMalformed = "token_malformed"
+	reasonExpired   = "token_expired"
+)
+
+// tokenError is returned whenever a JWT cannot be decoded or fails a check.
+type tokenError struct {
+	Reason string
+	Msg    string
+}
+
+func (e *tokenError) Error() string {
+	return e.Reason + ": " + e.Msg
+}
+
+// malformed builds a tokenError for structurally invalid tokens.
+func malformed(format string, args ...any) error {
+	return &tokenError{Reason: reasonMalformed, Msg: fmt.Sprintf(format, args...)}
+}
+
+// jwtHeader holds the JOSE header fields the proxy cares about.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// hasuraClaims mirrors the authorization block the Zone01 platform embeds in every token.
+type hasuraClaims struct {
+	UserID       string   `json:"x-hasura-user-id"`
+	DefaultRole  string   `json:"x-hasura-default-role"`
+	AllowedRoles []string `json:"x-hasura-allowed-roles"`
+}
+
+// jwtClaims is the subset of registered and Hasura claims read from upstream tokens.
+type jwtClaims struct {
+	Subject   string       `json:"sub"`
+	Issuer    string       `json:"iss,omitempty"`
+	Audience  audience     `json:"aud,omitempty"`
+	IssuedAt  int64        `json:"iat"`
+	NotBefore int64        `json:"nbf,omitempty"`
+	ExpiresAt int64        `json:"exp"`
+	Login     string       `json:"login,omitempty"`
+	Hasura    hasuraClaims `json:"https://hasura.io/jwt/claims"`
+}
+
+// audience accepts both the string and array forms allowed by RFC 7519.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// jwtToken is a decoded (but not verified) compact JWT.
+type jwtToken struct {
+	Raw          string
+	Header       jwtHeader
+	Claims       jwtClaims
+	SigningInput string
+	Signature    []byte
+}
+
+// parseJWT decodes a compact JWT without verifying its signature.
+func parseJWT(raw string) (*jwtToken, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, malformed("expected 3 segments, got %d", len(parts))
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, malformed("header is not base64url: %v", err)
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, malformed("payload is not base64url: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, malformed("signature is not base64url: %v", err)
+	}
+	tok := &jwtToken{Raw: raw, SigningInput: parts[0] + "." + parts[1], Signature: sig}
+	if err := json.Unmarshal(headerJSON, &tok.Header); err != nil {
+		return nil, malformed("header is not JSON: %v", err)
+	}
+	if tok.Header.Alg == "" {
+		return nil, malformed("header has no alg")
+	}
+	if err := json.Unmarshal(claimsJSON, &tok.Claims); err != nil {
+		return nil, malformed("payload is not JSON: %v", err)
+	}
+	if tok.Claims.ExpiresAt == 0 {
+		return nil, malformed("payload has no exp")
+	}
+	return tok, nil
+}
+
+// UserID prefers the Hasura user id and falls back to the subject claim.
+func (c jwtClaims) UserID() string {
+	if c.Hasura.UserID != "" {
+		return c.Hasura.UserID
+	}
+	return c.Subject
+}
+
+// Expiry converts the exp claim to a time.Time.
+func (c jwtClaims) Expiry() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// makeJWT builds an unsigned-looking HS256 token carrying the given claims.
+func makeJWT(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshal claims: %v", err)
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(header) + "." + enc.EncodeToString(payload) + "." + enc.EncodeToString([]byte("sig"))
+}
+
+// zoneClaims returns claims shaped like the ones issued by the Zone01 platform.
+func zoneClaims(userID string, exp time.Time) map[string]any {
+	return map[string]any{
+		"sub": userID,
+		"iat": exp.Add(-24 * time.Hour).Unix(),
+		"exp": exp.Unix(),
+		hasuraClaimsKey: map[string]any{
+			"x-hasura-user-id":       userID,
+			"x-hasura-default-role":  "user",
+			"x-hasura-allowed-roles": []string{"user"},
+		},
+	}
+}
+
+func TestParseJWT(t *testing.T) {
+	exp := time.Unix(1900000000, 0)
+	raw := makeJWT(t, zoneClaims("42", exp))
+
+	tok, err := parseJWT(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok.Header.Alg != "HS256" {
+		t.Fatalf("unexpected alg: %q", tok.Header.Alg)
+	}
+	if got := tok.Claims.UserID(); got != "42" {
+		t.Fatalf("unexpected user id: %q", got)
+	}
+	if !tok.Claims.Expiry().Equal(exp) {
+		t.Fatalf("unexpected expiry: %v", tok.Claims.Expiry())
+	}
+	if got := tok.Claims.Hasura.AllowedRoles; len(got) != 1 || got[0] != "user" {
+		t.Fatalf("unexpected roles: %v", got)
+	}
+	if string(tok.Signature) != "sig" || !strings.HasPrefix(raw, tok.SigningInput+".") {
+		t.Fatalf("unexpected signing parts: %q", tok.SigningInput)
+	}
+}
+
+func TestParseJWTAudienceForms(t *testing.T) {
+	one := zoneClaims("1", time.Now().Add(time.Hour))
+	one["aud"] = "zone01"
+	many := zoneClaims("1", time.Now().Add(time.Hour))
+	many["aud"] = []string{"a", "b"}
+
+	tok, err := parseJWT(makeJWT(t, one))
+	if err != nil || len(tok.Claims.Audience) != 1 || tok.Claims.Audience[0] != "zone01" {
+		t.Fatalf("string aud not decoded: %v %v", tok, err)
+	}
+	tok, err = parseJWT(makeJWT(t, many))
+	if err != nil || len(tok.Claims.Audience) != 2 {
+		t.Fatalf("array aud not decoded: %v %v", tok, err)
+	}
+}
+
+func TestParseJWTMalformed(t *testing.T) {
+	enc := base64.RawURLEncoding
+	noExp := makeJWT(t, map[string]any{"sub": "1"})
+	tests := []string{
+		"plain-token",
+		"a.b",
+		"!!!.e30.c2ln",
+		enc.EncodeToString([]byte(`{"alg":"HS256"}`)) + ".not-json." + enc.EncodeToString([]byte("s")),
+		enc.EncodeToString([]byte(`{}`)) + "." + enc.EncodeToString([]byte(`{"exp":1}`)) + ".c2ln",
+		noExp,
+	}
+	for _, raw := range tests {
+		_, err := parseJWT(raw)
+		var te *tokenError
+		if !errors.As(err, &te) || te.Reason != reasonMalformed {
+			t.Errorf("%q: expected malformed tokenError, got %v", raw, err)
+		}
+	}
+}
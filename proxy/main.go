@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 
@@ -15,6 +16,12 @@ func main() {
 	router := mux.NewRouter()
 	router.StrictSlash(true)
 	RegisterRoutes(router)
+	if snapshots.enabled() && snapshotInterval > 0 {
+		go snapshots.run(context.Background(), snapshotInterval)
+	}
+	if webhooks.enabled() {
+		go webhooks.run(context.Background(), webhookPollInterval)
+	}
 	log.Printf("listening on :%s", port)
 	if err := http.ListenAndServe(":"+port, logRequest(router)); err != nil {
 		log.Fatal(err)
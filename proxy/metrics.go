@@ -0,0 +1,178 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Zone01 records audits as "up" transactions (XP earned by auditing others) and "down"
+// transactions (XP attributed to the audits received), the current level as "level"
+// transactions and skill progress as "skill_<name>" transactions.
+const (
+	txUp          = "up"
+	txDown        = "down"
+	txLevel       = "level"
+	txSkillPrefix = "skill_"
+)
+
+// auditsResponse is the body of GET /api/me/audits.
+type auditsResponse struct {
+	dateRange
+	TotalUp   float64  `json:"totalUp"`
+	TotalDown float64  `json:"totalDown"`
+	UpCount   int      `json:"upCount"`
+	DownCount int      `json:"downCount"`
+	Ratio     *float64 `json:"ratio"` // totalUp / totalDown, null until an audit was received
+}
+
+// auditStats sums up and down transactions into an audit ratio rounded to two decimals.
+func auditStats(txs []transaction) auditsResponse {
+	var resp auditsResponse
+	for _, tx := range txs {
+		switch tx.Type {
+		case txUp:
+			resp.TotalUp += tx.Amount
+			resp.UpCount++
+		case txDown:
+			resp.TotalDown += tx.Amount
+			resp.DownCount++
+		}
+	}
+	if resp.TotalDown > 0 {
+		ratio := math.Round(resp.TotalUp/resp.TotalDown*100) / 100
+		resp.Ratio = &ratio
+	}
+	return resp
+}
+
+// auditsHandler reports the caller's audit ratio within the requested range.
+func auditsHandler() http.HandlerFunc {
+	return meHandler(func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		dr, err := parseDateRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		txs, err := fetchTransactions(r.Context(), c.Token, dr.where("createdAt", map[string]any{"type": map[string]any{"_in": []string{txUp, txDown}}}))
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		resp := auditStats(txs)
+		resp.dateRange = dr
+		withJSON(w)
+		okJSON(w, resp)
+	})
+}
+
+// levelChange is one recorded level.
+type levelChange struct {
+	Level     float64   `json:"level"`
+	CreatedAt time.Time `json:"createdAt"`
+	Path      string    `json:"path"`
+}
+
+// levelResponse is the body of GET /api/me/level.
+type levelResponse struct {
+	Event     string        `json:"event,omitempty"`
+	Level     float64       `json:"level"` // 0 before the first level transaction
+	UpdatedAt *time.Time    `json:"updatedAt"`
+	History   []levelChange `json:"history"`
+}
+
+// levelStats takes the latest level transaction as the current level; txs must be oldest first.
+func levelStats(txs []transaction) levelResponse {
+	resp := levelResponse{History: []levelChange{}}
+	for _, tx := range txs {
+		if tx.Type != txLevel {
+			continue
+		}
+		resp.History = append(resp.History, levelChange{Level: tx.Amount, CreatedAt: tx.CreatedAt, Path: tx.Path})
+	}
+	if n := len(resp.History); n > 0 {
+		last := resp.History[n-1]
+		resp.Level, resp.UpdatedAt = last.Level, &last.CreatedAt
+	}
+	return resp
+}
+
+// levelHandler reports the caller's current level. Students collect levels in several
+// events (piscines, the main curriculum), so ?event=/athens/div-01 narrows it to paths
+// under that prefix.
+func levelHandler() http.HandlerFunc {
+	return meHandler(func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		event := strings.TrimSuffix(r.URL.Query().Get("event"), "/")
+		where := map[string]any{"type": map[string]any{"_eq": txLevel}}
+		if event != "" {
+			where["path"] = map[string]any{"_like": event + "/%"}
+		}
+		txs, err := fetchTransactions(r.Context(), c.Token, where)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		resp := levelStats(txs)
+		resp.Event = event
+		withJSON(w)
+		okJSON(w, resp)
+	})
+}
+
+// skill is the best recorded amount for one skill.
+type skill struct {
+	Name      string    `json:"name"` // the transaction type without "skill_"
+	Type      string    `json:"type"`
+	Amount    float64   `json:"amount"`
+	UpdatedAt time.Time `json:"updatedAt"` // when that amount was reached
+}
+
+// skillsResponse is the body of GET /api/me/skills.
+type skillsResponse struct {
+	Skills []skill `json:"skills"`
+}
+
+// skillStats keeps the highest amount per skill type, strongest skills first. Each skill
+// transaction records the level reached, so amounts are not summed.
+func skillStats(txs []transaction) skillsResponse {
+	best := map[string]*skill{}
+	for _, tx := range txs {
+		if !strings.HasPrefix(tx.Type, txSkillPrefix) {
+			continue
+		}
+		s, ok := best[tx.Type]
+		if !ok {
+			s = &skill{Name: strings.TrimPrefix(tx.Type, txSkillPrefix), Type: tx.Type}
+			best[tx.Type] = s
+		}
+		if !ok || tx.Amount > s.Amount {
+			s.Amount, s.UpdatedAt = tx.Amount, tx.CreatedAt
+		}
+	}
+	resp := skillsResponse{Skills: make([]skill, 0, len(best))}
+	for _, s := range best {
+		resp.Skills = append(resp.Skills, *s)
+	}
+	sort.Slice(resp.Skills, func(i, j int) bool {
+		if resp.Skills[i].Amount != resp.Skills[j].Amount {
+			return resp.Skills[i].Amount > resp.Skills[j].Amount
+		}
+		return resp.Skills[i].Name < resp.Skills[j].Name
+	})
+	return resp
+}
+
+// skillsHandler reports the caller's skills.
+func skillsHandler() http.HandlerFunc {
+	return meHandler(func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		txs, err := fetchTransactions(r.Context(), c.Token, map[string]any{"type": map[string]any{"_like": txSkillPrefix + "%"}})
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		withJSON(w)
+		okJSON(w, skillStats(txs))
+	})
+}
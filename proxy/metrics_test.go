@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// getJSON sends an authenticated GET to h as user 42 and decodes the 200 response into out.
+func getJSON(t *testing.T, h http.Handler, target string, out any) {
+	t.Helper()
+	rr := apiGet(t, h, target, "42")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("%s: expected 200, got %d %s", target, rr.Code, rr.Body.String())
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditsHandler(t *testing.T) {
+	newFakeZone01(t).load(t, "transactions.json")
+
+	var resp auditsResponse
+	getJSON(t, auditsHandler(), "/api/me/audits", &resp)
+	if resp.TotalUp != 17700 || resp.UpCount != 3 || resp.TotalDown != 15000 || resp.DownCount != 2 {
+		t.Fatalf("unexpected totals %+v", resp)
+	}
+	if resp.Ratio == nil || *resp.Ratio != 1.18 {
+		t.Fatalf("expected ratio 1.18, got %v", resp.Ratio)
+	}
+
+	resp = auditsResponse{}
+	getJSON(t, auditsHandler(), "/api/me/audits?from=2024-02-01", &resp)
+	if resp.TotalUp != 10200 || resp.TotalDown != 6000 || resp.Ratio == nil || *resp.Ratio != 1.7 || resp.From == nil {
+		t.Fatalf("unexpected ranged audits %+v", resp)
+	}
+
+	resp = auditsResponse{}
+	getJSON(t, auditsHandler(), "/api/me/audits?from=2024-02-11", &resp)
+	if resp.UpCount != 1 || resp.DownCount != 0 || resp.Ratio != nil {
+		t.Fatalf("ratio should be null without received audits: %+v", resp)
+	}
+
+	if rr := apiGet(t, auditsHandler(), "/api/me/audits?from=yesterday", "42"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad date, got %d", rr.Code)
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	newFakeZone01(t).load(t, "transactions.json")
+
+	var resp levelResponse
+	getJSON(t, levelHandler(), "/api/me/level", &resp)
+	want := time.Date(2024, 2, 10, 17, 48, 0, 13e6, time.UTC)
+	if resp.Level != 5 || len(resp.History) != 4 || resp.UpdatedAt == nil || !resp.UpdatedAt.Equal(want) {
+		t.Fatalf("unexpected level %+v", resp)
+	}
+
+	resp = levelResponse{}
+	getJSON(t, levelHandler(), "/api/me/level?event=/athens/piscine-go/", &resp)
+	if resp.Level != 7 || len(resp.History) != 2 || resp.Event != "/athens/piscine-go" {
+		t.Fatalf("unexpected piscine level %+v", resp)
+	}
+
+	resp = levelResponse{}
+	getJSON(t, levelHandler(), "/api/me/level?event=/athens/nowhere", &resp)
+	if resp.Level != 0 || resp.UpdatedAt != nil || resp.History == nil || len(resp.History) != 0 {
+		t.Fatalf("unexpected empty level %+v", resp)
+	}
+}
+
+func TestSkillsHandler(t *testing.T) {
+	newFakeZone01(t).load(t, "transactions.json")
+
+	var resp skillsResponse
+	getJSON(t, skillsHandler(), "/api/me/skills", &resp)
+	got := make([]string, 0, len(resp.Skills))
+	for _, s := range resp.Skills {
+		got = append(got, s.Name)
+	}
+	if len(got) != 3 || got[0] != "go" || got[1] != "algo" || got[2] != "prog" {
+		t.Fatalf("unexpected skill order %v", got)
+	}
+	if goSkill := resp.Skills[0]; goSkill.Amount != 15 || goSkill.Type != "skill_go" || goSkill.UpdatedAt.Month() != time.January {
+		t.Fatalf("skill_go should keep its best amount, got %+v", goSkill)
+	}
+}
+
+func TestSkillStatsEmpty(t *testing.T) {
+	if resp := skillStats(nil); resp.Skills == nil || len(resp.Skills) != 0 {
+		t.Fatalf("expected an empty list, got %+v", resp)
+	}
+}
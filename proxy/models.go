@@ -1,14 +1,73 @@
 package main
 
+import "encoding/json"
+
 // loginRequest represents the body sent by the frontend to authenticate a user.
 type loginRequest struct {
 	Identity string `json:"identity"` // username OR email
 	Password string `json:"password"`
 }
 
-// loginResponse is the minimal payload returned back to the client after auth.
+// loginResponse is the payload returned back to the client after auth.
+// Everything besides Token is decoded from the upstream JWT so clients can
+// schedule re-login and skip an extra ME round-trip. Token is omitted in session mode.
 type loginResponse struct {
-	Token string `json:"token"`
-	// optional: include expiry if the auth API returns it
-	Exp *int64 `json:"exp,omitempty"`
+	Token        string   `json:"token,omitempty"`
+	Exp          *int64   `json:"exp,omitempty"`
+	Iat          *int64   `json:"iat,omitempty"`
+	UserID       string   `json:"userId,omitempty"`
+	DefaultRole  string   `json:"defaultRole,omitempty"`
+	AllowedRoles []string `json:"allowedRoles,omitempty"`
+	Login        string   `json:"login,omitempty"`
+}
+
+// refreshResponse reports the remaining lifetime of the session checked by /auth/refresh.
+// Token is only set when the proxy obtained a replacement.
+type refreshResponse struct {
+	Status    string `json:"status"`
+	Exp       int64  `json:"exp"`
+	ExpiresIn int64  `json:"expiresIn"` // seconds
+	Refreshed bool   `json:"refreshed"`
+	Token     string `json:"token,omitempty"`
+}
+
+// authErrorResponse is the 401 body returned when local token verification fails.
+type authErrorResponse struct {
+	Error   string `json:"error"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// limitsResponse is the payload of GET /admin/limits.
+type limitsResponse struct {
+	IP       []bucketState  `json:"ip"`
+	Identity []bucketState  `json:"identity"`
+	Lockouts []lockoutState `json:"lockouts"`
+	GraphQL  []quotaState   `json:"graphql"`
+}
+
+// graphqlRequest is a GraphQL-over-HTTP request body.
+type graphqlRequest struct {
+	Query         string                     `json:"query"`
+	Variables     map[string]any             `json:"variables,omitempty"`
+	OperationName string                     `json:"operationName,omitempty"`
+	Extensions    map[string]json.RawMessage `json:"extensions,omitempty"`
+}
+
+// gqlLocation points at a line and column of the submitted document.
+type gqlLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// gqlError is one entry of a GraphQL response "errors" list.
+type gqlError struct {
+	Message    string         `json:"message"`
+	Locations  []gqlLocation  `json:"locations,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// gqlErrorResponse is a GraphQL response that failed before execution.
+type gqlErrorResponse struct {
+	Errors []gqlError `json:"errors"`
 }
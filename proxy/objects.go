@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Object metadata (names and types of projects, exercises, ...) is the same for every user,
+// so lookups are cached across callers.
+var objectCacheTTL = getenvDuration("OBJECT_CACHE_TTL", time.Hour)
+var objectCacheMaxEntries = getenvInt("OBJECT_CACHE_MAX_ENTRIES", 20000)
+
+// objects is the object metadata cache shared by every /api/me endpoint.
+var objects = newObjectCache(objectCacheTTL, objectCacheMaxEntries)
+
+// objectsByIDsQuery resolves object ids; it mirrors OBJECT_BY_IDS in the frontend.
+const objectsByIDsQuery = `query ObjByIds($ids: [Int!]) { object(where: { id: { _in: $ids } }) { id name type } }`
+
+// objectInfo is the metadata joined onto transactions and progress entries.
+type objectInfo struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type cachedObject struct {
+	info    objectInfo
+	expires time.Time
+}
+
+// objectCache maps object ids to their metadata for ttl. When it holds max entries, expired
+// ones are dropped first and then the entries closest to expiry.
+type objectCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[int]cachedObject
+	now     func() time.Time
+}
+
+func newObjectCache(ttl time.Duration, max int) *objectCache {
+	return &objectCache{ttl: ttl, max: max, entries: map[int]cachedObject{}, now: time.Now}
+}
+
+// cached splits ids into the objects already known and the ids still to fetch.
+func (c *objectCache) cached(ids []int) (map[int]objectInfo, []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	found := make(map[int]objectInfo, len(ids))
+	var missing []int
+	seen := map[int]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if e, ok := c.entries[id]; ok && now.Before(e.expires) {
+			found[id] = e.info
+			continue
+		}
+		missing = append(missing, id)
+	}
+	return found, missing
+}
+
+// store remembers objs, making room when the cache is full.
+func (c *objectCache) store(objs []objectInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.max > 0 && len(c.entries)+len(objs) > c.max {
+		ids := make([]int, 0, len(c.entries))
+		for id, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, id)
+				continue
+			}
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return c.entries[ids[i]].expires.Before(c.entries[ids[j]].expires) })
+		for _, id := range ids {
+			if len(c.entries)+len(objs) <= c.max {
+				break
+			}
+			delete(c.entries, id)
+		}
+	}
+	for _, o := range objs {
+		c.entries[o.ID] = cachedObject{info: o, expires: now.Add(c.ttl)}
+	}
+}
+
+// Lookup returns the metadata of ids, fetching unknown ones from the upstream as the caller
+// behind token. Ids the upstream does not know are absent from the result.
+func (c *objectCache) Lookup(ctx context.Context, token string, ids []int) (map[int]objectInfo, error) {
+	found, missing := c.cached(ids)
+	if len(missing) == 0 {
+		return found, nil
+	}
+	var data struct {
+		Object []objectInfo `json:"object"`
+	}
+	if err := queryUpstream(ctx, token, objectsByIDsQuery, map[string]any{"ids": missing}, &data); err != nil {
+		return nil, err
+	}
+	c.store(data.Object)
+	for _, o := range data.Object {
+		found[o.ID] = o
+	}
+	return found, nil
+}
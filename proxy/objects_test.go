@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestObjectCacheSharedAcrossUsers(t *testing.T) {
+	z := newFakeZone01(t)
+	z.add("object", map[string]any{"id": 1, "name": "go-reloaded", "type": "project"})
+	z.add("object", map[string]any{"id": 2, "name": "ascii-art", "type": "project"})
+	c := newObjectCache(time.Hour, 100)
+
+	got, err := c.Lookup(context.Background(), "token-a", []int{1, 2, 1})
+	if err != nil || len(got) != 2 || got[1].Name != "go-reloaded" {
+		t.Fatalf("unexpected lookup %v %v", got, err)
+	}
+	if _, err := c.Lookup(context.Background(), "token-b", []int{2, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if n := z.callCount("object"); n != 1 {
+		t.Fatalf("expected one upstream lookup for both users, got %d", n)
+	}
+
+	got, err = c.Lookup(context.Background(), "token-b", []int{3})
+	if err != nil || len(got) != 0 {
+		t.Fatalf("unknown ids should be absent, got %v %v", got, err)
+	}
+}
+
+func TestObjectCacheExpiresAndEvicts(t *testing.T) {
+	z := newFakeZone01(t)
+	for i := 1; i <= 3; i++ {
+		z.add("object", map[string]any{"id": i, "name": "o", "type": "project"})
+	}
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	c := newObjectCache(time.Minute, 2)
+	c.now = clock.now
+
+	c.Lookup(context.Background(), "t", []int{1})
+	clock.advance(time.Second)
+	c.Lookup(context.Background(), "t", []int{2})
+	clock.advance(time.Second)
+	c.Lookup(context.Background(), "t", []int{3})
+	if _, missing := c.cached([]int{1, 2, 3}); len(missing) != 1 || missing[0] != 1 {
+		t.Fatalf("expected the oldest entry to be evicted, missing %v", missing)
+	}
+
+	clock.advance(time.Minute)
+	if _, missing := c.cached([]int{2, 3}); len(missing) != 2 {
+		t.Fatalf("expected entries to expire, missing %v", missing)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Automatic pagination: a top-level query field marked "@paginate" (or every top-level field
+// with a limit argument when the request has extensions.paginate = true) is fetched page by
+// page with limit/offset until a short page comes back, and the pages are stitched into one
+// list. @paginate(pageSize: Int, max: Int) overrides the page size and lowers the row cap.
+// Every page after the first takes a request from the caller's GraphQL quota, and the row
+// cap is lowered so that the stitched result stays within GQL_MAX_COST.
+var gqlPageSize = getenvInt("GQL_PAGE_SIZE", 1000)
+var gqlPaginateMaxRows = getenvInt("GQL_PAGINATE_MAX_ROWS", 50000)
+
+// paginateDirective is the client-side directive that requests automatic pagination.
+const paginateDirective = "paginate"
+
+// pagedField is one field to be fetched in pages.
+type pagedField struct {
+	field    *gqlField
+	pageSize int
+	offset   int // offset of the first page
+	maxRows  int
+}
+
+// paginationPlan describes how an operation is paginated.
+type paginationPlan struct {
+	parsed *parsedOperation
+	vars   map[string]any
+	fields []*pagedField
+}
+
+// pageStats is reported per field in extensions.pagination.
+type pageStats struct {
+	Pages     int  `json:"pages"`
+	Rows      int  `json:"rows"`
+	Truncated bool `json:"truncated"`
+}
+
+// paginationExtension is the "pagination" entry added to a stitched response's extensions.
+type paginationExtension struct {
+	Truncated bool                  `json:"truncated"`
+	Fields    map[string]*pageStats `json:"fields"`
+}
+
+// planPagination returns how req should be paginated, or nil when it does not ask for it.
+func planPagination(p *parsedOperation, req graphqlRequest) (*paginationPlan, error) {
+	all := false
+	if raw, ok := req.Extensions["paginate"]; ok {
+		if err := json.Unmarshal(raw, &all); err != nil {
+			return nil, requestErrorf(codeBadRequest, "extensions.paginate must be a boolean.")
+		}
+	}
+	if err := checkPaginateDirectives(p.Doc, p.Op); err != nil {
+		return nil, err
+	}
+
+	a := newQueryAnalyzer(p, req.Variables)
+	plan := &paginationPlan{parsed: p, vars: req.Variables}
+	for _, sel := range p.Op.Selections {
+		f, ok := sel.(*gqlField)
+		if !ok {
+			continue
+		}
+		dir := findDirective(f.Directives, paginateDirective)
+		if dir == nil && !(all && f.Argument("limit") != nil) {
+			continue
+		}
+		if p.Op.Type != "query" {
+			return nil, requestErrorf(codeValidationFailed, "Only queries can be paginated.")
+		}
+		pf := &pagedField{field: f, pageSize: gqlPageSize, maxRows: gqlPaginateMaxRows}
+		if arg := f.Argument("limit"); arg != nil {
+			if n, ok := a.intValue(arg.Value); ok && n > 0 {
+				pf.pageSize = int(n)
+			}
+		}
+		if arg := f.Argument("offset"); arg != nil {
+			if n, ok := a.intValue(arg.Value); ok && n > 0 {
+				pf.offset = int(n)
+			}
+		}
+		if dir != nil {
+			for _, arg := range dir.Arguments {
+				n, ok := a.intValue(arg.Value)
+				if !ok || n <= 0 {
+					return nil, requestErrorf(codeValidationFailed, "@paginate(%s:) must be a positive integer.", arg.Name)
+				}
+				switch arg.Name {
+				case "pageSize":
+					pf.pageSize = int(n)
+				case "max":
+					pf.maxRows = min(pf.maxRows, int(n))
+				default:
+					return nil, requestErrorf(codeValidationFailed, "Unknown argument %q on @paginate.", arg.Name)
+				}
+			}
+		}
+		if gqlMaxLimit > 0 {
+			pf.pageSize = min(pf.pageSize, gqlMaxLimit)
+		}
+		plan.fields = append(plan.fields, pf)
+	}
+	if len(plan.fields) == 0 {
+		return nil, nil
+	}
+	if gqlMaxCost > 0 {
+		// each row costs what its selections cost; the fields share the cost budget
+		budget := int64(gqlMaxCost) / int64(len(plan.fields))
+		for _, pf := range plan.fields {
+			perRow, err := a.selections(pf.field.Selections)
+			if err != nil {
+				return nil, err
+			}
+			if perRow.Cost > 0 {
+				pf.maxRows = int(min(int64(pf.maxRows), max(budget/perRow.Cost, 1)))
+			}
+		}
+	}
+	return plan, nil
+}
+
+// checkPaginateDirectives rejects @paginate anywhere but on top-level fields of op.
+func checkPaginateDirectives(doc *gqlDocument, op *gqlOperation) error {
+	var walk func(sels []gqlSelection, top bool) error
+	walk = func(sels []gqlSelection, top bool) error {
+		for _, sel := range sels {
+			switch s := sel.(type) {
+			case *gqlField:
+				if !top && findDirective(s.Directives, paginateDirective) != nil {
+					return requestErrorf(codeValidationFailed, "@paginate is only supported on top-level fields.")
+				}
+				if err := walk(s.Selections, false); err != nil {
+					return err
+				}
+			case *gqlInlineFragment:
+				if err := walk(s.Selections, false); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(op.Selections, true); err != nil {
+		return err
+	}
+	for _, f := range doc.Fragments {
+		if err := walk(f.Selections, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findDirective returns the directive called name, or nil.
+func findDirective(dirs []*gqlDirective, name string) *gqlDirective {
+	for _, d := range dirs {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// pageField copies f with literal limit and offset arguments and without @paginate.
+func pageField(f *gqlField, limit, offset int) *gqlField {
+	pf := *f
+	pf.Arguments = nil
+	for _, a := range f.Arguments {
+		if a.Name != "limit" && a.Name != "offset" {
+			pf.Arguments = append(pf.Arguments, a)
+		}
+	}
+	pf.Arguments = append(pf.Arguments,
+		&gqlArgument{Name: "limit", Value: gqlValue{Kind: gqlInt, Raw: strconv.Itoa(limit)}},
+		&gqlArgument{Name: "offset", Value: gqlValue{Kind: gqlInt, Raw: strconv.Itoa(offset)}},
+	)
+	pf.Directives = nil
+	for _, d := range f.Directives {
+		if d.Name != paginateDirective {
+			pf.Directives = append(pf.Directives, d)
+		}
+	}
+	return &pf
+}
+
+// pageRequest builds an upstream request for the given top-level selections, keeping only
+// the fragments and variables they use so the document stays valid.
+func (plan *paginationPlan) pageRequest(sels []gqlSelection) ([]byte, error) {
+	op := *plan.parsed.Op
+	op.Selections = sels
+	used := usedDefinitions{doc: plan.parsed.Doc, fragments: map[string]bool{}, vars: map[string]bool{}}
+	used.directives(op.Directives)
+	used.selections(sels)
+
+	doc := &gqlDocument{Operations: []*gqlOperation{&op}}
+	for _, f := range plan.parsed.Doc.Fragments {
+		if used.fragments[f.Name] {
+			doc.Fragments = append(doc.Fragments, f)
+		}
+	}
+	op.Variables = nil
+	vars := map[string]any{}
+	for _, v := range plan.parsed.Op.Variables {
+		if used.vars[v.Name] {
+			op.Variables = append(op.Variables, v)
+			if val, ok := plan.vars[v.Name]; ok {
+				vars[v.Name] = val
+			}
+		}
+	}
+	return upstreamBody(graphqlRequest{Query: printDocument(doc), Variables: vars, OperationName: op.Name})
+}
+
+// usedDefinitions collects the fragments and variables referenced by a selection set.
+type usedDefinitions struct {
+	doc       *gqlDocument
+	fragments map[string]bool
+	vars      map[string]bool
+}
+
+func (u *usedDefinitions) selections(sels []gqlSelection) {
+	for _, sel := range sels {
+		switch s := sel.(type) {
+		case *gqlField:
+			for _, a := range s.Arguments {
+				u.value(a.Value)
+			}
+			u.directives(s.Directives)
+			u.selections(s.Selections)
+		case *gqlInlineFragment:
+			u.directives(s.Directives)
+			u.selections(s.Selections)
+		case *gqlFragmentSpread:
+			u.directives(s.Directives)
+			if u.fragments[s.Name] {
+				continue
+			}
+			u.fragments[s.Name] = true
+			if f := u.doc.fragment(s.Name); f != nil {
+				u.directives(f.Directives)
+				u.selections(f.Selections)
+			}
+		}
+	}
+}
+
+func (u *usedDefinitions) directives(dirs []*gqlDirective) {
+	for _, d := range dirs {
+		for _, a := range d.Arguments {
+			u.value(a.Value)
+		}
+	}
+}
+
+func (u *usedDefinitions) value(v gqlValue) {
+	switch v.Kind {
+	case gqlVariable:
+		u.vars[v.Raw] = true
+	case gqlList:
+		for _, item := range v.List {
+			u.value(item)
+		}
+	case gqlObject:
+		for _, f := range v.Fields {
+			u.value(f.Value)
+		}
+	}
+}
+
+// pageResponse is the part of an upstream answer pagination needs.
+type pageResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []json.RawMessage          `json:"errors,omitempty"`
+}
+
+// stitchedResponse is the combined answer returned to the client.
+type stitchedResponse struct {
+	Data       map[string]json.RawMessage `json:"data"`
+	Errors     []json.RawMessage          `json:"errors,omitempty"`
+	Extensions map[string]any             `json:"extensions"`
+}
+
+// run fetches the first page of every paginated field together with the rest of the
+// operation, then the remaining pages field by field, and stitches them together.
+func (plan *paginationPlan) run(ctx context.Context, token string) (int, []byte, error) {
+	first := make([]gqlSelection, len(plan.parsed.Op.Selections))
+	copy(first, plan.parsed.Op.Selections)
+	for _, pf := range plan.fields {
+		for i, sel := range first {
+			if sel == gqlSelection(pf.field) {
+				first[i] = pageField(pf.field, min(pf.pageSize, pf.maxRows), pf.offset)
+			}
+		}
+	}
+	body, err := plan.pageRequest(first)
+	if err != nil {
+		return 0, nil, err
+	}
+	status, respBody, err := fetchGraphQL(ctx, token, body)
+	if err != nil || status != http.StatusOK {
+		return status, respBody, err
+	}
+	var resp pageResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil || resp.Data == nil {
+		return status, respBody, nil // nothing to stitch, pass the answer through
+	}
+
+	ext := paginationExtension{Fields: map[string]*pageStats{}}
+	out := stitchedResponse{Data: resp.Data, Errors: resp.Errors, Extensions: map[string]any{"pagination": &ext}}
+	for _, pf := range plan.fields {
+		stats, err := plan.fetchRemaining(ctx, token, pf, &out)
+		if err != nil {
+			return 0, nil, err
+		}
+		ext.Fields[pf.field.ResponseKey()] = stats
+		ext.Truncated = ext.Truncated || stats.Truncated
+	}
+	b, err := json.Marshal(out)
+	return http.StatusOK, b, err
+}
+
+// fetchRemaining pages through pf, whose first page is already in out, appending rows to it.
+func (plan *paginationPlan) fetchRemaining(ctx context.Context, token string, pf *pagedField, out *stitchedResponse) (*pageStats, error) {
+	key := pf.field.ResponseKey()
+	var rows []json.RawMessage
+	if err := json.Unmarshal(out.Data[key], &rows); err != nil {
+		return &pageStats{}, nil // null or not a list: nothing to paginate
+	}
+	stats := &pageStats{Pages: 1, Rows: len(rows)}
+	last := len(rows)
+	requested := min(pf.pageSize, pf.maxRows)
+	for last == requested && stats.Rows < pf.maxRows {
+		requested = min(pf.pageSize, pf.maxRows-stats.Rows)
+		if err := waitForQuota(ctx, token); err != nil {
+			return nil, err
+		}
+		body, err := plan.pageRequest([]gqlSelection{pageField(pf.field, requested, pf.offset+stats.Rows)})
+		if err != nil {
+			return nil, err
+		}
+		status, respBody, err := fetchGraphQL(ctx, token, body)
+		if err != nil {
+			return nil, err
+		}
+		var page pageResponse
+		var pageRows []json.RawMessage
+		if status != http.StatusOK || json.Unmarshal(respBody, &page) != nil || len(page.Errors) > 0 ||
+			json.Unmarshal(page.Data[key], &pageRows) != nil {
+			out.Errors = append(out.Errors, page.Errors...)
+			if len(page.Errors) == 0 {
+				msg, _ := json.Marshal(gqlError{Message: fmt.Sprintf("pagination of %q stopped: upstream answered with status %d", key, status)})
+				out.Errors = append(out.Errors, msg)
+			}
+			stats.Truncated = true
+			break
+		}
+		rows = append(rows, pageRows...)
+		stats.Pages++
+		stats.Rows += len(pageRows)
+		last = len(pageRows)
+	}
+	if stats.Rows >= pf.maxRows && last == requested {
+		stats.Truncated = true // the cap was hit on a full page, so more rows may exist
+	}
+	merged, err := json.Marshal(rows)
+	if err != nil {
+		return nil, err
+	}
+	out.Data[key] = merged
+	return stats, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pagingUpstream serves "transaction" rows 0..total-1 honouring literal limit/offset
+// arguments and records every request it receives but the identity queries of quotas.
+type pagingUpstream struct {
+	mu       sync.Mutex
+	total    int
+	requests []graphqlRequest
+}
+
+func newPagingUpstream(t *testing.T, total int) *pagingUpstream {
+	t.Helper()
+	u := &pagingUpstream{total: total}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphqlRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Query != identityQuery {
+			u.mu.Lock()
+			u.requests = append(u.requests, req)
+			u.mu.Unlock()
+		}
+
+		doc, err := parseGraphQL(req.Query)
+		if err != nil {
+			t.Errorf("upstream got an invalid document %q: %v", req.Query, err)
+			return
+		}
+		data := map[string]any{}
+		for _, sel := range doc.Operations[0].Selections {
+			f := sel.(*gqlField)
+			if f.Name != "transaction" {
+				data[f.ResponseKey()] = []map[string]any{{"id": 1}}
+				continue
+			}
+			limit, offset := u.total, 0
+			if a := f.Argument("limit"); a != nil && a.Value.Kind == gqlInt {
+				limit, _ = strconv.Atoi(a.Value.Raw)
+			}
+			if a := f.Argument("offset"); a != nil && a.Value.Kind == gqlInt {
+				offset, _ = strconv.Atoi(a.Value.Raw)
+			}
+			rows := []map[string]any{}
+			for i := offset; i < u.total && i < offset+limit; i++ {
+				rows = append(rows, map[string]any{"id": i})
+			}
+			data[f.ResponseKey()] = rows
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	t.Cleanup(srv.Close)
+	overridePaths(t, srv.URL, signinPath, "/graphql")
+	return u
+}
+
+type paginatedResult struct {
+	Data struct {
+		Transaction []struct{ ID int } `json:"transaction"`
+		User        []any              `json:"user"`
+	} `json:"data"`
+	Errors     []gqlError `json:"errors"`
+	Extensions struct {
+		Pagination paginationExtension `json:"pagination"`
+	} `json:"extensions"`
+}
+
+func postPaginated(t *testing.T, body map[string]any) paginatedResult {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(b)))
+	req.Header.Set("Authorization", "Bearer "+makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour))))
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+	var res paginatedResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode %s: %v", rr.Body.String(), err)
+	}
+	return res
+}
+
+func usePagination(t *testing.T, pageSize, maxRows int) {
+	oldSize, oldMax := gqlPageSize, gqlPaginateMaxRows
+	gqlPageSize, gqlPaginateMaxRows = pageSize, maxRows
+	t.Cleanup(func() { gqlPageSize, gqlPaginateMaxRows = oldSize, oldMax })
+}
+
+func TestPaginateDirectiveStitchesPages(t *testing.T) {
+	usePagination(t, 1000, 100)
+	u := newPagingUpstream(t, 5)
+	res := postPaginated(t, map[string]any{
+		"query": `query Q($limit: Int = 2, $uid: Int) {
+			user(where: { id: { _eq: $uid } }) { id }
+			transaction(order_by: { id: asc }, limit: $limit) @paginate { id }
+		}`,
+		"variables": map[string]any{"uid": 42},
+	})
+	if len(res.Data.Transaction) != 5 || len(res.Data.User) != 1 {
+		t.Fatalf("expected 5 stitched rows and the user, got %+v", res.Data)
+	}
+	for i, row := range res.Data.Transaction {
+		if row.ID != i {
+			t.Fatalf("row %d has id %d", i, row.ID)
+		}
+	}
+	stats := res.Extensions.Pagination.Fields["transaction"]
+	if stats == nil || stats.Pages != 3 || stats.Rows != 5 || stats.Truncated || res.Extensions.Pagination.Truncated {
+		t.Fatalf("unexpected pagination stats %+v", res.Extensions.Pagination)
+	}
+
+	if len(u.requests) != 3 {
+		t.Fatalf("expected 3 upstream requests, got %d", len(u.requests))
+	}
+	for i, req := range u.requests {
+		if strings.Contains(req.Query, "@paginate") {
+			t.Fatalf("directive leaked upstream: %s", req.Query)
+		}
+		if i > 0 && (strings.Contains(req.Query, "user") || strings.Contains(req.Query, "$") || len(req.Variables) != 0) {
+			t.Fatalf("follow-up pages should only carry the paginated field: %s %v", req.Query, req.Variables)
+		}
+	}
+	if !strings.Contains(u.requests[2].Query, "offset: 4") {
+		t.Fatalf("expected the last page at offset 4: %s", u.requests[2].Query)
+	}
+}
+
+func TestPaginateHonoursCap(t *testing.T) {
+	usePagination(t, 2, 100)
+	newPagingUpstream(t, 10)
+	res := postPaginated(t, map[string]any{"query": `{ transaction @paginate(max: 3) { id } }`})
+	stats := res.Extensions.Pagination.Fields["transaction"]
+	if len(res.Data.Transaction) != 3 || stats == nil || !stats.Truncated || !res.Extensions.Pagination.Truncated {
+		t.Fatalf("expected 3 rows and truncated, got %d rows %+v", len(res.Data.Transaction), res.Extensions.Pagination)
+	}
+
+	usePagination(t, 2, 4)
+	res = postPaginated(t, map[string]any{"query": `{ transaction @paginate(max: 50) { id } }`})
+	if len(res.Data.Transaction) != 4 || !res.Extensions.Pagination.Truncated {
+		t.Fatalf("GQL_PAGINATE_MAX_ROWS must bound @paginate(max:), got %d rows", len(res.Data.Transaction))
+	}
+}
+
+func TestPaginateStaysWithinQuotaAndCost(t *testing.T) {
+	usePagination(t, 2, 100)
+	useQuotas(t, newQuotaManager(quota{Rate: 0.001, Burst: 10, Concurrent: 1}, nil))
+	newPagingUpstream(t, 5)
+	res := postPaginated(t, map[string]any{"query": `{ transaction @paginate { id } }`})
+	if len(res.Data.Transaction) != 5 {
+		t.Fatalf("expected 5 rows, got %d", len(res.Data.Transaction))
+	}
+	if snap := graphqlQuotas.Snapshot(); len(snap) != 1 || int(snap[0].Tokens) != 7 {
+		t.Fatalf("every page should take a request from the quota, got %+v", snap)
+	}
+
+	overrideQueryLimits(t, 10, 20, 5000, 6)
+	res = postPaginated(t, map[string]any{"query": `{ transaction @paginate { id amount } }`})
+	if len(res.Data.Transaction) != 3 || !res.Extensions.Pagination.Truncated {
+		t.Fatalf("two fields per row within a cost of 6 allow 3 rows, got %d", len(res.Data.Transaction))
+	}
+}
+
+func TestPaginateExtensionFlag(t *testing.T) {
+	usePagination(t, 1000, 100)
+	u := newPagingUpstream(t, 7)
+	res := postPaginated(t, map[string]any{
+		"query":      `{ transaction(limit: 3) { id } user { id } }`,
+		"extensions": map[string]any{"paginate": true},
+	})
+	if len(res.Data.Transaction) != 7 || len(u.requests) != 3 {
+		t.Fatalf("expected 7 rows over 3 requests, got %d rows over %d", len(res.Data.Transaction), len(u.requests))
+	}
+	if _, ok := res.Extensions.Pagination.Fields["user"]; ok {
+		t.Fatal("fields without a limit argument are not paginated")
+	}
+}
+
+func TestPaginateRejectsMisuse(t *testing.T) {
+	newPagingUpstream(t, 1)
+	for _, q := range []string{
+		`{ user { transactions @paginate { id } } }`,
+		`{ ...F } fragment F on query_root { transaction @paginate { id } }`,
+		`mutation { transaction @paginate { id } }`,
+		`{ transaction @paginate(pageSize: 0) { id } }`,
+		`{ transaction @paginate(size: 10) { id } }`,
+	} {
+		res := postPaginated(t, map[string]any{"query": q})
+		if len(res.Errors) != 1 || res.Errors[0].Extensions["code"] != codeValidationFailed {
+			t.Errorf("%s: expected a validation error, got %+v", q, res.Errors)
+		}
+	}
+}
+
+func TestPageRequestKeepsUsedFragments(t *testing.T) {
+	p, err := parseOperation(graphqlRequest{Query: `query Q($a: Int, $b: Int) { x(v: $a) { ...X } y(v: $b) { ...Y } } fragment X on T { id } fragment Y on T { id }`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := &paginationPlan{parsed: p, vars: map[string]any{"a": 1, "b": 2}}
+	body, err := plan.pageRequest(p.Op.Selections[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req graphqlRequest
+	json.Unmarshal(body, &req)
+	want := "query Q($b: Int) { y(v: $b) { ...Y } } fragment Y on T { id }"
+	if req.Query != want || fmt.Sprint(req.Variables) != "map[b:2]" {
+		t.Fatalf("got %q %v", req.Query, req.Variables)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Persisted query modes.
+const (
+	persistedOff    = "off"    // plain GraphQL only; hashes are refused
+	persistedAPQ    = "apq"    // Automatic Persisted Queries: clients register documents by hash
+	persistedStrict = "strict" // only documents listed in PERSISTED_MANIFEST are executed
+)
+
+var persistedMode = getenv("PERSISTED_QUERIES", persistedAPQ)
+var persistedManifest = getenv("PERSISTED_MANIFEST", "")
+var persistedMaxEntries = getenvInt("PERSISTED_MAX_ENTRIES", 1000)
+
+// Extension codes used by Apollo-compatible APQ clients.
+const (
+	codePersistedNotFound     = "PERSISTED_QUERY_NOT_FOUND"
+	codePersistedNotSupported = "PERSISTED_QUERY_NOT_SUPPORTED"
+	codePersistedNotAllowed   = "PERSISTED_QUERY_NOT_ALLOWED"
+	codePersistedHashMismatch = "PERSISTED_QUERY_HASH_MISMATCH"
+)
+
+// persistedQueries holds manifest entries plus documents registered through APQ.
+var persistedQueries = newPersistedQueryStoreFromEnv()
+
+// persistedQueryExtension is the "persistedQuery" entry of a request's extensions.
+type persistedQueryExtension struct {
+	Version    int    `json:"version"`
+	Sha256Hash string `json:"sha256Hash"`
+}
+
+// persistedQueryStore maps SHA-256 hashes to documents. Manifest entries are permanent;
+// registered entries are evicted oldest first once max is reached.
+type persistedQueryStore struct {
+	mu       sync.Mutex
+	manifest map[string]string
+	docs     map[string]string
+	order    []string
+	max      int
+}
+
+func newPersistedQueryStore(manifest map[string]string, max int) *persistedQueryStore {
+	if manifest == nil {
+		manifest = map[string]string{}
+	}
+	return &persistedQueryStore{manifest: manifest, docs: map[string]string{}, max: max}
+}
+
+// newPersistedQueryStoreFromEnv loads PERSISTED_MANIFEST when set. In strict mode a missing
+// manifest leaves the allowlist empty, which rejects everything rather than failing open.
+func newPersistedQueryStoreFromEnv() *persistedQueryStore {
+	var manifest map[string]string
+	if persistedManifest != "" {
+		m, err := loadPersistedManifest(persistedManifest)
+		if err != nil {
+			log.Printf("persisted query manifest not loaded: %v", err)
+		}
+		manifest = m
+	}
+	return newPersistedQueryStore(manifest, persistedMaxEntries)
+}
+
+// loadPersistedManifest reads a JSON object of sha256 hash -> document. Entries whose hash
+// does not match their document are skipped.
+func loadPersistedManifest(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(raw))
+	for hash, doc := range raw {
+		if queryHash(doc) != strings.ToLower(hash) {
+			log.Printf("persisted query manifest entry %s skipped: hash mismatch", hash)
+			continue
+		}
+		out[strings.ToLower(hash)] = doc
+	}
+	return out, nil
+}
+
+// queryHash returns the hex SHA-256 of a document, as used by APQ.
+func queryHash(doc string) string {
+	sum := sha256.Sum256([]byte(doc))
+	return hex.EncodeToString(sum[:])
+}
+
+// Lookup returns the document stored under hash.
+func (s *persistedQueryStore) Lookup(hash string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if doc, ok := s.manifest[hash]; ok {
+		return doc, true
+	}
+	doc, ok := s.docs[hash]
+	return doc, ok
+}
+
+// InManifest reports whether hash is part of the static allowlist.
+func (s *persistedQueryStore) InManifest(hash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.manifest[hash]
+	return ok
+}
+
+// Register stores doc under hash, evicting the oldest registration when full.
+func (s *persistedQueryStore) Register(hash, doc string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.manifest[hash]; ok {
+		return
+	}
+	if _, ok := s.docs[hash]; ok {
+		return
+	}
+	if s.max > 0 && len(s.order) >= s.max {
+		delete(s.docs, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.docs[hash] = doc
+	s.order = append(s.order, hash)
+}
+
+// persistedExtension decodes the persistedQuery extension of req, if any.
+func persistedExtension(req graphqlRequest) (*persistedQueryExtension, error) {
+	raw, ok := req.Extensions["persistedQuery"]
+	if !ok {
+		return nil, nil
+	}
+	ext := &persistedQueryExtension{}
+	if err := json.Unmarshal(raw, ext); err != nil || ext.Sha256Hash == "" {
+		return nil, requestErrorf(codeBadRequest, "Invalid persistedQuery extension.")
+	}
+	ext.Sha256Hash = strings.ToLower(ext.Sha256Hash)
+	return ext, nil
+}
+
+// resolvePersistedQuery fills req.Query from the store according to persistedMode and
+// enforces the strict allowlist. It reports whether the request referenced a persisted
+// query, in which case the upstream body has to be rebuilt without the extension.
+func resolvePersistedQuery(req *graphqlRequest) (bool, error) {
+	ext, err := persistedExtension(*req)
+	if err != nil {
+		return false, err
+	}
+
+	switch persistedMode {
+	case persistedStrict:
+		hash := ""
+		if ext != nil {
+			hash = ext.Sha256Hash
+		} else if req.Query != "" {
+			hash = queryHash(req.Query)
+		}
+		if hash == "" || !persistedQueries.InManifest(hash) {
+			return false, requestErrorf(codePersistedNotAllowed, "Only operations from the persisted query manifest are accepted.")
+		}
+		if req.Query != "" && queryHash(req.Query) != hash {
+			return false, requestErrorf(codePersistedHashMismatch, "provided sha does not match query")
+		}
+		req.Query, _ = persistedQueries.Lookup(hash)
+		return ext != nil, nil
+	case persistedOff:
+		if ext != nil {
+			return false, requestErrorf(codePersistedNotSupported, "PersistedQueryNotSupported")
+		}
+		return false, nil
+	}
+
+	if ext == nil {
+		return false, nil
+	}
+	if req.Query == "" {
+		doc, ok := persistedQueries.Lookup(ext.Sha256Hash)
+		if !ok {
+			return false, requestErrorf(codePersistedNotFound, "PersistedQueryNotFound")
+		}
+		req.Query = doc
+		return true, nil
+	}
+	if queryHash(req.Query) != ext.Sha256Hash {
+		return false, requestErrorf(codePersistedHashMismatch, "provided sha does not match query")
+	}
+	return true, nil
+}
+
+// registerPersistedQuery remembers an APQ registration once the document passed validation.
+func registerPersistedQuery(req graphqlRequest) {
+	if persistedMode != persistedAPQ {
+		return
+	}
+	if ext, _ := persistedExtension(req); ext != nil {
+		persistedQueries.Register(ext.Sha256Hash, req.Query)
+	}
+}
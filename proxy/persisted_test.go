@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func usePersisted(t *testing.T, mode string, store *persistedQueryStore) {
+	oldMode, oldStore := persistedMode, persistedQueries
+	persistedMode, persistedQueries = mode, store
+	t.Cleanup(func() { persistedMode, persistedQueries = oldMode, oldStore })
+}
+
+// apqBody builds a request body carrying a persistedQuery extension for doc.
+func apqBody(t *testing.T, doc string, includeQuery bool) string {
+	t.Helper()
+	req := map[string]any{
+		"extensions": map[string]any{"persistedQuery": map[string]any{"version": 1, "sha256Hash": queryHash(doc)}},
+		"variables":  map[string]any{"limit": 10},
+	}
+	if includeQuery {
+		req["query"] = doc
+	}
+	b, _ := json.Marshal(req)
+	return string(b)
+}
+
+// postGraphQL sends body to graphqlHandler and returns the recorder plus what upstream received.
+func postGraphQL(t *testing.T, body string) (*httptest.ResponseRecorder, []byte) {
+	t.Helper()
+	var seen []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = io.ReadAll(r.Body)
+		io.WriteString(w, `{"data":{}}`)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, signinPath, "/graphql")
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer token")
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+	return rr, seen
+}
+
+func errorCode(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp gqlErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || len(resp.Errors) == 0 {
+		return ""
+	}
+	code, _ := resp.Errors[0].Extensions["code"].(string)
+	return code
+}
+
+func TestManifestCoversFrontendQueries(t *testing.T) {
+	manifest, err := loadPersistedManifest("persisted-queries.json")
+	if err != nil {
+		t.Fatalf("load manifest: %v", err)
+	}
+	for _, doc := range []string{queryMe, queryXpTransactions, queryObjectByIDs, queryProgress} {
+		if manifest[queryHash(doc)] != doc {
+			t.Errorf("manifest is missing %q", doc)
+		}
+	}
+}
+
+func TestPersistedQueryStoreEvictsOldest(t *testing.T) {
+	s := newPersistedQueryStore(map[string]string{"m": "manifest"}, 2)
+	s.Register("a", "A")
+	s.Register("b", "B")
+	s.Register("c", "C")
+	if _, ok := s.Lookup("a"); ok {
+		t.Fatal("expected oldest registration to be evicted")
+	}
+	if doc, ok := s.Lookup("c"); !ok || doc != "C" {
+		t.Fatalf("expected newest registration, got %q", doc)
+	}
+	if doc, ok := s.Lookup("m"); !ok || doc != "manifest" {
+		t.Fatal("manifest entries must never be evicted")
+	}
+}
+
+func TestAPQRegisterThenLookup(t *testing.T) {
+	usePersisted(t, persistedAPQ, newPersistedQueryStore(nil, 10))
+
+	rr, _ := postGraphQL(t, apqBody(t, queryXpTransactions, false))
+	if code := errorCode(t, rr); code != codePersistedNotFound {
+		t.Fatalf("expected %s before registration, got %q", codePersistedNotFound, code)
+	}
+
+	rr, _ = postGraphQL(t, apqBody(t, queryXpTransactions, true))
+	if rr.Code != http.StatusOK || errorCode(t, rr) != "" {
+		t.Fatalf("registration failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr, seen := postGraphQL(t, apqBody(t, queryXpTransactions, false))
+	if rr.Code != http.StatusOK || errorCode(t, rr) != "" {
+		t.Fatalf("hash-only request failed: %d %s", rr.Code, rr.Body.String())
+	}
+	var forwarded map[string]any
+	if err := json.Unmarshal(seen, &forwarded); err != nil {
+		t.Fatalf("upstream body not JSON: %v", err)
+	}
+	if forwarded["query"] != queryXpTransactions || forwarded["extensions"] != nil {
+		t.Fatalf("upstream should get the full document without extensions: %s", seen)
+	}
+	if vars, _ := forwarded["variables"].(map[string]any); vars["limit"] != float64(10) {
+		t.Fatalf("variables not forwarded: %s", seen)
+	}
+}
+
+func TestAPQRejectsHashMismatch(t *testing.T) {
+	usePersisted(t, persistedAPQ, newPersistedQueryStore(nil, 10))
+	body := `{"query":"{ user { id } }","extensions":{"persistedQuery":{"version":1,"sha256Hash":"deadbeef"}}}`
+	rr, seen := postGraphQL(t, body)
+	if code := errorCode(t, rr); code != codePersistedHashMismatch || seen != nil {
+		t.Fatalf("expected %s without upstream call, got %q", codePersistedHashMismatch, code)
+	}
+}
+
+func TestAPQDoesNotRegisterRejectedQueries(t *testing.T) {
+	overrideQueryLimits(t, 1, 0, 0, 0)
+	store := newPersistedQueryStore(nil, 10)
+	usePersisted(t, persistedAPQ, store)
+	deep := `{ user { id } }`
+	postGraphQL(t, apqBody(t, deep, true))
+	if _, ok := store.Lookup(queryHash(deep)); ok {
+		t.Fatal("queries failing validation must not be registered")
+	}
+}
+
+func TestStrictModeAllowsOnlyManifest(t *testing.T) {
+	usePersisted(t, persistedStrict, newPersistedQueryStore(map[string]string{queryHash(queryMe): queryMe}, 10))
+
+	body, _ := json.Marshal(map[string]string{"query": queryMe})
+	if rr, seen := postGraphQL(t, string(body)); errorCode(t, rr) != "" || seen == nil {
+		t.Fatalf("manifest query should pass: %s", rr.Body.String())
+	}
+	if rr, _ := postGraphQL(t, apqBody(t, queryMe, false)); errorCode(t, rr) != "" {
+		t.Fatalf("manifest hash should pass: %s", rr.Body.String())
+	}
+	if rr, seen := postGraphQL(t, `{"query":"{ user { email } }"}`); errorCode(t, rr) != codePersistedNotAllowed || seen != nil {
+		t.Fatalf("expected %s, got %s", codePersistedNotAllowed, rr.Body.String())
+	}
+	if rr, _ := postGraphQL(t, apqBody(t, "{ other }", true)); errorCode(t, rr) != codePersistedNotAllowed {
+		t.Fatalf("strict mode must not accept registrations: %s", rr.Body.String())
+	}
+}
+
+func TestPersistedQueriesOff(t *testing.T) {
+	usePersisted(t, persistedOff, newPersistedQueryStore(nil, 10))
+	if rr, _ := postGraphQL(t, apqBody(t, queryMe, true)); errorCode(t, rr) != codePersistedNotSupported {
+		t.Fatalf("expected %s, got %s", codePersistedNotSupported, rr.Body.String())
+	}
+}
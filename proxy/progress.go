@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// passGradeThreshold is the lowest grade that counts as a pass.
+var passGradeThreshold = getenvFloat("PASS_GRADE_THRESHOLD", 1)
+
+// progressQuery fetches the caller's finished results in chronological order.
+const progressQuery = `query MeProgress($where: progress_bool_exp!) {
+  progress(where: $where, order_by: [{ createdAt: asc }, { id: asc }]) @paginate {
+    id grade isDone createdAt updatedAt path objectId
+    object { id name type }
+  }
+}`
+
+// progressEntry is one finished result.
+type progressEntry struct {
+	ID        int         `json:"id"`
+	Grade     *float64    `json:"grade"`
+	IsDone    bool        `json:"isDone"`
+	CreatedAt time.Time   `json:"createdAt"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+	Path      string      `json:"path"`
+	ObjectID  *int        `json:"objectId"`
+	Object    *objectInfo `json:"object"`
+}
+
+// passed applies the pass rule: a grade at or above passGradeThreshold. Missing grades fail.
+func (p progressEntry) passed() bool {
+	return p.Grade != nil && *p.Grade >= passGradeThreshold
+}
+
+// objectKey groups attempts at the same object; entries without one are grouped by path.
+func (p progressEntry) objectKey() string {
+	if p.ObjectID != nil {
+		return strconv.Itoa(*p.ObjectID)
+	}
+	return p.Path
+}
+
+// objectType returns the type of the attempted object, or "unknown".
+func (p progressEntry) objectType() string {
+	if p.Object != nil && p.Object.Type != "" {
+		return p.Object.Type
+	}
+	return "unknown"
+}
+
+// fetchProgress loads the caller's finished results created within dr, oldest first.
+func fetchProgress(ctx context.Context, c *apiCaller, dr dateRange) ([]progressEntry, error) {
+	return fetchProgressWhere(ctx, c, dr.where("createdAt", map[string]any{"isDone": map[string]any{"_eq": true}}))
+}
+
+// fetchProgressWhere loads the caller's results matching the Hasura expression where,
+// oldest first.
+func fetchProgressWhere(ctx context.Context, c *apiCaller, where map[string]any) ([]progressEntry, error) {
+	if c.UserID != 0 {
+		where["userId"] = map[string]any{"_eq": c.UserID}
+	}
+	var data struct {
+		Progress []progressEntry `json:"progress"`
+	}
+	if err := queryUpstream(ctx, c.Token, progressQuery, map[string]any{"where": where}, &data); err != nil {
+		return nil, err
+	}
+	if data.Progress == nil {
+		data.Progress = []progressEntry{}
+	}
+	return data.Progress, nil
+}
+
+// passFail counts results against the pass rule.
+type passFail struct {
+	Total    int     `json:"total"`
+	Pass     int     `json:"pass"`
+	Fail     int     `json:"fail"`
+	PassRate float64 `json:"passRate"` // 0..1, 0 when there are no results
+}
+
+func (s *passFail) add(passed bool) {
+	s.Total++
+	if passed {
+		s.Pass++
+	} else {
+		s.Fail++
+	}
+	s.PassRate = float64(s.Pass) / float64(s.Total)
+}
+
+// firstAttemptStats reports how many distinct objects were passed on their first attempt.
+type firstAttemptStats struct {
+	Objects int     `json:"objects"`
+	Passed  int     `json:"passed"`
+	Rate    float64 `json:"rate"`
+}
+
+// progressStatsResponse is the body of GET /api/me/progress/stats.
+type progressStatsResponse struct {
+	dateRange
+	Threshold float64 `json:"threshold"`
+	passFail
+	ByType       map[string]*passFail `json:"byType"`
+	FirstAttempt firstAttemptStats    `json:"firstAttempt"`
+}
+
+// progressStats aggregates entries, which must be in chronological order for the
+// first-attempt figures.
+func progressStats(entries []progressEntry) progressStatsResponse {
+	resp := progressStatsResponse{Threshold: passGradeThreshold, ByType: map[string]*passFail{}}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		passed := e.passed()
+		resp.add(passed)
+		t, ok := resp.ByType[e.objectType()]
+		if !ok {
+			t = &passFail{}
+			resp.ByType[e.objectType()] = t
+		}
+		t.add(passed)
+
+		if key := e.objectKey(); !seen[key] {
+			seen[key] = true
+			resp.FirstAttempt.Objects++
+			if passed {
+				resp.FirstAttempt.Passed++
+			}
+		}
+	}
+	if resp.FirstAttempt.Objects > 0 {
+		resp.FirstAttempt.Rate = float64(resp.FirstAttempt.Passed) / float64(resp.FirstAttempt.Objects)
+	}
+	return resp
+}
+
+// progressStatsHandler reports pass/fail counts, the pass rate per object type and the
+// first-attempt success rate of the caller's finished results.
+func progressStatsHandler() http.HandlerFunc {
+	return meHandler(func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		dr, err := parseDateRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entries, err := fetchProgress(r.Context(), c, dr)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		resp := progressStats(entries)
+		resp.dateRange = dr
+		withJSON(w)
+		okJSON(w, resp)
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func usePassThreshold(t *testing.T, v float64) {
+	old := passGradeThreshold
+	passGradeThreshold = v
+	t.Cleanup(func() { passGradeThreshold = old })
+}
+
+// seedProgress fills z with finished results for user 42 in chronological order:
+// a project failed then passed, an exercise passed first time, a piscine exercise with a
+// low grade and an unfinished attempt that must be ignored.
+func seedProgress(z *fakeZone01) {
+	project := map[string]any{"id": 10, "name": "go-reloaded", "type": "project"}
+	exercise := map[string]any{"id": 30, "name": "printalphabet", "type": "exercise"}
+	piscine := map[string]any{"id": 40, "name": "quest-01", "type": "piscine"}
+	z.add("progress", map[string]any{"id": 1, "userId": 42, "isDone": true, "grade": 0, "createdAt": "2024-01-01T10:00:00+00:00", "path": "/a/go-reloaded", "objectId": 10, "object": project})
+	z.add("progress", map[string]any{"id": 2, "userId": 42, "isDone": true, "grade": 1.2, "createdAt": "2024-01-03T10:00:00+00:00", "path": "/a/go-reloaded", "objectId": 10, "object": project})
+	z.add("progress", map[string]any{"id": 3, "userId": 42, "isDone": true, "grade": 1, "createdAt": "2024-01-04T10:00:00+00:00", "path": "/a/printalphabet", "objectId": 30, "object": exercise})
+	z.add("progress", map[string]any{"id": 4, "userId": 42, "isDone": true, "grade": 0.5, "createdAt": "2024-02-01T10:00:00+00:00", "path": "/a/quest-01", "objectId": 40, "object": piscine})
+	z.add("progress", map[string]any{"id": 5, "userId": 42, "isDone": false, "grade": nil, "createdAt": "2024-02-02T10:00:00+00:00", "path": "/a/quest-02", "objectId": nil, "object": nil})
+	z.add("progress", map[string]any{"id": 6, "userId": 7, "isDone": true, "grade": 1, "createdAt": "2024-02-02T10:00:00+00:00", "path": "/a/other", "objectId": 10, "object": project})
+}
+
+func getStats(t *testing.T, target string) progressStatsResponse {
+	t.Helper()
+	rr := apiGet(t, progressStatsHandler(), target, "42")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	var resp progressStatsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestProgressStatsHandler(t *testing.T) {
+	usePassThreshold(t, 1)
+	seedProgress(newFakeZone01(t))
+
+	resp := getStats(t, "/api/me/progress/stats")
+	if resp.Total != 4 || resp.Pass != 2 || resp.Fail != 2 || resp.PassRate != 0.5 || resp.Threshold != 1 {
+		t.Fatalf("unexpected totals %+v", resp.passFail)
+	}
+	if p := resp.ByType["project"]; p == nil || p.Total != 2 || p.Pass != 1 {
+		t.Fatalf("unexpected project breakdown %+v", p)
+	}
+	if p := resp.ByType["piscine"]; p == nil || p.Fail != 1 || p.PassRate != 0 {
+		t.Fatalf("unexpected piscine breakdown %+v", p)
+	}
+	// go-reloaded failed first, printalphabet passed first, quest-01 failed
+	if fa := resp.FirstAttempt; fa.Objects != 3 || fa.Passed != 1 || math.Abs(fa.Rate-1.0/3) > 1e-9 {
+		t.Fatalf("unexpected first-attempt stats %+v", fa)
+	}
+
+	resp = getStats(t, "/api/me/progress/stats?from=2024-01-02")
+	if resp.Total != 3 || resp.FirstAttempt.Passed != 2 {
+		t.Fatalf("from should drop the first failure: %+v", resp)
+	}
+}
+
+func TestProgressStatsThreshold(t *testing.T) {
+	usePassThreshold(t, 0.5)
+	seedProgress(newFakeZone01(t))
+	resp := getStats(t, "/api/me/progress/stats")
+	if resp.Pass != 3 || resp.Threshold != 0.5 || resp.ByType["piscine"].Pass != 1 {
+		t.Fatalf("threshold 0.5 should pass the piscine result: %+v", resp)
+	}
+}
+
+func TestProgressStatsEmpty(t *testing.T) {
+	resp := progressStats(nil)
+	if resp.Total != 0 || resp.PassRate != 0 || resp.FirstAttempt.Rate != 0 || resp.ByType == nil {
+		t.Fatalf("unexpected empty stats %+v", resp)
+	}
+	one := 1.0
+	if !(progressEntry{Grade: &one}).passed() || (progressEntry{}).passed() {
+		t.Fatal("missing grades must count as failures")
+	}
+}
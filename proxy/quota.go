@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GraphQL quotas are "rate/burst/concurrent" triples: requests per minute, bucket size and
+// simultaneous in-flight requests. GRAPHQL_QUOTAS overrides them per Hasura role, e.g.
+// "user=120/30/4,admin=600/100/16".
+var graphqlQuotaDefault = getenv("GRAPHQL_QUOTA_DEFAULT", "120/30/4")
+var graphqlQuotaRoles = getenv("GRAPHQL_QUOTAS", "")
+
+// graphqlQuotas is shared by graphqlHandler and the admin endpoint.
+var graphqlQuotas = newQuotaManager(mustQuota(graphqlQuotaDefault), parseRoleQuotas(graphqlQuotaRoles))
+
+// quota bounds how much one subject may use the GraphQL proxy.
+type quota struct {
+	Rate       float64 // requests per minute
+	Burst      int
+	Concurrent int
+}
+
+// parseQuota reads a "rate/burst/concurrent" triple.
+func parseQuota(v string) (quota, error) {
+	parts := strings.Split(strings.TrimSpace(v), "/")
+	if len(parts) != 3 {
+		return quota{}, fmt.Errorf("quota %q: want rate/burst/concurrent", v)
+	}
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return quota{}, fmt.Errorf("quota %q: %w", v, err)
+	}
+	burst, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return quota{}, fmt.Errorf("quota %q: %w", v, err)
+	}
+	conc, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return quota{}, fmt.Errorf("quota %q: %w", v, err)
+	}
+	return quota{Rate: rate, Burst: burst, Concurrent: conc}, nil
+}
+
+// mustQuota parses v and falls back to a permissive default when it is invalid.
+func mustQuota(v string) quota {
+	q, err := parseQuota(v)
+	if err != nil {
+		log.Printf("invalid GRAPHQL_QUOTA_DEFAULT: %v", err)
+		return quota{Rate: 120, Burst: 30, Concurrent: 4}
+	}
+	return q
+}
+
+// parseRoleQuotas reads "role=rate/burst/concurrent" pairs separated by commas; bad entries are skipped.
+func parseRoleQuotas(v string) map[string]quota {
+	out := map[string]quota{}
+	for _, pair := range strings.Split(v, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		role, spec, ok := strings.Cut(pair, "=")
+		if !ok {
+			log.Printf("invalid GRAPHQL_QUOTAS entry %q", pair)
+			continue
+		}
+		q, err := parseQuota(spec)
+		if err != nil {
+			log.Printf("invalid GRAPHQL_QUOTAS entry: %v", err)
+			continue
+		}
+		out[strings.TrimSpace(role)] = q
+	}
+	return out
+}
+
+// quotaManager enforces per-subject request rates and concurrency, with limits chosen by role.
+type quotaManager struct {
+	mu       sync.Mutex
+	def      quota
+	roles    map[string]quota
+	limiters map[string]*rateLimiter // one limiter per role, keyed by subject inside
+	inflight map[string]int
+	now      func() time.Time
+}
+
+func newQuotaManager(def quota, roles map[string]quota) *quotaManager {
+	return &quotaManager{def: def, roles: roles, limiters: map[string]*rateLimiter{}, inflight: map[string]int{}, now: time.Now}
+}
+
+// quotaFor returns the quota applying to role.
+func (m *quotaManager) quotaFor(role string) quota {
+	if q, ok := m.roles[role]; ok {
+		return q
+	}
+	return m.def
+}
+
+// limiter returns the rate limiter for role; callers must hold m.mu.
+func (m *quotaManager) limiter(role string) *rateLimiter {
+	l, ok := m.limiters[role]
+	if !ok {
+		q := m.quotaFor(role)
+		l = newRateLimiter(q.Rate, q.Burst)
+		l.now = m.now
+		m.limiters[role] = l
+	}
+	return l
+}
+
+// quotaDecision is the outcome of quotaManager.Acquire.
+type quotaDecision struct {
+	Allowed    bool
+	Limit      int // requests per minute
+	Remaining  int
+	RetryAfter time.Duration
+	Reason     string
+	release    func()
+}
+
+// Release frees the concurrency slot held by an allowed request.
+func (d quotaDecision) Release() {
+	if d.release != nil {
+		d.release()
+	}
+}
+
+// Acquire checks the concurrency and rate limits of subject; allowed decisions must be released.
+func (m *quotaManager) Acquire(subject, role string) quotaDecision {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.quotaFor(role)
+	l := m.limiter(role)
+	d := quotaDecision{Limit: int(math.Round(q.Rate))}
+	if q.Concurrent > 0 && m.inflight[subject] >= q.Concurrent {
+		d.Remaining = l.Remaining(subject)
+		d.RetryAfter = time.Second
+		d.Reason = "too many concurrent requests"
+		return d
+	}
+	ok, wait := l.Allow(subject)
+	d.Remaining = l.Remaining(subject)
+	if !ok {
+		d.RetryAfter = wait
+		d.Reason = "rate limit exceeded"
+		return d
+	}
+	d.Allowed = true
+	m.inflight[subject]++
+	var once sync.Once
+	d.release = func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if m.inflight[subject]--; m.inflight[subject] <= 0 {
+				delete(m.inflight, subject)
+			}
+		})
+	}
+	return d
+}
+
+// Take consumes one request of subject's rate without taking a concurrency slot, for
+// upstream calls made on behalf of a request that already holds one.
+func (m *quotaManager) Take(subject, role string) quotaDecision {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q := m.quotaFor(role)
+	l := m.limiter(role)
+	ok, wait := l.Allow(subject)
+	d := quotaDecision{Allowed: ok, Limit: int(math.Round(q.Rate)), Remaining: l.Remaining(subject)}
+	if !ok {
+		d.RetryAfter = wait
+		d.Reason = "rate limit exceeded"
+	}
+	return d
+}
+
+// waitForQuota takes one request of the quota of the caller behind token, waiting for the
+// bucket to refill when it is empty. Requests that page through results call it for every
+// page after the first, so long downloads are throttled like the requests they replace.
+func waitForQuota(ctx context.Context, token string) error {
+	subject, role := tokenSubject(ctx, token)
+	for {
+		q := graphqlQuotas.Take(subject, role)
+		if q.Allowed {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(q.RetryAfter):
+		}
+	}
+}
+
+// quotaState is the admin view of one subject.
+type quotaState struct {
+	Role     string  `json:"role"`
+	Subject  string  `json:"subject"`
+	Tokens   float64 `json:"tokens"`
+	InFlight int     `json:"inFlight"`
+}
+
+// Snapshot lists every tracked subject, sorted by role then subject.
+func (m *quotaManager) Snapshot() []quotaState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []quotaState{}
+	for role, l := range m.limiters {
+		for _, b := range l.Snapshot() {
+			out = append(out, quotaState{Role: role, Subject: b.Key, Tokens: b.Tokens, InFlight: m.inflight[b.Key]})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Role != out[j].Role {
+			return out[i].Role < out[j].Role
+		}
+		return out[i].Subject < out[j].Subject
+	})
+	return out
+}
+
+// tokenSubject derives the quota key and role from a bearer token. Claims are only trusted
+// once the token is known to be genuine: localVerifier checked its signature, or the
+// upstream confirmed it belongs to the user it claims (see identities, one query per token).
+// Other tokens, JWTs or not, are keyed by a hash so they still get a bucket of their own but
+// cannot claim another user's or role.
+func tokenSubject(ctx context.Context, raw string) (subject, role string) {
+	if tok, err := parseJWT(raw); err == nil && tok.Claims.UserID() != "" {
+		if owner, err := identities.owner(ctx, raw); err == nil && owner == tok.Claims.UserID() {
+			return owner, tok.Claims.Hasura.DefaultRole
+		}
+	}
+	sum := sha256.Sum256([]byte(raw))
+	return "token:" + hex.EncodeToString(sum[:8]), ""
+}
+
+// setRateLimitHeaders reports the caller's quota on w.
+func setRateLimitHeaders(w http.ResponseWriter, d quotaDecision) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useQuotas(t *testing.T, m *quotaManager) {
+	old := graphqlQuotas
+	graphqlQuotas = m
+	t.Cleanup(func() { graphqlQuotas = old })
+}
+
+// TestMain replaces the GRAPHQL_QUOTA_DEFAULT quota, which the whole suite's requests for
+// the same users would exhaust; quota tests install their own manager with useQuotas.
+func TestMain(m *testing.M) {
+	graphqlQuotas = newQuotaManager(quota{Rate: 60000, Burst: 100000, Concurrent: 64}, nil)
+	zone01Base = "http://127.0.0.1:0" // tests without a fake upstream must not reach the real one
+	os.Exit(m.Run())
+}
+
+func TestParseRoleQuotas(t *testing.T) {
+	got := parseRoleQuotas("user=60/10/2, admin=600/100/16,broken,bad=1/2")
+	if len(got) != 2 {
+		t.Fatalf("expected 2 valid entries, got %+v", got)
+	}
+	if q := got["admin"]; q.Rate != 600 || q.Burst != 100 || q.Concurrent != 16 {
+		t.Fatalf("unexpected admin quota: %+v", q)
+	}
+	if _, err := parseQuota("1/x/3"); err == nil {
+		t.Fatal("expected error for non-numeric burst")
+	}
+}
+
+func TestQuotaManagerRateAndRoles(t *testing.T) {
+	m := newQuotaManager(quota{Rate: 60, Burst: 1, Concurrent: 0}, map[string]quota{"admin": {Rate: 60, Burst: 3}})
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	m.now = clock.now
+
+	if d := m.Acquire("1", "user"); !d.Allowed || d.Limit != 60 || d.Remaining != 0 {
+		t.Fatalf("unexpected first decision: %+v", d)
+	}
+	if d := m.Acquire("1", "user"); d.Allowed || d.RetryAfter != time.Second {
+		t.Fatalf("expected rate limit, got %+v", d)
+	}
+	if d := m.Acquire("2", "user"); !d.Allowed {
+		t.Fatal("subjects must not share buckets")
+	}
+	for i := 0; i < 3; i++ {
+		if d := m.Acquire("9", "admin"); !d.Allowed || d.Limit != 60 || d.Remaining != 2-i {
+			t.Fatalf("admin request %d: %+v", i, d)
+		}
+	}
+}
+
+func TestQuotaManagerConcurrency(t *testing.T) {
+	m := newQuotaManager(quota{Rate: 600, Burst: 10, Concurrent: 1}, nil)
+	first := m.Acquire("1", "")
+	if !first.Allowed {
+		t.Fatal("first request should pass")
+	}
+	if d := m.Acquire("1", ""); d.Allowed || d.Reason != "too many concurrent requests" {
+		t.Fatalf("expected concurrency limit, got %+v", d)
+	}
+	first.Release()
+	first.Release() // releasing twice must not free a second slot
+	if d := m.Acquire("1", ""); !d.Allowed {
+		t.Fatal("slot should be free after release")
+	}
+	if snap := m.Snapshot(); len(snap) != 1 || snap[0].InFlight != 1 {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+}
+
+func TestTokenSubject(t *testing.T) {
+	z := newFakeZone01(t)
+	ctx := context.Background()
+	claims := zoneClaims("42", time.Now().Add(time.Hour))
+	if sub, role := tokenSubject(ctx, makeJWT(t, claims)); sub != "42" || role != "user" {
+		t.Fatalf("claims the upstream confirmed should be trusted: %q %q", sub, role)
+	}
+	if sub, _ := tokenSubject(ctx, makeJWT(t, zoneClaims("42", time.Now().Add(3*time.Hour)))); sub != "42" {
+		t.Fatalf("every token of a user should share the user's bucket, got %q", sub)
+	}
+	forged := makeJWT(t, zoneClaims("42", time.Now().Add(2*time.Hour)))
+	forged = forged[:strings.LastIndex(forged, ".")+1] + "Zm9yZ2Vk"
+	z.errors["user"] = gqlError{Message: "Could not verify JWT", Extensions: map[string]any{"code": "invalid-jwt"}}
+	if sub, role := tokenSubject(ctx, forged); !strings.HasPrefix(sub, "token:") || role != "" {
+		t.Fatalf("claims the upstream refused must not be trusted: %q %q", sub, role)
+	}
+
+	useVerifier(t, testVerifier(verificationKey{key: []byte("s3cret")}))
+	if sub, role := tokenSubject(ctx, signJWT(t, "HS256", "", []byte("s3cret"), claims)); sub != "42" || role != "user" {
+		t.Fatalf("unexpected subject: %q %q", sub, role)
+	}
+	if sub, _ := tokenSubject(ctx, signJWT(t, "HS256", "", []byte("forged"), claims)); sub == "42" {
+		t.Fatal("a token with a bad signature must not get the user's bucket")
+	}
+	a, _ := tokenSubject(ctx, "opaque-a")
+	b, _ := tokenSubject(ctx, "opaque-b")
+	if !strings.HasPrefix(a, "token:") || a == b {
+		t.Fatalf("opaque tokens need distinct keys: %q %q", a, b)
+	}
+}
+
+func TestGraphqlHandlerEnforcesQuota(t *testing.T) {
+	useQuotas(t, newQuotaManager(quota{Rate: 1, Burst: 1, Concurrent: 1}, nil))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"data":{}}`)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, signinPath, "/graphql")
+
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{ user { id } }"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		graphqlHandler().ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("request %d: expected %d, got %d", i, want, rr.Code)
+		}
+		h := rr.Result().Header
+		if h.Get("RateLimit-Limit") != "1" || h.Get("RateLimit-Remaining") != "0" {
+			t.Fatalf("unexpected limit headers: %v", h)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/subtle"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sign-in throttling; rates are expressed in attempts per minute.
+var signinIPRate = getenvFloat("SIGNIN_IP_RATE", 10)
+var signinIPBurst = getenvInt("SIGNIN_IP_BURST", 10)
+var signinIdentityRate = getenvFloat("SIGNIN_IDENTITY_RATE", 5)
+var signinIdentityBurst = getenvInt("SIGNIN_IDENTITY_BURST", 5)
+var signinLockoutThreshold = getenvInt("SIGNIN_LOCKOUT_THRESHOLD", 3)
+var signinLockoutBase = getenvDuration("SIGNIN_LOCKOUT_BASE", 30*time.Second)
+var signinLockoutMax = getenvDuration("SIGNIN_LOCKOUT_MAX", 15*time.Minute)
+
+// trustProxyHeaders makes clientIP honour X-Forwarded-For; only enable it behind a trusted proxy.
+var trustProxyHeaders = getenv("TRUST_PROXY_HEADERS", "") == "true"
+
+// adminToken guards the /admin endpoints; they are disabled while it is empty.
+var adminToken = getenv("ADMIN_TOKEN", "")
+
+// signinGuard is shared by authHandler and the admin endpoint.
+var signinGuard = newSigninLimiter()
+
+// tokenBucket is the per-key state of a rateLimiter.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter hands out tokens per key, refilling at rate tokens per second up to burst.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// newRateLimiter builds a limiter allowing perMinute requests per minute with the given burst.
+func newRateLimiter(perMinute float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    perMinute / 60,
+		burst:   float64(burst),
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// refill brings b up to date; callers must hold l.mu.
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) {
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+}
+
+// bucket returns the bucket for key, creating a full one on first use; callers must hold l.mu.
+func (l *rateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+	return b
+}
+
+// Allow consumes a token for key. When none is left it reports how long until one is available.
+func (l *rateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b := l.bucket(key, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.rate <= 0 {
+		return false, time.Hour
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// Remaining reports how many whole tokens key currently has.
+func (l *rateLimiter) Remaining(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.bucket(key, l.now()).tokens)
+}
+
+// sweep forgets buckets that have refilled completely so idle keys do not pile up.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// bucketState is the admin view of one bucket.
+type bucketState struct {
+	Key    string  `json:"key"`
+	Tokens float64 `json:"tokens"`
+}
+
+// Snapshot lists every tracked bucket, sorted by key.
+func (l *rateLimiter) Snapshot() []bucketState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	out := make([]bucketState, 0, len(l.buckets))
+	for k, b := range l.buckets {
+		l.refill(b, now)
+		out = append(out, bucketState{Key: k, Tokens: math.Round(b.tokens*100) / 100})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// lockoutState tracks consecutive failed sign-ins for one identity.
+type lockoutState struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"lockedUntil"`
+	lastFailure time.Time
+}
+
+// lockoutTracker locks identities out for exponentially growing periods after repeated failures.
+type lockoutTracker struct {
+	mu        sync.Mutex
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	states    map[string]*lockoutState
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newLockoutTracker(threshold int, base, max time.Duration) *lockoutTracker {
+	return &lockoutTracker{threshold: threshold, base: base, max: max, states: map[string]*lockoutState{}, now: time.Now}
+}
+
+// Locked reports whether key is currently locked out and for how much longer.
+func (t *lockoutTracker) Locked(key string) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweep(t.now())
+	s, ok := t.states[key]
+	if !ok {
+		return false, 0
+	}
+	if left := s.LockedUntil.Sub(t.now()); left > 0 {
+		return true, left
+	}
+	return false, 0
+}
+
+// Failure records a rejected attempt and starts or extends the lockout once the threshold is hit.
+func (t *lockoutTracker) Failure(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.sweep(now)
+	s, ok := t.states[key]
+	if !ok {
+		s = &lockoutState{Key: key}
+		t.states[key] = s
+	}
+	s.Failures++
+	s.lastFailure = now
+	if t.threshold <= 0 || s.Failures < t.threshold {
+		return
+	}
+	d := t.base << (s.Failures - t.threshold)
+	if d > t.max || d <= 0 {
+		d = t.max
+	}
+	s.LockedUntil = now.Add(d)
+}
+
+// sweep forgets identities whose lockout is over and that have not failed for the longest
+// lockout period, so guessed usernames do not pile up; callers must hold t.mu.
+func (t *lockoutTracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+	for k, s := range t.states {
+		if now.After(s.LockedUntil) && now.Sub(s.lastFailure) >= t.max {
+			delete(t.states, k)
+		}
+	}
+}
+
+// Success clears the failure history of key.
+func (t *lockoutTracker) Success(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, key)
+}
+
+// Snapshot lists every identity with recorded failures, sorted by key.
+func (t *lockoutTracker) Snapshot() []lockoutState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]lockoutState, 0, len(t.states))
+	for _, s := range t.states {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// signinLimiter combines per-IP and per-identity buckets with the identity lockout.
+type signinLimiter struct {
+	byIP       *rateLimiter
+	byIdentity *rateLimiter
+	lockout    *lockoutTracker
+}
+
+// newSigninLimiter builds a limiter from the SIGNIN_* settings.
+func newSigninLimiter() *signinLimiter {
+	return &signinLimiter{
+		byIP:       newRateLimiter(signinIPRate, signinIPBurst),
+		byIdentity: newRateLimiter(signinIdentityRate, signinIdentityBurst),
+		lockout:    newLockoutTracker(signinLockoutThreshold, signinLockoutBase, signinLockoutMax),
+	}
+}
+
+// identityKey normalises identities so "Alice" and "alice " share a bucket.
+func identityKey(identity string) string {
+	return strings.ToLower(strings.TrimSpace(identity))
+}
+
+// allowIP consumes a token from the caller's IP bucket.
+func (s *signinLimiter) allowIP(ip string) (bool, time.Duration) {
+	return s.byIP.Allow(ip)
+}
+
+// allowIdentity checks the lockout and consumes a token from the identity bucket.
+func (s *signinLimiter) allowIdentity(identity string) (bool, time.Duration) {
+	key := identityKey(identity)
+	if locked, left := s.lockout.Locked(key); locked {
+		return false, left
+	}
+	return s.byIdentity.Allow(key)
+}
+
+// tooManyRequests writes a 429 with a Retry-After header rounded up to whole seconds.
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	secs := int(math.Ceil(retryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
+
+// clientIP returns the caller address, preferring X-Forwarded-For when TRUST_PROXY_HEADERS is set.
+func clientIP(r *http.Request) string {
+	if trustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requireAdmin checks the admin bearer token; the endpoints 404 while ADMIN_TOKEN is unset.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if adminToken == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	tok, ok := bearerToken(r)
+	if !ok || subtle.ConstantTimeCompare([]byte(tok), []byte(adminToken)) != 1 {
+		unauthorized(w, "admin token required")
+		return false
+	}
+	return true
+}
+
+// adminLimitsHandler exposes the current sign-in limiter and GraphQL quota state.
+func adminLimitsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r) {
+			return
+		}
+		withJSON(w)
+		okJSON(w, limitsResponse{
+			IP:       signinGuard.byIP.Snapshot(),
+			Identity: signinGuard.byIdentity.Snapshot(),
+			Lockouts: signinGuard.lockout.Snapshot(),
+			GraphQL:  graphqlQuotas.Snapshot(),
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced time source for limiter tests.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func useSigninGuard(t *testing.T, g *signinLimiter) {
+	old := signinGuard
+	signinGuard = g
+	t.Cleanup(func() { signinGuard = old })
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	l := newRateLimiter(60, 2) // one token per second
+	l.now = clock.now
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("k"); !ok {
+			t.Fatalf("request %d should pass", i)
+		}
+	}
+	ok, wait := l.Allow("k")
+	if ok || wait != time.Second {
+		t.Fatalf("expected throttle with 1s wait, got %t %s", ok, wait)
+	}
+	if ok, _ := l.Allow("other"); !ok {
+		t.Fatal("keys must not share buckets")
+	}
+	clock.advance(time.Second)
+	if ok, _ := l.Allow("k"); !ok {
+		t.Fatal("expected token after refill")
+	}
+	if got := l.Remaining("k"); got != 0 {
+		t.Fatalf("unexpected remaining: %d", got)
+	}
+}
+
+func TestRateLimiterSweepsIdleBuckets(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	l := newRateLimiter(60, 1)
+	l.now = clock.now
+	l.Allow("a")
+	clock.advance(2 * time.Minute)
+	l.Allow("b")
+	if snap := l.Snapshot(); len(snap) != 1 || snap[0].Key != "b" {
+		t.Fatalf("expected only the active bucket, got %+v", snap)
+	}
+}
+
+func TestLockoutBacksOffExponentially(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	lt := newLockoutTracker(2, 10*time.Second, 35*time.Second)
+	lt.now = clock.now
+
+	lt.Failure("alice")
+	if locked, _ := lt.Locked("alice"); locked {
+		t.Fatal("should not lock before threshold")
+	}
+	for _, want := range []time.Duration{10 * time.Second, 20 * time.Second, 35 * time.Second} {
+		lt.Failure("alice")
+		locked, left := lt.Locked("alice")
+		if !locked || left != want {
+			t.Fatalf("expected lockout of %s, got %t %s", want, locked, left)
+		}
+	}
+	lt.Success("alice")
+	if locked, _ := lt.Locked("alice"); locked {
+		t.Fatal("success should clear the lockout")
+	}
+}
+
+func TestLockoutForgetsIdleIdentities(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	lt := newLockoutTracker(1, 10*time.Second, time.Minute)
+	lt.now = clock.now
+	lt.Failure("alice")
+	lt.Failure("bob")
+	clock.advance(30 * time.Second)
+	lt.Failure("bob")
+	clock.advance(40 * time.Second)
+	lt.Failure("carol")
+	if snap := lt.Snapshot(); len(snap) != 2 || snap[0].Key != "bob" || snap[1].Key != "carol" {
+		t.Fatalf("expected alice to be forgotten, got %+v", snap)
+	}
+}
+
+func TestAuthHandlerThrottlesByIP(t *testing.T) {
+	g := newSigninLimiter()
+	g.byIP = newRateLimiter(1, 1)
+	useSigninGuard(t, g)
+
+	for i, want := range []int{http.StatusBadRequest, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodPost, "/auth/signin", bytes.NewBufferString("{}"))
+		rr := httptest.NewRecorder()
+		authHandler().ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("request %d: expected %d, got %d", i, want, rr.Code)
+		}
+		if want == http.StatusTooManyRequests && rr.Result().Header.Get("Retry-After") != "60" {
+			t.Fatalf("unexpected Retry-After: %q", rr.Result().Header.Get("Retry-After"))
+		}
+	}
+}
+
+func TestAuthHandlerLocksOutAfterUpstreamRejections(t *testing.T) {
+	g := newSigninLimiter()
+	g.lockout = newLockoutTracker(2, time.Minute, time.Hour)
+	useSigninGuard(t, g)
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "nope", http.StatusUnauthorized)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, "/signin", graphqlPath)
+
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		body := `{"identity":"Alice","password":"wrong"}`
+		req := httptest.NewRequest(http.MethodPost, "/auth/signin", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		authHandler().ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("attempt %d: expected %d, got %d", i, want, rr.Code)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("locked-out attempt must not reach upstream, got %d calls", calls)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "198.51.100.7:5555"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+	if got := clientIP(req); got != "198.51.100.7" {
+		t.Fatalf("expected RemoteAddr host, got %q", got)
+	}
+	old := trustProxyHeaders
+	trustProxyHeaders = true
+	t.Cleanup(func() { trustProxyHeaders = old })
+	if got := clientIP(req); got != "203.0.113.9" {
+		t.Fatalf("expected forwarded address, got %q", got)
+	}
+}
+
+func TestAdminLimitsHandler(t *testing.T) {
+	g := newSigninLimiter()
+	g.byIP.Allow("192.0.2.1")
+	g.lockout.Failure("alice")
+	useSigninGuard(t, g)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/limits", nil)
+	rr := httptest.NewRecorder()
+	adminLimitsHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 while disabled, got %d", rr.Code)
+	}
+
+	old := adminToken
+	adminToken = "root"
+	t.Cleanup(func() { adminToken = old })
+
+	rr = httptest.NewRecorder()
+	adminLimitsHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rr.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer root")
+	rr = httptest.NewRecorder()
+	adminLimitsHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var resp limitsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response not JSON: %v", err)
+	}
+	if len(resp.IP) != 1 || resp.IP[0].Key != "192.0.2.1" || len(resp.Lockouts) != 1 || resp.Lockouts[0].Failures != 1 {
+		t.Fatalf("unexpected snapshot: %+v", resp)
+	}
+}
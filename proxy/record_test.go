@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var record = flag.Bool("record", false, "re-record testdata/transactions.json from ZONE01_BASE with the JWT in ZONE01_TOKEN")
+
+// fixtureUserID is the user every recorded fixture belongs to.
+const fixtureUserID = 42
+
+// anonymizeTransactions strips a recorded response of what identifies its student: the
+// user id becomes fixtureUserID and transaction and object ids are renumbered in order of
+// appearance. Types, amounts, dates and paths are curriculum data and are kept, so the
+// aggregations see the real shapes.
+func anonymizeTransactions(txs []transaction) []map[string]any {
+	objectIDs := map[int]int{}
+	rows := make([]map[string]any, 0, len(txs))
+	for i, tx := range txs {
+		var objectID any
+		if tx.ObjectID != nil {
+			id, ok := objectIDs[*tx.ObjectID]
+			if !ok {
+				id = 100001 + len(objectIDs)
+				objectIDs[*tx.ObjectID] = id
+			}
+			objectID = id
+		}
+		rows = append(rows, map[string]any{
+			"id":        9001 + i,
+			"type":      tx.Type,
+			"amount":    tx.Amount,
+			"objectId":  objectID,
+			"userId":    fixtureUserID,
+			"createdAt": tx.CreatedAt.Format(time.RFC3339Nano),
+			"path":      tx.Path,
+		})
+	}
+	return rows
+}
+
+func TestAnonymizeTransactions(t *testing.T) {
+	obj := func(id int) *int { return &id }
+	created := time.Date(2024, 1, 15, 10, 21, 44, 0, time.UTC)
+	rows := anonymizeTransactions([]transaction{
+		{ID: 551234, Type: "xp", Amount: 4600, ObjectID: obj(3321), UserID: 1877, CreatedAt: created, Path: "/athens/div-01/go-reloaded"},
+		{ID: 551240, Type: "up", Amount: 7500, ObjectID: obj(4410), UserID: 1877, CreatedAt: created, Path: "/athens/div-01/ascii-art"},
+		{ID: 551299, Type: "level", Amount: 2, ObjectID: obj(3321), UserID: 1877, CreatedAt: created, Path: "/athens/div-01/go-reloaded"},
+		{ID: 551300, Type: "skill_go", Amount: 5, UserID: 1877, CreatedAt: created, Path: "/athens/div-01"},
+	})
+	b, _ := json.Marshal(rows)
+	for _, leaked := range []string{"551234", "1877", "3321", "4410"} {
+		if containsNumber(rows, leaked) {
+			t.Fatalf("recorded id %s survived anonymization: %s", leaked, b)
+		}
+	}
+	if rows[0]["objectId"] != rows[2]["objectId"] || rows[0]["objectId"] == rows[1]["objectId"] || rows[3]["objectId"] != nil {
+		t.Fatalf("objects should be renumbered consistently, got %s", b)
+	}
+	if rows[1]["path"] != "/athens/div-01/ascii-art" || rows[1]["amount"] != 7500.0 || rows[1]["userId"] != fixtureUserID {
+		t.Fatalf("curriculum data should be kept, got %s", b)
+	}
+}
+
+// containsNumber reports whether any value of rows prints as n.
+func containsNumber(rows []map[string]any, n string) bool {
+	for _, row := range rows {
+		for _, v := range row {
+			if b, _ := json.Marshal(v); string(b) == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TestRecordTransactionsFixture replaces testdata/transactions.json with the anonymized
+// transactions of a real account. It only runs with
+//
+//	ZONE01_TOKEN=<jwt> go test -run TestRecordTransactionsFixture -record
+//
+// and the tests loading the fixture then need their expectations reviewed.
+func TestRecordTransactionsFixture(t *testing.T) {
+	if !*record {
+		t.Skip("run with -record and ZONE01_TOKEN to re-record the fixture")
+	}
+	token := os.Getenv("ZONE01_TOKEN")
+	if token == "" {
+		t.Fatal("ZONE01_TOKEN must hold the JWT of the account to record")
+	}
+	old := zone01Base
+	zone01Base = getenv("ZONE01_BASE", "https://platform.zone01.gr")
+	t.Cleanup(func() { zone01Base = old })
+
+	txs, err := fetchTransactions(context.Background(), token, map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.MarshalIndent(map[string]any{"data": map[string]any{"transaction": anonymizeTransactions(txs)}}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("testdata", "transactions.json"), append(b, '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("recorded %d transactions", len(txs))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Refresh modes select where /auth/refresh obtains a replacement token.
+const (
+	refreshModeNone        = ""            // only re-validate, never mint a new token
+	refreshModeUpstream    = "upstream"    // POST the current token to REFRESH_PATH
+	refreshModeCredentials = "credentials" // replay the sign-in credentials the proxy kept in memory
+)
+
+// Refresh behaviour is configurable per deployment like the other upstream endpoints.
+var refreshMode = getenv("REFRESH_MODE", refreshModeNone)
+var refreshPath = getenv("REFRESH_PATH", "/api/auth/refresh")
+var refreshWindow = getenvDuration("REFRESH_WINDOW", 10*time.Minute)
+
+// sessionCheckQuery is the cheapest query the upstream accepts; it fails for forged or revoked tokens.
+const sessionCheckQuery = `{"query":"query { user { id } }"}`
+
+// credentialStore keeps the Basic credential of each signed-in user so tokens can be
+// re-issued. A credential lives as long as the last token it produced and is dropped as
+// soon as replaying it fails.
+type credentialStore struct {
+	mu    sync.Mutex
+	creds map[string]heldCredential
+	now   func() time.Time
+}
+
+// heldCredential is a Basic Authorization header value and when it stops being kept.
+type heldCredential struct {
+	authorization string
+	expires       time.Time
+}
+
+// heldCredentials is only populated when REFRESH_MODE=credentials.
+var heldCredentials = &credentialStore{creds: map[string]heldCredential{}, now: time.Now}
+
+// remember stores the Authorization header value used to sign userID in until expires,
+// the expiry of the token it produced. Expired credentials of other users are swept.
+func (s *credentialStore) remember(userID, authorization string, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for id, c := range s.creds {
+		if !now.Before(c.expires) {
+			delete(s.creds, id)
+		}
+	}
+	s.creds[userID] = heldCredential{authorization: authorization, expires: expires}
+}
+
+// forget drops the stored credential for userID.
+func (s *credentialStore) forget(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.creds, userID)
+}
+
+// lookup returns the stored Authorization header for userID while it has not expired.
+func (s *credentialStore) lookup(userID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.creds[userID]
+	if ok && !s.now().Before(c.expires) {
+		delete(s.creds, userID)
+		return "", false
+	}
+	return c.authorization, ok
+}
+
+// refreshToken obtains a replacement for tok according to refreshMode.
+func refreshToken(tok *jwtToken) (*jwtToken, error) {
+	switch refreshMode {
+	case refreshModeUpstream:
+		return requestUpstreamToken(zone01Base+refreshPath, "Bearer "+tok.Raw)
+	case refreshModeCredentials:
+		userID := tok.Claims.UserID()
+		basic, ok := heldCredentials.lookup(userID)
+		if !ok {
+			return nil, fmt.Errorf("no held credential for user %q", userID)
+		}
+		fresh, err := requestUpstreamToken(zone01Base+signinPath, basic)
+		if err != nil {
+			heldCredentials.forget(userID) // a password that no longer works is not kept around
+			return nil, err
+		}
+		heldCredentials.remember(userID, basic, fresh.Claims.Expiry())
+		return fresh, nil
+	default:
+		return nil, fmt.Errorf("refresh disabled (REFRESH_MODE=%q)", refreshMode)
+	}
+}
+
+// validateUpstreamSession asks the GraphQL engine whether it still accepts the token.
+// It returns (false, nil) when the upstream rejects the token and an error when it is unreachable.
+func validateUpstreamSession(token string) (bool, error) {
+	zReq, err := http.NewRequest(http.MethodPost, zone01Base+graphqlPath, bytes.NewReader([]byte(sessionCheckQuery)))
+	if err != nil {
+		return false, err
+	}
+	zReq.Header.Set("Content-Type", "application/json")
+	zReq.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	zResp, err := client.Do(zReq)
+	if err != nil {
+		return false, err
+	}
+	defer zResp.Body.Close()
+
+	if zResp.StatusCode == http.StatusUnauthorized || zResp.StatusCode == http.StatusForbidden {
+		return false, nil
+	}
+	if zResp.StatusCode < 200 || zResp.StatusCode >= 300 {
+		return false, fmt.Errorf("upstream status %d", zResp.StatusCode)
+	}
+	body, _ := io.ReadAll(zResp.Body)
+	var out struct {
+		Errors []json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return false, fmt.Errorf("decode upstream response: %w", err)
+	}
+	// Hasura answers 200 with an invalid-jwt error for tokens it does not trust.
+	return len(out.Errors) == 0, nil
+}
+
+// unauthorized writes a 401 with a Bearer challenge describing why the token was refused.
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, msg, http.StatusUnauthorized)
+}
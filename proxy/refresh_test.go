@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func overrideRefresh(t *testing.T, mode, path string, window time.Duration) {
+	oldMode, oldPath, oldWindow := refreshMode, refreshPath, refreshWindow
+	refreshMode, refreshPath, refreshWindow = mode, path, window
+	t.Cleanup(func() {
+		refreshMode, refreshPath, refreshWindow = oldMode, oldPath, oldWindow
+	})
+}
+
+// refreshUpstream serves a GraphQL session check plus sign-in and refresh endpoints returning fresh.
+func refreshUpstream(t *testing.T, graphqlBody, fresh string) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/graphql":
+			io.WriteString(w, graphqlBody)
+		case "/refresh", "/signin":
+			io.WriteString(w, `{"token":"`+fresh+`"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, "/signin", "/graphql")
+	return upstream
+}
+
+func postRefresh(t *testing.T, token string) (*httptest.ResponseRecorder, refreshResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	refreshHandler().ServeHTTP(rr, req)
+	var resp refreshResponse
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("response not JSON: %v", err)
+		}
+	}
+	return rr, resp
+}
+
+func TestRefreshRejectsExpiredToken(t *testing.T) {
+	refreshUpstream(t, `{"data":{"user":[]}}`, "")
+	rr, _ := postRefresh(t, makeJWT(t, zoneClaims("1", time.Now().Add(-time.Minute))))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+}
+
+func TestRefreshRejectsMalformedToken(t *testing.T) {
+	rr, _ := postRefresh(t, "garbage")
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+}
+
+func TestRefreshRejectsTokenUpstreamDistrusts(t *testing.T) {
+	refreshUpstream(t, `{"errors":[{"message":"Could not verify JWT","extensions":{"code":"invalid-jwt"}}]}`, "")
+	rr, _ := postRefresh(t, makeJWT(t, zoneClaims("1", time.Now().Add(time.Hour))))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+}
+
+func TestRefreshUpstreamUnreachable(t *testing.T) {
+	overridePaths(t, "http://127.0.0.1:0", signinPath, "/graphql")
+	rr, _ := postRefresh(t, makeJWT(t, zoneClaims("1", time.Now().Add(time.Hour))))
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected status 502, got %d", rr.Code)
+	}
+}
+
+func TestRefreshUpstreamModeRenewsNearExpiry(t *testing.T) {
+	freshExp := time.Now().Add(24 * time.Hour)
+	fresh := makeJWT(t, zoneClaims("1", freshExp))
+	refreshUpstream(t, `{"data":{"user":[{"id":1}]}}`, fresh)
+	overrideRefresh(t, refreshModeUpstream, "/refresh", 10*time.Minute)
+
+	rr, resp := postRefresh(t, makeJWT(t, zoneClaims("1", time.Now().Add(time.Minute))))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if !resp.Refreshed || resp.Token != fresh || resp.Exp != freshExp.Unix() {
+		t.Fatalf("expected refreshed token, got %+v", resp)
+	}
+}
+
+func TestRefreshSkipsRenewalOutsideWindow(t *testing.T) {
+	refreshUpstream(t, `{"data":{"user":[{"id":1}]}}`, makeJWT(t, zoneClaims("1", time.Now().Add(48*time.Hour))))
+	overrideRefresh(t, refreshModeUpstream, "/refresh", 10*time.Minute)
+
+	_, resp := postRefresh(t, makeJWT(t, zoneClaims("1", time.Now().Add(time.Hour))))
+	if resp.Refreshed || resp.Token != "" {
+		t.Fatalf("did not expect a refresh, got %+v", resp)
+	}
+}
+
+func TestRefreshCredentialsMode(t *testing.T) {
+	fresh := makeJWT(t, zoneClaims("7", time.Now().Add(24*time.Hour)))
+	refreshUpstream(t, `{"data":{"user":[{"id":7}]}}`, fresh)
+	overrideRefresh(t, refreshModeCredentials, refreshPath, 10*time.Minute)
+
+	expiring := makeJWT(t, zoneClaims("7", time.Now().Add(time.Minute)))
+	_, resp := postRefresh(t, expiring)
+	if resp.Refreshed {
+		t.Fatalf("expected no refresh without held credential, got %+v", resp)
+	}
+
+	heldCredentials.remember("7", "Basic abc", time.Now().Add(time.Minute))
+	t.Cleanup(func() { heldCredentials.forget("7") })
+	_, resp = postRefresh(t, expiring)
+	if !resp.Refreshed || resp.Token != fresh {
+		t.Fatalf("expected credential refresh, got %+v", resp)
+	}
+	if c := heldCredentials.creds["7"]; c.expires.Before(time.Now().Add(time.Hour)) {
+		t.Fatalf("the credential should live as long as the fresh token, expires %s", c.expires)
+	}
+}
+
+func TestRefreshCredentialsDroppedWhenSignInFails(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/signin" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{"data":{"user":[{"id":7}]}}`)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, "/signin", "/graphql")
+	overrideRefresh(t, refreshModeCredentials, refreshPath, 10*time.Minute)
+
+	heldCredentials.remember("7", "Basic stale", time.Now().Add(time.Minute))
+	t.Cleanup(func() { heldCredentials.forget("7") })
+	if _, resp := postRefresh(t, makeJWT(t, zoneClaims("7", time.Now().Add(time.Minute)))); resp.Refreshed {
+		t.Fatalf("did not expect a refresh, got %+v", resp)
+	}
+	if _, ok := heldCredentials.lookup("7"); ok {
+		t.Fatal("a credential the upstream refused should be dropped")
+	}
+}
+
+func TestHeldCredentialsExpire(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	s := &credentialStore{creds: map[string]heldCredential{}, now: clock.now}
+	s.remember("1", "Basic a", clock.t.Add(time.Minute))
+	s.remember("2", "Basic b", clock.t.Add(time.Hour))
+	if got, ok := s.lookup("1"); !ok || got != "Basic a" {
+		t.Fatalf("unexpected credential %q %v", got, ok)
+	}
+	clock.advance(2 * time.Minute)
+	if _, ok := s.lookup("1"); ok {
+		t.Fatal("the credential should expire with its token")
+	}
+	s.remember("3", "Basic c", clock.t.Add(time.Minute))
+	clock.advance(2 * time.Minute)
+	s.remember("2", "Basic b", clock.t.Add(time.Hour))
+	if _, ok := s.creds["3"]; ok {
+		t.Fatal("expired credentials should be swept")
+	}
+}
@@ -15,7 +15,39 @@ func RegisterRoutes(r *mux.Router) {
 	// CORS preflight (OPTIONS) is handled by handlers via withCORS + OPTIONS
 	r.HandleFunc("/auth/signin", authHandler()).Methods(http.MethodPost, http.MethodOptions)
 	r.HandleFunc("/auth/refresh", refreshHandler()).Methods(http.MethodPost, http.MethodOptions)
-	r.HandleFunc("/graphql", graphqlHandler()).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/auth/logout", logoutHandler()).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/graphql", graphqlWSHandler()).Methods(http.MethodGet).HeadersRegexp("Upgrade", "(?i)^websocket$")
+	r.HandleFunc("/graphql", graphqlHandler()).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/events", eventsHandler()).Methods(http.MethodGet, http.MethodOptions)
+
+	// Read-only views of the caller's data assembled from upstream queries
+	r.HandleFunc("/api/me/xp/transactions", xpTransactionsHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/xp/summary", xpSummaryHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/xp/timeline", xpTimelineHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/xp/by-project", xpByProjectHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/progress/stats", progressStatsHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/audits", auditsHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/level", levelHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/skills", skillsHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/export/transactions", exportHandler(transactionsExport)).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/export/progress", exportHandler(progressExport)).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/charts/xp-over-time.svg", xpOverTimeChartHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/charts/xp-by-project.svg", xpByProjectChartHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/charts/pass-fail.svg", passFailChartHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/shares", sharesHandler()).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/api/me/shares/{id}", shareHandler()).Methods(http.MethodDelete, http.MethodOptions)
+	r.HandleFunc("/api/me/snapshots", snapshotsHandler()).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/api/me/snapshots/diff", snapshotDiffHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/snapshots/{id}", snapshotByIDHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/api/me/webhooks", webhooksHandler()).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/api/me/webhooks/{id}", webhookHandler()).Methods(http.MethodDelete, http.MethodOptions)
+
+	// Public views of share links; disabled unless SHARE_SIGNING_KEY is set
+	r.HandleFunc("/share/{token}.json", sharedJSONHandler()).Methods(http.MethodGet, http.MethodOptions)
+	r.HandleFunc("/share/{token}/badge.svg", sharedBadgeHandler()).Methods(http.MethodGet, http.MethodOptions)
+
+	// Operator endpoints; disabled unless ADMIN_TOKEN is set
+	r.HandleFunc("/admin/limits", adminLimitsHandler()).Methods(http.MethodGet)
 
 	// Optional health endpoint
 	r.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
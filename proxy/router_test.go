@@ -19,7 +19,32 @@ func TestRegisterRoutes(t *testing.T) {
 	}{
 		{http.MethodOptions, "/auth/signin", http.StatusNoContent},
 		{http.MethodOptions, "/auth/refresh", http.StatusNoContent},
+		{http.MethodOptions, "/auth/logout", http.StatusNoContent},
 		{http.MethodOptions, "/graphql", http.StatusNoContent},
+		{http.MethodGet, "/graphql", http.StatusUnauthorized},
+		{http.MethodOptions, "/api/me/xp/transactions", http.StatusNoContent},
+		{http.MethodGet, "/api/me/xp/transactions", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/xp/summary", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/xp/timeline", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/xp/by-project", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/progress/stats", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/audits", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/level", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/skills", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/export/transactions", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/export/progress", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/charts/xp-over-time.svg", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/charts/xp-by-project.svg", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/charts/pass-fail.svg", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/shares", http.StatusUnauthorized},
+		{http.MethodDelete, "/api/me/shares/abc", http.StatusUnauthorized},
+		{http.MethodPost, "/api/me/snapshots", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/snapshots/diff", http.StatusUnauthorized},
+		{http.MethodGet, "/api/me/snapshots/20240101T000000.000Z", http.StatusUnauthorized},
+		{http.MethodPost, "/api/me/webhooks", http.StatusUnauthorized},
+		{http.MethodGet, "/events", http.StatusUnauthorized},
+		{http.MethodDelete, "/api/me/webhooks/abc", http.StatusUnauthorized},
+		{http.MethodGet, "/share/abc.def.json", http.StatusNotFound},
 		{http.MethodGet, "/healthz", http.StatusOK},
 		{http.MethodGet, "/missing", http.StatusNotFound},
 	}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Session mode keeps upstream JWTs on the server and hands browsers an opaque HttpOnly cookie instead.
+var sessionMode = getenv("SESSION_MODE", "") // "cookie" enables server-side sessions
+var sessionStoreKind = getenv("SESSION_STORE", "memory")
+var sessionFile = getenv("SESSION_FILE", "sessions.json")
+var sessionCookieName = getenv("SESSION_COOKIE", "z01_session")
+var sessionSameSite = getenv("SESSION_SAMESITE", "lax")
+var sessionInsecure = getenv("SESSION_INSECURE", "") == "true" // allow plain-HTTP cookies for local dev
+
+// sessions is the store backing session mode.
+var sessions = newSessionStore(sessionStoreKind, sessionFile)
+
+// session is what the proxy remembers about a signed-in browser.
+type session struct {
+	Token     string    `json:"token"`
+	UserID    string    `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// expired reports whether the upstream token behind the session is no longer usable.
+func (s session) expired() bool {
+	return !time.Now().Before(s.ExpiresAt)
+}
+
+// sessionStore persists sessions under opaque IDs.
+type sessionStore interface {
+	Get(id string) (session, bool, error)
+	Put(id string, s session) error
+	Delete(id string) error
+}
+
+// newSessionStore builds the store selected by SESSION_STORE; a session file that cannot be
+// loaded stops the proxy.
+func newSessionStore(kind, path string) sessionStore {
+	if kind == "file" {
+		store, err := newFileSessionStore(path)
+		if err != nil {
+			log.Fatalf("session file store: %v", err)
+		}
+		return store
+	}
+	return newMemorySessionStore()
+}
+
+// sessionsEnabled reports whether /auth/signin should issue cookies instead of bearer tokens.
+func sessionsEnabled() bool {
+	return sessionMode == "cookie"
+}
+
+// jsonSessionStore keeps sessions in a jsonStore. Expired sessions are dropped when read and
+// swept at most once a minute, so sessions whose cookie is never sent again do not pile up.
+type jsonSessionStore struct {
+	*jsonStore[map[string]session]
+	lastSweep time.Time // guarded by mu
+}
+
+// newMemorySessionStore keeps sessions in process memory; they vanish on restart.
+func newMemorySessionStore() *jsonSessionStore {
+	store, _ := newJSONStore("", map[string]session{})
+	return &jsonSessionStore{jsonStore: store}
+}
+
+// newFileSessionStore mirrors sessions to the JSON file at path so they survive restarts;
+// a missing file starts an empty store.
+func newFileSessionStore(path string) (*jsonSessionStore, error) {
+	store, err := newJSONStore(path, map[string]session{})
+	if err != nil {
+		return nil, err
+	}
+	return &jsonSessionStore{jsonStore: store}, nil
+}
+
+func (j *jsonSessionStore) Get(id string) (session, bool, error) {
+	var s session
+	var ok bool
+	err := j.update(func(data map[string]session) bool {
+		changed := j.sweep(data)
+		if s, ok = data[id]; ok && s.expired() {
+			delete(data, id)
+			s, ok, changed = session{}, false, true
+		}
+		return changed
+	})
+	if err != nil {
+		return session{}, false, err
+	}
+	return s, ok, nil
+}
+
+func (j *jsonSessionStore) Put(id string, s session) error {
+	return j.update(func(data map[string]session) bool {
+		j.sweep(data) // written out with the new session
+		data[id] = s
+		return true
+	})
+}
+
+func (j *jsonSessionStore) Delete(id string) error {
+	return j.update(func(data map[string]session) bool {
+		delete(data, id)
+		return true
+	})
+}
+
+// sweep drops expired sessions from data and reports whether any were; callers must hold
+// j.mu.
+func (j *jsonSessionStore) sweep(data map[string]session) bool {
+	now := time.Now()
+	if now.Sub(j.lastSweep) < time.Minute {
+		return false
+	}
+	j.lastSweep = now
+	swept := false
+	for id, s := range data {
+		if s.expired() {
+			delete(data, id)
+			swept = true
+		}
+	}
+	return swept
+}
+
+// newSessionID returns 256 bits of randomness encoded for use in a cookie.
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// sessionCookie builds the cookie carrying id; a zero expires clears it.
+func sessionCookie(id string, expires time.Time) *http.Cookie {
+	c := &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   !sessionInsecure,
+		SameSite: parseSameSite(sessionSameSite),
+	}
+	if expires.IsZero() {
+		c.MaxAge = -1
+	} else {
+		c.Expires = expires
+	}
+	return c
+}
+
+// parseSameSite maps SESSION_SAMESITE onto http.SameSite, defaulting to Lax.
+func parseSameSite(v string) http.SameSite {
+	switch strings.ToLower(v) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// startSession stores token under a fresh session ID and sets the cookie on w.
+func startSession(w http.ResponseWriter, tok *jwtToken) error {
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	s := session{Token: tok.Raw, UserID: tok.Claims.UserID(), ExpiresAt: tok.Claims.Expiry()}
+	if err := sessions.Put(id, s); err != nil {
+		return err
+	}
+	http.SetCookie(w, sessionCookie(id, s.ExpiresAt))
+	return nil
+}
+
+// sessionFromRequest resolves the session cookie on r, if session mode is on.
+func sessionFromRequest(r *http.Request) (string, session, bool) {
+	if !sessionsEnabled() {
+		return "", session{}, false
+	}
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil || c.Value == "" {
+		return "", session{}, false
+	}
+	s, ok, err := sessions.Get(c.Value)
+	if err != nil {
+		log.Printf("session lookup error: %v", err)
+		return "", session{}, false
+	}
+	return c.Value, s, ok
+}
+
+// requestToken returns the upstream JWT for r: an explicit bearer token wins, otherwise the
+// token held in the caller's session is used.
+func requestToken(r *http.Request) (string, bool) {
+	if tok, ok := bearerToken(r); ok {
+		return tok, true
+	}
+	if _, s, ok := sessionFromRequest(r); ok {
+		return s.Token, true
+	}
+	return "", false
+}
+
+// logoutHandler destroys the caller's session and clears the cookie.
+func logoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		withCORS(w, r)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if id, s, ok := sessionFromRequest(r); ok {
+			if err := sessions.Delete(id); err != nil {
+				log.Printf("session delete error: %v", err)
+				http.Error(w, "cannot end session", http.StatusInternalServerError)
+				return
+			}
+			heldCredentials.forget(s.UserID)
+		}
+		http.SetCookie(w, sessionCookie("", time.Time{}))
+		withJSON(w)
+		okJSON(w, map[string]string{"status": "ok"})
+	}
+}
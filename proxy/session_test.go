@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// enableSessions switches the proxy into cookie mode backed by a fresh memory store.
+func enableSessions(t *testing.T) *jsonSessionStore {
+	oldMode, oldStore := sessionMode, sessions
+	store := newMemorySessionStore()
+	sessionMode, sessions = "cookie", store
+	t.Cleanup(func() { sessionMode, sessions = oldMode, oldStore })
+	return store
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	store := newMemorySessionStore()
+	live := session{Token: "t", UserID: "1", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Put("a", live); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if got, ok, _ := store.Get("a"); !ok || got.Token != "t" {
+		t.Fatalf("expected stored session, got %+v %t", got, ok)
+	}
+	store.Put("old", session{Token: "x", ExpiresAt: time.Now().Add(-time.Second)})
+	if _, ok, _ := store.Get("old"); ok {
+		t.Fatal("expected expired session to be dropped")
+	}
+	store.Delete("a")
+	if _, ok, _ := store.Get("a"); ok {
+		t.Fatal("expected deleted session to be gone")
+	}
+}
+
+func TestFileSessionStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	store, err := newFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := store.Put("a", session{Token: "t", UserID: "1", ExpiresAt: exp}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	store.Put("b", session{Token: "u", ExpiresAt: exp})
+	store.Delete("b")
+
+	reopened, err := newFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	got, ok, _ := reopened.Get("a")
+	if !ok || got.Token != "t" || !got.ExpiresAt.Equal(exp) {
+		t.Fatalf("session not persisted: %+v %t", got, ok)
+	}
+	if _, ok, _ := reopened.Get("b"); ok {
+		t.Fatal("deleted session came back")
+	}
+}
+
+func TestNewSessionStoreKinds(t *testing.T) {
+	if s, ok := newSessionStore("memory", "").(*jsonSessionStore); !ok || s.path != "" {
+		t.Fatal("expected memory store")
+	}
+	if s, ok := newSessionStore("file", filepath.Join(t.TempDir(), "s.json")).(*jsonSessionStore); !ok || s.path == "" {
+		t.Fatal("expected file store")
+	}
+}
+
+func TestSigninSessionModeSetsCookie(t *testing.T) {
+	store := enableSessions(t)
+	token := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, token)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, "/signin", graphqlPath)
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/signin", bytes.NewBufferString(`{"identity":"user","password":"pass"}`))
+	rr := httptest.NewRecorder()
+	authHandler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var resp loginResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Token != "" || resp.UserID != "42" {
+		t.Fatalf("expected claims without token, got %+v", resp)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected one cookie, got %d", len(cookies))
+	}
+	c := cookies[0]
+	if c.Name != sessionCookieName || !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("unexpected cookie attributes: %+v", c)
+	}
+	if s, ok, _ := store.Get(c.Value); !ok || s.Token != token {
+		t.Fatalf("session not stored: %+v", s)
+	}
+}
+
+func TestGraphqlUsesSessionToken(t *testing.T) {
+	store := enableSessions(t)
+	store.Put("sid", session{Token: "server-side", ExpiresAt: time.Now().Add(time.Hour)})
+	seen := ""
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get("Authorization")
+		io.WriteString(w, `{"data":{}}`)
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, signinPath, "/graphql")
+
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{ user { id } }"}`))
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "sid"})
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if seen != "Bearer server-side" {
+		t.Fatalf("unexpected upstream Authorization: %q", seen)
+	}
+}
+
+func TestGraphqlRejectsUnknownSession(t *testing.T) {
+	enableSessions(t)
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{ user { id } }"}`))
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "nope"})
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+}
+
+func TestLogoutDestroysSession(t *testing.T) {
+	store := enableSessions(t)
+	store.Put("sid", session{Token: "t", UserID: "9", ExpiresAt: time.Now().Add(time.Hour)})
+	heldCredentials.remember("9", "Basic x", time.Now().Add(time.Hour))
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "sid"})
+	rr := httptest.NewRecorder()
+	logoutHandler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if _, ok, _ := store.Get("sid"); ok {
+		t.Fatal("expected session to be deleted")
+	}
+	if _, ok := heldCredentials.lookup("9"); ok {
+		t.Fatal("expected held credential to be forgotten")
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Fatalf("expected cookie to be cleared, got %+v", cookies)
+	}
+}
+
+func TestLogoutMethodNotAllowed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/auth/logout", nil)
+	rr := httptest.NewRecorder()
+	logoutHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405, got %d", rr.Code)
+	}
+}
+
+func useCORSOrigins(t *testing.T, origins string) {
+	old := corsAllowedOrigins
+	corsAllowedOrigins = parseOriginList(origins)
+	t.Cleanup(func() { corsAllowedOrigins = old })
+}
+
+func TestCORSAllowsCredentialsInSessionMode(t *testing.T) {
+	enableSessions(t)
+	useCORSOrigins(t, "https://app.example/, http://localhost:5173")
+	for origin, want := range map[string]string{"https://app.example": "true", "http://localhost:5173": "true", "https://evil.example": ""} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		withCORS(rr, req)
+		h := rr.Result().Header
+		if got := h.Get("Access-Control-Allow-Credentials"); got != want {
+			t.Fatalf("%s: expected credentials %q, got %q", origin, want, got)
+		}
+		if got := h.Get("Access-Control-Allow-Origin"); (got == origin) != (want == "true") {
+			t.Fatalf("%s: unexpected allowed origin %q", origin, got)
+		}
+	}
+}
+
+func TestCORSNeverAllowsCredentialsWithoutAllowlist(t *testing.T) {
+	enableSessions(t)
+	useCORSOrigins(t, "")
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Origin", "https://app.example")
+	rr := httptest.NewRecorder()
+	withCORS(rr, req)
+	h := rr.Result().Header
+	if h.Get("Access-Control-Allow-Credentials") != "" || h.Get("Access-Control-Allow-Origin") != "https://app.example" {
+		t.Fatalf("unlisted origins may read anonymous responses only, got %v", h)
+	}
+}
+
+func TestMemorySessionStoreSweepsExpired(t *testing.T) {
+	store := newMemorySessionStore()
+	store.Put("old", session{Token: "t", ExpiresAt: time.Now().Add(-time.Minute)})
+	store.lastSweep = time.Time{}
+	store.Put("new", session{Token: "t", ExpiresAt: time.Now().Add(time.Hour)})
+	if _, ok := store.data["old"]; ok || len(store.data) != 1 {
+		t.Fatalf("expired sessions should be swept, got %v", store.data)
+	}
+}
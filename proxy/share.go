@@ -0,0 +1,631 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Share links publish a few metrics of one user, as JSON or as a shields.io-style badge,
+// without the caller holding a token. Each link is an HMAC-signed link id; the proxy keeps
+// the owner's upstream token next to the link (never in it) to refresh the cached values.
+// Deleting the link revokes it and rotating SHARE_SIGNING_KEY revokes every link.
+var shareSigningKey = getenv("SHARE_SIGNING_KEY", "") // share links are disabled while empty
+var shareStoreKind = getenv("SHARE_STORE", "memory")
+var shareFile = getenv("SHARE_FILE", "shares.json")
+var shareCacheTTL = getenvDuration("SHARE_CACHE_TTL", 15*time.Minute)
+var shareMaxLinks = getenvInt("SHARE_MAX_LINKS", 20) // per user
+
+// shares issues and serves the share links.
+var shares = newShareLinks(newShareStore(shareStoreKind, shareFile), shareSigningKey)
+
+// Metrics that can be shared.
+const (
+	shareXP         = "xp"
+	shareLevel      = "level"
+	shareAuditRatio = "audit-ratio"
+	sharePassRate   = "pass-rate"
+)
+
+// shareMetricLabels lists the shareable metrics with their badge labels.
+var shareMetricLabels = map[string]string{
+	shareXP:         "XP",
+	shareLevel:      "Level",
+	shareAuditRatio: "Audit ratio",
+	sharePassRate:   "Pass rate",
+}
+
+// shareLink is what the proxy remembers about an issued link.
+type shareLink struct {
+	ID        string     `json:"id"`
+	Owner     string     `json:"owner"` // verified id of the user who created the link
+	UserID    int        `json:"userId"`
+	Login     string     `json:"login"`
+	Metrics   []string   `json:"metrics"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt"` // nil for links that do not expire
+
+	// Token is the owner's latest upstream JWT; values are refreshed with it until it expires
+	// and served stale afterwards.
+	Token          string             `json:"token"`
+	TokenExpiresAt time.Time          `json:"tokenExpiresAt"`
+	Values         map[string]float64 `json:"values"` // a metric without a value (no audits yet) is absent
+	RefreshedAt    time.Time          `json:"refreshedAt"`
+}
+
+// shareStore persists share links by id.
+type shareStore interface {
+	Get(id string) (shareLink, bool, error)
+	Put(l shareLink) error
+	Delete(id string) error
+	List(owner string) ([]shareLink, error) // oldest first
+}
+
+// newShareStore builds the store selected by SHARE_STORE; a share file that cannot be loaded
+// stops the proxy.
+func newShareStore(kind, path string) shareStore {
+	if kind == "file" {
+		store, err := newFileShareStore(path)
+		if err != nil {
+			log.Fatalf("share file store: %v", err)
+		}
+		return store
+	}
+	return newMemoryShareStore()
+}
+
+// jsonShareStore keeps links in a jsonStore.
+type jsonShareStore struct {
+	*jsonStore[map[string]shareLink]
+}
+
+// newMemoryShareStore keeps links in process memory; they vanish on restart.
+func newMemoryShareStore() *jsonShareStore {
+	store, _ := newJSONStore("", map[string]shareLink{})
+	return &jsonShareStore{store}
+}
+
+// newFileShareStore mirrors links to the JSON file at path so they survive restarts; a
+// missing file starts an empty store.
+func newFileShareStore(path string) (*jsonShareStore, error) {
+	store, err := newJSONStore(path, map[string]shareLink{})
+	if err != nil {
+		return nil, err
+	}
+	return &jsonShareStore{store}, nil
+}
+
+func (j *jsonShareStore) Get(id string) (shareLink, bool, error) {
+	var l shareLink
+	var ok bool
+	j.view(func(data map[string]shareLink) { l, ok = data[id] })
+	return l, ok, nil
+}
+
+func (j *jsonShareStore) Put(l shareLink) error {
+	return j.update(func(data map[string]shareLink) bool {
+		data[l.ID] = l
+		return true
+	})
+}
+
+func (j *jsonShareStore) Delete(id string) error {
+	return j.update(func(data map[string]shareLink) bool {
+		delete(data, id)
+		return true
+	})
+}
+
+func (j *jsonShareStore) List(owner string) ([]shareLink, error) {
+	out := []shareLink{}
+	j.view(func(data map[string]shareLink) {
+		for _, l := range data {
+			if l.Owner == owner {
+				out = append(out, l)
+			}
+		}
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// Errors reported for links that cannot be served.
+var (
+	errShareInvalid = errors.New("share link not found") // bad signature, revoked or unknown
+	errShareExpired = errors.New("share link expired")
+)
+
+// shareLinks signs, verifies and refreshes share links.
+type shareLinks struct {
+	store shareStore
+	key   []byte
+	now   func() time.Time
+	locks sync.Map // link id -> *sync.Mutex, so each link refreshes once at a time
+}
+
+func newShareLinks(store shareStore, key string) *shareLinks {
+	return &shareLinks{store: store, key: []byte(key), now: time.Now}
+}
+
+func (s *shareLinks) enabled() bool { return len(s.key) > 0 }
+
+// shareClaims is the signed part of a link.
+type shareClaims struct {
+	ID  string `json:"id"`
+	Exp int64  `json:"exp,omitempty"` // unix seconds, 0 for no expiry
+}
+
+// sign returns the public token of l: base64url(claims) "." base64url(HMAC-SHA256(claims)).
+func (s *shareLinks) sign(l shareLink) string {
+	c := shareClaims{ID: l.ID}
+	if l.ExpiresAt != nil {
+		c.Exp = l.ExpiresAt.Unix()
+	}
+	b, _ := json.Marshal(c)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+func (s *shareLinks) mac(payload string) []byte {
+	m := hmac.New(sha256.New, s.key)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+// open verifies token and returns its link.
+func (s *shareLinks) open(token string) (shareLink, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return shareLink{}, errShareInvalid
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, s.mac(payload)) {
+		return shareLink{}, errShareInvalid
+	}
+	var c shareClaims
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(b, &c) != nil {
+		return shareLink{}, errShareInvalid
+	}
+	if c.Exp != 0 && !s.now().Before(time.Unix(c.Exp, 0)) {
+		return shareLink{}, errShareExpired
+	}
+	l, ok, err := s.store.Get(c.ID)
+	if err != nil {
+		return shareLink{}, err
+	}
+	if !ok {
+		return shareLink{}, errShareInvalid
+	}
+	return l, nil
+}
+
+// fresh returns l with values no older than shareCacheTTL when the owner's token still
+// allows a refresh. A failed refresh keeps the previous values.
+func (s *shareLinks) fresh(ctx context.Context, l shareLink) shareLink {
+	if s.now().Sub(l.RefreshedAt) < shareCacheTTL || !s.now().Before(l.TokenExpiresAt) {
+		return l
+	}
+	mu, _ := s.locks.LoadOrStore(l.ID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+	// another request may have refreshed (or revoked) the link while this one waited
+	cur, ok, err := s.store.Get(l.ID)
+	if err != nil || !ok {
+		return l
+	}
+	l = cur
+	if s.now().Sub(l.RefreshedAt) < shareCacheTTL {
+		return l
+	}
+	values, err := shareValues(ctx, &apiCaller{Token: l.Token, UserID: l.UserID}, l.Metrics)
+	if err != nil {
+		log.Printf("share %s: refresh failed, serving cached values: %v", l.ID, err)
+		return l
+	}
+	l.Values, l.RefreshedAt = values, s.now()
+	if err := s.store.Put(l); err != nil {
+		log.Printf("share %s: %v", l.ID, err)
+	}
+	return l
+}
+
+// prune deletes expired links and returns the others.
+func (s *shareLinks) prune(links []shareLink) []shareLink {
+	live := links[:0]
+	for _, l := range links {
+		if l.ExpiresAt != nil && !s.now().Before(*l.ExpiresAt) {
+			if err := s.store.Delete(l.ID); err != nil {
+				log.Printf("share %s: %v", l.ID, err)
+			}
+			continue
+		}
+		live = append(live, l)
+	}
+	return live
+}
+
+// adoptToken hands the caller's current token to their links, so values keep refreshing
+// for as long as the owner uses the dashboard. Callers must have verified that the token
+// belongs to the links' owner (see apiCaller.owner).
+func (s *shareLinks) adoptToken(c *apiCaller, links []shareLink) {
+	exp := tokenExpiry(c.Token)
+	for _, l := range links {
+		if exp.After(l.TokenExpiresAt) {
+			l.Token, l.TokenExpiresAt = c.Token, exp
+			if err := s.store.Put(l); err != nil {
+				log.Printf("share %s: %v", l.ID, err)
+			}
+		}
+	}
+}
+
+// tokenExpiry returns the expiry of token, or the zero time when it cannot be parsed.
+func tokenExpiry(token string) time.Time {
+	tok, err := parseJWT(token)
+	if err != nil {
+		return time.Time{}
+	}
+	return tok.Claims.Expiry()
+}
+
+// shareValues computes metrics as the caller.
+func shareValues(ctx context.Context, c *apiCaller, metrics []string) (map[string]float64, error) {
+	values := map[string]float64{}
+	for _, m := range metrics {
+		switch m {
+		case shareXP:
+			txs, err := fetchTransactions(ctx, c.Token, map[string]any{"type": map[string]any{"_eq": "xp"}})
+			if err != nil {
+				return nil, err
+			}
+			var total float64
+			for _, tx := range txs {
+				total += tx.Amount
+			}
+			values[m] = total
+		case shareLevel:
+			txs, err := fetchTransactions(ctx, c.Token, map[string]any{"type": map[string]any{"_eq": txLevel}})
+			if err != nil {
+				return nil, err
+			}
+			values[m] = levelStats(txs).Level
+		case shareAuditRatio:
+			txs, err := fetchTransactions(ctx, c.Token, map[string]any{"type": map[string]any{"_in": []string{txUp, txDown}}})
+			if err != nil {
+				return nil, err
+			}
+			if ratio := auditStats(txs).Ratio; ratio != nil {
+				values[m] = *ratio
+			}
+		case sharePassRate:
+			entries, err := fetchProgress(ctx, c, dateRange{})
+			if err != nil {
+				return nil, err
+			}
+			values[m] = progressStats(entries).PassRate
+		}
+	}
+	return values, nil
+}
+
+// formatShareValue renders a metric the way the dashboard does: XP in kB/MB (base 1000),
+// ratios with one decimal and rates as percentages.
+func formatShareValue(metric string, v float64, ok bool) string {
+	if !ok {
+		return "n/a"
+	}
+	switch metric {
+	case shareXP:
+		switch {
+		case v >= 1e6:
+			return strconv.FormatFloat(math.Round(v/1e4)/100, 'f', -1, 64) + " MB"
+		case v >= 1e3:
+			return strconv.FormatFloat(math.Round(v/1e3), 'f', -1, 64) + " kB"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64) + " B"
+	case shareAuditRatio:
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	case sharePassRate:
+		return strconv.Itoa(int(math.Round(v*100))) + "%"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// shareRequest is the body of POST /api/me/shares.
+type shareRequest struct {
+	Metrics   []string `json:"metrics"`
+	ExpiresIn string   `json:"expiresIn"` // Go duration such as "720h"; empty for no expiry
+}
+
+// shareLinkResponse describes a link to its owner.
+type shareLinkResponse struct {
+	ID        string     `json:"id"`
+	Metrics   []string   `json:"metrics"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	JSON      string     `json:"json"`  // path of the JSON view
+	Badge     string     `json:"badge"` // path of the SVG badge
+}
+
+func (s *shareLinks) describe(l shareLink) shareLinkResponse {
+	token := s.sign(l)
+	return shareLinkResponse{ID: l.ID, Metrics: l.Metrics, CreatedAt: l.CreatedAt, ExpiresAt: l.ExpiresAt,
+		JSON: "/share/" + token + ".json", Badge: "/share/" + token + "/badge.svg"}
+}
+
+// parseShareRequest validates the requested metrics (deduplicated, in request order) and
+// expiry.
+func parseShareRequest(r *http.Request) (shareRequest, time.Duration, error) {
+	var req shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, 0, fmt.Errorf("invalid JSON body")
+	}
+	var metrics []string
+	for _, m := range req.Metrics {
+		if _, ok := shareMetricLabels[m]; !ok {
+			return req, 0, fmt.Errorf("unknown metric %q; choose from xp, level, audit-ratio, pass-rate", m)
+		}
+		if !slices.Contains(metrics, m) {
+			metrics = append(metrics, m)
+		}
+	}
+	if len(metrics) == 0 {
+		return req, 0, fmt.Errorf("metrics must list at least one metric")
+	}
+	req.Metrics = metrics
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return req, 0, fmt.Errorf("expiresIn must be a positive duration such as 720h")
+		}
+		ttl = d
+	}
+	return req, ttl, nil
+}
+
+// sharesHandler lists the caller's links (GET) or issues a new one (POST) with the values
+// computed right away.
+func sharesHandler() http.HandlerFunc {
+	return callerHandler([]string{http.MethodGet, http.MethodPost}, func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		if !shares.enabled() {
+			http.NotFound(w, r)
+			return
+		}
+		owner, err := c.owner(r.Context())
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		links, err := shares.store.List(owner)
+		if err != nil {
+			log.Printf("share store error: %v", err)
+			http.Error(w, "share links unavailable", http.StatusInternalServerError)
+			return
+		}
+		links = shares.prune(links)
+		shares.adoptToken(c, links)
+		if r.Method == http.MethodGet {
+			resp := struct {
+				Shares []shareLinkResponse `json:"shares"`
+			}{Shares: []shareLinkResponse{}}
+			for _, l := range links {
+				resp.Shares = append(resp.Shares, shares.describe(l))
+			}
+			withJSON(w)
+			okJSON(w, resp)
+			return
+		}
+
+		req, ttl, err := parseShareRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(links) >= shareMaxLinks {
+			http.Error(w, "too many share links; delete one first", http.StatusConflict)
+			return
+		}
+		values, err := shareValues(r.Context(), c, req.Metrics)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		id, err := newSessionID()
+		if err != nil {
+			http.Error(w, "cannot create share link", http.StatusInternalServerError)
+			return
+		}
+		now := shares.now()
+		l := shareLink{ID: id, Owner: owner, UserID: c.UserID, Login: c.Login, Metrics: req.Metrics,
+			CreatedAt: now, Token: c.Token, TokenExpiresAt: tokenExpiry(c.Token), Values: values, RefreshedAt: now}
+		if ttl > 0 {
+			exp := now.Add(ttl).Truncate(time.Second)
+			l.ExpiresAt = &exp
+		}
+		if err := shares.store.Put(l); err != nil {
+			log.Printf("share store error: %v", err)
+			http.Error(w, "cannot create share link", http.StatusInternalServerError)
+			return
+		}
+		withJSON(w)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(shares.describe(l))
+	})
+}
+
+// shareHandler revokes one of the caller's links.
+func shareHandler() http.HandlerFunc {
+	return callerHandler([]string{http.MethodDelete}, func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		if !shares.enabled() {
+			http.NotFound(w, r)
+			return
+		}
+		owner, err := c.owner(r.Context())
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		id := mux.Vars(r)["id"]
+		l, ok, err := shares.store.Get(id)
+		if err == nil && ok && l.Owner == owner {
+			err = shares.store.Delete(id)
+		} else if err == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("share store error: %v", err)
+			http.Error(w, "cannot revoke share link", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// sharedMetric is one metric in the public JSON view.
+type sharedMetric struct {
+	Label   string   `json:"label"`
+	Value   *float64 `json:"value"`
+	Display string   `json:"display"`
+}
+
+// sharedProfile is the body of GET /share/{token}.json.
+type sharedProfile struct {
+	Login     string                  `json:"login"`
+	Metrics   map[string]sharedMetric `json:"metrics"`
+	UpdatedAt time.Time               `json:"updatedAt"`
+	ExpiresAt *time.Time              `json:"expiresAt"`
+}
+
+// publicShareHandler serves a link without authentication; render writes the response.
+func publicShareHandler(render func(w http.ResponseWriter, r *http.Request, l shareLink)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		withCORS(w, r)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !shares.enabled() {
+			http.NotFound(w, r)
+			return
+		}
+		l, err := shares.open(mux.Vars(r)["token"])
+		switch {
+		case errors.Is(err, errShareExpired):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case errors.Is(err, errShareInvalid):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			log.Printf("share store error: %v", err)
+			http.Error(w, "share links unavailable", http.StatusInternalServerError)
+			return
+		}
+		l = shares.fresh(r.Context(), l)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(shareCacheTTL.Seconds())))
+		render(w, r, l)
+	}
+}
+
+// sharedJSONHandler serves the public JSON view of a link.
+func sharedJSONHandler() http.HandlerFunc {
+	return publicShareHandler(func(w http.ResponseWriter, _ *http.Request, l shareLink) {
+		resp := sharedProfile{Login: l.Login, Metrics: map[string]sharedMetric{}, UpdatedAt: l.RefreshedAt, ExpiresAt: l.ExpiresAt}
+		for _, m := range l.Metrics {
+			sm := sharedMetric{Label: shareMetricLabels[m]}
+			v, ok := l.Values[m]
+			if ok {
+				sm.Value = &v
+			}
+			sm.Display = formatShareValue(m, v, ok)
+			resp.Metrics[m] = sm
+		}
+		withJSON(w)
+		okJSON(w, resp)
+	})
+}
+
+// sharedBadgeHandler serves the badge of a link: every metric ("zone01 | XP 412 kB | Level
+// 23") or, with ?metric=, a single one.
+func sharedBadgeHandler() http.HandlerFunc {
+	return publicShareHandler(func(w http.ResponseWriter, r *http.Request, l shareLink) {
+		metrics := l.Metrics
+		label := "zone01"
+		if m := r.URL.Query().Get("metric"); m != "" {
+			if !slices.Contains(l.Metrics, m) {
+				http.Error(w, "metric is not shared by this link", http.StatusBadRequest)
+				return
+			}
+			metrics, label = []string{m}, shareMetricLabels[m]
+		}
+		parts := make([]string, 0, len(metrics))
+		for _, m := range metrics {
+			v, ok := l.Values[m]
+			value := formatShareValue(m, v, ok)
+			if len(metrics) > 1 {
+				value = shareMetricLabels[m] + " " + value
+			}
+			parts = append(parts, value)
+		}
+		writeSVG(w, renderBadge(label, strings.Join(parts, " | ")))
+	})
+}
+
+// badgeTextWidth estimates the width of s in 11px Verdana; the server has no font metrics,
+// so this uses an average character width.
+func badgeTextWidth(s string) float64 {
+	return math.Round(float64(len([]rune(s))) * 6.5)
+}
+
+// renderBadge draws a flat shields.io-style badge.
+func renderBadge(label, value string) []byte {
+	lw, vw := badgeTextWidth(label)+10, badgeTextWidth(value)+10
+	w := lw + vw
+	s := &svgWriter{}
+	s.open("svg", "xmlns", "http://www.w3.org/2000/svg", "width", w, "height", 20, "role", "img", "aria-label", label+": "+value)
+	s.content("title", label+": "+value)
+	s.open("linearGradient", "id", "s", "x2", 0, "y2", "100%")
+	s.el("stop", "offset", 0, "stop-color", "#bbb", "stop-opacity", ".1")
+	s.el("stop", "offset", 1, "stop-opacity", ".1")
+	s.close("linearGradient")
+	s.open("clipPath", "id", "r")
+	s.el("rect", "width", w, "height", 20, "rx", 3, "fill", "#fff")
+	s.close("clipPath")
+	s.open("g", "clip-path", "url(#r)")
+	s.el("rect", "width", lw, "height", 20, "fill", "#555")
+	s.el("rect", "x", lw, "width", vw, "height", 20, "fill", "#007ec6")
+	s.el("rect", "width", w, "height", 20, "fill", "url(#s)")
+	s.close("g")
+	s.open("g", "fill", "#fff", "text-anchor", "middle", "font-family", "Verdana,Geneva,DejaVu Sans,sans-serif", "font-size", 11)
+	for _, t := range []struct {
+		x    float64
+		text string
+	}{{lw / 2, label}, {lw + vw/2, value}} {
+		s.text(t.text, "x", t.x, "y", 15, "fill", "#010101", "fill-opacity", ".3")
+		s.text(t.text, "x", t.x, "y", 14)
+	}
+	s.close("g")
+	return s.bytes()
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// useShares enables share links with key, an empty memory store and a manual clock.
+func useShares(t *testing.T, key string) (*shareLinks, *fakeClock) {
+	old := shares
+	clock := &fakeClock{t: time.Now()}
+	shares = newShareLinks(newMemoryShareStore(), key)
+	shares.now = clock.now
+	t.Cleanup(func() { shares = old })
+	return shares, clock
+}
+
+// shareRoute sends a request through the router, authenticated as userID unless it is empty.
+func shareRoute(t *testing.T, method, target, body, userID string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := mux.NewRouter()
+	RegisterRoutes(router)
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if userID != "" {
+		claims := zoneClaims(userID, time.Now().Add(24*time.Hour))
+		claims["login"] = "jdoe"
+		req.Header.Set("Authorization", "Bearer "+makeJWT(t, claims))
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// createShare issues a link for user 42 and returns it.
+func createShare(t *testing.T, body string) shareLinkResponse {
+	t.Helper()
+	rr := shareRoute(t, http.MethodPost, "/api/me/shares", body, "42")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	var link shareLinkResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &link); err != nil {
+		t.Fatal(err)
+	}
+	return link
+}
+
+func getShared(t *testing.T, path string) sharedProfile {
+	t.Helper()
+	rr := shareRoute(t, http.MethodGet, path, "", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("%s: expected 200, got %d %s", path, rr.Code, rr.Body.String())
+	}
+	var p sharedProfile
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestShareLinkServesCachedMetrics(t *testing.T) {
+	useShares(t, "test-key")
+	usePassThreshold(t, 1)
+	z := newFakeZone01(t)
+	z.load(t, "transactions.json")
+	seedProgress(z)
+
+	link := createShare(t, `{"metrics":["xp","level","audit-ratio","pass-rate","xp"]}`)
+	if len(link.Metrics) != 4 || link.ExpiresAt != nil || !strings.HasPrefix(link.Badge, "/share/") {
+		t.Fatalf("unexpected link %+v", link)
+	}
+
+	rr := shareRoute(t, http.MethodGet, link.JSON, "", "")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Cache-Control"), "public") {
+		t.Fatalf("unexpected response %d %v", rr.Code, rr.Header())
+	}
+	if strings.Contains(rr.Body.String(), "Bearer") || strings.Contains(rr.Body.String(), "eyJ") {
+		t.Fatalf("the public view must not leak the token: %s", rr.Body.String())
+	}
+	p := getShared(t, link.JSON)
+	want := map[string]string{"xp": "5 kB", "level": "5", "audit-ratio": "1.2", "pass-rate": "50%"}
+	for m, display := range want {
+		if got := p.Metrics[m]; got.Display != display || got.Value == nil {
+			t.Fatalf("%s: expected %s, got %+v", m, display, got)
+		}
+	}
+	if p.Login != "jdoe" {
+		t.Fatalf("expected the owner's login, got %q", p.Login)
+	}
+
+	calls := z.callCount("transaction")
+	rr = shareRoute(t, http.MethodGet, link.Badge, "", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("unexpected badge response %d %v", rr.Code, rr.Header())
+	}
+	if !strings.Contains(rr.Body.String(), "XP 5 kB | Level 5 | Audit ratio 1.2 | Pass rate 50%") {
+		t.Fatalf("unexpected badge %s", rr.Body.String())
+	}
+	rr = shareRoute(t, http.MethodGet, link.Badge+"?metric=level", "", "")
+	if !strings.Contains(rr.Body.String(), `aria-label="Level: 5"`) {
+		t.Fatalf("unexpected single-metric badge %s", rr.Body.String())
+	}
+	if rr := shareRoute(t, http.MethodGet, link.Badge+"?metric=skills", "", ""); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a metric outside the link, got %d", rr.Code)
+	}
+	if n := z.callCount("transaction"); n != calls {
+		t.Fatalf("cached values should be served without upstream calls, got %d more", n-calls)
+	}
+}
+
+func TestShareLinkRefreshesAfterTTL(t *testing.T) {
+	_, clock := useShares(t, "test-key")
+	z := newFakeZone01(t)
+	z.load(t, "transactions.json")
+	link := createShare(t, `{"metrics":["xp"]}`)
+
+	z.add("transaction", map[string]any{"id": 9100, "type": "xp", "amount": 400000, "objectId": nil, "userId": 42, "createdAt": "2024-03-02T10:00:00+00:00", "path": "/athens/div-01/x"})
+	clock.advance(shareCacheTTL / 2)
+	if p := getShared(t, link.JSON); p.Metrics["xp"].Display != "5 kB" {
+		t.Fatalf("values should be cached for SHARE_CACHE_TTL, got %+v", p.Metrics["xp"])
+	}
+	clock.advance(shareCacheTTL)
+	if p := getShared(t, link.JSON); p.Metrics["xp"].Display != "405 kB" || !p.UpdatedAt.Equal(clock.now()) {
+		t.Fatalf("values should be refreshed after SHARE_CACHE_TTL, got %+v", p)
+	}
+
+	// a failing upstream keeps the last values
+	z.errors["transaction"] = gqlError{Message: "boom"}
+	clock.advance(shareCacheTTL + time.Second)
+	if p := getShared(t, link.JSON); p.Metrics["xp"].Display != "405 kB" {
+		t.Fatalf("expected stale values on refresh failure, got %+v", p.Metrics["xp"])
+	}
+}
+
+func TestShareLinkRejectsTamperingAndExpiry(t *testing.T) {
+	s, clock := useShares(t, "test-key")
+	z := newFakeZone01(t)
+	z.load(t, "transactions.json")
+	link := createShare(t, `{"metrics":["level"],"expiresIn":"1h"}`)
+	if link.ExpiresAt == nil {
+		t.Fatal("expected an expiry")
+	}
+
+	token := strings.TrimSuffix(strings.TrimPrefix(link.JSON, "/share/"), ".json")
+	payload, _, _ := strings.Cut(token, ".")
+	forged := newShareLinks(s.store, "other-key")
+	l, _, _ := s.store.Get(link.ID)
+	for _, bad := range []string{payload + ".AAAA", "garbage", forged.sign(l)} {
+		if rr := shareRoute(t, http.MethodGet, "/share/"+bad+".json", "", ""); rr.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", bad, rr.Code)
+		}
+	}
+
+	clock.advance(time.Hour)
+	if rr := shareRoute(t, http.MethodGet, link.JSON, "", ""); rr.Code != http.StatusGone {
+		t.Fatalf("expected 410 for an expired link, got %d", rr.Code)
+	}
+	// listing prunes expired links
+	rr := shareRoute(t, http.MethodGet, "/api/me/shares", "", "42")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"shares":[]`) {
+		t.Fatalf("expected no live links, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestShareLinkRevocation(t *testing.T) {
+	useShares(t, "test-key")
+	z := newFakeZone01(t)
+	z.load(t, "transactions.json")
+	link := createShare(t, `{"metrics":["level"]}`)
+
+	// a token claiming the owner's id that the upstream does not accept
+	forged := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	forged = forged[:strings.LastIndex(forged, ".")+1] + "Zm9yZ2Vk"
+	z.errors["user"] = gqlError{Message: "Could not verify JWT", Extensions: map[string]any{"code": "invalid-jwt"}}
+	router := mux.NewRouter()
+	RegisterRoutes(router)
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/api/me/shares/"+link.ID, nil)
+		if method == http.MethodGet {
+			req = httptest.NewRequest(method, "/api/me/shares", nil)
+		}
+		req.Header.Set("Authorization", "Bearer "+forged)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized || strings.Contains(rr.Body.String(), link.ID) {
+			t.Fatalf("%s with a forged token: expected 401, got %d %s", method, rr.Code, rr.Body.String())
+		}
+	}
+	delete(z.errors, "user")
+
+	rr := shareRoute(t, http.MethodGet, "/api/me/shares", "", "42")
+	if !strings.Contains(rr.Body.String(), link.ID) || !strings.Contains(rr.Body.String(), link.Badge) {
+		t.Fatalf("owner should see the link: %s", rr.Body.String())
+	}
+	if rr := shareRoute(t, http.MethodDelete, "/api/me/shares/"+link.ID, "", "7"); rr.Code != http.StatusNotFound {
+		t.Fatalf("other users must not revoke the link, got %d", rr.Code)
+	}
+	if rr := shareRoute(t, http.MethodDelete, "/api/me/shares/"+link.ID, "", "42"); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	if rr := shareRoute(t, http.MethodGet, link.Badge, "", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("revoked link should 404, got %d", rr.Code)
+	}
+}
+
+func TestShareRequestValidation(t *testing.T) {
+	useShares(t, "test-key")
+	newFakeZone01(t)
+	for _, body := range []string{`{"metrics":[]}`, `{"metrics":["salary"]}`, `{"metrics":["xp"],"expiresIn":"soon"}`, `{"metrics":["xp"],"expiresIn":"-1h"}`, `nope`} {
+		if rr := shareRoute(t, http.MethodPost, "/api/me/shares", body, "42"); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, rr.Code)
+		}
+	}
+
+	old := shareMaxLinks
+	shareMaxLinks = 1
+	t.Cleanup(func() { shareMaxLinks = old })
+	createShare(t, `{"metrics":["xp"]}`)
+	if rr := shareRoute(t, http.MethodPost, "/api/me/shares", `{"metrics":["xp"]}`, "42"); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 over SHARE_MAX_LINKS, got %d", rr.Code)
+	}
+}
+
+func TestShareLinksDisabledWithoutKey(t *testing.T) {
+	useShares(t, "")
+	newFakeZone01(t)
+	if rr := shareRoute(t, http.MethodPost, "/api/me/shares", `{"metrics":["xp"]}`, "42"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without SHARE_SIGNING_KEY, got %d", rr.Code)
+	}
+}
+
+func TestFileShareStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shares.json")
+	store, err := newFileShareStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put(shareLink{ID: "a", Owner: "42", Metrics: []string{"xp"}, Values: map[string]float64{"xp": 1}})
+	store.Put(shareLink{ID: "b", Owner: "7"})
+
+	reopened, err := newFileShareStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, _ := reopened.List("42")
+	if len(links) != 1 || links[0].Values["xp"] != 1 {
+		t.Fatalf("unexpected links after reload %+v", links)
+	}
+	reopened.Delete("a")
+	if l, ok, _ := reopened.Get("a"); ok {
+		t.Fatalf("expected a to be deleted, got %+v", l)
+	}
+}
+
+func TestFormatShareValue(t *testing.T) {
+	for _, tc := range []struct {
+		metric string
+		v      float64
+		want   string
+	}{
+		{shareXP, 950, "950 B"},
+		{shareXP, 412345, "412 kB"},
+		{shareXP, 1234567, "1.23 MB"},
+		{shareLevel, 23, "23"},
+		{shareAuditRatio, 1.18, "1.2"},
+		{sharePassRate, 0.876, "88%"},
+	} {
+		if got := formatShareValue(tc.metric, tc.v, true); got != tc.want {
+			t.Fatalf("%s %v: expected %s, got %s", tc.metric, tc.v, tc.want, got)
+		}
+	}
+	if got := formatShareValue(shareAuditRatio, 0, false); got != "n/a" {
+		t.Fatalf("missing values should read n/a, got %s", got)
+	}
+}
+
+func TestRenderBadgeGolden(t *testing.T) {
+	checkGolden(t, "badge-all.svg", renderBadge("zone01", "XP 412 kB | Level 23"))
+	checkGolden(t, "badge-single.svg", renderBadge("Audit ratio", "1.2"))
+}
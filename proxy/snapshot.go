@@ -0,0 +1,615 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Snapshots record each user's transactions and progress over time so changes can be
+// listed without trusting the upstream to keep its history. A snapshot is taken in the
+// background after an authenticated /api/me request (at most every SNAPSHOT_MIN_INTERVAL),
+// on demand, and every SNAPSHOT_INTERVAL for users whose token is still valid.
+var snapshotStoreKind = getenv("SNAPSHOT_STORE", "") // "memory" or "file"; empty disables snapshots
+var snapshotDir = getenv("SNAPSHOT_DIR", "snapshots")
+var snapshotMinInterval = getenvDuration("SNAPSHOT_MIN_INTERVAL", time.Hour)
+var snapshotInterval = getenvDuration("SNAPSHOT_INTERVAL", 0) // 0 disables scheduled snapshots
+var snapshotKeep = getenvInt("SNAPSHOT_KEEP", 200)            // per user, newest kept
+
+// snapshotTimeout bounds a background snapshot.
+const snapshotTimeout = 2 * time.Minute
+
+// snapshots records and serves the snapshot history.
+var snapshots = newSnapshotRecorder(newSnapshotStore(snapshotStoreKind, snapshotDir))
+
+// snapshotInfo describes a stored snapshot.
+type snapshotInfo struct {
+	ID               string    `json:"id"`
+	TakenAt          time.Time `json:"takenAt"`
+	TransactionCount int       `json:"transactionCount"`
+	ProgressCount    int       `json:"progressCount"`
+	Hash             string    `json:"hash"` // of the recorded data; unchanged data is not recorded again
+}
+
+// snapshot is everything recorded for one user at one point in time.
+type snapshot struct {
+	snapshotInfo
+	Transactions []transaction   `json:"transactions"`
+	Progress     []progressEntry `json:"progress"`
+}
+
+// snapshotStore persists snapshots per owner (token subject).
+type snapshotStore interface {
+	Save(owner string, s snapshot) error
+	List(owner string) ([]snapshotInfo, error) // oldest first
+	Load(owner, id string) (snapshot, bool, error)
+	Prune(owner string, keep int) error // drops all but the newest keep
+}
+
+// newSnapshotStore builds the store selected by SNAPSHOT_STORE, or nil when disabled; a
+// snapshot directory that cannot be opened stops the proxy.
+func newSnapshotStore(kind, dir string) snapshotStore {
+	switch kind {
+	case "memory":
+		return newMemorySnapshotStore()
+	case "file":
+		store, err := newFileSnapshotStore(dir)
+		if err != nil {
+			log.Fatalf("snapshot file store: %v", err)
+		}
+		return store
+	}
+	return nil
+}
+
+// memorySnapshotStore keeps snapshots in process memory; they vanish on restart.
+type memorySnapshotStore struct {
+	mu   sync.Mutex
+	data map[string][]snapshot
+}
+
+func newMemorySnapshotStore() *memorySnapshotStore {
+	return &memorySnapshotStore{data: map[string][]snapshot{}}
+}
+
+func (m *memorySnapshotStore) Save(owner string, s snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[owner] = append(m.data[owner], s)
+	return nil
+}
+
+func (m *memorySnapshotStore) List(owner string) ([]snapshotInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	infos := []snapshotInfo{}
+	for _, s := range m.data[owner] {
+		infos = append(infos, s.snapshotInfo)
+	}
+	return infos, nil
+}
+
+func (m *memorySnapshotStore) Load(owner, id string) (snapshot, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.data[owner] {
+		if s.ID == id {
+			return s, true, nil
+		}
+	}
+	return snapshot{}, false, nil
+}
+
+func (m *memorySnapshotStore) Prune(owner string, keep int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n := len(m.data[owner]); n > keep {
+		m.data[owner] = append([]snapshot(nil), m.data[owner][n-keep:]...)
+	}
+	return nil
+}
+
+// fileSnapshotStore keeps one directory per owner under dir, holding an index.json of the
+// snapshot infos and one gzipped JSON file per snapshot.
+type fileSnapshotStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+func newFileSnapshotStore(dir string) (*fileSnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &fileSnapshotStore{dir: dir}, nil
+}
+
+var safeOwner = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ownerDir maps owner to its directory; subjects that are not safe file names are hashed.
+func (f *fileSnapshotStore) ownerDir(owner string) string {
+	if !safeOwner.MatchString(owner) {
+		sum := sha256.Sum256([]byte(owner))
+		owner = hex.EncodeToString(sum[:16])
+	}
+	return filepath.Join(f.dir, owner)
+}
+
+// index reads the infos of owner; callers must hold f.mu.
+func (f *fileSnapshotStore) index(owner string) ([]snapshotInfo, error) {
+	infos := []snapshotInfo{}
+	b, err := os.ReadFile(filepath.Join(f.ownerDir(owner), "index.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return infos, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return infos, json.Unmarshal(b, &infos)
+}
+
+func (f *fileSnapshotStore) writeIndex(owner string, infos []snapshotInfo) error {
+	b, err := json.Marshal(infos)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(f.ownerDir(owner), "index.json"), b)
+}
+
+func (f *fileSnapshotStore) Save(owner string, s snapshot) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.MkdirAll(f.ownerDir(owner), 0o700); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(s); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(f.ownerDir(owner), s.ID+".json.gz"), buf.Bytes()); err != nil {
+		return err
+	}
+	infos, err := f.index(owner)
+	if err != nil {
+		return err
+	}
+	return f.writeIndex(owner, append(infos, s.snapshotInfo))
+}
+
+func (f *fileSnapshotStore) List(owner string) ([]snapshotInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.index(owner)
+}
+
+func (f *fileSnapshotStore) Load(owner, id string) (snapshot, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	infos, err := f.index(owner)
+	if err != nil {
+		return snapshot{}, false, err
+	}
+	found := false
+	for _, info := range infos {
+		found = found || info.ID == id // only indexed ids reach the file system
+	}
+	if !found {
+		return snapshot{}, false, nil
+	}
+	file, err := os.Open(filepath.Join(f.ownerDir(owner), id+".json.gz"))
+	if err != nil {
+		return snapshot{}, false, err
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return snapshot{}, false, err
+	}
+	var s snapshot
+	if err := json.NewDecoder(zr).Decode(&s); err != nil {
+		return snapshot{}, false, err
+	}
+	return s, true, nil
+}
+
+func (f *fileSnapshotStore) Prune(owner string, keep int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	infos, err := f.index(owner)
+	if err != nil || len(infos) <= keep {
+		return err
+	}
+	drop := infos[:len(infos)-keep]
+	if err := f.writeIndex(owner, infos[len(infos)-keep:]); err != nil {
+		return err
+	}
+	for _, info := range drop {
+		if err := os.Remove(filepath.Join(f.ownerDir(owner), info.ID+".json.gz")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotRecorder takes snapshots and remembers the latest token of each user for the
+// scheduled ones.
+type snapshotRecorder struct {
+	store snapshotStore
+	now   func() time.Time
+
+	mu       sync.Mutex
+	callers  map[string]apiCaller // latest caller per owner
+	attempts map[string]time.Time // last background snapshot per owner
+	inflight map[string]bool
+	saveMu   sync.Mutex     // serialises the compare-and-save of record
+	wg       sync.WaitGroup // background snapshots
+}
+
+func newSnapshotRecorder(store snapshotStore) *snapshotRecorder {
+	return &snapshotRecorder{store: store, now: time.Now, callers: map[string]apiCaller{}, attempts: map[string]time.Time{}, inflight: map[string]bool{}}
+}
+
+func (s *snapshotRecorder) enabled() bool { return s.store != nil }
+
+// errSnapshotStore wraps failures of the store, as opposed to the upstream, in record.
+var errSnapshotStore = errors.New("snapshot store")
+
+// record takes a snapshot for c under owner. When nothing changed since the latest snapshot,
+// that one is returned with recorded false.
+func (s *snapshotRecorder) record(ctx context.Context, owner string, c *apiCaller) (info snapshotInfo, recorded bool, err error) {
+	txs, err := fetchTransactions(ctx, c.Token, map[string]any{})
+	if err != nil {
+		return info, false, err
+	}
+	progress, err := fetchProgressWhere(ctx, c, map[string]any{})
+	if err != nil {
+		return info, false, err
+	}
+	b, err := json.Marshal(struct {
+		T []transaction   `json:"t"`
+		P []progressEntry `json:"p"`
+	}{txs, progress})
+	if err != nil {
+		return info, false, err
+	}
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	infos, err := s.store.List(owner)
+	if err != nil {
+		return info, false, fmt.Errorf("%w: %v", errSnapshotStore, err)
+	}
+	if n := len(infos); n > 0 && infos[n-1].Hash == hash {
+		return infos[n-1], false, nil
+	}
+	now := s.now().UTC()
+	info = snapshotInfo{ID: now.Format("20060102T150405.000Z"), TakenAt: now, TransactionCount: len(txs), ProgressCount: len(progress), Hash: hash}
+	if n := len(infos); n > 0 && infos[n-1].ID >= info.ID {
+		return info, false, fmt.Errorf("%w: snapshot %s already exists", errSnapshotStore, info.ID)
+	}
+	if err := s.store.Save(owner, snapshot{snapshotInfo: info, Transactions: txs, Progress: progress}); err != nil {
+		return info, false, fmt.Errorf("%w: %v", errSnapshotStore, err)
+	}
+	if err := s.store.Prune(owner, snapshotKeep); err != nil {
+		return info, true, fmt.Errorf("%w: %v", errSnapshotStore, err)
+	}
+	return info, true, nil
+}
+
+// observe remembers c as the latest caller of owner, the verified user behind its token,
+// and takes a background snapshot when the last one is older than SNAPSHOT_MIN_INTERVAL.
+func (s *snapshotRecorder) observe(owner string, c *apiCaller) {
+	if !s.enabled() {
+		return
+	}
+	s.mu.Lock()
+	_, known := s.attempts[owner]
+	s.mu.Unlock()
+	var taken time.Time
+	if !known {
+		// first request since start-up: the store knows when the last snapshot was taken,
+		// read without s.mu so other requests do not wait for the disk
+		if infos, err := s.store.List(owner); err == nil && len(infos) > 0 {
+			taken = infos[len(infos)-1].TakenAt
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.callers[owner] = *c
+	last, ok := s.attempts[owner]
+	if !ok {
+		last = taken
+		s.attempts[owner] = last
+	}
+	if s.inflight[owner] || s.now().Sub(last) < snapshotMinInterval {
+		return
+	}
+	s.start(owner, *c)
+}
+
+// start records a snapshot of owner with c in the background; callers must hold s.mu.
+func (s *snapshotRecorder) start(owner string, c apiCaller) {
+	s.inflight[owner] = true
+	s.attempts[owner] = s.now()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+		defer cancel()
+		if _, _, err := s.record(ctx, owner, &c); err != nil {
+			log.Printf("snapshot for %s failed: %v", owner, err)
+		}
+		s.mu.Lock()
+		delete(s.inflight, owner)
+		s.mu.Unlock()
+	}()
+}
+
+// tick takes a snapshot for every remembered user whose token has not expired, forgetting
+// the others.
+func (s *snapshotRecorder) tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for owner, c := range s.callers {
+		if !s.now().Before(tokenExpiry(c.Token)) {
+			delete(s.callers, owner)
+			continue
+		}
+		if !s.inflight[owner] {
+			s.start(owner, c)
+		}
+	}
+}
+
+// run calls tick every interval until ctx is done.
+func (s *snapshotRecorder) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.tick()
+		}
+	}
+}
+
+// change is an entry present in both snapshots with different contents.
+type change[T any] struct {
+	Before T `json:"before"`
+	After  T `json:"after"`
+}
+
+// tableDiff lists the entries of one table that differ between two snapshots.
+type tableDiff[T any] struct {
+	Added   []T         `json:"added"`
+	Removed []T         `json:"removed"`
+	Changed []change[T] `json:"changed"`
+}
+
+// diffByID compares before and after by id; entries are equal when they encode to the
+// same JSON. Results keep the order of the snapshots.
+func diffByID[T any](before, after []T, id func(T) int) tableDiff[T] {
+	d := tableDiff[T]{Added: []T{}, Removed: []T{}, Changed: []change[T]{}}
+	old := make(map[int]T, len(before))
+	for _, e := range before {
+		old[id(e)] = e
+	}
+	seen := make(map[int]bool, len(after))
+	for _, e := range after {
+		seen[id(e)] = true
+		prev, ok := old[id(e)]
+		if !ok {
+			d.Added = append(d.Added, e)
+			continue
+		}
+		a, _ := json.Marshal(prev)
+		b, _ := json.Marshal(e)
+		if !bytes.Equal(a, b) {
+			d.Changed = append(d.Changed, change[T]{Before: prev, After: e})
+		}
+	}
+	for _, e := range before {
+		if !seen[id(e)] {
+			d.Removed = append(d.Removed, e)
+		}
+	}
+	return d
+}
+
+// snapshotDiffResponse is the body of GET /api/me/snapshots/diff.
+type snapshotDiffResponse struct {
+	Base         snapshotInfo             `json:"base"`
+	Head         snapshotInfo             `json:"head"`
+	XPGained     float64                  `json:"xpGained"` // net XP of added, removed and changed xp transactions
+	Transactions tableDiff[transaction]   `json:"transactions"`
+	Progress     tableDiff[progressEntry] `json:"progress"`
+}
+
+// diffSnapshots compares base with head.
+func diffSnapshots(base, head snapshot) snapshotDiffResponse {
+	resp := snapshotDiffResponse{
+		Base:         base.snapshotInfo,
+		Head:         head.snapshotInfo,
+		Transactions: diffByID(base.Transactions, head.Transactions, func(t transaction) int { return t.ID }),
+		Progress:     diffByID(base.Progress, head.Progress, func(p progressEntry) int { return p.ID }),
+	}
+	xp := func(t transaction) float64 {
+		if t.Type == "xp" {
+			return t.Amount
+		}
+		return 0
+	}
+	for _, t := range resp.Transactions.Added {
+		resp.XPGained += xp(t)
+	}
+	for _, t := range resp.Transactions.Removed {
+		resp.XPGained -= xp(t)
+	}
+	for _, c := range resp.Transactions.Changed {
+		resp.XPGained += xp(c.After) - xp(c.Before)
+	}
+	return resp
+}
+
+// snapshotHandler wraps the /api/me/snapshots endpoints, which 404 while snapshots are
+// disabled. fn gets the verified owner of the caller's snapshots.
+func snapshotHandler(methods []string, fn func(w http.ResponseWriter, r *http.Request, c *apiCaller, owner string)) http.HandlerFunc {
+	return callerHandler(methods, func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		if !snapshots.enabled() {
+			http.NotFound(w, r)
+			return
+		}
+		owner, err := c.owner(r.Context())
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		fn(w, r, c, owner)
+	})
+}
+
+func writeSnapshotStoreError(w http.ResponseWriter, err error) {
+	log.Printf("snapshot store error: %v", err)
+	http.Error(w, "snapshots unavailable", http.StatusInternalServerError)
+}
+
+// snapshotsHandler lists the caller's snapshots (GET) or takes one now (POST): 201 with the
+// new snapshot, or 200 with the latest one when nothing changed.
+func snapshotsHandler() http.HandlerFunc {
+	return snapshotHandler([]string{http.MethodGet, http.MethodPost}, func(w http.ResponseWriter, r *http.Request, c *apiCaller, owner string) {
+		if r.Method == http.MethodGet {
+			infos, err := snapshots.store.List(owner)
+			if err != nil {
+				writeSnapshotStoreError(w, err)
+				return
+			}
+			withJSON(w)
+			okJSON(w, map[string]any{"snapshots": infos})
+			return
+		}
+		info, recorded, err := snapshots.record(r.Context(), owner, c)
+		if err != nil {
+			if errors.Is(err, errSnapshotStore) {
+				writeSnapshotStoreError(w, err)
+				return
+			}
+			writeUpstreamError(w, err)
+			return
+		}
+		withJSON(w)
+		if recorded {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(info)
+	})
+}
+
+// snapshotByIDHandler returns one of the caller's snapshots in full.
+func snapshotByIDHandler() http.HandlerFunc {
+	return snapshotHandler([]string{http.MethodGet}, func(w http.ResponseWriter, r *http.Request, c *apiCaller, owner string) {
+		s, ok, err := snapshots.store.Load(owner, mux.Vars(r)["id"])
+		if err != nil {
+			writeSnapshotStoreError(w, err)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		withJSON(w)
+		okJSON(w, s)
+	})
+}
+
+// pickSnapshots resolves the base and head of a diff: ?head= defaults to the latest
+// snapshot; ?base= defaults to the last one taken at or before ?since= (the first snapshot
+// when none is that old) or, without since, to the one before head.
+func pickSnapshots(r *http.Request, infos []snapshotInfo) (base, head string, err error) {
+	q := r.URL.Query()
+	indexOf := func(id string) int {
+		for i, info := range infos {
+			if info.ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+	h := len(infos) - 1
+	if v := q.Get("head"); v != "" {
+		if h = indexOf(v); h < 0 {
+			return "", "", fmt.Errorf("unknown head snapshot %q", v)
+		}
+	}
+	b := max(h-1, 0)
+	switch {
+	case q.Get("base") != "":
+		if b = indexOf(q.Get("base")); b < 0 {
+			return "", "", fmt.Errorf("unknown base snapshot %q", q.Get("base"))
+		}
+	case q.Get("since") != "":
+		since, _, err := parseDateParam(q.Get("since"))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid since: %w", err)
+		}
+		b = sort.Search(len(infos), func(i int) bool { return infos[i].TakenAt.After(since) }) - 1
+		b = max(b, 0)
+	}
+	return infos[b].ID, infos[h].ID, nil
+}
+
+// snapshotDiffHandler lists what changed between two of the caller's snapshots.
+func snapshotDiffHandler() http.HandlerFunc {
+	return snapshotHandler([]string{http.MethodGet}, func(w http.ResponseWriter, r *http.Request, c *apiCaller, owner string) {
+		infos, err := snapshots.store.List(owner)
+		if err != nil {
+			writeSnapshotStoreError(w, err)
+			return
+		}
+		if len(infos) == 0 {
+			http.Error(w, "no snapshots yet", http.StatusNotFound)
+			return
+		}
+		baseID, headID, err := pickSnapshots(r, infos)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var pair [2]snapshot
+		for i, id := range []string{baseID, headID} {
+			s, ok, err := snapshots.store.Load(owner, id)
+			if err == nil && !ok {
+				err = fmt.Errorf("snapshot %s is indexed but missing", id)
+			}
+			if err != nil {
+				writeSnapshotStoreError(w, err)
+				return
+			}
+			pair[i] = s
+		}
+		withJSON(w)
+		okJSON(w, diffSnapshots(pair[0], pair[1]))
+	})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// useSnapshots enables snapshots with store and a manual clock. Call it after
+// newFakeZone01 so background snapshots finish before the fake upstream goes away.
+func useSnapshots(t *testing.T, store snapshotStore) (*snapshotRecorder, *fakeClock) {
+	old := snapshots
+	clock := &fakeClock{t: time.Now()}
+	snapshots = newSnapshotRecorder(store)
+	snapshots.now = clock.now
+	t.Cleanup(func() {
+		snapshots.wg.Wait()
+		snapshots = old
+	})
+	return snapshots, clock
+}
+
+// takeSnapshot posts to /api/me/snapshots as user 42 and expects status.
+func takeSnapshot(t *testing.T, status int) snapshotInfo {
+	t.Helper()
+	rr := shareRoute(t, http.MethodPost, "/api/me/snapshots", "", "42")
+	if rr.Code != status {
+		t.Fatalf("expected %d, got %d %s", status, rr.Code, rr.Body.String())
+	}
+	var info snapshotInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func getDiff(t *testing.T, target string) snapshotDiffResponse {
+	t.Helper()
+	rr := shareRoute(t, http.MethodGet, target, "", "42")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("%s: expected 200, got %d %s", target, rr.Code, rr.Body.String())
+	}
+	var d snapshotDiffResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestSnapshotsRecordAndDiff(t *testing.T) {
+	z := newFakeZone01(t)
+	_, clock := useSnapshots(t, newMemorySnapshotStore())
+	seedXP(z)
+	seedProgress(z)
+	start := clock.now().UTC()
+
+	first := takeSnapshot(t, http.StatusCreated)
+	// user 7's result is filtered out; unfinished ones are kept
+	if first.TransactionCount != 4 || first.ProgressCount != 5 || !first.TakenAt.Equal(clock.now()) {
+		t.Fatalf("unexpected snapshot %+v", first)
+	}
+	clock.advance(time.Minute)
+	if again := takeSnapshot(t, http.StatusOK); again.ID != first.ID {
+		t.Fatalf("unchanged data should not be recorded again, got %+v", again)
+	}
+
+	clock.advance(24 * time.Hour)
+	z.add("transaction", map[string]any{"id": 5, "type": "xp", "amount": 1500, "objectId": nil, "userId": 42, "createdAt": "2024-03-02T08:00:00+00:00", "path": "/athens/div-01/x"})
+	z.mu.Lock()
+	z.tables["progress"][4]["isDone"] = true
+	z.tables["progress"][4]["grade"] = 1
+	z.tables["transaction"] = z.tables["transaction"][1:]
+	z.mu.Unlock()
+	second := takeSnapshot(t, http.StatusCreated)
+
+	d := getDiff(t, "/api/me/snapshots/diff")
+	if d.Base.ID != first.ID || d.Head.ID != second.ID {
+		t.Fatalf("expected the latest two snapshots, got %s..%s", d.Base.ID, d.Head.ID)
+	}
+	if len(d.Transactions.Added) != 1 || d.Transactions.Added[0].ID != 5 || len(d.Transactions.Removed) != 1 || d.Transactions.Removed[0].ID != 1 {
+		t.Fatalf("unexpected transaction diff %+v", d.Transactions)
+	}
+	if d.XPGained != 500 {
+		t.Fatalf("expected 1500 - 1000 XP, got %v", d.XPGained)
+	}
+	if len(d.Progress.Changed) != 1 || d.Progress.Changed[0].Before.IsDone || !d.Progress.Changed[0].After.IsDone || len(d.Progress.Added) != 0 {
+		t.Fatalf("unexpected progress diff %+v", d.Progress)
+	}
+
+	if d := getDiff(t, "/api/me/snapshots/diff?since="+start.Add(-time.Hour).Format(time.RFC3339)); d.Base.ID != first.ID {
+		t.Fatalf("since before the first snapshot should use the first, got %s", d.Base.ID)
+	}
+	if d := getDiff(t, "/api/me/snapshots/diff?base="+second.ID); len(d.Transactions.Added)+len(d.Progress.Changed) != 0 {
+		t.Fatalf("a snapshot should not differ from itself: %+v", d)
+	}
+	if rr := shareRoute(t, http.MethodGet, "/api/me/snapshots/diff?head=nope", "", "42"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown snapshot, got %d", rr.Code)
+	}
+
+	rr := shareRoute(t, http.MethodGet, "/api/me/snapshots/"+first.ID, "", "42")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"path":"/athens/div-01/go-reloaded"`) {
+		t.Fatalf("unexpected snapshot body %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := shareRoute(t, http.MethodGet, "/api/me/snapshots/"+first.ID, "", "7"); rr.Code != http.StatusNotFound {
+		t.Fatalf("other users must not read the snapshot, got %d", rr.Code)
+	}
+	rr = shareRoute(t, http.MethodGet, "/api/me/snapshots", "", "42")
+	if !strings.Contains(rr.Body.String(), first.ID) || !strings.Contains(rr.Body.String(), second.ID) {
+		t.Fatalf("expected both snapshots listed: %s", rr.Body.String())
+	}
+}
+
+func TestSnapshotsTakenAfterRequests(t *testing.T) {
+	z := newFakeZone01(t)
+	s, clock := useSnapshots(t, newMemorySnapshotStore())
+	seedXP(z)
+
+	shareRoute(t, http.MethodGet, "/api/me/level", "", "42")
+	s.wg.Wait()
+	infos, _ := s.store.List("42")
+	if len(infos) != 1 {
+		t.Fatalf("expected a snapshot after the first request, got %+v", infos)
+	}
+
+	z.add("transaction", map[string]any{"id": 5, "type": "xp", "amount": 1500, "objectId": nil, "userId": 42, "createdAt": "2024-03-02T08:00:00+00:00", "path": "/athens/div-01/x"})
+	clock.advance(snapshotMinInterval / 2)
+	shareRoute(t, http.MethodGet, "/api/me/level", "", "42")
+	s.wg.Wait()
+	if infos, _ := s.store.List("42"); len(infos) != 1 {
+		t.Fatalf("snapshots should wait for SNAPSHOT_MIN_INTERVAL, got %d", len(infos))
+	}
+
+	clock.advance(snapshotMinInterval)
+	s.tick()
+	s.wg.Wait()
+	if infos, _ := s.store.List("42"); len(infos) != 2 {
+		t.Fatalf("the schedule should snapshot known users, got %d", len(infos))
+	}
+	clock.advance(48 * time.Hour)
+	s.tick()
+	if len(s.callers) != 0 {
+		t.Fatalf("expired tokens should be forgotten, got %+v", s.callers)
+	}
+}
+
+func TestSnapshotsNeedAnAcceptedToken(t *testing.T) {
+	z := newFakeZone01(t)
+	s, _ := useSnapshots(t, newMemorySnapshotStore())
+	seedXP(z)
+
+	z.errors["transaction"] = gqlError{Message: "Could not verify JWT", Extensions: map[string]any{"code": "invalid-jwt"}}
+	if rr := shareRoute(t, http.MethodGet, "/api/me/level", "", "42"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+	s.wg.Wait()
+	if len(s.callers) != 0 {
+		t.Fatalf("failed requests must not be remembered, got %+v", s.callers)
+	}
+	delete(z.errors, "transaction")
+
+	takeSnapshot(t, http.StatusCreated)
+	// a token claiming user 42 that the upstream does not accept
+	forged := makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour)))
+	forged = forged[:strings.LastIndex(forged, ".")+1] + "Zm9yZ2Vk"
+	z.errors["user"] = gqlError{Message: "Could not verify JWT", Extensions: map[string]any{"code": "invalid-jwt"}}
+	router := mux.NewRouter()
+	RegisterRoutes(router)
+	for _, target := range []string{"/api/me/snapshots", "/api/me/snapshots/diff"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer "+forged)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: a token the upstream refuses must not read snapshots, got %d", target, rr.Code)
+		}
+	}
+}
+
+func TestSnapshotsDisabled(t *testing.T) {
+	seedXP(newFakeZone01(t))
+	useSnapshots(t, nil)
+	for _, target := range []string{"/api/me/snapshots", "/api/me/snapshots/diff"} {
+		if rr := shareRoute(t, http.MethodGet, target, "", "42"); rr.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404 without SNAPSHOT_STORE, got %d", target, rr.Code)
+		}
+	}
+}
+
+func TestFileSnapshotStorePersistsAndPrunes(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newFileSnapshotStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := "https://example.com|42" // not a safe file name
+	for i, id := range []string{"a", "b", "c"} {
+		s := snapshot{snapshotInfo: snapshotInfo{ID: id, TransactionCount: i}, Transactions: []transaction{{ID: i, Amount: float64(i)}}}
+		if err := store.Save(owner, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Prune(owner, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := newFileSnapshotStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, _ := reopened.List(owner)
+	if len(infos) != 2 || infos[0].ID != "b" {
+		t.Fatalf("unexpected snapshots after prune %+v", infos)
+	}
+	if _, ok, _ := reopened.Load(owner, "a"); ok {
+		t.Fatal("pruned snapshot should be gone")
+	}
+	s, ok, err := reopened.Load(owner, "c")
+	if err != nil || !ok || len(s.Transactions) != 1 || s.Transactions[0].Amount != 2 {
+		t.Fatalf("unexpected snapshot %+v %v %v", s, ok, err)
+	}
+	if _, ok, _ := reopened.Load(owner, "../../etc/passwd"); ok {
+		t.Fatal("unindexed ids must not be loaded")
+	}
+	if infos, _ := reopened.List("7"); len(infos) != 0 {
+		t.Fatalf("unknown owners have no snapshots, got %+v", infos)
+	}
+}
+
+func TestDiffByID(t *testing.T) {
+	before := []transaction{{ID: 1, Type: "xp", Amount: 10}, {ID: 2, Type: "up", Amount: 5}}
+	after := []transaction{{ID: 2, Type: "up", Amount: 6}, {ID: 3, Type: "xp", Amount: 7}}
+	d := diffSnapshots(snapshot{Transactions: before}, snapshot{Transactions: after})
+	if len(d.Transactions.Added) != 1 || len(d.Transactions.Removed) != 1 || len(d.Transactions.Changed) != 1 {
+		t.Fatalf("unexpected diff %+v", d.Transactions)
+	}
+	if c := d.Transactions.Changed[0]; c.Before.Amount != 5 || c.After.Amount != 6 {
+		t.Fatalf("unexpected change %+v", c)
+	}
+	if d.XPGained != -3 {
+		t.Fatalf("only xp transactions count, got %v", d.XPGained)
+	}
+	if d.Progress.Added == nil || d.Progress.Changed == nil {
+		t.Fatal("empty lists should encode as []")
+	}
+}
+
+// slowListStore blocks the first List for owner until release is closed.
+type slowListStore struct {
+	snapshotStore
+	owner   string
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *slowListStore) List(owner string) ([]snapshotInfo, error) {
+	if owner == s.owner {
+		s.once.Do(func() {
+			close(s.entered)
+			<-s.release
+		})
+	}
+	return s.snapshotStore.List(owner)
+}
+
+func TestSnapshotObserveDoesNotHoldTheLockForTheStore(t *testing.T) {
+	seedXP(newFakeZone01(t))
+	store := &slowListStore{snapshotStore: newMemorySnapshotStore(), owner: "7", entered: make(chan struct{}), release: make(chan struct{})}
+	s, _ := useSnapshots(t, store)
+	done := make(chan struct{})
+	go func() {
+		s.observe("7", newAPICaller(makeJWT(t, zoneClaims("7", time.Now().Add(time.Hour))), "7"))
+		close(done)
+	}()
+	<-store.entered
+
+	observed := make(chan struct{})
+	go func() {
+		s.observe("42", newAPICaller(makeJWT(t, zoneClaims("42", time.Now().Add(time.Hour))), "42"))
+		close(observed)
+	}()
+	select {
+	case <-observed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("a slow store read for one user must not block the others")
+	}
+	close(store.release)
+	<-done
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// jsonStore holds the state of a store, usually maps keyed by id, behind a mutex. With a
+// path every change is written to that JSON file, so the state survives restarts; without
+// one it lives in process memory and vanishes on restart. Sessions, share links and
+// webhooks are kept this way.
+type jsonStore[T any] struct {
+	mu   sync.Mutex
+	path string // empty for memory-only stores
+	data T
+}
+
+// newJSONStore starts from empty and, when path is set, loads the file if it exists. A file
+// that cannot be read or decoded is an error rather than an empty store: the next write
+// would otherwise replace everything it held.
+func newJSONStore[T any](path string, empty T) (*jsonStore[T], error) {
+	s := &jsonStore[T]{path: path, data: empty}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &s.data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// view calls fn with the state under the lock; fn must not change it.
+func (s *jsonStore[T]) view(fn func(data T)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.data)
+}
+
+// update calls fn with the state under the lock and writes the file when fn reports a
+// change.
+func (s *jsonStore[T]) update(fn func(data T) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !fn(s.data) || s.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b)
+}
+
+// writeFileAtomic replaces path with b through a temporary file in the same directory, so
+// readers never see a partial file. The file is only readable by the proxy's user.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
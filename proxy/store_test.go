@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONStoreRefusesCorruptFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	if err := os.WriteFile(path, []byte(`{"a":`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newJSONStore(path, map[string]int{}); err == nil {
+		t.Fatal("a corrupt file must not start an empty store")
+	}
+	for _, open := range []func(string) error{
+		func(p string) error { _, err := newFileSessionStore(p); return err },
+		func(p string) error { _, err := newFileShareStore(p); return err },
+		func(p string) error { _, err := newFileWebhookStore(p); return err },
+	} {
+		if open(path) == nil {
+			t.Fatal("stores must report a corrupt file")
+		}
+	}
+}
+
+func TestJSONStoreWritesOnlyChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := newJSONStore(path, map[string]int{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.update(func(data map[string]int) bool { data["a"] = 1; return false })
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("unchanged state should not be written, got %v", err)
+	}
+	s.update(func(data map[string]int) bool { data["b"] = 2; return true })
+	reopened, err := newJSONStore(path, map[string]int{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.view(func(data map[string]int) {
+		if len(data) != 2 || data["b"] != 2 {
+			t.Fatalf("unexpected state after reload %v", data)
+		}
+	})
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// Additional reason codes produced by local verification.
+const (
+	reasonIssuer      = "token_issuer"
+	reasonAudience    = "token_audience"
+	reasonSignature   = "token_signature"
+	reasonAlgorithm   = "token_unsupported_alg"
+	reasonNotYetValid = "token_not_yet_valid"
+)
+
+// Local verification is opt-in; without JWT_VERIFY=true tokens are only checked upstream.
+var jwtVerify = getenv("JWT_VERIFY", "") == "true"
+var jwtIssuer = getenv("JWT_ISSUER", "")
+var jwtAudience = getenv("JWT_AUDIENCE", "")
+var jwtLeeway = getenvDuration("JWT_LEEWAY", 30*time.Second)
+var jwtHMACSecret = getenv("JWT_HMAC_SECRET", "")
+var jwtPublicKeyFile = getenv("JWT_PUBLIC_KEY_FILE", "")
+var jwtJWKSFile = getenv("JWT_JWKS_FILE", "")
+
+// localVerifier is nil when local verification is disabled.
+var localVerifier = newVerifierFromEnv()
+
+// verificationKey is a public (or shared) key, optionally bound to a JWKS key id.
+type verificationKey struct {
+	kid string
+	key any // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+// jwtVerifier checks expiry, issuer, audience and, when keys are configured, signatures.
+type jwtVerifier struct {
+	issuer   string
+	audience string
+	leeway   time.Duration
+	keys     []verificationKey
+	now      func() time.Time
+}
+
+// newVerifierFromEnv assembles the verifier described by the JWT_* variables.
+// A key file that fails to load stops the proxy: running on would skip signature checks.
+func newVerifierFromEnv() *jwtVerifier {
+	if !jwtVerify {
+		return nil
+	}
+	v := &jwtVerifier{issuer: jwtIssuer, audience: jwtAudience, leeway: jwtLeeway, now: time.Now}
+	if jwtHMACSecret != "" {
+		v.keys = append(v.keys, verificationKey{key: []byte(jwtHMACSecret)})
+	}
+	if jwtPublicKeyFile != "" {
+		key, err := loadPublicKeyFile(jwtPublicKeyFile)
+		if err != nil {
+			log.Fatalf("jwt public key not loaded: %v", err)
+		}
+		v.keys = append(v.keys, verificationKey{key: key})
+	}
+	if jwtJWKSFile != "" {
+		keys, err := loadJWKSFile(jwtJWKSFile)
+		if err == nil && len(keys) == 0 {
+			err = fmt.Errorf("%s: no usable keys", jwtJWKSFile)
+		}
+		if err != nil {
+			log.Fatalf("jwt jwks not loaded: %v", err)
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	return v
+}
+
+// checksSignatures reports whether v verifies signatures, so that claims it accepts can be
+// trusted; it is false when local verification is off or no key is configured.
+func (v *jwtVerifier) checksSignatures() bool {
+	return v != nil && len(v.keys) > 0
+}
+
+// Verify runs every configured check against tok and returns a *tokenError on failure.
+func (v *jwtVerifier) Verify(tok *jwtToken) error {
+	now := v.now()
+	c := tok.Claims
+	if !now.Before(c.Expiry().Add(v.leeway)) {
+		return &tokenError{Reason: reasonExpired, Msg: "token expired at " + c.Expiry().UTC().Format(time.RFC3339)}
+	}
+	if c.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return &tokenError{Reason: reasonNotYetValid, Msg: "token not valid yet"}
+	}
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return &tokenError{Reason: reasonIssuer, Msg: fmt.Sprintf("unexpected issuer %q", c.Issuer)}
+	}
+	if v.audience != "" && !slices.Contains(c.Audience, v.audience) {
+		return &tokenError{Reason: reasonAudience, Msg: fmt.Sprintf("audience %v does not include %q", []string(c.Audience), v.audience)}
+	}
+	if len(v.keys) == 0 {
+		return nil
+	}
+	return v.verifySignature(tok)
+}
+
+// verifySignature accepts tok if any key matching its kid validates the signature.
+func (v *jwtVerifier) verifySignature(tok *jwtToken) error {
+	alg := tok.Header.Alg
+	if _, ok := signatureHashes[alg]; !ok {
+		return &tokenError{Reason: reasonAlgorithm, Msg: fmt.Sprintf("algorithm %q is not accepted", alg)}
+	}
+	for _, k := range v.keys {
+		if k.kid != "" && tok.Header.Kid != "" && k.kid != tok.Header.Kid {
+			continue
+		}
+		if verifyWithKey(alg, k.key, tok.SigningInput, tok.Signature) {
+			return nil
+		}
+	}
+	return &tokenError{Reason: reasonSignature, Msg: "signature does not match any configured key"}
+}
+
+// signatureHashes lists the JWS algorithms the proxy can verify.
+var signatureHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verifyWithKey checks sig over input using alg; keys of the wrong type simply do not match.
+func verifyWithKey(alg string, key any, input string, sig []byte) bool {
+	h := signatureHashes[alg]
+	switch k := key.(type) {
+	case []byte:
+		if alg[:2] != "HS" {
+			return false
+		}
+		mac := hmac.New(newHash(h), k)
+		mac.Write([]byte(input))
+		return hmac.Equal(mac.Sum(nil), sig)
+	case *rsa.PublicKey:
+		if alg[:2] != "RS" {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(k, h, digest(h, input), sig) == nil
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" {
+			return false
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest(h, input), r, s)
+	}
+	return false
+}
+
+func newHash(h crypto.Hash) func() hash.Hash {
+	switch h {
+	case crypto.SHA384:
+		return sha512.New384
+	case crypto.SHA512:
+		return sha512.New
+	default:
+		return sha256.New
+	}
+}
+
+func digest(h crypto.Hash, input string) []byte {
+	d := newHash(h)()
+	d.Write([]byte(input))
+	return d.Sum(nil)
+}
+
+// loadPublicKeyFile reads a PEM encoded RSA or ECDSA public key (PKIX or certificate).
+func loadPublicKeyFile(path string) (any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block in " + path)
+	}
+	var key any
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// jwk is the subset of RFC 7517 key fields needed for RSA, EC and symmetric keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// loadJWKSFile reads a JSON Web Key Set; unusable keys are skipped.
+func loadJWKSFile(path string) ([]verificationKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+	var out []verificationKey
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("jwks key %q skipped: %v", k.Kid, err)
+			continue
+		}
+		out = append(out, verificationKey{kid: k.Kid, key: key})
+	}
+	return out, nil
+}
+
+// publicKey converts the JWK into a Go key value.
+func (k jwk) publicKey() (any, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "oct":
+		return dec(k.K)
+	case "RSA":
+		n, err := dec(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := dec(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := dec(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifyLocally parses and checks raw with localVerifier; it is a no-op when verification is off.
+func verifyLocally(raw string) error {
+	if localVerifier == nil {
+		return nil
+	}
+	tok, err := parseJWT(raw)
+	if err != nil {
+		return err
+	}
+	return localVerifier.Verify(tok)
+}
+
+// writeTokenError answers with a structured 401 carrying the reason code of err.
+func writeTokenError(w http.ResponseWriter, err error) {
+	reason := reasonMalformed
+	var te *tokenError
+	if errors.As(err, &te) {
+		reason = te.Reason
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	withJSON(w)
+	w.WriteHeader(http.StatusUnauthorized)
+	okJSON(w, authErrorResponse{Error: "invalid_token", Reason: reason, Message: err.Error()})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signJWT produces a token signed with key using alg (HS256, RS256 or ES256).
+func signJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	input := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			t.Fatalf("rsa sign: %v", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatalf("ecdsa sign: %v", err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return input + "." + enc.EncodeToString(sig)
+}
+
+func testVerifier(keys ...verificationKey) *jwtVerifier {
+	return &jwtVerifier{leeway: time.Second, keys: keys, now: time.Now}
+}
+
+func useVerifier(t *testing.T, v *jwtVerifier) {
+	old := localVerifier
+	localVerifier = v
+	t.Cleanup(func() { localVerifier = old })
+}
+
+func mustParse(t *testing.T, raw string) *jwtToken {
+	t.Helper()
+	tok, err := parseJWT(raw)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return tok
+}
+
+func reasonOf(err error) string {
+	var te *tokenError
+	if errors.As(err, &te) {
+		return te.Reason
+	}
+	return ""
+}
+
+func TestVerifierClaims(t *testing.T) {
+	live := zoneClaims("1", time.Now().Add(time.Hour))
+	live["iss"] = "zone01"
+	live["aud"] = "dashboard"
+
+	v := testVerifier()
+	v.issuer, v.audience = "zone01", "dashboard"
+	if err := v.Verify(mustParse(t, makeJWT(t, live))); err != nil {
+		t.Fatalf("expected valid token, got %v", err)
+	}
+
+	expired := zoneClaims("1", time.Now().Add(-time.Minute))
+	if got := reasonOf(testVerifier().Verify(mustParse(t, makeJWT(t, expired)))); got != reasonExpired {
+		t.Fatalf("expected %s, got %q", reasonExpired, got)
+	}
+	v.issuer = "other"
+	if got := reasonOf(v.Verify(mustParse(t, makeJWT(t, live)))); got != reasonIssuer {
+		t.Fatalf("expected %s, got %q", reasonIssuer, got)
+	}
+	v.issuer, v.audience = "zone01", "cli"
+	if got := reasonOf(v.Verify(mustParse(t, makeJWT(t, live)))); got != reasonAudience {
+		t.Fatalf("expected %s, got %q", reasonAudience, got)
+	}
+	early := zoneClaims("1", time.Now().Add(time.Hour))
+	early["nbf"] = time.Now().Add(time.Minute).Unix()
+	if got := reasonOf(testVerifier().Verify(mustParse(t, makeJWT(t, early)))); got != reasonNotYetValid {
+		t.Fatalf("expected %s, got %q", reasonNotYetValid, got)
+	}
+}
+
+func TestVerifierSignatures(t *testing.T) {
+	claims := zoneClaims("1", time.Now().Add(time.Hour))
+	secret := []byte("s3cret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	v := testVerifier(
+		verificationKey{key: secret},
+		verificationKey{kid: "rsa", key: &rsaKey.PublicKey},
+		verificationKey{kid: "ec", key: &ecKey.PublicKey},
+	)
+	for _, raw := range []string{
+		signJWT(t, "HS256", "", secret, claims),
+		signJWT(t, "RS256", "rsa", rsaKey, claims),
+		signJWT(t, "ES256", "ec", ecKey, claims),
+	} {
+		if err := v.Verify(mustParse(t, raw)); err != nil {
+			t.Errorf("expected valid signature, got %v", err)
+		}
+	}
+
+	forged := signJWT(t, "HS256", "", []byte("guess"), claims)
+	if got := reasonOf(v.Verify(mustParse(t, forged))); got != reasonSignature {
+		t.Fatalf("expected %s, got %q", reasonSignature, got)
+	}
+	wrongKid := signJWT(t, "RS256", "ec", rsaKey, claims)
+	if got := reasonOf(v.Verify(mustParse(t, wrongKid))); got != reasonSignature {
+		t.Fatalf("expected kid mismatch to fail, got %q", got)
+	}
+	enc := base64.RawURLEncoding
+	none := enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString([]byte(`{"exp":9999999999}`)) + "."
+	if got := reasonOf(v.Verify(mustParse(t, none))); got != reasonAlgorithm {
+		t.Fatalf("expected %s, got %q", reasonAlgorithm, got)
+	}
+}
+
+func TestLoadKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pemPath := filepath.Join(dir, "key.pem")
+	os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+
+	key, err := loadPublicKeyFile(pemPath)
+	if err != nil {
+		t.Fatalf("load pem: %v", err)
+	}
+	if _, ok := key.(*rsa.PublicKey); !ok {
+		t.Fatalf("unexpected key type %T", key)
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	enc := base64.RawURLEncoding
+	jwks := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "r1", "n": enc.EncodeToString(rsaKey.N.Bytes()), "e": "AQAB"},
+		{"kty": "EC", "kid": "e1", "crv": "P-256", "x": enc.EncodeToString(ecKey.X.Bytes()), "y": enc.EncodeToString(ecKey.Y.Bytes())},
+		{"kty": "oct", "kid": "h1", "k": enc.EncodeToString([]byte("secret"))},
+		{"kty": "OKP", "kid": "skip"},
+	}}
+	b, _ := json.Marshal(jwks)
+	jwksPath := filepath.Join(dir, "jwks.json")
+	os.WriteFile(jwksPath, b, 0o600)
+
+	keys, err := loadJWKSFile(jwksPath)
+	if err != nil {
+		t.Fatalf("load jwks: %v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 usable keys, got %d", len(keys))
+	}
+	claims := zoneClaims("1", time.Now().Add(time.Hour))
+	if err := testVerifier(keys...).Verify(mustParse(t, signJWT(t, "ES256", "e1", ecKey, claims))); err != nil {
+		t.Fatalf("expected JWKS key to verify, got %v", err)
+	}
+}
+
+func TestGraphqlRejectsTokenLocally(t *testing.T) {
+	useVerifier(t, testVerifier(verificationKey{key: []byte("s3cret")}))
+
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	t.Cleanup(upstream.Close)
+	overridePaths(t, upstream.URL, signinPath, "/graphql")
+
+	expired := signJWT(t, "HS256", "", []byte("s3cret"), zoneClaims("1", time.Now().Add(-time.Hour)))
+	req := httptest.NewRequest(http.MethodPost, "/graphql", bytes.NewBufferString(`{"query":"{ user { id } }"}`))
+	req.Header.Set("Authorization", "Bearer "+expired)
+	rr := httptest.NewRecorder()
+	graphqlHandler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401, got %d", rr.Code)
+	}
+	if called {
+		t.Fatal("upstream should not be contacted for rejected tokens")
+	}
+	var resp authErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response not JSON: %v", err)
+	}
+	if resp.Error != "invalid_token" || resp.Reason != reasonExpired {
+		t.Fatalf("unexpected payload: %+v", resp)
+	}
+}
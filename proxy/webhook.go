@@ -0,0 +1,806 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Webhooks tell users when new transactions (XP, audits, levels) appear or a result is
+// graded, without them refreshing the dashboard. Every WEBHOOK_POLL_INTERVAL a watcher
+// queries the upstream with each subscribed user's latest token, compares the rows with
+// what it saw last and POSTs signed JSON events to the user's URLs. Deliveries are retried
+// with exponential backoff and end up in the dead-letter file when every attempt failed.
+var webhookStoreKind = getenv("WEBHOOK_STORE", "") // "memory" or "file"; empty disables webhooks
+var webhookFile = getenv("WEBHOOK_FILE", "webhooks.json")
+var webhookDeadLetterFile = getenv("WEBHOOK_DEAD_LETTER_FILE", "webhooks-dead.jsonl")
+var webhookPollInterval = getenvDuration("WEBHOOK_POLL_INTERVAL", 5*time.Minute)
+var webhookMaxAttempts = getenvInt("WEBHOOK_MAX_ATTEMPTS", 5)
+var webhookBackoff = getenvDuration("WEBHOOK_BACKOFF", 2*time.Second) // doubled after every failed attempt
+var webhookTimeout = getenvDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+var webhookMaxPerUser = getenvInt("WEBHOOK_MAX_PER_USER", 5)
+var webhookQueueSize = getenvInt("WEBHOOK_QUEUE_SIZE", 1000)            // pending events per webhook; more go to the dead-letter file
+var webhookAllowHTTP = getenv("WEBHOOK_ALLOW_HTTP", "") == "true"       // accept plain-HTTP URLs for local dev
+var webhookAllowPrivate = getenv("WEBHOOK_ALLOW_PRIVATE", "") == "true" // deliver to loopback and private addresses, for local dev
+
+// webhooks stores subscriptions and delivers their events.
+var webhooks = newWebhookWatcher(newWebhookStore(webhookStoreKind, webhookFile), webhookDeadLetterFile)
+
+// Event types.
+const (
+	eventTransactionCreated = "transaction.created"
+	eventProgressGraded     = "progress.graded"
+)
+
+var webhookEventTypes = []string{eventTransactionCreated, eventProgressGraded}
+
+// webhook is a URL subscribed to events of one user.
+type webhook struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"` // verified id of the user who subscribed
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret"` // HMAC key of the signature header, shown once
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (h webhook) wants(eventType string) bool { return slices.Contains(h.Events, eventType) }
+
+// watchState is what the watcher last saw of a subscribed user.
+type watchState struct {
+	Owner  string `json:"owner"`
+	UserID int    `json:"userId"`
+	Login  string `json:"login"`
+
+	// Token is the owner's latest upstream JWT; polling pauses once it expires and resumes
+	// when the owner uses any /api/me endpoint again.
+	Token          string    `json:"token"`
+	TokenExpiresAt time.Time `json:"tokenExpiresAt"`
+
+	LastTransactionID int             `json:"lastTransactionId"` // transaction ids only grow
+	ProgressUpdatedAt time.Time       `json:"progressUpdatedAt"` // latest updatedAt seen
+	Graded            map[int]float64 `json:"graded"`            // grade per finished result id
+	PolledAt          time.Time       `json:"polledAt"`
+}
+
+// webhookStore persists subscriptions by id and watch states by owner.
+type webhookStore interface {
+	Hook(id string) (webhook, bool, error)
+	Hooks(owner string) ([]webhook, error) // oldest first
+	PutHook(h webhook) error
+	DeleteHook(id string) error
+	Watch(owner string) (watchState, bool, error)
+	Watches() ([]watchState, error)
+	PutWatch(w watchState) error
+	DeleteWatch(owner string) error
+}
+
+// newWebhookStore builds the store selected by WEBHOOK_STORE, or nil when disabled; a
+// webhook file that cannot be loaded stops the proxy.
+func newWebhookStore(kind, path string) webhookStore {
+	switch kind {
+	case "memory":
+		return newMemoryWebhookStore()
+	case "file":
+		store, err := newFileWebhookStore(path)
+		if err != nil {
+			log.Fatalf("webhook file store: %v", err)
+		}
+		return store
+	}
+	return nil
+}
+
+// webhookData is the content of a webhook store.
+type webhookData struct {
+	Hooks   map[string]webhook    `json:"hooks"`
+	Watches map[string]watchState `json:"watches"`
+}
+
+// jsonWebhookStore keeps subscriptions and watch states in a jsonStore.
+type jsonWebhookStore struct {
+	*jsonStore[webhookData]
+}
+
+// newMemoryWebhookStore keeps subscriptions in process memory; they vanish on restart.
+func newMemoryWebhookStore() *jsonWebhookStore {
+	store, _ := newFileWebhookStore("")
+	return store
+}
+
+// newFileWebhookStore mirrors subscriptions to the JSON file at path so they survive
+// restarts; a missing file starts an empty store.
+func newFileWebhookStore(path string) (*jsonWebhookStore, error) {
+	store, err := newJSONStore(path, webhookData{Hooks: map[string]webhook{}, Watches: map[string]watchState{}})
+	if err != nil {
+		return nil, err
+	}
+	if store.data.Hooks == nil {
+		store.data.Hooks = map[string]webhook{}
+	}
+	if store.data.Watches == nil {
+		store.data.Watches = map[string]watchState{}
+	}
+	return &jsonWebhookStore{store}, nil
+}
+
+func (j *jsonWebhookStore) Hook(id string) (webhook, bool, error) {
+	var h webhook
+	var ok bool
+	j.view(func(data webhookData) { h, ok = data.Hooks[id] })
+	return h, ok, nil
+}
+
+func (j *jsonWebhookStore) Hooks(owner string) ([]webhook, error) {
+	out := []webhook{}
+	j.view(func(data webhookData) {
+		for _, h := range data.Hooks {
+			if h.Owner == owner {
+				out = append(out, h)
+			}
+		}
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (j *jsonWebhookStore) PutHook(h webhook) error {
+	return j.update(func(data webhookData) bool {
+		data.Hooks[h.ID] = h
+		return true
+	})
+}
+
+func (j *jsonWebhookStore) DeleteHook(id string) error {
+	return j.update(func(data webhookData) bool {
+		delete(data.Hooks, id)
+		return true
+	})
+}
+
+func (j *jsonWebhookStore) Watch(owner string) (watchState, bool, error) {
+	var w watchState
+	var ok bool
+	j.view(func(data webhookData) { w, ok = data.Watches[owner] })
+	return w, ok, nil
+}
+
+func (j *jsonWebhookStore) Watches() ([]watchState, error) {
+	out := []watchState{}
+	j.view(func(data webhookData) {
+		for _, w := range data.Watches {
+			out = append(out, w)
+		}
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Owner < out[j].Owner })
+	return out, nil
+}
+
+func (j *jsonWebhookStore) PutWatch(w watchState) error {
+	return j.update(func(data webhookData) bool {
+		data.Watches[w.Owner] = w
+		return true
+	})
+}
+
+func (j *jsonWebhookStore) DeleteWatch(owner string) error {
+	return j.update(func(data webhookData) bool {
+		delete(data.Watches, owner)
+		return true
+	})
+}
+
+// webhookEvent is the body of a delivery.
+type webhookEvent struct {
+	ID          string         `json:"id"`
+	Type        string         `json:"type"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	UserID      int            `json:"userId"`
+	Login       string         `json:"login"`
+	Transaction *transaction   `json:"transaction,omitempty"` // for transaction.created
+	Progress    *progressEntry `json:"progress,omitempty"`    // for progress.graded
+}
+
+// deadLetter is one line of the dead-letter file.
+type deadLetter struct {
+	FailedAt  time.Time    `json:"failedAt"`
+	WebhookID string       `json:"webhookId"`
+	URL       string       `json:"url"`
+	Attempts  int          `json:"attempts"`
+	Error     string       `json:"error"`
+	Event     webhookEvent `json:"event"`
+}
+
+// webhookWatcher polls subscribed users and delivers their events.
+type webhookWatcher struct {
+	store      webhookStore
+	client     *http.Client
+	deadLetter string
+	now        func() time.Time
+	sleep      func(ctx context.Context, d time.Duration) error // waits between attempts
+
+	pollMu  sync.Mutex // one poll at a time, so a state is never updated twice
+	dlMu    sync.Mutex // appends to the dead-letter file
+	queueMu sync.Mutex
+	queues  map[string]*hookQueue // by webhook id, while its worker runs
+	wg      sync.WaitGroup
+}
+
+// hookQueue holds the events waiting for one webhook's worker, oldest first.
+type hookQueue struct {
+	hook   webhook
+	events []webhookEvent
+}
+
+func newWebhookWatcher(store webhookStore, deadLetter string) *webhookWatcher {
+	return &webhookWatcher{store: store, client: newWebhookClient(), deadLetter: deadLetter, now: time.Now, sleep: sleepContext, queues: map[string]*hookQueue{}}
+}
+
+// newWebhookClient builds the delivery client. Receiver URLs come from users, so the client
+// only connects to public addresses, checked on the resolved IP when dialing so a DNS name
+// cannot lead it inside the network, and does not follow redirects: a 3xx is a failed
+// delivery.
+func newWebhookClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // a proxy would dial on the client's behalf, past the check
+	transport.DialContext = (&net.Dialer{Timeout: webhookTimeout, Control: webhookDialControl}).DialContext
+	return &http.Client{
+		Timeout:       webhookTimeout,
+		Transport:     transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// webhookDeniedPrefixes are the ranges a webhook may not be delivered to: this-network,
+// private, shared (CGNAT), loopback, link-local, IETF protocol, documentation, benchmarking,
+// multicast and reserved addresses, and their IPv6 counterparts.
+var webhookDeniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("fec0::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// webhookNAT64 and webhook6to4 carry an IPv4 address inside an IPv6 one; the embedded
+// address is checked against the deny list too.
+var (
+	webhookNAT64 = netip.MustParsePrefix("64:ff9b::/96")
+	webhook6to4  = netip.MustParsePrefix("2002::/16")
+)
+
+// webhookDialControl refuses connections to the webhookDeniedPrefixes, also when written
+// as IPv4-mapped, NAT64 or 6to4 IPv6 addresses, unless WEBHOOK_ALLOW_PRIVATE is set.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	if webhookAllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !webhookAddrPublic(ip) {
+		return fmt.Errorf("webhook receiver address %s is not public", ip)
+	}
+	return nil
+}
+
+// webhookAddrPublic reports whether ip is outside every denied range.
+func webhookAddrPublic(ip netip.Addr) bool {
+	ip = ip.WithZone("").Unmap()
+	if ip.Is6() {
+		b := ip.As16()
+		switch {
+		case webhookNAT64.Contains(ip):
+			ip = netip.AddrFrom4([4]byte(b[12:16]))
+		case webhook6to4.Contains(ip):
+			ip = netip.AddrFrom4([4]byte(b[2:6]))
+		}
+	}
+	for _, p := range webhookDeniedPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *webhookWatcher) enabled() bool { return w.store != nil }
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// baseline records the current rows of c as seen by owner, so subscribing does not replay
+// history.
+func (w *webhookWatcher) baseline(ctx context.Context, owner string, c *apiCaller) (watchState, error) {
+	s := watchState{Owner: owner, UserID: c.UserID, Login: c.Login, Token: c.Token, TokenExpiresAt: tokenExpiry(c.Token), Graded: map[int]float64{}, PolledAt: w.now()}
+	txs, err := fetchTransactions(ctx, c.Token, map[string]any{})
+	if err != nil {
+		return s, err
+	}
+	progress, err := fetchProgressWhere(ctx, c, map[string]any{})
+	if err != nil {
+		return s, err
+	}
+	for _, tx := range txs {
+		s.LastTransactionID = max(s.LastTransactionID, tx.ID)
+	}
+	s.see(progress)
+	return s, nil
+}
+
+// see records progress in s and returns the results graded since s was last updated.
+func (s *watchState) see(progress []progressEntry) []progressEntry {
+	var graded []progressEntry
+	for _, p := range progress {
+		if p.UpdatedAt.After(s.ProgressUpdatedAt) {
+			s.ProgressUpdatedAt = p.UpdatedAt
+		}
+		if !p.IsDone || p.Grade == nil {
+			continue
+		}
+		if prev, ok := s.Graded[p.ID]; ok && prev == *p.Grade {
+			continue
+		}
+		s.Graded[p.ID] = *p.Grade
+		graded = append(graded, p)
+	}
+	return graded
+}
+
+// observe hands the newer token of c to the watch state of owner, the verified user behind
+// it, so polling continues for as long as the owner uses the dashboard. Only tokens the
+// upstream accepted may be handed over: polling would otherwise run with whatever token
+// claims the owner's id.
+func (w *webhookWatcher) observe(owner string, c *apiCaller) {
+	if !w.enabled() {
+		return
+	}
+	s, ok, err := w.store.Watch(owner)
+	if err != nil || !ok {
+		return
+	}
+	if exp := tokenExpiry(c.Token); exp.After(s.TokenExpiresAt) {
+		s.Token, s.TokenExpiresAt = c.Token, exp
+		if err := w.store.PutWatch(s); err != nil {
+			log.Printf("webhook watch %s: %v", owner, err)
+		}
+	}
+}
+
+// poll checks every subscribed user whose token is still valid and starts delivering the
+// new events.
+func (w *webhookWatcher) poll(ctx context.Context) {
+	w.pollMu.Lock()
+	defer w.pollMu.Unlock()
+	states, err := w.store.Watches()
+	if err != nil {
+		log.Printf("webhook store error: %v", err)
+		return
+	}
+	for _, s := range states {
+		if !w.now().Before(s.TokenExpiresAt) {
+			continue
+		}
+		events, err := w.check(ctx, &s)
+		if err != nil {
+			log.Printf("webhook watch %s: %v", s.Owner, err)
+			continue
+		}
+		// a subscription revoked meanwhile must not resurrect its state
+		if _, ok, err := w.store.Watch(s.Owner); err != nil || !ok {
+			continue
+		}
+		if err := w.store.PutWatch(s); err != nil {
+			log.Printf("webhook watch %s: %v", s.Owner, err)
+			continue
+		}
+		if len(events) > 0 {
+			w.dispatch(s.Owner, events)
+		}
+	}
+}
+
+// check fetches the rows of s changed since the last poll, updates s and returns the
+// events, oldest first within each type.
+func (w *webhookWatcher) check(ctx context.Context, s *watchState) ([]webhookEvent, error) {
+	c := &apiCaller{Token: s.Token, Subject: s.Owner, UserID: s.UserID, Login: s.Login}
+	txs, err := fetchTransactions(ctx, c.Token, map[string]any{"id": map[string]any{"_gt": s.LastTransactionID}})
+	if err != nil {
+		return nil, err
+	}
+	where := map[string]any{}
+	if !s.ProgressUpdatedAt.IsZero() {
+		// rows updated in the same instant as the last one seen are filtered by Graded
+		where["updatedAt"] = map[string]any{"_gte": s.ProgressUpdatedAt.Format(time.RFC3339Nano)}
+	}
+	progress, err := fetchProgressWhere(ctx, c, where)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []webhookEvent
+	now := w.now()
+	for _, tx := range txs {
+		s.LastTransactionID = max(s.LastTransactionID, tx.ID)
+		events = append(events, webhookEvent{Type: eventTransactionCreated, CreatedAt: now, UserID: s.UserID, Login: s.Login, Transaction: &tx})
+	}
+	for _, p := range s.see(progress) {
+		events = append(events, webhookEvent{Type: eventProgressGraded, CreatedAt: now, UserID: s.UserID, Login: s.Login, Progress: &p})
+	}
+	for i := range events {
+		if events[i].ID, err = newSessionID(); err != nil {
+			return nil, err
+		}
+	}
+	s.PolledAt = now
+	return events, nil
+}
+
+// dispatch queues events for every subscription of owner that wants them. Each
+// subscription has a single worker delivering its queue in order, so a slow receiver
+// holds up only its own events; at most webhookQueueSize of them wait, the rest are
+// dead-lettered at once.
+func (w *webhookWatcher) dispatch(owner string, events []webhookEvent) {
+	hooks, err := w.store.Hooks(owner)
+	if err != nil {
+		log.Printf("webhook store error: %v", err)
+		return
+	}
+	for _, h := range hooks {
+		var wanted []webhookEvent
+		for _, e := range events {
+			if h.wants(e.Type) {
+				wanted = append(wanted, e)
+			}
+		}
+		if len(wanted) > 0 {
+			w.enqueue(h, wanted)
+		}
+	}
+}
+
+// enqueue appends events to h's queue and starts its worker unless one is running.
+func (w *webhookWatcher) enqueue(h webhook, events []webhookEvent) {
+	w.queueMu.Lock()
+	q, running := w.queues[h.ID]
+	if !running {
+		q = &hookQueue{}
+		w.queues[h.ID] = q
+		w.wg.Add(1)
+	}
+	q.hook = h
+	room := max(webhookQueueSize-len(q.events), 0)
+	overflow := events[min(room, len(events)):]
+	q.events = append(q.events, events[:len(events)-len(overflow)]...)
+	w.queueMu.Unlock()
+
+	for _, e := range overflow {
+		w.bury(deadLetter{FailedAt: w.now(), WebhookID: h.ID, URL: h.URL, Error: "delivery queue full", Event: e})
+	}
+	if !running {
+		go w.work(h.ID)
+	}
+}
+
+// work delivers the queue of webhook id until it is empty.
+func (w *webhookWatcher) work(id string) {
+	defer w.wg.Done()
+	for {
+		w.queueMu.Lock()
+		q := w.queues[id]
+		if len(q.events) == 0 {
+			delete(w.queues, id)
+			w.queueMu.Unlock()
+			return
+		}
+		h, e := q.hook, q.events[0]
+		q.events = q.events[1:]
+		w.queueMu.Unlock()
+		w.deliver(context.Background(), h, e)
+	}
+}
+
+// webhookSignature signs a delivery: hex HMAC-SHA256 of "<timestamp>.<body>" keyed by the
+// subscription secret.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(timestamp + "."))
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// deliver POSTs e to h until it answers 2xx or webhookMaxAttempts attempts failed, then
+// appends it to the dead-letter file.
+func (w *webhookWatcher) deliver(ctx context.Context, h webhook, e webhookEvent) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("webhook %s: %v", h.ID, err)
+		return
+	}
+	backoff := webhookBackoff
+	attempt := 1
+	for ; ; attempt++ {
+		if err = w.send(ctx, h, e, body); err == nil {
+			return
+		}
+		log.Printf("webhook %s: attempt %d failed: %v", h.ID, attempt, err)
+		if attempt >= webhookMaxAttempts {
+			break
+		}
+		if err := w.sleep(ctx, backoff); err != nil {
+			break
+		}
+		backoff *= 2
+	}
+	w.bury(deadLetter{FailedAt: w.now(), WebhookID: h.ID, URL: h.URL, Attempts: attempt, Error: err.Error(), Event: e})
+}
+
+// send makes one delivery attempt.
+func (w *webhookWatcher) send(ctx context.Context, h webhook, e webhookEvent, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(w.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "zone01-proxy-webhooks")
+	req.Header.Set("X-Zone01-Event", e.Type)
+	req.Header.Set("X-Zone01-Delivery", e.ID)
+	req.Header.Set("X-Zone01-Timestamp", timestamp)
+	req.Header.Set("X-Zone01-Signature", webhookSignature(h.Secret, timestamp, body))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// bury appends d to the dead-letter file, one JSON object per line.
+func (w *webhookWatcher) bury(d deadLetter) {
+	w.dlMu.Lock()
+	defer w.dlMu.Unlock()
+	b, _ := json.Marshal(d)
+	f, err := os.OpenFile(w.deadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err == nil {
+		_, err = f.Write(append(b, '\n'))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.Printf("webhook dead letter lost: %v: %s", err, b)
+	}
+}
+
+// run polls every interval until ctx is done.
+func (w *webhookWatcher) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			w.poll(ctx)
+		}
+	}
+}
+
+// webhookRequest is the body of POST /api/me/webhooks.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"` // all event types when empty
+}
+
+// webhookResponse describes a subscription to its owner; the secret only on creation.
+type webhookResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+	Secret    string    `json:"secret,omitempty"`
+}
+
+func describeWebhook(h webhook) webhookResponse {
+	return webhookResponse{ID: h.ID, URL: h.URL, Events: h.Events, CreatedAt: h.CreatedAt}
+}
+
+// parseWebhookRequest validates the URL (https unless WEBHOOK_ALLOW_HTTP) and the event
+// types (deduplicated, in request order).
+func parseWebhookRequest(r *http.Request) (webhookRequest, error) {
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, fmt.Errorf("invalid JSON body")
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Host == "" || !(u.Scheme == "https" || u.Scheme == "http" && webhookAllowHTTP) {
+		return req, fmt.Errorf("url must be an absolute https URL")
+	}
+	var events []string
+	for _, e := range req.Events {
+		if !slices.Contains(webhookEventTypes, e) {
+			return req, fmt.Errorf("unknown event %q; choose from transaction.created, progress.graded", e)
+		}
+		if !slices.Contains(events, e) {
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
+		events = slices.Clone(webhookEventTypes)
+	}
+	req.Events = events
+	return req, nil
+}
+
+func writeWebhookStoreError(w http.ResponseWriter, err error) {
+	log.Printf("webhook store error: %v", err)
+	http.Error(w, "webhooks unavailable", http.StatusInternalServerError)
+}
+
+// webhooksHandler lists the caller's subscriptions (GET) or adds one (POST). The first
+// subscription of a user records their current rows, so only later changes are sent.
+func webhooksHandler() http.HandlerFunc {
+	return callerHandler([]string{http.MethodGet, http.MethodPost}, func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		if !webhooks.enabled() {
+			http.NotFound(w, r)
+			return
+		}
+		owner, err := c.owner(r.Context())
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		hooks, err := webhooks.store.Hooks(owner)
+		if err != nil {
+			writeWebhookStoreError(w, err)
+			return
+		}
+		if r.Method == http.MethodGet {
+			resp := struct {
+				Webhooks []webhookResponse `json:"webhooks"`
+			}{Webhooks: []webhookResponse{}}
+			for _, h := range hooks {
+				resp.Webhooks = append(resp.Webhooks, describeWebhook(h))
+			}
+			withJSON(w)
+			okJSON(w, resp)
+			return
+		}
+
+		req, err := parseWebhookRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(hooks) >= webhookMaxPerUser {
+			http.Error(w, "too many webhooks; delete one first", http.StatusConflict)
+			return
+		}
+		if _, ok, err := webhooks.store.Watch(owner); err != nil {
+			writeWebhookStoreError(w, err)
+			return
+		} else if !ok {
+			s, err := webhooks.baseline(r.Context(), owner, c)
+			if err != nil {
+				writeUpstreamError(w, err)
+				return
+			}
+			if err := webhooks.store.PutWatch(s); err != nil {
+				writeWebhookStoreError(w, err)
+				return
+			}
+		}
+		id, err := newSessionID()
+		var secret string
+		if err == nil {
+			secret, err = newSessionID()
+		}
+		if err != nil {
+			http.Error(w, "cannot create webhook", http.StatusInternalServerError)
+			return
+		}
+		h := webhook{ID: id, Owner: owner, URL: req.URL, Events: req.Events, Secret: secret, CreatedAt: webhooks.now()}
+		if err := webhooks.store.PutHook(h); err != nil {
+			writeWebhookStoreError(w, err)
+			return
+		}
+		resp := describeWebhook(h)
+		resp.Secret = h.Secret
+		withJSON(w)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// webhookHandler deletes one of the caller's subscriptions; the watch state goes with the
+// last one.
+func webhookHandler() http.HandlerFunc {
+	return callerHandler([]string{http.MethodDelete}, func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		if !webhooks.enabled() {
+			http.NotFound(w, r)
+			return
+		}
+		owner, err := c.owner(r.Context())
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		id := mux.Vars(r)["id"]
+		h, ok, err := webhooks.store.Hook(id)
+		if err == nil && (!ok || h.Owner != owner) {
+			http.NotFound(w, r)
+			return
+		}
+		if err == nil {
+			err = webhooks.store.DeleteHook(id)
+		}
+		var rest []webhook
+		if err == nil {
+			rest, err = webhooks.store.Hooks(owner)
+		}
+		if err == nil && len(rest) == 0 {
+			err = webhooks.store.DeleteWatch(owner)
+		}
+		if err != nil {
+			writeWebhookStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
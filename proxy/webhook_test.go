@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// useWebhooks enables webhooks with an empty memory store, a manual clock and backoff
+// sleeps that return at once and are recorded, delivering to local receivers. Call it after newFakeZone01 so deliveries
+// finish before the fake upstream goes away.
+func useWebhooks(t *testing.T) (*webhookWatcher, *fakeClock, *[]time.Duration) {
+	old, oldHTTP, oldPrivate := webhooks, webhookAllowHTTP, webhookAllowPrivate
+	clock := &fakeClock{t: time.Now()}
+	var mu sync.Mutex
+	var sleeps []time.Duration
+	webhooks = newWebhookWatcher(newMemoryWebhookStore(), filepath.Join(t.TempDir(), "dead.jsonl"))
+	webhooks.now = clock.now
+	webhooks.sleep = func(_ context.Context, d time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sleeps = append(sleeps, d)
+		return nil
+	}
+	webhookAllowHTTP, webhookAllowPrivate = true, true // receivers are local test servers
+	w := webhooks
+	t.Cleanup(func() {
+		w.wg.Wait()
+		webhooks, webhookAllowHTTP, webhookAllowPrivate = old, oldHTTP, oldPrivate
+	})
+	return w, clock, &sleeps
+}
+
+// receiver records deliveries and fails the first failures of them with 500.
+type receiver struct {
+	mu         sync.Mutex
+	deliveries []*http.Request
+	bodies     [][]byte
+	failures   int
+}
+
+func newReceiver(t *testing.T, failures int) (*receiver, string) {
+	rc := &receiver{failures: failures}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		rc.deliveries = append(rc.deliveries, r)
+		rc.bodies = append(rc.bodies, body)
+		if rc.failures != 0 {
+			rc.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return rc, srv.URL + "/hook"
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.deliveries)
+}
+
+// subscribe registers url for user 42.
+func subscribe(t *testing.T, body string) webhookResponse {
+	t.Helper()
+	rr := shareRoute(t, http.MethodPost, "/api/me/webhooks", body, "42")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	var resp webhookResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// gradeQuest02 finishes the unfinished result seeded by seedProgress.
+func gradeQuest02(z *fakeZone01, updatedAt string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	row := z.tables["progress"][4]
+	row["isDone"], row["grade"], row["updatedAt"] = true, 1.0, updatedAt
+}
+
+func TestWebhookDeliversNewEntries(t *testing.T) {
+	z := newFakeZone01(t)
+	seedXP(z)
+	seedProgress(z)
+	w, _, _ := useWebhooks(t)
+	rc, target := newReceiver(t, 0)
+
+	all := subscribe(t, `{"url":"`+target+`"}`)
+	grades := subscribe(t, `{"url":"`+target+`?grades","events":["progress.graded"]}`)
+	if all.Secret == "" || len(all.Events) != 2 || len(grades.Events) != 1 {
+		t.Fatalf("unexpected subscriptions %+v %+v", all, grades)
+	}
+	w.poll(context.Background())
+	w.wg.Wait()
+	if n := rc.count(); n != 0 {
+		t.Fatalf("existing rows must not be delivered, got %d", n)
+	}
+
+	z.add("transaction", map[string]any{"id": 5, "type": "up", "amount": 1200, "objectId": nil, "userId": 42, "createdAt": "2024-03-02T08:00:00+00:00", "path": "/athens/div-01/x"})
+	gradeQuest02(z, "2024-03-02T09:00:00+00:00")
+	w.poll(context.Background())
+	w.wg.Wait()
+	if n := rc.count(); n != 3 {
+		t.Fatalf("expected a transaction and a grade for one hook and the grade for the other, got %d", n)
+	}
+
+	seen := map[string]int{}
+	rc.mu.Lock()
+	deliveries, bodies := rc.deliveries, rc.bodies
+	rc.mu.Unlock()
+	for i, r := range deliveries {
+		secret := all.Secret
+		if r.URL.RawQuery == "grades" {
+			secret = grades.Secret
+		}
+		m := hmac.New(sha256.New, []byte(secret))
+		m.Write([]byte(r.Header.Get("X-Zone01-Timestamp") + "."))
+		m.Write(bodies[i])
+		if got := r.Header.Get("X-Zone01-Signature"); got != "sha256="+hex.EncodeToString(m.Sum(nil)) {
+			t.Fatalf("bad signature %s", got)
+		}
+		var e webhookEvent
+		if err := json.Unmarshal(bodies[i], &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != r.Header.Get("X-Zone01-Event") || e.ID != r.Header.Get("X-Zone01-Delivery") || e.UserID != 42 {
+			t.Fatalf("headers do not match the event %+v %v", e, r.Header)
+		}
+		switch e.Type {
+		case eventTransactionCreated:
+			if e.Transaction == nil || e.Transaction.ID != 5 || r.URL.RawQuery == "grades" {
+				t.Fatalf("unexpected transaction event %+v", e)
+			}
+		case eventProgressGraded:
+			if e.Progress == nil || e.Progress.ID != 5 || *e.Progress.Grade != 1 {
+				t.Fatalf("unexpected progress event %+v", e)
+			}
+		}
+		seen[e.Type]++
+	}
+	if seen[eventTransactionCreated] != 1 || seen[eventProgressGraded] != 2 {
+		t.Fatalf("unexpected events %v", seen)
+	}
+
+	w.poll(context.Background())
+	w.wg.Wait()
+	if n := rc.count(); n != 3 {
+		t.Fatalf("events must be delivered once, got %d deliveries", n)
+	}
+}
+
+func TestWebhookRetriesThenDeadLetters(t *testing.T) {
+	z := newFakeZone01(t)
+	seedXP(z)
+	w, _, sleeps := useWebhooks(t)
+	old := webhookMaxAttempts
+	webhookMaxAttempts = 3
+	t.Cleanup(func() { webhookMaxAttempts = old })
+
+	flaky, flakyURL := newReceiver(t, 1)
+	down, downURL := newReceiver(t, -1)
+	subscribe(t, `{"url":"`+flakyURL+`"}`)
+	h := subscribe(t, `{"url":"`+downURL+`"}`)
+	z.add("transaction", map[string]any{"id": 5, "type": "xp", "amount": 1500, "objectId": nil, "userId": 42, "createdAt": "2024-03-02T08:00:00+00:00", "path": "/athens/div-01/x"})
+	w.poll(context.Background())
+	w.wg.Wait()
+
+	if flaky.count() != 2 || down.count() != 3 {
+		t.Fatalf("expected 2 and 3 attempts, got %d and %d", flaky.count(), down.count())
+	}
+	if len(*sleeps) != 3 || (*sleeps)[0] != webhookBackoff {
+		t.Fatalf("unexpected backoff %v", *sleeps)
+	}
+	letters := readDeadLetters(t, w)
+	if len(letters) != 1 || letters[0].WebhookID != h.ID || letters[0].Attempts != 3 || letters[0].Event.Transaction.ID != 5 || letters[0].Error != "receiver answered 500" {
+		t.Fatalf("unexpected dead letters %+v", letters)
+	}
+}
+
+// readDeadLetters returns the entries of w's dead-letter file.
+func readDeadLetters(t *testing.T, w *webhookWatcher) []deadLetter {
+	t.Helper()
+	f, err := os.Open(w.deadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var letters []deadLetter
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var d deadLetter
+		if err := json.Unmarshal(sc.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		letters = append(letters, d)
+	}
+	return letters
+}
+
+func TestWebhookDeliveriesQueuePerHook(t *testing.T) {
+	newFakeZone01(t)
+	w, _, _ := useWebhooks(t)
+	old := webhookQueueSize
+	webhookQueueSize = 2
+	t.Cleanup(func() { webhookQueueSize = old })
+
+	var mu sync.Mutex
+	var order []string
+	active, maxActive := 0, 0
+	entered, release := make(chan struct{}, 4), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		order = append(order, r.Header.Get("X-Zone01-Delivery"))
+		active++
+		maxActive = max(maxActive, active)
+		mu.Unlock()
+		entered <- struct{}{}
+		<-release
+		mu.Lock()
+		active--
+		mu.Unlock()
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	h := subscribe(t, `{"url":"`+srv.URL+`"}`)
+	event := func(id string) webhookEvent { return webhookEvent{ID: id, Type: eventTransactionCreated} }
+
+	w.dispatch("42", []webhookEvent{event("1"), event("2")})
+	<-entered // 1 is being delivered, 2 waits, 3 fits and 4 does not
+	w.dispatch("42", []webhookEvent{event("3"), event("4")})
+	w.queueMu.Lock()
+	queued := len(w.queues[h.ID].events)
+	w.queueMu.Unlock()
+	if queued != 2 {
+		t.Fatalf("the queue should stay at WEBHOOK_QUEUE_SIZE, got %d", queued)
+	}
+	close(release)
+	w.wg.Wait()
+
+	if strings.Join(order, ",") != "1,2,3" || maxActive != 1 {
+		t.Fatalf("deliveries should be sequential and in order, got %v with %d at once", order, maxActive)
+	}
+	letters := readDeadLetters(t, w)
+	if len(letters) != 1 || letters[0].Event.ID != "4" || letters[0].Error != "delivery queue full" {
+		t.Fatalf("events past a full queue should be dead-lettered, got %+v", letters)
+	}
+	if len(w.queues) != 0 {
+		t.Fatalf("idle hooks should not keep a worker, got %v", w.queues)
+	}
+}
+
+func TestWebhookPollingFollowsTheToken(t *testing.T) {
+	z := newFakeZone01(t)
+	seedXP(z)
+	w, clock, _ := useWebhooks(t)
+	rc, target := newReceiver(t, 0)
+	subscribe(t, `{"url":"`+target+`"}`)
+	s, _, _ := w.store.Watch("42")
+	s.TokenExpiresAt = clock.now().Add(-time.Minute)
+	w.store.PutWatch(s)
+
+	z.add("transaction", map[string]any{"id": 5, "type": "xp", "amount": 1500, "objectId": nil, "userId": 42, "createdAt": "2024-03-02T08:00:00+00:00", "path": "/athens/div-01/x"})
+	calls := z.callCount("transaction")
+	w.poll(context.Background())
+	if n := z.callCount("transaction"); n != calls {
+		t.Fatalf("expired tokens must not be used, got %d calls", n-calls)
+	}
+
+	// any /api/me request hands the newer token over
+	rr := apiGet(t, levelHandler(), "/api/me/level", "42")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	w.poll(context.Background())
+	w.wg.Wait()
+	if rc.count() != 1 {
+		t.Fatalf("expected the missed transaction once polling resumed, got %d", rc.count())
+	}
+}
+
+func TestWebhookManagement(t *testing.T) {
+	seedXP(newFakeZone01(t))
+	w, _, _ := useWebhooks(t)
+	webhookAllowHTTP = false
+	for _, body := range []string{`{"url":"http://example.com/hook"}`, `{"url":"/hook"}`, `{"url":"https://example.com","events":["grade"]}`, `nope`} {
+		if rr := shareRoute(t, http.MethodPost, "/api/me/webhooks", body, "42"); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, rr.Code)
+		}
+	}
+
+	old := webhookMaxPerUser
+	webhookMaxPerUser = 1
+	t.Cleanup(func() { webhookMaxPerUser = old })
+	h := subscribe(t, `{"url":"https://example.com/hook","events":["progress.graded","progress.graded"]}`)
+	if len(h.Events) != 1 {
+		t.Fatalf("events should be deduplicated, got %v", h.Events)
+	}
+	if rr := shareRoute(t, http.MethodPost, "/api/me/webhooks", `{"url":"https://example.com/other"}`, "42"); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 over WEBHOOK_MAX_PER_USER, got %d", rr.Code)
+	}
+	rr := shareRoute(t, http.MethodGet, "/api/me/webhooks", "", "42")
+	var list struct {
+		Webhooks []webhookResponse `json:"webhooks"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Webhooks) != 1 || list.Webhooks[0].ID != h.ID || list.Webhooks[0].Secret != "" {
+		t.Fatalf("the secret must only be shown once: %s", rr.Body.String())
+	}
+
+	if rr := shareRoute(t, http.MethodDelete, "/api/me/webhooks/"+h.ID, "", "7"); rr.Code != http.StatusNotFound {
+		t.Fatalf("other users must not delete the webhook, got %d", rr.Code)
+	}
+	if rr := shareRoute(t, http.MethodDelete, "/api/me/webhooks/"+h.ID, "", "42"); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	if states, _ := w.store.Watches(); len(states) != 0 {
+		t.Fatalf("the watch should stop with the last webhook, got %+v", states)
+	}
+}
+
+func TestWebhookOwnerIsConfirmedByTheUpstream(t *testing.T) {
+	z := newFakeZone01(t)
+	seedXP(z)
+	w, clock, _ := useWebhooks(t)
+	_, target := newReceiver(t, 0)
+	h := subscribe(t, `{"url":"`+target+`"}`)
+	s, _, _ := w.store.Watch("42")
+	s.TokenExpiresAt = clock.now().Add(-time.Minute)
+	w.store.PutWatch(s)
+
+	// a token claiming user 42 that the upstream does not accept
+	forged := makeJWT(t, zoneClaims("42", time.Now().Add(48*time.Hour)))
+	forged = forged[:strings.LastIndex(forged, ".")+1] + "Zm9yZ2Vk"
+	z.errors["user"] = gqlError{Message: "Could not verify JWT", Extensions: map[string]any{"code": "invalid-jwt"}}
+	router := mux.NewRouter()
+	RegisterRoutes(router)
+	for _, tc := range []struct{ method, target string }{
+		{http.MethodGet, "/api/me/webhooks"},
+		{http.MethodDelete, "/api/me/webhooks/" + h.ID},
+		{http.MethodGet, "/api/me/level"},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		req.Header.Set("Authorization", "Bearer "+forged)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if hooks, _ := w.store.Hooks("42"); len(hooks) != 1 {
+		t.Fatalf("a forged token must not delete the webhook, got %+v", hooks)
+	}
+	if s, _, _ := w.store.Watch("42"); s.Token == forged {
+		t.Fatal("a token the upstream refused must not be polled with")
+	}
+}
+
+func TestWebhookClientOnlyReachesPublicAddresses(t *testing.T) {
+	newFakeZone01(t)
+	w, _, _ := useWebhooks(t)
+	webhookAllowPrivate = false
+	rc, target := newReceiver(t, 0)
+	err := w.send(context.Background(), webhook{URL: target}, webhookEvent{}, []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "not public") || rc.count() != 0 {
+		t.Fatalf("loopback receivers must be refused, got %v", err)
+	}
+	for _, addr := range []string{
+		"0.0.0.0:443",              // unspecified
+		"0.1.2.3:443",              // this network
+		"10.0.0.1:443",             // private
+		"100.64.0.1:443",           // shared address space (CGNAT)
+		"127.0.0.1:443",            // loopback
+		"169.254.169.254:80",       // link-local, cloud metadata
+		"172.16.0.1:443",           // private
+		"192.0.0.1:443",            // IETF protocol assignments
+		"192.0.2.1:443",            // documentation
+		"192.168.1.1:443",          // private
+		"198.18.0.1:443",           // benchmarking
+		"198.51.100.1:443",         // documentation
+		"203.0.113.1:443",          // documentation
+		"224.0.0.1:443",            // multicast
+		"240.0.0.1:443",            // reserved
+		"255.255.255.255:443",      // broadcast
+		"[::]:443",                 // unspecified
+		"[::1]:443",                // loopback
+		"[64:ff9b:1::1]:443",       // local-use NAT64
+		"[100::1]:443",             // discard-only
+		"[2001:db8::1]:443",        // documentation
+		"[fd00::1]:443",            // unique local
+		"[fe80::1%eth0]:443",       // link-local
+		"[fec0::1]:443",            // site-local
+		"[ff02::1]:443",            // multicast
+		"[::ffff:127.0.0.1]:443",   // IPv4-mapped loopback
+		"[::ffff:10.0.0.1]:443",    // IPv4-mapped private
+		"[::ffff:100.64.0.1]:443",  // IPv4-mapped CGNAT
+		"[64:ff9b::a9fe:a9fe]:443", // NAT64 of 169.254.169.254
+		"[64:ff9b::7f00:1]:443",    // NAT64 of 127.0.0.1
+		"[64:ff9b::c0a8:101]:443",  // NAT64 of 192.168.1.1
+		"[2002:a00:1::1]:443",      // 6to4 of 10.0.0.1
+	} {
+		if webhookDialControl("tcp", addr, nil) == nil {
+			t.Fatalf("%s should be refused", addr)
+		}
+	}
+	for _, addr := range []string{"93.184.216.34:443", "[2606:2800:220:1::1]:443", "[64:ff9b::5db8:d822]:443", "[::ffff:93.184.216.34]:443"} {
+		if err := webhookDialControl("tcp", addr, nil); err != nil {
+			t.Fatalf("public address %s is allowed, got %v", addr, err)
+		}
+	}
+
+	webhookAllowPrivate = true
+	redirect := httptest.NewServer(http.RedirectHandler(target, http.StatusTemporaryRedirect))
+	t.Cleanup(redirect.Close)
+	if err := w.send(context.Background(), webhook{URL: redirect.URL}, webhookEvent{}, []byte("{}")); err == nil || rc.count() != 0 {
+		t.Fatalf("redirects must not be followed, got %v", err)
+	}
+}
+
+func TestWebhooksDisabled(t *testing.T) {
+	newFakeZone01(t)
+	old := webhooks
+	webhooks = newWebhookWatcher(nil, "")
+	t.Cleanup(func() { webhooks = old })
+	if rr := shareRoute(t, http.MethodGet, "/api/me/webhooks", "", "42"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without WEBHOOK_STORE, got %d", rr.Code)
+	}
+}
+
+func TestFileWebhookStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	store, err := newFileWebhookStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.PutHook(webhook{ID: "a", Owner: "42", URL: "https://example.com", Events: []string{eventProgressGraded}})
+	store.PutWatch(watchState{Owner: "42", LastTransactionID: 9, Graded: map[int]float64{3: 1.5}})
+
+	reopened, err := newFileWebhookStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks, _ := reopened.Hooks("42")
+	s, ok, _ := reopened.Watch("42")
+	if len(hooks) != 1 || !hooks[0].wants(eventProgressGraded) || !ok || s.LastTransactionID != 9 || s.Graded[3] != 1.5 {
+		t.Fatalf("unexpected state after reload %+v %+v", hooks, s)
+	}
+	reopened.DeleteHook("a")
+	reopened.DeleteWatch("42")
+	if hooks, _ := reopened.Hooks("42"); len(hooks) != 0 {
+		t.Fatalf("expected no hooks, got %+v", hooks)
+	}
+}
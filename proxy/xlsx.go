@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The fixed parts of a single-sheet SpreadsheetML workbook. Cells are written as inline
+// strings, numbers and booleans, so no shared string table or styles are needed.
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+	xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxSheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetFooter = `</sheetData></worksheet>`
+)
+
+// xlsxExport streams a single-sheet workbook. The small fixed parts are written first and
+// the sheet is compressed into the archive row by row.
+type xlsxExport struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	rows  int
+}
+
+func newXLSXExport(w io.Writer, sheet string, columns []string) (exportWriter, error) {
+	zw := zip.NewWriter(w)
+	var name strings.Builder
+	xml.EscapeText(&name, []byte(sheet))
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, name.String())},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, p.body); err != nil {
+			return nil, err
+		}
+	}
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	x := &xlsxExport{zw: zw, sheet: bufio.NewWriter(f)}
+	x.sheet.WriteString(xlsxSheetHeader)
+	header := make([]any, len(columns))
+	for i, c := range columns {
+		header[i] = c
+	}
+	return x, x.writeRow(header)
+}
+
+func (x *xlsxExport) write(row exportRow) error { return x.writeRow(row.exportValues()) }
+
+// writeRow appends one row. Every value gets a cell, empty ones included, so columns stay
+// aligned without cell references.
+func (x *xlsxExport) writeRow(values []any) error {
+	x.rows++
+	b := x.sheet
+	b.WriteString(`<row r="`)
+	b.WriteString(strconv.Itoa(x.rows))
+	b.WriteString(`">`)
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			b.WriteString(`<c/>`)
+		case bool:
+			if v {
+				b.WriteString(`<c t="b"><v>1</v></c>`)
+			} else {
+				b.WriteString(`<c t="b"><v>0</v></c>`)
+			}
+		case int:
+			b.WriteString(`<c><v>` + strconv.Itoa(v) + `</v></c>`)
+		case float64:
+			b.WriteString(`<c><v>` + strconv.FormatFloat(v, 'g', -1, 64) + `</v></c>`)
+		case time.Time:
+			x.inlineString(v.UTC().Format(time.RFC3339Nano))
+		case string:
+			x.inlineString(v)
+		}
+	}
+	_, err := b.WriteString(`</row>`)
+	return err
+}
+
+func (x *xlsxExport) inlineString(s string) {
+	x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+	xml.EscapeText(x.sheet, []byte(s))
+	x.sheet.WriteString(`</t></is></c>`)
+}
+
+func (x *xlsxExport) flush() error { return x.sheet.Flush() }
+
+func (x *xlsxExport) close() error {
+	x.sheet.WriteString(xlsxSheetFooter)
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+	"time"
+)
+
+// xlsxSheet is the subset of a worksheet needed to read cells back.
+type xlsxSheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			T      string `xml:"t,attr"`
+			V      string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX opens a workbook and returns the cells of its sheet as strings.
+func readXLSX(t *testing.T, b []byte) (map[string]bool, [][]string) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]bool{}
+	var sheet xlsxSheet
+	for _, f := range zr.File {
+		parts[f.Name] = true
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v any
+		if err := xml.Unmarshal(body, &v); err != nil && err != io.EOF {
+			t.Fatalf("%s is not well-formed: %v", f.Name, err)
+		}
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			if err := xml.Unmarshal(body, &sheet); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var rows [][]string
+	for i, r := range sheet.Rows {
+		if r.R != i+1 {
+			t.Fatalf("row %d numbered %d", i+1, r.R)
+		}
+		var cells []string
+		for _, c := range r.Cells {
+			switch c.T {
+			case "inlineStr":
+				cells = append(cells, c.Inline)
+			case "b":
+				cells = append(cells, "bool:"+c.V)
+			default:
+				cells = append(cells, c.V)
+			}
+		}
+		rows = append(rows, cells)
+	}
+	return parts, rows
+}
+
+func TestXLSXExport(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := newXLSXExport(&buf, "transactions", transactionsExport.columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := 10
+	rows := []transaction{
+		{ID: 1, Type: "xp", Amount: 1500.5, ObjectID: &id, Path: "/a/<go & reloaded>", CreatedAt: time.Date(2024, 1, 5, 10, 0, 0, 0, time.UTC), Object: &objectInfo{ID: 10, Name: "go-reloaded", Type: "project"}},
+		{ID: 2, Type: "up", Amount: 5, Path: "/a/audit", CreatedAt: time.Date(2024, 1, 6, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, r := range rows {
+		if err := out.write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := out.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	parts, cells := readXLSX(t, buf.Bytes())
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		if !parts[name] {
+			t.Fatalf("missing part %s", name)
+		}
+	}
+	if len(cells) != 3 || cells[0][0] != "id" || cells[0][7] != "createdAt" {
+		t.Fatalf("unexpected header %v", cells)
+	}
+	want := []string{"1", "xp", "1500.5", "10", "go-reloaded", "project", "/a/<go & reloaded>", "2024-01-05T10:00:00Z"}
+	for i, v := range want {
+		if cells[1][i] != v {
+			t.Fatalf("cell %d: expected %q, got %q", i, v, cells[1][i])
+		}
+	}
+	// empty cells keep later columns in place
+	if len(cells[2]) != 8 || cells[2][3] != "" || cells[2][4] != "" || cells[2][6] != "/a/audit" {
+		t.Fatalf("unexpected row without object %q", cells[2])
+	}
+}
+
+func TestXLSXExportBooleans(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := newXLSXExport(&buf, "progress", progressExport.columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.write(progressEntry{ID: 1, IsDone: true})
+	out.write(progressEntry{ID: 2})
+	if err := out.close(); err != nil {
+		t.Fatal(err)
+	}
+	_, cells := readXLSX(t, buf.Bytes())
+	if cells[1][2] != "bool:1" || cells[2][2] != "bool:0" || cells[1][1] != "" {
+		t.Fatalf("unexpected cells %q", cells)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// transactionsQuery fetches every transaction matching $where that is visible to the caller;
+// the upstream's row permissions restrict it to the caller's own.
+const transactionsQuery = `query MeTransactions($where: transaction_bool_exp!) {
+  transaction(where: $where, order_by: [{ createdAt: asc }, { id: asc }]) @paginate {
+    id type amount objectId userId createdAt path
+  }
+}`
+
+// transaction is an upstream transaction, with its object joined in where it was resolved.
+type transaction struct {
+	ID        int         `json:"id"`
+	Type      string      `json:"type"`
+	Amount    float64     `json:"amount"`
+	ObjectID  *int        `json:"objectId"`
+	UserID    int         `json:"userId"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Path      string      `json:"path"`
+	Object    *objectInfo `json:"object"`
+}
+
+// xpTransactionsResponse is the body of GET /api/me/xp/transactions.
+type xpTransactionsResponse struct {
+	Transactions []transaction `json:"transactions"`
+}
+
+// fetchTransactions loads the caller's transactions matching the Hasura expression where,
+// oldest first.
+func fetchTransactions(ctx context.Context, token string, where map[string]any) ([]transaction, error) {
+	var data struct {
+		Transaction []transaction `json:"transaction"`
+	}
+	if err := queryUpstream(ctx, token, transactionsQuery, map[string]any{"where": where}, &data); err != nil {
+		return nil, err
+	}
+	if data.Transaction == nil {
+		data.Transaction = []transaction{}
+	}
+	return data.Transaction, nil
+}
+
+// joinObjects resolves the objects of txs through the shared object cache.
+func joinObjects(ctx context.Context, token string, txs []transaction) error {
+	var ids []int
+	for _, tx := range txs {
+		if tx.ObjectID != nil {
+			ids = append(ids, *tx.ObjectID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	objs, err := objects.Lookup(ctx, token, ids)
+	if err != nil {
+		return err
+	}
+	for i, tx := range txs {
+		if tx.ObjectID == nil {
+			continue
+		}
+		if o, ok := objs[*tx.ObjectID]; ok {
+			txs[i].Object = &o
+		}
+	}
+	return nil
+}
+
+// fetchXPTransactions loads the caller's XP transactions created within dr with their objects.
+func fetchXPTransactions(ctx context.Context, token string, dr dateRange) ([]transaction, error) {
+	txs, err := fetchTransactions(ctx, token, dr.where("createdAt", map[string]any{"type": map[string]any{"_eq": "xp"}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := joinObjects(ctx, token, txs); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// xpHandler wraps the /api/me/xp endpoints: it parses the from/to filter and loads the
+// caller's transactions before handing them to fn.
+func xpHandler(fn func(w http.ResponseWriter, r *http.Request, dr dateRange, txs []transaction)) http.HandlerFunc {
+	return meHandler(func(w http.ResponseWriter, r *http.Request, c *apiCaller) {
+		dr, err := parseDateRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		txs, err := fetchXPTransactions(r.Context(), c.Token, dr)
+		if err != nil {
+			writeUpstreamError(w, err)
+			return
+		}
+		fn(w, r, dr, txs)
+	})
+}
+
+// xpTransactionsHandler returns the caller's XP transactions with object { id name type }
+// already resolved, replacing the XP_TRANSACTIONS then OBJECT_BY_IDS waterfall.
+func xpTransactionsHandler() http.HandlerFunc {
+	return xpHandler(func(w http.ResponseWriter, _ *http.Request, _ dateRange, txs []transaction) {
+		withJSON(w)
+		okJSON(w, xpTransactionsResponse{Transactions: txs})
+	})
+}
+
+// xpSummaryResponse is the body of GET /api/me/xp/summary.
+type xpSummaryResponse struct {
+	dateRange
+	TotalXP float64    `json:"totalXp"`
+	Count   int        `json:"count"`
+	First   *time.Time `json:"first"` // earliest transaction in the range
+	Last    *time.Time `json:"last"`
+}
+
+// xpSummaryHandler reports the caller's total XP within the requested range.
+func xpSummaryHandler() http.HandlerFunc {
+	return xpHandler(func(w http.ResponseWriter, _ *http.Request, dr dateRange, txs []transaction) {
+		resp := xpSummaryResponse{dateRange: dr, Count: len(txs)}
+		for i := range txs {
+			resp.TotalXP += txs[i].Amount
+			if resp.First == nil || txs[i].CreatedAt.Before(*resp.First) {
+				resp.First = &txs[i].CreatedAt
+			}
+			if resp.Last == nil || txs[i].CreatedAt.After(*resp.Last) {
+				resp.Last = &txs[i].CreatedAt
+			}
+		}
+		withJSON(w)
+		okJSON(w, resp)
+	})
+}
+
+// xpTimelinePoint is one bucket of an XP timeline.
+type xpTimelinePoint struct {
+	Start        time.Time `json:"start"`
+	XP           float64   `json:"xp"`
+	CumulativeXP float64   `json:"cumulativeXp"`
+	Count        int       `json:"count"`
+}
+
+// xpTimelineResponse is the body of GET /api/me/xp/timeline.
+type xpTimelineResponse struct {
+	dateRange
+	Bucket string            `json:"bucket"`
+	Points []xpTimelinePoint `json:"points"`
+}
+
+// xpTimeline buckets txs and returns every bucket from the first to the last transaction,
+// empty ones included, with a running total.
+func xpTimeline(txs []transaction, bucket string) []xpTimelinePoint {
+	points := []xpTimelinePoint{}
+	if len(txs) == 0 {
+		return points
+	}
+	sums := map[time.Time]*xpTimelinePoint{}
+	first, last := bucketStart(txs[0].CreatedAt, bucket), bucketStart(txs[0].CreatedAt, bucket)
+	for _, tx := range txs {
+		start := bucketStart(tx.CreatedAt, bucket)
+		p, ok := sums[start]
+		if !ok {
+			p = &xpTimelinePoint{Start: start}
+			sums[start] = p
+		}
+		p.XP += tx.Amount
+		p.Count++
+		if start.Before(first) {
+			first = start
+		}
+		if start.After(last) {
+			last = start
+		}
+	}
+	var total float64
+	for start := first; !start.After(last); start = nextBucket(start, bucket) {
+		p := xpTimelinePoint{Start: start}
+		if sum, ok := sums[start]; ok {
+			p = *sum
+		}
+		total += p.XP
+		p.CumulativeXP = total
+		points = append(points, p)
+	}
+	return points
+}
+
+// xpTimelineHandler returns cumulative XP bucketed by ?bucket=day|week|month (default day).
+func xpTimelineHandler() http.HandlerFunc {
+	return xpHandler(func(w http.ResponseWriter, r *http.Request, dr dateRange, txs []transaction) {
+		bucket := r.URL.Query().Get("bucket")
+		switch bucket {
+		case "":
+			bucket = bucketDay
+		case bucketDay, bucketWeek, bucketMonth:
+		default:
+			http.Error(w, "bucket must be day, week or month", http.StatusBadRequest)
+			return
+		}
+		withJSON(w)
+		okJSON(w, xpTimelineResponse{dateRange: dr, Bucket: bucket, Points: xpTimeline(txs, bucket)})
+	})
+}
+
+// projectXP is the XP earned on one object.
+type projectXP struct {
+	ObjectID *int    `json:"objectId"`
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	XP       float64 `json:"xp"`
+	Count    int     `json:"count"`
+}
+
+// xpByProjectResponse is the body of GET /api/me/xp/by-project.
+type xpByProjectResponse struct {
+	dateRange
+	TotalXP  float64     `json:"totalXp"`
+	Projects []projectXP `json:"projects"`
+	Others   *projectXP  `json:"others,omitempty"` // projects beyond ?top=N, lumped together
+}
+
+// xpByProject sums txs per object, highest XP first. Transactions without an object are
+// grouped by path.
+func xpByProject(txs []transaction) []projectXP {
+	groups := map[string]*projectXP{}
+	var order []string
+	for _, tx := range txs {
+		key := tx.Path
+		if tx.ObjectID != nil {
+			key = strconv.Itoa(*tx.ObjectID)
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &projectXP{ObjectID: tx.ObjectID, Name: path.Base(tx.Path)}
+			if tx.Object != nil {
+				g.Name, g.Type = tx.Object.Name, tx.Object.Type
+			}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.XP += tx.Amount
+		g.Count++
+	}
+	out := make([]projectXP, 0, len(order))
+	for _, key := range order {
+		out = append(out, *groups[key])
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].XP != out[j].XP {
+			return out[i].XP > out[j].XP
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// topProjects keeps the first n of projects and lumps the rest into "Others", which is nil
+// when nothing was left out.
+func topProjects(projects []projectXP, n int) ([]projectXP, *projectXP) {
+	if len(projects) <= n {
+		return projects, nil
+	}
+	others := &projectXP{Name: "Others"}
+	for _, p := range projects[n:] {
+		others.XP += p.XP
+		others.Count += p.Count
+	}
+	return projects[:n], others
+}
+
+// xpByProjectHandler returns XP per project; ?top=N keeps the N largest and lumps the rest
+// into "others".
+func xpByProjectHandler() http.HandlerFunc {
+	return xpHandler(func(w http.ResponseWriter, r *http.Request, dr dateRange, txs []transaction) {
+		top := 0
+		if v := r.URL.Query().Get("top"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "top must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			top = n
+		}
+		resp := xpByProjectResponse{dateRange: dr, Projects: xpByProject(txs)}
+		for _, p := range resp.Projects {
+			resp.TotalXP += p.XP
+		}
+		if top > 0 {
+			resp.Projects, resp.Others = topProjects(resp.Projects, top)
+		}
+		withJSON(w)
+		okJSON(w, resp)
+	})
+}
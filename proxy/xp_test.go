@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// seedXP fills z with a small XP history: two projects, one transaction without object,
+// and a non-XP transaction that must be ignored.
+func seedXP(z *fakeZone01) {
+	z.add("object", map[string]any{"id": 10, "name": "go-reloaded", "type": "project"})
+	z.add("object", map[string]any{"id": 20, "name": "ascii-art", "type": "project"})
+	z.add("transaction", map[string]any{"id": 1, "type": "xp", "amount": 1000, "objectId": 10, "userId": 42, "createdAt": "2024-01-05T10:00:00+00:00", "path": "/athens/div-01/go-reloaded"})
+	z.add("transaction", map[string]any{"id": 2, "type": "up", "amount": 5, "objectId": 10, "userId": 42, "createdAt": "2024-01-06T10:00:00+00:00", "path": "/athens/div-01/go-reloaded"})
+	z.add("transaction", map[string]any{"id": 3, "type": "xp", "amount": 2500, "objectId": 20, "userId": 42, "createdAt": "2024-02-10T12:30:00+00:00", "path": "/athens/div-01/ascii-art"})
+	z.add("transaction", map[string]any{"id": 4, "type": "xp", "amount": 500, "objectId": nil, "userId": 42, "createdAt": "2024-03-01T08:00:00+00:00", "path": "/athens/div-01/checkpoint"})
+}
+
+func TestXPTransactionsHandlerJoinsObjects(t *testing.T) {
+	useObjectCache(t, newObjectCache(time.Hour, 100))
+	z := newFakeZone01(t)
+	seedXP(z)
+
+	rr := apiGet(t, xpTransactionsHandler(), "/api/me/xp/transactions", "42")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	var resp xpTransactionsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Transactions) != 3 {
+		t.Fatalf("expected 3 XP transactions, got %+v", resp.Transactions)
+	}
+	first := resp.Transactions[0]
+	if first.Object == nil || first.Object.Name != "go-reloaded" || first.Amount != 1000 {
+		t.Fatalf("object not joined: %+v", first)
+	}
+	if resp.Transactions[2].Object != nil {
+		t.Fatalf("transactions without objectId should have a null object: %+v", resp.Transactions[2])
+	}
+
+	apiGet(t, xpTransactionsHandler(), "/api/me/xp/transactions", "7")
+	if n := z.callCount("object"); n != 1 {
+		t.Fatalf("object metadata should be fetched once for all users, got %d lookups", n)
+	}
+}
+
+func TestXPSummaryHandler(t *testing.T) {
+	useObjectCache(t, newObjectCache(time.Hour, 100))
+	seedXP(newFakeZone01(t))
+
+	rr := apiGet(t, xpSummaryHandler(), "/api/me/xp/summary", "42")
+	var resp xpSummaryResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.TotalXP != 4000 || resp.Count != 3 || resp.First.Day() != 5 || resp.Last.Month() != time.March {
+		t.Fatalf("unexpected summary %+v", resp)
+	}
+
+	rr = apiGet(t, xpSummaryHandler(), "/api/me/xp/summary?from=2024-02-01&to=2024-02-29", "42")
+	resp = xpSummaryResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.TotalXP != 2500 || resp.Count != 1 || resp.From == nil || resp.To == nil {
+		t.Fatalf("from/to should filter upstream rows: %s", rr.Body.String())
+	}
+
+	if rr := apiGet(t, xpSummaryHandler(), "/api/me/xp/summary?from=nope", "42"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a bad date, got %d", rr.Code)
+	}
+}
+
+func TestXPTimelineHandler(t *testing.T) {
+	useObjectCache(t, newObjectCache(time.Hour, 100))
+	seedXP(newFakeZone01(t))
+
+	rr := apiGet(t, xpTimelineHandler(), "/api/me/xp/timeline?bucket=month", "42")
+	var resp xpTimelineResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []xpTimelinePoint{
+		{Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), XP: 1000, CumulativeXP: 1000, Count: 1},
+		{Start: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), XP: 2500, CumulativeXP: 3500, Count: 1},
+		{Start: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), XP: 500, CumulativeXP: 4000, Count: 1},
+	}
+	if resp.Bucket != bucketMonth || len(resp.Points) != len(want) {
+		t.Fatalf("unexpected timeline %s", rr.Body.String())
+	}
+	for i, p := range resp.Points {
+		if !p.Start.Equal(want[i].Start) || p.XP != want[i].XP || p.CumulativeXP != want[i].CumulativeXP || p.Count != want[i].Count {
+			t.Fatalf("point %d: got %+v, want %+v", i, p, want[i])
+		}
+	}
+
+	rr = apiGet(t, xpTimelineHandler(), "/api/me/xp/timeline?bucket=week&to=2024-02-29", "42")
+	resp = xpTimelineResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	// 2024-01-01 through the week of 2024-02-05: six weeks, empty ones included
+	if len(resp.Points) != 6 || resp.Points[5].CumulativeXP != 3500 || resp.Points[2].Count != 0 {
+		t.Fatalf("unexpected weekly timeline %s", rr.Body.String())
+	}
+
+	if rr := apiGet(t, xpTimelineHandler(), "/api/me/xp/timeline?bucket=year", "42"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown bucket, got %d", rr.Code)
+	}
+}
+
+func TestXPByProjectHandler(t *testing.T) {
+	useObjectCache(t, newObjectCache(time.Hour, 100))
+	seedXP(newFakeZone01(t))
+
+	rr := apiGet(t, xpByProjectHandler(), "/api/me/xp/by-project", "42")
+	var resp xpByProjectResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.TotalXP != 4000 || len(resp.Projects) != 3 || resp.Others != nil {
+		t.Fatalf("unexpected breakdown %s", rr.Body.String())
+	}
+	if p := resp.Projects[0]; p.Name != "ascii-art" || p.XP != 2500 || p.Type != "project" || *p.ObjectID != 20 {
+		t.Fatalf("largest project first, got %+v", p)
+	}
+	if p := resp.Projects[2]; p.Name != "checkpoint" || p.ObjectID != nil {
+		t.Fatalf("transactions without object are named after their path, got %+v", p)
+	}
+
+	rr = apiGet(t, xpByProjectHandler(), "/api/me/xp/by-project?top=1", "42")
+	resp = xpByProjectResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Projects) != 1 || resp.Others == nil || resp.Others.XP != 1500 || resp.Others.Count != 2 {
+		t.Fatalf("unexpected top=1 breakdown %s", rr.Body.String())
+	}
+}
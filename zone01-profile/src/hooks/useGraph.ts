@@ -1,6 +1,6 @@
-import { gql } from "../lib/api";
+import { api, gql } from "../lib/api";
 import { useEffect, useState } from "react";
-import { ME, XP_TRANSACTIONS, OBJECT_BY_IDS as _OBJECT_BY_IDS, PROGRESS } from "../graphql/queries"; // ensure the import name matches your file
+import { ME, PROGRESS } from "../graphql/queries"; // ensure the import name matches your file
 import { useAuth } from "../auth/useAuth";
 import { messageFromError } from "../lib/errors";
 
@@ -49,7 +49,7 @@ export function useMe() {
   return { data, loading, error };
 }
 
-// useXpData fetches raw XP transactions plus their referenced objects so charts don't need to handle GraphQL.
+// useXpData fetches XP transactions with their objects already joined by the proxy, so charts don't need to handle GraphQL.
 export function useXpData() {
   const { token } = useAuth();
   const [txs, setTxs] = useState<Tx[]>([]);
@@ -63,18 +63,15 @@ export function useXpData() {
     (async () => {
       try {
         setLoading(true);
-        const d = await gql<{ transaction: Tx[] }>(token, XP_TRANSACTIONS, { limit: 2000 });
+        const d = await api<{ transactions: (Tx & { object: Obj | null })[] }>(token, "/api/me/xp/transactions");
         if (!mounted) return;
-        setTxs(d.transaction);
-
-        const ids = Array.from(new Set(d.transaction.map((t) => t.objectId))).filter((id) => typeof id === "number"); // map ensures we only fetch each object once
-        if (ids.length) {
-          const o = await gql<{ object: Obj[] }>(token, _OBJECT_BY_IDS, { ids });
-          const m = new Map<number, Obj>();
-          o.object.forEach((it) => m.set(it.id, it));
-          if (!mounted) return;
-          setObjects(m);
-        }
+        setTxs(d.transactions);
+
+        const m = new Map<number, Obj>();
+        d.transactions.forEach((t) => {
+          if (t.object) m.set(t.object.id, t.object);
+        });
+        setObjects(m);
       } catch (e: unknown) {
         setError(messageFromError(e));
       } finally {
@@ -90,7 +87,7 @@ export function useXpData() {
 }
 
 
-// usePassFailData computes aggregate pass/fail stats from the current user's results.
+// usePassFailData loads aggregate pass/fail stats for the current user's results from the proxy.
 export function usePassFailData(userId?: number) {
   const { token } = useAuth();
   const [passCount, setPassCount] = useState<number>(0);
@@ -109,16 +106,11 @@ export function usePassFailData(userId?: number) {
     (async () => {
       try {
         setLoading(true);
-        const d = await gql<{ progress: ProgressEntry[] }>(token, PROGRESS, { limit: 2000, userId });
+        // the pass rule (grade threshold) lives in the proxy
+        const d = await api<{ pass: number; fail: number }>(token, "/api/me/progress/stats");
         if (!mounted) return;
-        let pass = 0, fail = 0;
-        d.progress.forEach((p) => {
-          const grade = Number(p.grade);
-          if (Number.isFinite(grade) && grade >= 1) pass++;
-          else fail++;
-        });
-        setPassCount(pass);
-        setFailCount(fail);
+        setPassCount(d.pass);
+        setFailCount(d.fail);
       } catch (e: unknown) {
         setError(messageFromError(e));
       } finally {
@@ -1,6 +1,14 @@
 const BASE = import.meta.env.VITE_PROXY_BASE ?? "http://localhost:8080";
 
-export type LoginResp = { token: string };
+export type LoginResp = {
+  token: string;
+  exp?: number;
+  iat?: number;
+  userId?: string;
+  defaultRole?: string;
+  allowedRoles?: string[];
+  login?: string;
+};
 
 type GraphQLErrorItem = { message: string };
 type GraphQLResponse<T> = { data?: T; errors?: GraphQLErrorItem[] };
@@ -22,7 +30,11 @@ export async function gql<T>(
 ): Promise<T> {
   const r = await fetch(`${BASE}/graphql`, {
     method: "POST",
-    headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+    headers: {
+      "Content-Type": "application/json",
+      Accept: "application/graphql-response+json, application/json;q=0.9",
+      Authorization: `Bearer ${token}`,
+    },
     body: JSON.stringify({ query, variables }),
   });
 
@@ -37,3 +49,14 @@ export async function gql<T>(
   }
   return json.data;
 }
+
+// api performs an authenticated GET against one of the proxy's /api/me endpoints.
+export async function api<T>(token: string, path: string): Promise<T> {
+  const r = await fetch(`${BASE}${path}`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!r.ok) {
+    throw new Error((await r.text()).trim() || `HTTP ${r.status}`);
+  }
+  return (await r.json()) as T;
+}